@@ -0,0 +1,135 @@
+/*
+ * access.go
+ *
+ * Access pattern changes per table. Every -access_window each table's reads
+ * and writes are compared with the window before, and an alert goes out when
+ * they change a lot: writes turning up on a table that was only read (a
+ * reference table, say), the mix of reads and writes shifting, or the rate
+ * going up or down -access_change times. After a deploy that is usually the
+ * first sign something is wrong.
+ *
+ * A statement's first table is the one it writes, if it writes at all; the
+ * rest are read.
+ */
+
+package main
+
+import (
+    "fmt"
+    "log"
+    "math"
+    "strings"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+const (
+    ACCESS_MIN_RUNS  = 20  // statements a window needs to be compared
+    ACCESS_MIX_SHIFT = 0.5 // change in the share of writes alerted on
+)
+
+type accessStats struct {
+    reads, writes         uint64 // this window
+    lastReads, lastWrites uint64 // the window before
+    compared              bool   // there was a window before
+}
+
+var accessWindow time.Duration
+var accessChange float64
+var tableAccess map[string]*accessStats = make(map[string]*accessStats)
+
+// tableKey names a table the way -table_usage does: schema.table, or just
+// the table if the schema isn't known.
+func tableKey(ref tableRef, db string) string {
+    schema := ref.schema
+    if schema == "" {
+        schema = db
+    }
+    if schema == "" {
+        return ref.name
+    }
+    return schema + "." + ref.name
+}
+
+// noteTableAccess counts the query just seen on rs against its tables.
+func noteTableAccess(rs *source, qdata *queryData) {
+    if accessWindow == 0 || len(qdata.tables) == 0 {
+        return
+    }
+    write := false
+    switch strings.ToLower(strings.SplitN(qdata.sql, " ", 2)[0]) {
+    case "insert", "update", "delete", "replace", "truncate":
+        write = true
+    }
+    for i, ref := range qdata.tables {
+        key := tableKey(ref, rs.db)
+        a, ok := tableAccess[key]
+        if !ok {
+            a = &accessStats{}
+            tableAccess[key] = a
+        }
+        if write && i == 0 {
+            a.writes++
+        } else {
+            a.reads++
+        }
+    }
+}
+
+// accessAlert says how a table's access changed from the last window, if it
+// changed enough to alert on.
+func accessAlert(table string, a *accessStats) *event.AlertEvent {
+    total, last := a.reads+a.writes, a.lastReads+a.lastWrites
+    if !a.compared || (total < ACCESS_MIN_RUNS && last < ACCESS_MIN_RUNS) {
+        return nil
+    }
+    if a.lastWrites == 0 && a.writes >= ACCESS_MIN_RUNS && last >= ACCESS_MIN_RUNS {
+        return &event.AlertEvent{Kind: "table_writes_appeared", Value: float64(a.writes),
+            Message: fmt.Sprintf("%d writes to %s this window, which was only read before (%d reads)",
+                a.writes, table, a.lastReads)}
+    }
+    if total >= ACCESS_MIN_RUNS && last >= ACCESS_MIN_RUNS {
+        share := float64(a.writes) / float64(total)
+        lastShare := float64(a.lastWrites) / float64(last)
+        if math.Abs(share-lastShare) >= ACCESS_MIX_SHIFT {
+            return &event.AlertEvent{Kind: "table_mix_change", Value: share, Limit: lastShare,
+                Message: fmt.Sprintf("writes to %s went from %.0f%% to %.0f%% of its statements",
+                    table, lastShare*100, share*100)}
+        }
+    }
+    if accessChange > 1 && (float64(total) >= float64(last)*accessChange ||
+        float64(last) >= float64(total)*accessChange) {
+        return &event.AlertEvent{Kind: "table_rate_change", Value: float64(total),
+            Limit: float64(last),
+            Message: fmt.Sprintf("statements on %s went from %d to %d in a window", table, last, total)}
+    }
+    return nil
+}
+
+// checkTableAccess closes the window for every table and publishes alerts
+// for those whose access changed. Must be called with stateLock held.
+func checkTableAccess() {
+    for table, a := range tableAccess {
+        if alert := accessAlert(table, a); alert != nil {
+            alert.Type, alert.ServiceId, alert.TenantId = event.TYPE_ALERT, service_id, tenant_id
+            log.Printf("Access change: %s", alert.Message)
+            publish(alert)
+        }
+        if a.reads+a.writes == 0 && a.lastReads+a.lastWrites == 0 {
+            delete(tableAccess, table)
+            continue
+        }
+        a.lastReads, a.lastWrites, a.reads, a.writes = a.reads, a.writes, 0, 0
+        a.compared = true
+    }
+}
+
+// runAccessChecks closes a window every accessWindow.
+func runAccessChecks() {
+    for _ = range time.Tick(accessWindow) {
+        stateLock.Lock()
+        checkTableAccess()
+        stateLock.Unlock()
+    }
+}
@@ -0,0 +1,27 @@
+/*
+ * access_test.go
+ *
+ * Alerts on a table's reads and writes changing.
+ */
+
+package main
+
+import (
+    "testing"
+)
+
+func TestAccessAlert(t *testing.T) {
+    a := &accessStats{lastReads: 500, reads: 480, writes: 30, compared: true}
+    if alert := accessAlert("shop.countries", a); alert == nil || alert.Kind != "table_writes_appeared" {
+        t.Errorf("writes on a read-only table: got %+v", alert)
+    }
+    accessChange = 5
+    a = &accessStats{lastReads: 100, lastWrites: 100, reads: 1100, writes: 1000, compared: true}
+    if alert := accessAlert("shop.orders", a); alert == nil || alert.Kind != "table_rate_change" {
+        t.Errorf("rate up 10x: got %+v", alert)
+    }
+    a = &accessStats{lastReads: 100, lastWrites: 100, reads: 110, writes: 90, compared: true}
+    if alert := accessAlert("shop.orders", a); alert != nil {
+        t.Errorf("steady: got %+v", alert)
+    }
+}
@@ -0,0 +1,69 @@
+/*
+ * addr.go
+ *
+ * Endpoint addresses. Streams are keyed by the client's ip:port, IPv6 ones
+ * as [ip]:port. A client reaching a dual-stack server over IPv4 can show up
+ * as a v4-mapped IPv6 address (::ffff:a.b.c.d), so those are written in
+ * their IPv4 form: the same client keeps one identity in the stream keys,
+ * per-IP limits, tenant and attribution lookups and the events.
+ */
+
+package main
+
+import (
+    "fmt"
+    "net"
+    "strconv"
+    "strings"
+)
+
+// endpointAddr formats a 4 or 16 byte address and a port as a stream key.
+func endpointAddr(ip []byte, port uint16) string {
+    if len(ip) == net.IPv6len {
+        if v4 := net.IP(ip).To4(); v4 != nil {
+            ip = v4
+        } else {
+            return fmt.Sprintf("[%s]:%d", net.IP(ip), port)
+        }
+    }
+    return fmt.Sprintf("%d.%d.%d.%d:%d", ip[0], ip[1], ip[2], ip[3], port)
+}
+
+// hostOf returns the IP of an ip:port or [ip]:port, without brackets.
+func hostOf(addr string) string {
+    if i := strings.LastIndex(addr, ":"); i >= 0 {
+        addr = addr[:i]
+    }
+    return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// portOf returns the port of an ip:port or [ip]:port, or 0.
+func portOf(addr string) uint16 {
+    p, _ := strconv.ParseUint(addr[strings.LastIndex(addr, ":")+1:], 10, 16)
+    return uint16(p)
+}
+
+// ipv6Payload skips an IPv6 header at data[pos:] and any extension headers
+// after it, returning where the TCP header starts. ok is false if the
+// packet isn't TCP, is a fragment or is cut short.
+func ipv6Payload(data []byte, pos int) (int, bool) {
+    if len(data) < pos+40 {
+        return 0, false
+    }
+    next := data[pos+6]
+    pos += 40
+    for {
+        switch next {
+        case 6: // TCP
+            return pos, true
+        case 0, 43, 60: // hop-by-hop, routing, destination options
+            if len(data) < pos+2 {
+                return 0, false
+            }
+            next = data[pos]
+            pos += (int(data[pos+1]) + 1) * 8
+        default: // fragments and anything else
+            return 0, false
+        }
+    }
+}
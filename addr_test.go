@@ -0,0 +1,41 @@
+/*
+ * addr_test.go
+ *
+ * Endpoint addresses for IPv4, IPv4-mapped and IPv6 peers.
+ */
+
+package main
+
+import (
+    "testing"
+)
+
+func TestEndpointAddr(t *testing.T) {
+    mapped := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 10, 0, 0, 1}
+    v6 := []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+    for _, test := range []struct {
+        ip         []byte
+        want, host string
+    }{
+        {[]byte{10, 0, 0, 1}, "10.0.0.1:3306", "10.0.0.1"},
+        {mapped, "10.0.0.1:3306", "10.0.0.1"},
+        {v6, "[2001:db8::1]:3306", "2001:db8::1"},
+    } {
+        addr := endpointAddr(test.ip, 3306)
+        if addr != test.want || hostOf(addr) != test.host {
+            t.Errorf("%v: got %s (%s), want %s", test.ip, addr, hostOf(addr), test.want)
+        }
+    }
+
+    // An IPv6 header with a destination options header before TCP.
+    pkt := make([]byte, 14+40+8+20)
+    pkt[14+6] = 60
+    pkt[14+40] = 6
+    if pos, ok := ipv6Payload(pkt, 14); !ok || pos != 14+48 {
+        t.Errorf("got %d, %v", pos, ok)
+    }
+    pkt[14+40] = 44 // a fragment
+    if _, ok := ipv6Payload(pkt, 14); ok {
+        t.Errorf("fragment accepted")
+    }
+}
@@ -0,0 +1,248 @@
+/*
+ * antipatterns.go
+ *
+ * SQL anti-pattern detection. Each rule looks at a fingerprint once, when it
+ * is first seen; rules that depend on the literals (how big an OFFSET is)
+ * then look at each statement of a fingerprint that passed. Query events
+ * carry the names of the rules a statement broke, the counts per fingerprint
+ * are in the `antipatterns` report, and a new rule is one more entry in
+ * antiPatterns.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "sort"
+    "strings"
+)
+
+type antiPattern struct {
+    name  string
+    about string
+    // fingerprint says if a canonical query may break the rule.
+    fingerprint func(words []string) bool
+    // statement, if not nil, decides for each statement as sent.
+    statement func(words []string) bool
+}
+
+var antiPatterns = []antiPattern{
+    {name: "order_by_rand", about: "ORDER BY RAND() sorts every row",
+        fingerprint: hasOrderByRand},
+    {name: "large_offset", about: "LIMIT/OFFSET pagination reads and throws away the skipped rows",
+        fingerprint: hasWord("limit"), statement: hasLargeOffset},
+    {name: "not_in_subquery", about: "NOT IN (SELECT ...) is empty if the subquery returns a NULL",
+        fingerprint: hasNotInSubquery},
+    {name: "implicit_cross_join", about: "FROM a, b without a WHERE joins every row with every row",
+        fingerprint: hasImplicitCrossJoin},
+    {name: "leading_wildcard", about: "LIKE '%...' can't use an index",
+        fingerprint: hasWord("like"), statement: hasLeadingWildcard},
+}
+
+// Offsets from this on are large_offset.
+var antiPatternOffset int = 10000
+
+// queryWords returns the tokens of a query, lower cased, without whitespace
+// and backquotes.
+func queryWords(query []byte) []string {
+    var words []string
+    for i := 0; i < len(query); {
+        length, toktype := scanToken(query[i:])
+        if toktype != TOKEN_WHITESPACE && query[i] != '`' {
+            words = append(words, strings.ToLower(string(query[i:i+length])))
+        }
+        i += length
+    }
+    return words
+}
+
+// fingerprintPatterns returns the rules a canonical query may break.
+func fingerprintPatterns(query string) []*antiPattern {
+    words := queryWords([]byte(query))
+    var found []*antiPattern
+    for i := range antiPatterns {
+        if antiPatterns[i].fingerprint(words) {
+            found = append(found, &antiPatterns[i])
+        }
+    }
+    return found
+}
+
+// needsWords says if a statement's words are needed to check it against
+// qdata's rules.
+func needsWords(qdata *queryData) bool {
+    for _, rule := range qdata.patterns {
+        if rule.statement != nil {
+            return true
+        }
+    }
+    return false
+}
+
+// noteAntiPatterns returns the names of the rules a statement of qdata
+// breaks and counts them against it. words are the statement's words, if
+// needsWords.
+func noteAntiPatterns(qdata *queryData, words []string) []string {
+    if len(qdata.patterns) == 0 {
+        return nil
+    }
+    var names []string
+    for _, rule := range qdata.patterns {
+        if rule.statement != nil && !rule.statement(words) {
+            continue
+        }
+        names = append(names, rule.name)
+    }
+    if len(names) > 0 && qdata.violations == nil {
+        qdata.violations = make(map[string]uint64)
+    }
+    for _, name := range names {
+        qdata.violations[name]++
+    }
+    return names
+}
+
+func hasWord(word string) func([]string) bool {
+    return func(words []string) bool {
+        for _, w := range words {
+            if w == word {
+                return true
+            }
+        }
+        return false
+    }
+}
+
+func hasOrderByRand(words []string) bool {
+    for i := 0; i+3 < len(words); i++ {
+        if words[i] == "order" && words[i+1] == "by" && words[i+2] == "rand" && words[i+3] == "(" {
+            return true
+        }
+    }
+    return false
+}
+
+func hasNotInSubquery(words []string) bool {
+    for i := 0; i+3 < len(words); i++ {
+        if words[i] == "not" && words[i+1] == "in" && words[i+2] == "(" && words[i+3] == "select" {
+            return true
+        }
+    }
+    return false
+}
+
+// hasImplicitCrossJoin looks for FROM a, b with no WHERE at the same depth
+// before the statement or subquery ends.
+func hasImplicitCrossJoin(words []string) bool {
+    type fromClause struct {
+        depth          int
+        listing, comma bool // still in the table list, which had a comma
+        where          bool
+    }
+    var open []fromClause
+    depth := 0
+    closeAt := func(d int) bool {
+        for len(open) > 0 && open[len(open)-1].depth >= d {
+            f := open[len(open)-1]
+            if f.comma && !f.where {
+                return true
+            }
+            open = open[:len(open)-1]
+        }
+        return false
+    }
+    for _, w := range words {
+        var top *fromClause
+        if len(open) > 0 && open[len(open)-1].depth == depth {
+            top = &open[len(open)-1]
+        }
+        switch w {
+        case "(":
+            depth++
+        case ")":
+            if closeAt(depth) {
+                return true
+            }
+            depth--
+        case "from":
+            open = append(open, fromClause{depth: depth, listing: true})
+        case ",":
+            if top != nil && top.listing {
+                top.comma = true
+            }
+        case "where":
+            if top != nil {
+                top.listing, top.where = false, true
+            }
+        case "union":
+            if closeAt(depth) {
+                return true
+            }
+        case "group", "order", "having", "limit", "on", "using", "join", "for":
+            if top != nil {
+                top.listing = false
+            }
+        }
+    }
+    return closeAt(0)
+}
+
+func hasLargeOffset(words []string) bool {
+    offset, ok := statementOffset(words)
+    return ok && offset >= uint64(antiPatternOffset)
+}
+
+func hasLeadingWildcard(words []string) bool {
+    for i := 0; i+1 < len(words); i++ {
+        if words[i] == "like" && len(words[i+1]) > 1 && words[i+1][1] == '%' &&
+            (words[i+1][0] == '\'' || words[i+1][0] == '"') {
+            return true
+        }
+    }
+    return false
+}
+
+func reportAntiPatterns(w io.Writer, asJSON bool) {
+    type violating struct {
+        Fingerprint string            `json:"fingerprint"`
+        Name        string            `json:"name,omitempty"`
+        Count       uint64            `json:"count"`
+        Violations  map[string]uint64 `json:"violations"`
+    }
+    var out []violating
+    for _, key := range sortedQueries() {
+        if qdata := qbuf[key]; len(qdata.violations) > 0 {
+            out = append(out, violating{Fingerprint: key, Name: nameOf(key), Count: qdata.count,
+                Violations: qdata.violations})
+        }
+    }
+    if asJSON {
+        rules := make(map[string]string)
+        for _, rule := range antiPatterns {
+            rules[rule.name] = rule.about
+        }
+        json.NewEncoder(w).Encode(map[string]interface{}{"rules": rules, "antipatterns": out})
+        return
+    }
+    for _, v := range out {
+        fmt.Fprintf(w, "%s== antipatterns: %s (%d queries)%s\n", color(COLOR_CYAN),
+            labelOf(v.Fingerprint), v.Count, color(COLOR_DEFAULT))
+        var names []string
+        for name := range v.Violations {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        for _, name := range names {
+            about := ""
+            for _, rule := range antiPatterns {
+                if rule.name == name {
+                    about = rule.about
+                }
+            }
+            fmt.Fprintf(w, "%10d  %s: %s\n", v.Violations[name], name, about)
+        }
+        fmt.Fprintf(w, "\n")
+    }
+}
@@ -0,0 +1,34 @@
+/*
+ * antipatterns_test.go
+ *
+ * Statements that are slow by construction are flagged.
+ */
+
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestAntiPatterns(t *testing.T) {
+    for _, c := range []struct {
+        query string
+        want  []string
+    }{
+        {"SELECT * FROM t ORDER BY RAND() LIMIT 1", []string{"order_by_rand"}},
+        {"SELECT * FROM t LIMIT 50000, 20", []string{"large_offset"}},
+        {"SELECT * FROM t LIMIT 20 OFFSET 40", nil},
+        {"SELECT * FROM t WHERE id NOT IN (SELECT t_id FROM u)", []string{"not_in_subquery"}},
+        {"SELECT * FROM a, b ORDER BY a.id", []string{"implicit_cross_join"}},
+        {"SELECT * FROM a, b WHERE a.id = b.a_id", nil},
+        {"SELECT * FROM a WHERE x IN (SELECT y FROM b, c) AND z = 1", []string{"implicit_cross_join"}},
+        {"SELECT a, b FROM t WHERE name LIKE '%son'", []string{"leading_wildcard"}},
+        {"SELECT a, b FROM t WHERE name LIKE 'son%'", nil},
+    } {
+        qdata := &queryData{patterns: fingerprintPatterns(cleanupQuery([]byte(c.query)))}
+        if got := noteAntiPatterns(qdata, queryWords([]byte(c.query))); !reflect.DeepEqual(got, c.want) {
+            t.Errorf("%s: got %v, want %v", c.query, got, c.want)
+        }
+    }
+}
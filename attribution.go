@@ -0,0 +1,154 @@
+/*
+ * attribution.go
+ *
+ * Latency attribution per client IP within each fingerprint. When one query
+ * gets slow this tells whether all clients see it or one application
+ * instance is the culprit, even when the -f key doesn't split by client.
+ * Served on demand by the `attribution` report and GET /attribution.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "sort"
+)
+
+// Per fingerprint; further clients are lumped together.
+const ATTRIBUTION_MAX_SOURCES = 256
+const ATTRIBUTION_OTHER = "other"
+
+type sourceLatency struct {
+    count     uint64
+    timed     uint64
+    timeTotal uint64 // nanoseconds
+    timeMax   uint64
+}
+
+// attributionFor returns the entry for rs's client within qdata.
+func attributionFor(qdata *queryData, rs *source) *sourceLatency {
+    if qdata.bySource == nil {
+        qdata.bySource = make(map[string]*sourceLatency)
+    }
+    key := rs.srcip
+    sl, ok := qdata.bySource[key]
+    if !ok {
+        if len(qdata.bySource) >= ATTRIBUTION_MAX_SOURCES {
+            key = ATTRIBUTION_OTHER
+            if sl, ok = qdata.bySource[key]; ok {
+                return sl
+            }
+        }
+        sl = &sourceLatency{}
+        qdata.bySource[key] = sl
+    }
+    return sl
+}
+
+// noteAttribution counts a response time against the client.
+func noteAttribution(qdata *queryData, rs *source, reqtime uint64) {
+    sl := attributionFor(qdata, rs)
+    sl.timed++
+    sl.timeTotal += reqtime
+    if reqtime > sl.timeMax {
+        sl.timeMax = reqtime
+    }
+}
+
+type sourceAttribution struct {
+    Source    string  `json:"source"`
+    Count     uint64  `json:"count"`
+    AvgMs     float64 `json:"avg_ms"`
+    MaxMs     float64 `json:"max_ms"`
+    TimeShare float64 `json:"time_share"` // of the fingerprint's total time
+    VsAll     float64 `json:"vs_all"`     // average over the fingerprint's average
+}
+
+type fingerprintAttribution struct {
+    Fingerprint string              `json:"fingerprint"`
+    Name        string              `json:"name,omitempty"`
+    AvgMs       float64             `json:"avg_ms"`
+    Sources     []sourceAttribution `json:"sources"`
+}
+
+// attribute breaks a fingerprint's time down by client, most time first.
+func attribute(key string, qdata *queryData) fingerprintAttribution {
+    fa := fingerprintAttribution{Fingerprint: key, Name: nameOf(key),
+        AvgMs: avgMs(qdata.timeTotal, qdata.timed)}
+    var list sortableSlice
+    for src, sl := range qdata.bySource {
+        list = append(list, sortable{value: -float64(sl.timeTotal), line: src})
+    }
+    sort.Sort(list)
+    for _, item := range list {
+        sl := qdata.bySource[item.line]
+        sa := sourceAttribution{Source: item.line, Count: sl.count,
+            AvgMs: avgMs(sl.timeTotal, sl.timed), MaxMs: float64(sl.timeMax) / 1e6}
+        if qdata.timeTotal > 0 {
+            sa.TimeShare = float64(sl.timeTotal) / float64(qdata.timeTotal)
+        }
+        if fa.AvgMs > 0 {
+            sa.VsAll = sa.AvgMs / fa.AvgMs
+        }
+        fa.Sources = append(fa.Sources, sa)
+    }
+    return fa
+}
+
+// attributions covers the given fingerprints, or all of them if none are
+// given; names from -names are accepted too.
+func attributions(wanted []string) []fingerprintAttribution {
+    keys := sortedQueries()
+    if len(wanted) > 0 {
+        want := make(map[string]bool)
+        for _, w := range wanted {
+            want[w] = true
+        }
+        var picked []string
+        for _, key := range keys {
+            if want[key] || want[nameOf(key)] {
+                picked = append(picked, key)
+            }
+        }
+        keys = picked
+    }
+    var out []fingerprintAttribution
+    for _, key := range keys {
+        if len(qbuf[key].bySource) > 0 {
+            out = append(out, attribute(key, qbuf[key]))
+        }
+    }
+    return out
+}
+
+func reportAttribution(w io.Writer, asJSON bool) {
+    out := attributions(nil)
+    if asJSON {
+        json.NewEncoder(w).Encode(map[string]interface{}{"attribution": out})
+        return
+    }
+    for _, fa := range out {
+        fmt.Fprintf(w, "%s== attribution: %s (avg %.3fms)%s\n", color(COLOR_CYAN),
+            labelOf(fa.Fingerprint), fa.AvgMs, color(COLOR_DEFAULT))
+        fmt.Fprintf(w, "%10s %10s %10s %8s %8s  %s\n", "count", "avg ms", "max ms", "time %",
+            "vs all", "source")
+        for _, sa := range fa.Sources {
+            fmt.Fprintf(w, "%10d %10.3f %10.3f %8.2f %7.2fx  %s\n", sa.Count, sa.AvgMs, sa.MaxMs,
+                sa.TimeShare*100, sa.VsAll, sa.Source)
+        }
+        fmt.Fprintf(w, "\n")
+    }
+}
+
+// handleAttribution serves GET /attribution, optionally narrowed with one or
+// more ?q=fingerprint-or-name.
+func handleAttribution(w http.ResponseWriter, r *http.Request) {
+    stateLock.Lock()
+    out := attributions(r.URL.Query()["q"])
+    stateLock.Unlock()
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,128 @@
+/*
+ * bandwidth.go
+ *
+ * Bandwidth accounting. Every segment to or from the MySQL port is counted
+ * by its length on the wire, whether or not a query comes of it, so
+ * handshakes, TLS, replication, retransmits and streams we lost sync on all
+ * show up. The running totals are on /metrics, and every -bandwidth_window a "bandwidth" event gives the bytes and
+ * rates each way, overall and for the busiest clients. Segments whose
+ * direction can't be told from the port or a known server aren't counted.
+ */
+
+package main
+
+import (
+    "log"
+    "sort"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+const BANDWIDTH_TOP = 20 // clients listed
+
+type bandwidthTotals struct {
+    in, out               uint64 // bytes client->server, server->client
+    inPackets, outPackets uint64
+}
+
+var bandwidthWindow time.Duration
+var bandwidthStart time.Time
+var bandwidthTotal bandwidthTotals
+var bandwidthClients map[string]*bandwidthTotals = make(map[string]*bandwidthTotals)
+
+// noteBandwidth counts a segment of length bytes between srcaddr and
+// dstaddr.
+func noteBandwidth(srcaddr string, srcPort uint16, dstaddr string, dstPort uint16, length uint32) {
+    var client string
+    var request bool
+    switch {
+    case servers[dstaddr]:
+        client, request = srcaddr, true
+    case servers[srcaddr]:
+        client, request = dstaddr, false
+    case srcPort != dstPort && isServerPort(dstPort):
+        client, request = srcaddr, true
+    case srcPort != dstPort && isServerPort(srcPort):
+        client, request = dstaddr, false
+    default:
+        return
+    }
+    if request {
+        stats.wire.in += uint64(length)
+    } else {
+        stats.wire.out += uint64(length)
+    }
+    if bandwidthWindow <= 0 {
+        return
+    }
+    ip := hostOf(client)
+    c, ok := bandwidthClients[ip]
+    if !ok {
+        c = &bandwidthTotals{}
+        bandwidthClients[ip] = c
+    }
+    for _, t := range []*bandwidthTotals{&bandwidthTotal, c} {
+        if request {
+            t.in += uint64(length)
+            t.inPackets++
+        } else {
+            t.out += uint64(length)
+            t.outPackets++
+        }
+    }
+}
+
+func bandwidthEntry(client string, t *bandwidthTotals, secs float64) event.BandwidthEntry {
+    return event.BandwidthEntry{Client: client, InBytes: t.in, OutBytes: t.out,
+        InPackets: t.inPackets, OutPackets: t.outPackets,
+        InPerSec: float64(t.in) / secs, OutPerSec: float64(t.out) / secs}
+}
+
+// closeBandwidthWindow returns the report on the window ending now and
+// starts a new one. Must be called with stateLock held.
+func closeBandwidthWindow(now time.Time) *event.BandwidthEvent {
+    secs := now.Sub(bandwidthStart).Seconds()
+    if secs <= 0 {
+        secs = 1
+    }
+    ev := &event.BandwidthEvent{
+        Type:        event.TYPE_BANDWIDTH,
+        ServiceId:   service_id,
+        TenantId:    tenant_id,
+        WindowStart: bandwidthStart.Unix(),
+        WindowSecs:  secs,
+        Total:       bandwidthEntry("", &bandwidthTotal, secs),
+        Clients:     len(bandwidthClients),
+    }
+    var list sortableSlice
+    for ip, t := range bandwidthClients {
+        list = append(list, sortable{value: -float64(t.in + t.out), line: ip})
+    }
+    sort.Sort(list)
+    if len(list) > BANDWIDTH_TOP {
+        list = list[:BANDWIDTH_TOP]
+    }
+    for _, item := range list {
+        ev.Top = append(ev.Top, bandwidthEntry(item.line, bandwidthClients[item.line], secs))
+    }
+    bandwidthTotal = bandwidthTotals{}
+    bandwidthClients = make(map[string]*bandwidthTotals)
+    bandwidthStart = now
+    return ev
+}
+
+// runBandwidthAccounting closes a window every bandwidthWindow.
+func runBandwidthAccounting() {
+    stateLock.Lock()
+    bandwidthStart = time.Now()
+    stateLock.Unlock()
+    for now := range time.Tick(bandwidthWindow) {
+        stateLock.Lock()
+        ev := closeBandwidthWindow(now)
+        log.Printf("Bandwidth: %.0f bytes/s in, %.0f bytes/s out, %d clients",
+            ev.Total.InPerSec, ev.Total.OutPerSec, ev.Clients)
+        publish(ev)
+        stateLock.Unlock()
+    }
+}
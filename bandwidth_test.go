@@ -0,0 +1,34 @@
+/*
+ * bandwidth_test.go
+ *
+ * Bytes and packets per client in each -bandwidth_window.
+ */
+
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestBandwidth(t *testing.T) {
+    port, bandwidthWindow = 3306, time.Minute
+    start := time.Unix(1700000000, 0)
+    bandwidthStart = start
+    noteBandwidth("10.0.0.1:5000", 5000, "10.0.0.9:3306", 3306, 100)
+    noteBandwidth("10.0.0.9:3306", 3306, "10.0.0.1:5000", 5000, 1000)
+    noteBandwidth("10.0.0.1:5001", 5001, "10.0.0.9:3306", 3306, 100)
+    noteBandwidth("10.0.0.2:5000", 5000, "10.0.0.9:3306", 3306, 60)
+    noteBandwidth("10.0.0.3:5000", 5000, "10.0.0.4:5000", 5000, 60) // direction unknown
+    ev := closeBandwidthWindow(start.Add(10 * time.Second))
+    if ev.Total.InBytes != 260 || ev.Total.OutBytes != 1000 || ev.Total.OutPerSec != 100 || ev.Clients != 2 {
+        t.Errorf("got %+v", ev)
+    }
+    if len(ev.Top) != 2 || ev.Top[0].Client != "10.0.0.1" || ev.Top[0].InPackets != 2 || ev.Top[0].InPerSec != 20 {
+        t.Errorf("top: got %+v", ev.Top)
+    }
+    if len(bandwidthClients) != 0 || bandwidthStart != start.Add(10*time.Second) {
+        t.Errorf("window not reset")
+    }
+    bandwidthWindow = 0
+}
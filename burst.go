@@ -0,0 +1,152 @@
+/*
+ * burst.go
+ *
+ * How bursty each fingerprint's arrivals are. A query that runs 600 times a
+ * minute evenly is a different load from one that runs 600 times in the same
+ * second each minute, though their averages agree; the second kind is behind
+ * many intermittent latency spikes. Two numbers capture it:
+ *
+ *   fano        variance over mean of the arrivals per BURST_BIN; 1 for
+ *               random (Poisson) arrivals, below for regular ones, well
+ *               above for bursts
+ *   burstiness  (sd - mean) / (sd + mean) of the gaps between arrivals; -1
+ *               for a metronome, 0 for random, towards 1 for bursts
+ *
+ * The `burstiness` report lists the burstiest fingerprints and /metrics has
+ * both for those seen at least BURST_MIN_ARRIVALS times.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "math"
+    "sort"
+    "time"
+)
+
+const BURST_BIN = time.Second
+const BURST_MIN_ARRIVALS = 20
+
+type arrivalStats struct {
+    bin      int64  // current bin, in BURST_BINs since the epoch
+    binCount uint64 // arrivals in it
+    bins     uint64 // finished bins since the first arrival, and their counts
+    sum      uint64
+    sumSq    float64
+    peak     uint64 // most arrivals in a bin
+
+    last     time.Time // previous arrival, and the gaps between arrivals
+    gaps     uint64
+    gapSum   float64 // seconds
+    gapSumSq float64
+}
+
+// noteArrival counts an execution of qdata at now.
+func noteArrival(qdata *queryData, now time.Time) {
+    a := &qdata.arrivals
+    bin := now.UnixNano() / int64(BURST_BIN)
+    switch {
+    case a.last.IsZero():
+        a.bin = bin
+    case bin > a.bin:
+        a.bins += uint64(bin - a.bin) // the bins between had no arrivals
+        a.sum += a.binCount
+        a.sumSq += float64(a.binCount) * float64(a.binCount)
+        a.bin, a.binCount = bin, 0
+    }
+    a.binCount++
+    if a.binCount > a.peak {
+        a.peak = a.binCount
+    }
+    if !a.last.IsZero() {
+        gap := now.Sub(a.last).Seconds()
+        a.gaps++
+        a.gapSum += gap
+        a.gapSumSq += gap * gap
+    }
+    a.last = now
+}
+
+// fano returns the variance over the mean of arrivals per bin.
+func (a *arrivalStats) fano() float64 {
+    if a.bins == 0 || a.sum == 0 {
+        return 0
+    }
+    mean := float64(a.sum) / float64(a.bins)
+    return (a.sumSq/float64(a.bins) - mean*mean) / mean
+}
+
+// burstiness returns (sd - mean) / (sd + mean) of the gaps between arrivals.
+func (a *arrivalStats) burstiness() float64 {
+    if a.gaps == 0 {
+        return 0
+    }
+    mean := a.gapSum / float64(a.gaps)
+    sd := math.Sqrt(math.Max(a.gapSumSq/float64(a.gaps)-mean*mean, 0))
+    if sd+mean == 0 {
+        return 0
+    }
+    return (sd - mean) / (sd + mean)
+}
+
+type queryBurst struct {
+    Fingerprint string  `json:"fingerprint"`
+    Name        string  `json:"name,omitempty"`
+    Count       uint64  `json:"count"`
+    Fano        float64 `json:"fano"`
+    Burstiness  float64 `json:"burstiness"`
+    PeakPerBin  uint64  `json:"peak_per_sec"`
+}
+
+// burstiest returns the fingerprints seen often enough to judge, burstiest
+// first by Fano factor.
+func burstiest() []queryBurst {
+    var list []queryBurst
+    for _, key := range sortedQueries() {
+        qdata := qbuf[key]
+        a := &qdata.arrivals
+        if a.gaps+1 < BURST_MIN_ARRIVALS {
+            continue
+        }
+        list = append(list, queryBurst{Fingerprint: key, Name: nameOf(key), Count: qdata.count,
+            Fano: a.fano(), Burstiness: a.burstiness(), PeakPerBin: a.peak})
+    }
+    sort.SliceStable(list, func(i, j int) bool { return list[i].Fano > list[j].Fano })
+    return list
+}
+
+func reportBurstiness(w io.Writer, asJSON bool) {
+    list := burstiest()
+    if asJSON {
+        json.NewEncoder(w).Encode(map[string]interface{}{"burstiness": list})
+        return
+    }
+    fmt.Fprintf(w, "%s== burstiness (%d fingerprints with %d+ executions)%s\n", color(COLOR_CYAN),
+        len(list), BURST_MIN_ARRIVALS, color(COLOR_DEFAULT))
+    fmt.Fprintf(w, "%10s %10s %10s %10s  %s\n", "count", "fano", "burstiness", "peak/s",
+        "fingerprint")
+    for _, q := range list {
+        fmt.Fprintf(w, "%10d %10.2f %10.2f %10d  %s\n", q.Count, q.Fano, q.Burstiness,
+            q.PeakPerBin, labelOf(q.Fingerprint))
+    }
+    fmt.Fprintf(w, "\n")
+}
+
+// writeBurstMetrics writes the Fano factor and burstiness of each
+// fingerprint seen often enough.
+func writeBurstMetrics(w io.Writer) {
+    list := burstiest()
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_query_fano_factor gauge\n")
+    for _, q := range list {
+        fmt.Fprintf(w, "mysql_sniffer_query_fano_factor{%s} %g\n",
+            metricLabels("fingerprint", q.Fingerprint), q.Fano)
+    }
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_query_burstiness gauge\n")
+    for _, q := range list {
+        fmt.Fprintf(w, "mysql_sniffer_query_burstiness{%s} %g\n",
+            metricLabels("fingerprint", q.Fingerprint), q.Burstiness)
+    }
+}
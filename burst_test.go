@@ -0,0 +1,41 @@
+/*
+ * burst_test.go
+ *
+ * How bursty each fingerprint's arrivals are.
+ */
+
+package main
+
+import (
+    "math"
+    "testing"
+    "time"
+)
+
+func TestBurstiness(t *testing.T) {
+    resetState()
+    steady, bursty := &queryData{count: 100}, &queryData{count: 100}
+    qbuf["steady"], qbuf["bursty"] = steady, bursty
+    start := time.Unix(1000, 0)
+    for i := 0; i < 100; i++ {
+        noteArrival(steady, start.Add(time.Duration(i)*100*time.Millisecond))
+        // 25 in the first 250ms of every fifth second.
+        noteArrival(bursty, start.Add(time.Duration(i/25)*5*time.Second+time.Duration(i%25)*10*time.Millisecond))
+    }
+    if f := steady.arrivals.fano(); f != 0 {
+        t.Errorf("steady fano %g", f)
+    }
+    if b := steady.arrivals.burstiness(); math.Abs(b+1) > 1e-6 {
+        t.Errorf("steady burstiness %g", b)
+    }
+    if f := bursty.arrivals.fano(); f < 10 {
+        t.Errorf("bursty fano %g", f)
+    }
+    if b := bursty.arrivals.burstiness(); b < 0.5 {
+        t.Errorf("bursty burstiness %g", b)
+    }
+    list := burstiest()
+    if len(list) != 2 || list[0].Fingerprint != "bursty" || list[0].PeakPerBin != 25 {
+        t.Errorf("burstiest %+v", list)
+    }
+}
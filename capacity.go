@@ -0,0 +1,162 @@
+/*
+ * capacity.go
+ *
+ * The capacity report. Every -capacity_interval (a week, say) the load of the
+ * period that just ended, QPS, average latency and bytes per second, is added
+ * to a short history, a straight line is fitted through it (see linearFit)
+ * and a "capacity" event goes out with the growth per period, overall and for
+ * the fastest growing fingerprints. With -capacity_qps, the QPS the server is
+ * known to manage, it also says in how many days that would be reached if the
+ * trend holds. Early warning without an analytics job on the side.
+ */
+
+package main
+
+import (
+    "log"
+    "sort"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+const (
+    CAPACITY_PERIODS = 8  // periods of history the trends are fitted over
+    CAPACITY_TOP     = 10 // fingerprints listed
+)
+
+var capacityInterval time.Duration
+var capacityQPS float64
+var capacityStart time.Time
+
+type capacityTotals struct {
+    count, timed, timeTotal, bytes uint64
+}
+
+var capacityLast map[string]capacityTotals = make(map[string]capacityTotals)
+
+// Load per period, oldest first.
+var capacityHistory struct {
+    qps, avgMs, bytes []float64
+    byQuery           map[string][]float64 // qps
+}
+
+// pushHistory appends v to a history of at most CAPACITY_PERIODS.
+func pushHistory(history []float64, v float64) []float64 {
+    history = append(history, v)
+    if len(history) > CAPACITY_PERIODS {
+        history = history[1:]
+    }
+    return history
+}
+
+// growth returns the fitted change per period as a fraction of the latest
+// value, and the change itself.
+func growth(history []float64) (float64, float64) {
+    if len(history) < 2 {
+        return 0, 0
+    }
+    slope, _ := linearFit(history)
+    last := history[len(history)-1]
+    if last <= 0 {
+        return 0, slope
+    }
+    return slope / last, slope
+}
+
+// closeCapacityPeriod adds the period ending now to the history and returns
+// the report on it. Must be called with stateLock held.
+func closeCapacityPeriod(now time.Time) *event.CapacityEvent {
+    secs := now.Sub(capacityStart).Seconds()
+    if capacityHistory.byQuery == nil {
+        capacityHistory.byQuery = make(map[string][]float64)
+    }
+    var total capacityTotals
+    last := capacityLast
+    capacityLast = make(map[string]capacityTotals, len(qbuf))
+    for key, qdata := range qbuf {
+        cur := capacityTotals{qdata.count, qdata.timed, qdata.timeTotal, qdata.bytes}
+        capacityLast[key] = cur
+        d := cur
+        if was, ok := last[key]; ok && was.count <= cur.count {
+            d = capacityTotals{cur.count - was.count, cur.timed - was.timed,
+                cur.timeTotal - was.timeTotal, cur.bytes - was.bytes}
+        }
+        total.count += d.count
+        total.timed += d.timed
+        total.timeTotal += d.timeTotal
+        total.bytes += d.bytes
+        if !hiddenQuery(qdata) {
+            capacityHistory.byQuery[key] = pushHistory(capacityHistory.byQuery[key],
+                float64(d.count)/secs)
+        }
+    }
+    for key := range capacityHistory.byQuery {
+        if _, ok := qbuf[key]; !ok {
+            delete(capacityHistory.byQuery, key) // purged
+        }
+    }
+
+    h := &capacityHistory
+    h.qps = pushHistory(h.qps, float64(total.count)/secs)
+    h.avgMs = pushHistory(h.avgMs, avgMs(total.timeTotal, total.timed))
+    h.bytes = pushHistory(h.bytes, float64(total.bytes)/secs)
+    ev := &event.CapacityEvent{
+        Type:        event.TYPE_CAPACITY,
+        ServiceId:   service_id,
+        TenantId:    tenant_id,
+        WindowStart: capacityStart.Unix(),
+        WindowSecs:  secs,
+        Periods:     len(h.qps),
+        QPS:         h.qps[len(h.qps)-1],
+        AvgMs:       h.avgMs[len(h.avgMs)-1],
+        BytesPerSec: h.bytes[len(h.bytes)-1],
+    }
+    var slope float64
+    ev.QPSGrowth, slope = growth(h.qps)
+    ev.AvgMsGrowth, _ = growth(h.avgMs)
+    ev.BytesGrowth, _ = growth(h.bytes)
+    if capacityQPS > 0 && slope > 0 {
+        days := (capacityQPS - ev.QPS) / slope * capacityInterval.Hours() / 24
+        if days < 0 {
+            days = 0
+        }
+        ev.SaturationDays = &days
+    }
+
+    var list sortableSlice
+    for key, history := range h.byQuery {
+        if _, slope := growth(history); slope > 0 {
+            list = append(list, sortable{value: -slope, line: key})
+        }
+    }
+    sort.Sort(list)
+    if len(list) > CAPACITY_TOP {
+        list = list[:CAPACITY_TOP]
+    }
+    for _, item := range list {
+        history := h.byQuery[item.line]
+        rate, _ := growth(history)
+        ev.Top = append(ev.Top, event.CapacityEntry{Fingerprint: item.line, Name: nameOf(item.line),
+            QPS: history[len(history)-1], QPSGrowth: rate})
+    }
+    capacityStart = now
+    return ev
+}
+
+// runCapacityReports closes a period every capacityInterval.
+func runCapacityReports() {
+    stateLock.Lock()
+    closeCapacityPeriod(time.Now()) // what came before doesn't count
+    h := &capacityHistory
+    h.qps, h.avgMs, h.bytes, h.byQuery = nil, nil, nil, nil
+    stateLock.Unlock()
+    for now := range time.Tick(capacityInterval) {
+        stateLock.Lock()
+        ev := closeCapacityPeriod(now)
+        log.Printf("Capacity: %.1f qps (%+.1f%% a period), %.2fms avg (%+.1f%%), %.0f bytes/s (%+.1f%%)",
+            ev.QPS, ev.QPSGrowth*100, ev.AvgMs, ev.AvgMsGrowth*100, ev.BytesPerSec, ev.BytesGrowth*100)
+        publish(ev)
+        stateLock.Unlock()
+    }
+}
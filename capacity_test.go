@@ -0,0 +1,37 @@
+/*
+ * capacity_test.go
+ *
+ * The capacity trend: growth per period and days to saturation.
+ */
+
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+func TestCapacityTrend(t *testing.T) {
+    qbuf = map[string]*queryData{"select ?": {}}
+    capacityLast = make(map[string]capacityTotals)
+    capacityHistory.qps, capacityHistory.avgMs, capacityHistory.bytes = nil, nil, nil
+    capacityHistory.byQuery = nil
+    capacityInterval, capacityQPS = 24*time.Hour, 1000
+    start := time.Unix(1700000000, 0)
+    capacityStart = start
+    var ev *event.CapacityEvent
+    for day := 1; day <= 4; day++ {
+        // 100 qps more every day
+        qbuf["select ?"].count += uint64(day * 100 * 86400)
+        ev = closeCapacityPeriod(start.Add(time.Duration(day) * 24 * time.Hour))
+    }
+    if ev.QPS != 400 || ev.QPSGrowth != 0.25 || ev.SaturationDays == nil || *ev.SaturationDays != 6 {
+        t.Errorf("got %+v", ev)
+    }
+    if len(ev.Top) != 1 || ev.Top[0].Fingerprint != "select ?" {
+        t.Errorf("top: got %+v", ev.Top)
+    }
+    qbuf = make(map[string]*queryData)
+}
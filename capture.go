@@ -0,0 +1,125 @@
+/*
+ * capture.go
+ *
+ * Where packets come from. A CaptureSource hands out one packet at a time,
+ * borrowed until the next call (see handlePacket), and takes the capture
+ * filter. -capture picks the backend for live capture: libpcap, or afpacket
+ * on Linux, which reads the kernel's TPACKET_V3 ring in place instead of
+ * having libpcap copy each packet out of it. -r reads a pcap file through
+ * libpcap, whatever -capture says. libpcap is reached through gopacket's
+ * pcap package.
+ */
+
+package main
+
+import (
+    "fmt"
+    "io"
+    "time"
+
+    "github.com/google/gopacket/pcap"
+)
+
+const (
+    CAPTURE_LIBPCAP  = "libpcap"
+    CAPTURE_AFPACKET = "afpacket"
+
+    CAPTURE_SNAPLEN    = 1024
+    CAPTURE_TIMEOUT_MS = 1000
+)
+
+var captureKind string = CAPTURE_LIBPCAP
+
+// A packet as captured: Caplen bytes of it in Data, of Len on the wire.
+type capturedPacket struct {
+    Time   time.Time
+    Caplen uint32
+    Len    uint32
+    Data   []byte
+}
+
+type CaptureSource interface {
+    // Next reads the next packet into pkt. pkt.Data is nil if none came
+    // in time; the error is io.EOF at the end of a file.
+    Next(pkt *capturedPacket) error
+    SetFilter(expr string) error
+    // Stats returns the capture's counts since it opened.
+    Stats() (captureStats, error)
+    Close()
+}
+
+// Packets the kernel passed the filter, and those it dropped for want of
+// buffer space, or the interface dropped before that.
+type captureStats struct {
+    received, dropped, ifDropped uint64
+}
+
+func validCapture(kind string) error {
+    switch kind {
+    case CAPTURE_LIBPCAP, CAPTURE_AFPACKET:
+        return nil
+    }
+    return fmt.Errorf("unknown -capture %q", kind)
+}
+
+// openCapture starts capturing on device with the -capture backend.
+func openCapture(device string) (CaptureSource, error) {
+    if captureKind == CAPTURE_AFPACKET {
+        return openAfpacket(device)
+    }
+    handle, err := pcap.OpenLive(device, CAPTURE_SNAPLEN, false, CAPTURE_TIMEOUT_MS*time.Millisecond)
+    if err != nil {
+        return nil, err
+    }
+    return &pcapSource{handle}, nil
+}
+
+func openCaptureFile(path string) (CaptureSource, error) {
+    handle, err := pcap.OpenOffline(path)
+    if err != nil {
+        return nil, err
+    }
+    return &pcapSource{handle}, nil
+}
+
+// pcapSource captures with libpcap, live or from a file.
+type pcapSource struct {
+    handle *pcap.Handle
+}
+
+// Next borrows the packet from libpcap's buffer, without copying it.
+func (s *pcapSource) Next(pkt *capturedPacket) error {
+    data, ci, err := s.handle.ZeroCopyReadPacketData()
+    switch err {
+    case nil:
+    case pcap.NextErrorTimeoutExpired:
+        pkt.Data = nil
+        return nil
+    case io.EOF:
+        pkt.Data = nil
+        return io.EOF
+    default:
+        pkt.Data = nil
+        return err
+    }
+    pkt.Time, pkt.Caplen, pkt.Len = ci.Timestamp, uint32(ci.CaptureLength), uint32(ci.Length)
+    pkt.Data = data
+    return nil
+}
+
+func (s *pcapSource) SetFilter(expr string) error {
+    return s.handle.SetBPFFilter(expr)
+}
+
+func (s *pcapSource) Stats() (captureStats, error) {
+    stat, err := s.handle.Stats()
+    if err != nil {
+        return captureStats{}, err
+    }
+    return captureStats{uint64(stat.PacketsReceived), uint64(stat.PacketsDropped),
+        uint64(stat.PacketsIfDropped)}, nil
+}
+
+func (s *pcapSource) Close() {
+    s.handle.Close()
+}
@@ -0,0 +1,214 @@
+/*
+ * capture_afpacket_linux.go
+ *
+ * The afpacket capture backend: an AF_PACKET socket with a TPACKET_V3
+ * receive ring mapped into the process. The kernel fills blocks of packets
+ * and hands each over whole; packets are read where they lie and the block
+ * goes back once the last of them is done with. The filter is compiled by
+ * libpcap and attached to the socket.
+ */
+
+package main
+
+import (
+    "net"
+    "sync/atomic"
+    "syscall"
+    "time"
+    "unsafe"
+
+    "github.com/google/gopacket/layers"
+    "github.com/google/gopacket/pcap"
+)
+
+const (
+    AFPACKET_BLOCK_SIZE = 1 << 20
+    AFPACKET_BLOCKS     = 32
+    AFPACKET_FRAME_SIZE = 2048 // a hint; V3 packs packets as they come
+    AFPACKET_RETIRE_MS  = 100  // hand over a block that isn't full after this long
+    AFPACKET_SNAPLEN    = 65535
+
+    // From linux/if_packet.h.
+    PACKET_VERSION   = 10
+    TPACKET_V3       = 2
+    TP_STATUS_KERNEL = 0
+    TP_STATUS_USER   = 1
+    PACKET_OUTGOING  = 4
+
+    // Offsets into a block's tpacket_block_desc and a packet's
+    // tpacket3_hdr, which is followed by its sockaddr_ll.
+    BLOCK_STATUS    = 8
+    BLOCK_NUM_PKTS  = 12
+    BLOCK_FIRST_PKT = 16
+    PKT_NEXT        = 0
+    PKT_SEC         = 4
+    PKT_NSEC        = 8
+    PKT_SNAPLEN     = 12
+    PKT_LEN         = 16
+    PKT_MAC         = 24
+    PKT_SLL_PKTTYPE = 48 + 10
+)
+
+// struct tpacket_req3
+type tpacketReq3 struct {
+    blockSize, blockNr, frameSize, frameNr uint32
+    retireBlkTov, sizeofPriv, featureReq   uint32
+}
+
+type afpacketSource struct {
+    fd       int
+    ring     []byte
+    loopback bool // outgoing packets come back in; skip them
+    block    int  // block being read
+    held     bool // we have it, not the kernel
+    pkts     int  // packets left in it
+    offset   int  // of the next one in ring
+    stats    captureStats
+}
+
+func htons(v uint16) uint16 {
+    return v<<8 | v>>8
+}
+
+func openAfpacket(device string) (CaptureSource, error) {
+    ifi, err := net.InterfaceByName(device)
+    if err != nil {
+        return nil, err
+    }
+    fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ALL)))
+    if err != nil {
+        return nil, err
+    }
+    s := &afpacketSource{fd: fd, loopback: ifi.Flags&net.FlagLoopback != 0}
+    if err := s.setup(ifi.Index); err != nil {
+        s.Close()
+        return nil, err
+    }
+    return s, nil
+}
+
+func (s *afpacketSource) setup(ifindex int) error {
+    if err := syscall.SetsockoptInt(s.fd, syscall.SOL_PACKET, PACKET_VERSION, TPACKET_V3); err != nil {
+        return err
+    }
+    req := tpacketReq3{blockSize: AFPACKET_BLOCK_SIZE, blockNr: AFPACKET_BLOCKS,
+        frameSize: AFPACKET_FRAME_SIZE, frameNr: AFPACKET_BLOCK_SIZE / AFPACKET_FRAME_SIZE * AFPACKET_BLOCKS,
+        retireBlkTov: AFPACKET_RETIRE_MS}
+    raw := (*[unsafe.Sizeof(req)]byte)(unsafe.Pointer(&req))[:]
+    if err := syscall.SetsockoptString(s.fd, syscall.SOL_PACKET, syscall.PACKET_RX_RING, string(raw)); err != nil {
+        return err
+    }
+    ring, err := syscall.Mmap(s.fd, 0, AFPACKET_BLOCK_SIZE*AFPACKET_BLOCKS,
+        syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+    if err != nil {
+        return err
+    }
+    s.ring = ring
+    return syscall.Bind(s.fd, &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL),
+        Ifindex: ifindex})
+}
+
+func (s *afpacketSource) u32(off int) uint32 {
+    return *(*uint32)(unsafe.Pointer(&s.ring[off]))
+}
+
+func (s *afpacketSource) Next(pkt *capturedPacket) error {
+    for {
+        if s.pkts == 0 {
+            ready, err := s.nextBlock()
+            if err != nil || !ready {
+                pkt.Data = nil
+                return err
+            }
+            continue
+        }
+        hdr := s.offset
+        s.offset += int(s.u32(hdr + PKT_NEXT))
+        s.pkts--
+        if s.loopback && s.ring[hdr+PKT_SLL_PKTTYPE] == PACKET_OUTGOING {
+            continue
+        }
+        start := hdr + int(*(*uint16)(unsafe.Pointer(&s.ring[hdr+PKT_MAC])))
+        end := start + int(s.u32(hdr+PKT_SNAPLEN))
+        pkt.Time = time.Unix(int64(s.u32(hdr+PKT_SEC)), int64(s.u32(hdr+PKT_NSEC)))
+        pkt.Caplen, pkt.Len = uint32(end-start), s.u32(hdr+PKT_LEN)
+        pkt.Data = s.ring[start:end:end]
+        return nil
+    }
+}
+
+// nextBlock hands the block just read back to the kernel and waits for the
+// next one. It returns false if none came in time.
+func (s *afpacketSource) nextBlock() (bool, error) {
+    if s.held {
+        atomic.StoreUint32((*uint32)(unsafe.Pointer(&s.ring[s.block*AFPACKET_BLOCK_SIZE+BLOCK_STATUS])),
+            TP_STATUS_KERNEL)
+        s.block = (s.block + 1) % AFPACKET_BLOCKS
+        s.held = false
+    }
+    base := s.block * AFPACKET_BLOCK_SIZE
+    status := (*uint32)(unsafe.Pointer(&s.ring[base+BLOCK_STATUS]))
+    if atomic.LoadUint32(status)&TP_STATUS_USER == 0 {
+        if err := s.wait(); err != nil {
+            return false, err
+        }
+        if atomic.LoadUint32(status)&TP_STATUS_USER == 0 {
+            return false, nil
+        }
+    }
+    s.held = true
+    s.pkts = int(s.u32(base + BLOCK_NUM_PKTS))
+    s.offset = base + int(s.u32(base+BLOCK_FIRST_PKT))
+    return true, nil
+}
+
+// wait polls the socket until a block is ready or the capture timeout
+// passes.
+func (s *afpacketSource) wait() error {
+    pfd := struct {
+        fd              int32
+        events, revents int16
+    }{int32(s.fd), 1, 0} // POLLIN
+    ts := syscall.NsecToTimespec(int64(CAPTURE_TIMEOUT_MS * time.Millisecond))
+    _, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd)), 1,
+        uintptr(unsafe.Pointer(&ts)), 0, 0, 0)
+    if errno != 0 && errno != syscall.EINTR {
+        return errno
+    }
+    return nil
+}
+
+func (s *afpacketSource) SetFilter(expr string) error {
+    program, err := pcap.CompileBPFFilter(layers.LinkTypeEthernet, AFPACKET_SNAPLEN, expr)
+    if err != nil {
+        return err
+    }
+    filter := make([]syscall.SockFilter, len(program))
+    for i, insn := range program {
+        filter[i] = syscall.SockFilter{Code: insn.Code, Jt: insn.Jt, Jf: insn.Jf, K: insn.K}
+    }
+    return syscall.AttachLsf(s.fd, filter)
+}
+
+// Stats adds up PACKET_STATISTICS, which the kernel resets on each read.
+// syscall has no getsockopt for structs, but tpacket_stats_v3 is three 32
+// bit counters, laid out as struct ucred is.
+func (s *afpacketSource) Stats() (captureStats, error) {
+    raw, err := syscall.GetsockoptUcred(s.fd, syscall.SOL_PACKET, syscall.PACKET_STATISTICS)
+    if err != nil {
+        return s.stats, err
+    }
+    // tp_packets counts the drops too.
+    packets, drops := uint64(uint32(raw.Pid)), uint64(raw.Uid)
+    s.stats.received += packets - drops
+    s.stats.dropped += drops
+    return s.stats, nil
+}
+
+func (s *afpacketSource) Close() {
+    if s.ring != nil {
+        syscall.Munmap(s.ring)
+        s.ring = nil
+    }
+    syscall.Close(s.fd)
+}
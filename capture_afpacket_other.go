@@ -0,0 +1,17 @@
+//go:build !linux
+
+/*
+ * capture_afpacket_other.go
+ *
+ * AF_PACKET is Linux only.
+ */
+
+package main
+
+import (
+    "fmt"
+)
+
+func openAfpacket(device string) (CaptureSource, error) {
+    return nil, fmt.Errorf("-capture %s is only available on Linux", CAPTURE_AFPACKET)
+}
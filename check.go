@@ -0,0 +1,249 @@
+/*
+ * check.go
+ *
+ * The `check` command (or -validate): resolve and sanity check the whole
+ * configuration without capturing anything, then print it as JSON so what
+ * the sniffer would actually run with is visible up front.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "net/url"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+
+    "github.com/google/gopacket/layers"
+    "github.com/google/gopacket/pcap"
+)
+
+// Flags holding credentials; only file:/env: references are ever printed.
+var secretFlags = map[string]bool{
+    "zmq_password": true, "es_password": true,
+}
+
+// redactSecret hides a literal credential; file: and env: references are fine
+// to show.
+func redactSecret(value string) string {
+    if value == "" || strings.HasPrefix(value, "file:") || strings.HasPrefix(value, "env:") {
+        return value
+    }
+    return "<redacted>"
+}
+
+// redactURL hides the password in a URL's user info.
+func redactURL(value string) string {
+    u, err := url.Parse(value)
+    if err != nil || u.User == nil {
+        return value
+    }
+    if _, ok := u.User.Password(); ok {
+        u.User = url.UserPassword(u.User.Username(), "redacted")
+    }
+    return u.String()
+}
+
+// redactSpec hides the credentials in a sink spec.
+func redactSpec(spec sinkSpec) sinkSpec {
+    spec.Password = redactSecret(spec.Password)
+    spec.Addr = redactURL(spec.Addr)
+    spec.Sign = redactKeySpec(spec.Sign)
+    return spec
+}
+
+// effectiveConfig returns every flag's value after parsing, plus the values
+// derived from them.
+func effectiveConfig() map[string]interface{} {
+    config := make(map[string]interface{})
+    flag.VisitAll(func(f *flag.Flag) {
+        value := f.Value.String()
+        if secretFlags[f.Name] {
+            value = redactSecret(value)
+        } else if f.Name == "es_url" {
+            value = redactURL(value)
+        } else if keySpecFlags[f.Name] {
+            value = redactKeySpec(value)
+        }
+        config[f.Name] = value
+    })
+    config["topic"] = topic
+    config["filter"] = captureFilter()
+    return config
+}
+
+// checkEndpoint makes sure a ZeroMQ endpoint could plausibly be reached.
+func checkEndpoint(endpoint string) error {
+    parts := strings.SplitN(endpoint, "://", 2)
+    if len(parts) != 2 {
+        return fmt.Errorf("%s: missing transport", endpoint)
+    }
+    switch parts[0] {
+    case "tcp":
+        // Strip any "source;" prefix used to pick a local address.
+        addr := parts[1][strings.LastIndex(parts[1], ";")+1:]
+        if _, err := net.ResolveTCPAddr("tcp", addr); err != nil {
+            return err
+        }
+    case "ipc":
+        if _, err := os.Stat(filepath.Dir(parts[1])); err != nil {
+            return err
+        }
+    case "inproc", "pgm", "epgm", "tipc":
+        // nothing useful to check
+    default:
+        return fmt.Errorf("%s: unknown transport %s", endpoint, parts[0])
+    }
+    return nil
+}
+
+// checkCommand makes sure the program a sh -c command line starts exists.
+func checkCommand(command string) error {
+    fields := strings.Fields(command)
+    if len(fields) == 0 {
+        return fmt.Errorf("empty command")
+    }
+    _, err := exec.LookPath(fields[0])
+    return err
+}
+
+// checkDir makes sure the directory a file would be created in exists.
+func checkDir(path string) error {
+    _, err := os.Stat(filepath.Dir(path))
+    return err
+}
+
+// checkSink validates a sink spec and resolves what it points at, without
+// opening or starting anything.
+func checkSink(spec sinkSpec) error {
+    if err := validSinkSpec(spec); err != nil {
+        return err
+    }
+    var err error
+    switch spec.Type {
+    case "zmq":
+        err = checkEndpoint(spec.Addr)
+        if err == nil {
+            _, err = loadSecret("zmq_password", spec.Password)
+        }
+    case "exec":
+        err = checkCommand(spec.Command)
+    case "file":
+        err = checkDir(spec.Path)
+    case "elasticsearch":
+        var u *url.URL
+        if u, err = url.Parse(spec.Addr); err == nil {
+            _, err = net.LookupHost(u.Hostname())
+        }
+        if err == nil {
+            _, err = loadSecret("es_password", spec.Password)
+        }
+    case "statsd":
+        _, err = net.ResolveUDPAddr("udp", spec.Addr)
+    case "sql":
+        if spec.Command != "" {
+            err = checkCommand(spec.Command)
+        } else {
+            err = checkDir(spec.Path)
+        }
+    }
+    if err == nil && spec.Spool != "" {
+        err = checkDir(spec.Spool)
+    }
+    if err == nil && spec.Sign != "" {
+        var alg string
+        var key *secret
+        if alg, key, err = loadKeySpec("sign", spec.Sign); err == nil {
+            _, err = makeSigner(alg, key.Value())
+        }
+    }
+    return err
+}
+
+// runCheck validates the configuration and prints it. It returns false if
+// any check failed.
+func runCheck(eth string) bool {
+    checks := make(map[string]string)
+    ok := true
+    record := func(name string, err error) {
+        if err != nil {
+            checks[name] = err.Error()
+            ok = false
+        } else {
+            checks[name] = "ok"
+        }
+    }
+
+    devs, err := pcap.FindAllDevs()
+    if err == nil {
+        err = fmt.Errorf("no such interface %s", eth)
+        for _, dev := range devs {
+            if dev.Name == eth {
+                err = nil
+                break
+            }
+        }
+    }
+    record("interface", err)
+    _, err = parseFormat(flag.Lookup("f").Value.String())
+    record("format", err)
+    _, err = pcap.CompileBPFFilter(layers.LinkTypeEthernet, CAPTURE_SNAPLEN, captureFilter())
+    record("filter", err)
+    record("top_by", validTopBy(summaryBy))
+    if configFile != "" {
+        _, _, err := readConfig(configFile)
+        record("config", err)
+    }
+    record("sample", validSampleRate(sampleRate))
+    record("schema_names", validSchemaNames(schemaNames))
+    record("capture", validCapture(captureKind))
+    specs := flagSinks()
+    if path := flag.Lookup("sinks").Value.String(); path != "" {
+        var fileSpecs []sinkSpec
+        data, err := ioutil.ReadFile(path)
+        if err == nil {
+            err = json.Unmarshal(data, &fileSpecs)
+        }
+        record("sinks", err)
+        specs = append(specs, fileSpecs...)
+    }
+    for i, spec := range specs {
+        record(fmt.Sprintf("sink %d (%s)", i, spec.Type), checkSink(spec))
+        specs[i] = redactSpec(spec)
+    }
+    if path := flag.Lookup("names").Value.String(); path != "" {
+        record("names", loadNames(path))
+    }
+    if spec := flag.Lookup("literal_profile").Value.String(); spec != "" {
+        record("literal_profile", parseLiteralProfiles(spec))
+    }
+    if path := flag.Lookup("mask_rules").Value.String(); path != "" {
+        record("mask_rules", loadMaskRules(path))
+    }
+    if spec := flag.Lookup("schema_tables").Value.String(); spec != "" {
+        _, err := loadSchemaTables(spec)
+        record("schema_tables", err)
+    }
+    if path := flag.Lookup("tenant_map").Value.String(); path != "" {
+        record("tenant_map", loadTenantMap(path))
+    }
+    if dockerSocket != "" {
+        _, err := listContainers(dockerClient(dockerSocket))
+        record("docker", err)
+    }
+
+    out, _ := json.MarshalIndent(map[string]interface{}{
+        "config": effectiveConfig(),
+        "sinks":  specs,
+        "checks": checks,
+        "ok":     ok,
+    }, "", "  ")
+    fmt.Println(string(out))
+    return ok
+}
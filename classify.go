@@ -0,0 +1,64 @@
+/*
+ * classify.go
+ *
+ * Telling monitoring traffic apart from the application's. Agents like PMM
+ * or Datadog poll information_schema, performance_schema, sys and SHOW
+ * commands constantly, which can crowd real queries out of the top of a
+ * report. Such queries are classed "monitoring"; -hide_monitoring leaves
+ * them out of the per-query reports, counting them separately instead.
+ */
+
+package main
+
+import (
+    "strings"
+)
+
+const CLASS_MONITORING = "monitoring"
+
+// Substrings of a lowercased query that mark it as monitoring.
+var monitoringMarkers = []string{
+    "information_schema.",
+    "performance_schema.",
+    "from sys.",
+    "join sys.",
+    "@@global.",
+    "show global status",
+    "show global variables",
+    "show engine innodb status",
+    "show slave status",
+    "show replica status",
+    "show master status",
+    "show binary logs",
+    "show processlist",
+    "show full processlist",
+}
+
+var hideMonitoring bool = false
+
+// classifyQuery returns the class of a query, or "" for ordinary ones.
+func classifyQuery(query string) string {
+    lower := strings.ToLower(query)
+    for _, marker := range monitoringMarkers {
+        if strings.Contains(lower, marker) {
+            return CLASS_MONITORING
+        }
+    }
+    return ""
+}
+
+// hiddenQuery reports whether a fingerprint is left out of per-query reports.
+func hiddenQuery(qdata *queryData) bool {
+    return hideMonitoring && qdata.class == CLASS_MONITORING
+}
+
+// monitoringTotals counts what the monitoring class accounts for.
+func monitoringTotals() (fingerprints int, queries uint64) {
+    for _, qdata := range qbuf {
+        if qdata.class == CLASS_MONITORING {
+            fingerprints++
+            queries += qdata.count
+        }
+    }
+    return
+}
@@ -0,0 +1,107 @@
+/*
+ * color.go
+ *
+ * Colored output for people watching a terminal: report headings, the
+ * subscribe tail and verbose query logging. Colors are off when the output
+ * isn't a terminal, with -no-color, or when NO_COLOR is set.
+ */
+
+package main
+
+import (
+    "os"
+    "strings"
+)
+
+var colorStdout bool = false
+var colorStderr bool = false
+
+// Keywords highlighted in queries. Words after the ones in tableKeywords are
+// taken to be table names.
+var sqlKeywords = map[string]bool{}
+var tableKeywords = map[string]bool{
+    "FROM": true, "JOIN": true, "INTO": true, "UPDATE": true, "TABLE": true,
+    "STRAIGHT_JOIN": true,
+}
+
+func init() {
+    for _, kw := range strings.Fields(`
+        ALTER AND AS ASC BEGIN BETWEEN BY CALL CASE COMMIT CREATE CROSS DELETE
+        DESC DISTINCT DROP DUPLICATE ELSE END EXISTS EXPLAIN FOR FORCE FROM
+        GROUP HAVING IGNORE IN INDEX INNER INSERT INTO IS JOIN KEY LEFT LIKE
+        LIMIT LOCK NATURAL NOT NULL OFFSET ON OR ORDER OUTER REGEXP REPLACE
+        RIGHT ROLLBACK SELECT SET SHARE SHOW START STRAIGHT_JOIN TABLE THEN
+        TRANSACTION TRUNCATE UNION UPDATE USE USING VALUES WHEN WHERE WITH`) {
+        sqlKeywords[kw] = true
+    }
+}
+
+// isTerminal reports whether f is a character device, i.e. most likely a tty.
+func isTerminal(f *os.File) bool {
+    fi, err := f.Stat()
+    return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// setupColor decides whether to use colors on stdout and stderr.
+func setupColor(disabled bool) {
+    if disabled || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+        return
+    }
+    colorStdout = isTerminal(os.Stdout)
+    colorStderr = isTerminal(os.Stderr)
+}
+
+// color returns c if stdout gets colors, otherwise nothing.
+func color(c string) string {
+    if colorStdout {
+        return c
+    }
+    return ""
+}
+
+// errColor returns c if stderr gets colors, otherwise nothing.
+func errColor(c string) string {
+    if colorStderr {
+        return c
+    }
+    return ""
+}
+
+// highlightSQL colors keywords, placeholders and literals, and table names in
+// a query. It returns the query unchanged if on is false.
+func highlightSQL(query string, on bool) string {
+    if !on {
+        return query
+    }
+    var out []string
+    data := []byte(query)
+    table := false
+    for i := 0; i < len(data); {
+        length, toktype := scanToken(data[i:])
+        tok := string(data[i : i+length])
+        i += length
+
+        switch {
+        case toktype == TOKEN_WORD && sqlKeywords[strings.ToUpper(tok)]:
+            out = append(out, COLOR_YELLOW+tok+COLOR_DEFAULT)
+            table = tableKeywords[strings.ToUpper(tok)]
+            continue
+        case toktype == TOKEN_WORD && table:
+            out = append(out, COLOR_CYAN+tok+COLOR_DEFAULT)
+            continue
+        case toktype == TOKEN_NUMBER || toktype == TOKEN_QUOTE || tok == "?":
+            out = append(out, COLOR_GREEN+tok+COLOR_DEFAULT)
+        case toktype == TOKEN_WHITESPACE:
+            out = append(out, tok)
+            continue
+        default:
+            out = append(out, tok)
+            // Keep going through "db.table", quoting and "a, b" lists.
+            if tok == "." || tok == "," || tok == "`" {
+                continue
+            }
+        }
+        table = false
+    }
+    return strings.Join(out, "")
+}
@@ -0,0 +1,147 @@
+/*
+ * commands.go
+ *
+ * The command mix. Every command a synced client sends is counted by its
+ * command byte, not only the statements: a flood of COM_PING from a pool's
+ * health checks or COM_CHANGE_USER from constant re-logins shows up here
+ * and nowhere else. The counts are on /metrics and in the `commands`
+ * report, and each -interval summary carries the mix over its interval
+ * along with the connections opened.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "sort"
+)
+
+const (
+    COM_QUIT    = 0x01
+    COM_INIT_DB = 0x02
+    COM_PING    = 0x0e
+)
+
+var commandNames = map[int]string{
+    0x00:                    "sleep",
+    COM_QUIT:                "quit",
+    COM_INIT_DB:             "init_db",
+    COM_QUERY:               "query",
+    0x04:                    "field_list",
+    0x05:                    "create_db",
+    0x06:                    "drop_db",
+    0x07:                    "refresh",
+    0x08:                    "shutdown",
+    0x09:                    "statistics",
+    0x0a:                    "process_info",
+    0x0b:                    "connect",
+    0x0c:                    "process_kill",
+    0x0d:                    "debug",
+    COM_PING:                "ping",
+    0x0f:                    "time",
+    0x10:                    "delayed_insert",
+    COM_CHANGE_USER:         "change_user",
+    0x12:                    "binlog_dump",
+    0x13:                    "table_dump",
+    0x14:                    "connect_out",
+    0x15:                    "register_slave",
+    COM_STMT_PREPARE:        "stmt_prepare",
+    COM_STMT_EXECUTE:        "stmt_execute",
+    COM_STMT_SEND_LONG_DATA: "stmt_send_long_data",
+    COM_STMT_CLOSE:          "stmt_close",
+    COM_STMT_RESET:          "stmt_reset",
+    0x1b:                    "set_option",
+    0x1c:                    "stmt_fetch",
+    0x1d:                    "daemon",
+    0x1e:                    "binlog_dump_gtid",
+    COM_RESET_CONNECTION:    "reset_connection",
+}
+
+var commandCounts [256]uint64
+var commandsLast [256]uint64
+var connectsLast uint64
+
+func commandName(ptype int) string {
+    if name, ok := commandNames[ptype]; ok {
+        return name
+    }
+    return fmt.Sprintf("0x%02x", ptype)
+}
+
+// syncCommand says if an unsynced stream can start following the commands
+// at this one. Only commands that can't be mistaken for the middle of
+// something else qualify.
+func syncCommand(ptype int, pdata []byte) bool {
+    switch ptype {
+    case COM_QUERY, COM_RESET_CONNECTION:
+        return true
+    case COM_PING:
+        return len(pdata) == 0
+    case COM_INIT_DB:
+        return len(pdata) > 0 && len(pdata) <= 64
+    }
+    return false
+}
+
+func noteCommand(ptype int) {
+    if ptype >= 0 && ptype < len(commandCounts) {
+        commandCounts[ptype]++
+    }
+}
+
+// commandMix returns the commands counted since the last call, by name.
+// Must be called with stateLock held.
+func commandMix() (map[string]uint64, uint64) {
+    mix := make(map[string]uint64)
+    for ptype, n := range commandCounts {
+        if n > commandsLast[ptype] {
+            mix[commandName(ptype)] = n - commandsLast[ptype]
+        }
+    }
+    commandsLast = commandCounts
+    connects := stats.connects - connectsLast
+    connectsLast = stats.connects
+    return mix, connects
+}
+
+func writeCommandMetrics(w io.Writer) {
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_commands_total counter\n")
+    for ptype, n := range commandCounts {
+        if n > 0 {
+            fmt.Fprintf(w, "mysql_sniffer_commands_total{%s} %d\n",
+                metricLabels("command", commandName(ptype)), n)
+        }
+    }
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_connects_total counter\nmysql_sniffer_connects_total %d\n",
+        stats.connects)
+}
+
+func reportCommands(w io.Writer, asJSON bool) {
+    var list sortableSlice
+    var total uint64
+    for ptype, n := range commandCounts {
+        if n > 0 {
+            list = append(list, sortable{value: -float64(n), line: commandName(ptype)})
+            total += n
+        }
+    }
+    sort.Sort(list)
+    if asJSON {
+        counts := make(map[string]uint64)
+        for _, item := range list {
+            counts[item.line] = uint64(-item.value)
+        }
+        json.NewEncoder(w).Encode(map[string]interface{}{"commands": counts,
+            "connects": stats.connects})
+        return
+    }
+    fmt.Fprintf(w, "%s== commands (%d, %d connects)%s\n", color(COLOR_CYAN), total, stats.connects,
+        color(COLOR_DEFAULT))
+    for _, item := range list {
+        n := uint64(-item.value)
+        fmt.Fprintf(w, "%10d %6.2f%%  %s\n", n, float64(n)*100/float64(total), item.line)
+    }
+    fmt.Fprintf(w, "\n")
+}
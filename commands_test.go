@@ -0,0 +1,34 @@
+/*
+ * commands_test.go
+ *
+ * The mix of commands, and which of them are sync points.
+ */
+
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestCommandMix(t *testing.T) {
+    resetState()
+    commandCounts, commandsLast = [256]uint64{}, [256]uint64{}
+    rs := newSource("10.0.0.1:50000")
+    processPacket(rs.src, rs, true, []byte{0x05, 0, 0, 0, COM_STMT_CLOSE, 1, 0, 0, 0}) // not a sync point
+    if rs.synced {
+        t.Fatalf("synced on COM_STMT_CLOSE")
+    }
+    processPacket(rs.src, rs, true, []byte{0x01, 0, 0, 0, COM_PING})
+    processPacket(rs.src, rs, false, []byte{0x07, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0})
+    processPacket(rs.src, rs, true, []byte{0x01, 0, 0, 0, COM_PING})
+    processPacket(rs.src, rs, true, append([]byte{0x09, 0, 0, 0, COM_QUERY}, "SELECT 1"...))
+    mix, _ := commandMix()
+    if !rs.synced || !reflect.DeepEqual(mix, map[string]uint64{"ping": 2, "query": 1}) {
+        t.Errorf("synced %v, mix %v", rs.synced, mix)
+    }
+    if mix, _ := commandMix(); len(mix) != 0 {
+        t.Errorf("mix not reset: %v", mix)
+    }
+    commandCounts, commandsLast = [256]uint64{}, [256]uint64{}
+}
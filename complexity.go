@@ -0,0 +1,88 @@
+/*
+ * complexity.go
+ *
+ * Rough complexity features of a fingerprint, worked out once when it is
+ * first seen and published with each of its queries, so consumers can look
+ * at latency against how much a query asks of the server: its canonical
+ * length, JOINs, how deeply subqueries nest, how many predicates the WHERE,
+ * ON and HAVING clauses have, and whether it sorts or groups.
+ */
+
+package main
+
+import (
+    "strings"
+)
+
+type queryComplexity struct {
+    length     int
+    joins      int
+    depth      int // of nested subqueries, 0 for none
+    predicates int
+    orderBy    bool
+    groupBy    bool
+}
+
+// measureComplexity looks at a canonical query.
+func measureComplexity(query string) queryComplexity {
+    c := queryComplexity{length: len(query)}
+    var parens []bool // open parentheses, true for those holding a subquery
+    inPredicate := false
+    prev := ""
+    data := []byte(query)
+    for i := 0; i < len(data); {
+        length, toktype := scanToken(data[i:])
+        word := strings.ToLower(string(data[i : i+length]))
+        i += length
+        if toktype == TOKEN_WHITESPACE {
+            continue
+        }
+
+        switch word {
+        case "(":
+            parens = append(parens, false)
+        case ")":
+            if len(parens) > 0 {
+                parens = parens[:len(parens)-1]
+            }
+        case "select":
+            if len(parens) > 0 && !parens[len(parens)-1] {
+                parens[len(parens)-1] = true
+            }
+            depth := 0
+            for _, sub := range parens {
+                if sub {
+                    depth++
+                }
+            }
+            if depth > c.depth {
+                c.depth = depth
+            }
+            inPredicate = false
+        case "join":
+            c.joins++
+        case "where", "on", "having":
+            inPredicate = true
+        case "by":
+            if prev == "order" {
+                c.orderBy = true
+            } else if prev == "group" {
+                c.groupBy = true
+            }
+            inPredicate = false
+        case "set", "limit":
+            inPredicate = false
+        case "=", "<", ">", "!":
+            // <=, <> and != are one predicate.
+            if inPredicate && prev != "<" && prev != ">" && prev != "!" {
+                c.predicates++
+            }
+        case "like", "in", "between", "is", "exists":
+            if inPredicate {
+                c.predicates++
+            }
+        }
+        prev = word
+    }
+    return c
+}
@@ -0,0 +1,21 @@
+/*
+ * complexity_test.go
+ *
+ * Complexity of a statement, from its fingerprint.
+ */
+
+package main
+
+import (
+    "testing"
+)
+
+func TestMeasureComplexity(t *testing.T) {
+    c := measureComplexity("SELECT a.id, COUNT(*) FROM a JOIN b ON a.id = b.a_id LEFT JOIN c ON c.id = b.c_id " +
+        "WHERE a.x >= ? AND a.y <> ? AND a.z NOT IN (SELECT id FROM d WHERE e IN (SELECT id FROM f)) " +
+        "GROUP BY a.id ORDER BY a.id LIMIT ?")
+    want := queryComplexity{length: c.length, joins: 2, depth: 2, predicates: 6, orderBy: true, groupBy: true}
+    if c != want {
+        t.Errorf("got %+v, want %+v", c, want)
+    }
+}
@@ -0,0 +1,120 @@
+/*
+ * compress.go
+ *
+ * The compressed protocol (mysql --compress). If the client's login asks for
+ * compression, everything after the server's OK to it travels in frames of
+ *
+ *   3 bytes compressed length, 1 byte sequence, 3 bytes uncompressed length
+ *
+ * followed by the payload: one or more ordinary packets, deflated with zlib
+ * (or, with MySQL 8's zstd compression, a zstd frame) unless the
+ * uncompressed length is 0, in which case they are sent as is. We inflate
+ * the frames of each direction and hand the packets in them on as if they
+ * had come uncompressed.
+ */
+
+package main
+
+import (
+    "bytes"
+    "compress/zlib"
+    "io"
+
+    "github.com/klauspost/compress/zstd"
+)
+
+const (
+    COMPRESS_NONE = iota
+    COMPRESS_ZLIB
+    COMPRESS_ZSTD
+
+    COMPRESS_HEADER = 7
+)
+
+// zstdDecoder inflates the zstd frames of every stream. A frame holds at
+// most 16MB, the largest uncompressed length a header can give.
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(1<<24))
+
+// loginCompression returns the compression a client's handshake response
+// (with its header) asks for.
+func loginCompression(data []byte) int {
+    if len(data) < 8 || data[3] != 1 {
+        return COMPRESS_NONE
+    }
+    caps := uint32(data[4]) | uint32(data[5])<<8 | uint32(data[6])<<16 | uint32(data[7])<<24
+    switch {
+    case caps&CLIENT_COMPRESS != 0:
+        return COMPRESS_ZLIB
+    case caps&CLIENT_ZSTD_COMPRESSION_ALGORITHM != 0:
+        return COMPRESS_ZSTD
+    }
+    return COMPRESS_NONE
+}
+
+// noteCompression switches rs to compressed frames once the server accepts a
+// login that asked for them. Called for each response before sync.
+func noteCompression(rs *source, data []byte) {
+    // The OK to the login, possibly after an auth switch, is the last
+    // uncompressed packet.
+    if rs.compress == COMPRESS_NONE || rs.compressed || len(data) < 5 || data[3] < 2 || data[4] != 0x00 {
+        return
+    }
+    rs.compressed = true
+    stats.compressed++
+    if rs.compress == COMPRESS_ZSTD {
+        stats.zstd++
+    }
+}
+
+// inflate returns the ulen bytes a compressed frame's payload holds.
+func inflate(compress int, payload []byte, ulen int) ([]byte, bool) {
+    if compress == COMPRESS_ZSTD {
+        inflated, err := zstdDecoder.DecodeAll(payload, make([]byte, 0, ulen))
+        return inflated, err == nil && len(inflated) == ulen
+    }
+    r, err := zlib.NewReader(bytes.NewReader(payload))
+    if err != nil {
+        return nil, false
+    }
+    defer r.Close()
+    inflated := make([]byte, ulen)
+    _, err = io.ReadFull(r, inflated)
+    return inflated, err == nil
+}
+
+// inflateFrames adds a segment of compressed frames to what's pending in its
+// direction and returns the packets in the frames now complete, or false if
+// there are none yet.
+func inflateFrames(rs *source, request bool, data []byte) ([]byte, bool) {
+    pending := &rs.zres
+    if request {
+        pending = &rs.zreq
+    }
+    buf := append(*pending, data...)
+    var out []byte
+    for len(buf) >= COMPRESS_HEADER {
+        clen := int(buf[0]) | int(buf[1])<<8 | int(buf[2])<<16
+        ulen := int(buf[4]) | int(buf[5])<<8 | int(buf[6])<<16
+        if len(buf) < COMPRESS_HEADER+clen {
+            break
+        }
+        payload := buf[COMPRESS_HEADER : COMPRESS_HEADER+clen]
+        buf = buf[COMPRESS_HEADER+clen:]
+        if ulen == 0 {
+            out = append(out, payload...)
+            continue
+        }
+        inflated, ok := inflate(rs.compress, payload, ulen)
+        if !ok {
+            stats.desyncs++
+            *pending = nil
+            return nil, false
+        }
+        out = append(out, inflated...)
+    }
+    *pending = append([]byte(nil), buf...)
+    if len(*pending) == 0 {
+        *pending = nil
+    }
+    return out, len(out) > 0
+}
@@ -0,0 +1,88 @@
+/*
+ * compress_test.go
+ *
+ * Decoding the compressed protocol.
+ */
+
+package main
+
+import (
+    "bytes"
+    "compress/zlib"
+    "testing"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+    "github.com/klauspost/compress/zstd"
+)
+
+// compressFrame wraps packets in a compressed protocol frame, compressing
+// them with zlib or zstd unless compress is COMPRESS_NONE.
+func compressFrame(seq byte, packets []byte, compress int) []byte {
+    payload, ulen := packets, 0
+    switch compress {
+    case COMPRESS_ZLIB:
+        var buf bytes.Buffer
+        zw := zlib.NewWriter(&buf)
+        zw.Write(packets)
+        zw.Close()
+        payload, ulen = buf.Bytes(), len(packets)
+    case COMPRESS_ZSTD:
+        zw, _ := zstd.NewWriter(nil)
+        payload, ulen = zw.EncodeAll(packets, nil), len(packets)
+        zw.Close()
+    }
+    frame := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), seq,
+        byte(ulen), byte(ulen >> 8), byte(ulen >> 16)}
+    return append(frame, payload...)
+}
+
+func TestCompressedProtocol(t *testing.T) {
+    for _, compress := range []int{COMPRESS_ZLIB, COMPRESS_ZSTD} {
+        mem := resetState()
+        desyncs := stats.desyncs
+        s, c := newTestSoaker()
+        c.port++
+        c.cseq, c.sseq, c.open = 1, 1, true
+        s.packet(c, true, TCP_SYN, nil)
+        s.packet(c, false, TCP_SYN|TCP_ACK, nil)
+        s.packet(c, false, TCP_ACK, mysqlPacket(0, fakeGreeting()))
+        login := fakeLogin("zipped")
+        if compress == COMPRESS_ZLIB {
+            login[0] |= CLIENT_COMPRESS
+        } else {
+            login[3] |= CLIENT_ZSTD_COMPRESSION_ALGORITHM >> 24
+        }
+        s.packet(c, true, TCP_ACK, mysqlPacket(1, login))
+        s.packet(c, false, TCP_ACK, mysqlPacket(2, selftestOK))
+
+        query := mysqlPacket(0, append([]byte{COM_QUERY}, "SELECT name FROM users WHERE id = 1"...))
+        frame := compressFrame(0, query, compress)
+        // Split across two segments.
+        s.packet(c, true, TCP_ACK, frame[:5])
+        s.packet(c, true, TCP_ACK, frame[5:])
+        s.now = s.now.Add(2 * time.Millisecond)
+        s.packet(c, false, TCP_ACK, compressFrame(1, mysqlPacket(1, selftestOK), COMPRESS_NONE))
+
+        if stats.desyncs != desyncs || len(mem.payloads) != 1 {
+            t.Fatalf("compression %d: %d desyncs, %d published", compress, stats.desyncs-desyncs, len(mem.payloads))
+        }
+        ev, err := event.DecodeQuery(mem.payloads[0])
+        if err != nil {
+            t.Fatal(err)
+        }
+        if ev.Sql != "SELECT name FROM users WHERE id = ?" || ev.User != "zipped" || ev.Time == nil || *ev.Time != 2000 {
+            t.Errorf("compression %d: published %+v", compress, ev)
+        }
+    }
+
+    // A zstd frame that doesn't hold what its header says is a desync.
+    rs := &source{compress: COMPRESS_ZSTD}
+    frame := compressFrame(0, mysqlPacket(0, []byte{COM_PING}), COMPRESS_ZSTD)
+    frame[4]++
+    desyncs := stats.desyncs
+    if _, ok := inflateFrames(rs, true, frame); ok || stats.desyncs != desyncs+1 {
+        t.Errorf("short zstd frame accepted")
+    }
+    stats.desyncs = desyncs
+}
@@ -0,0 +1,325 @@
+/*
+ * config.go
+ *
+ * A configuration file for the flags (-config). Each line sets one flag by
+ * name, as `name = value` or `name: value`, so simple TOML and YAML files
+ * both read; values may be quoted and # starts a comment. Flags given on the
+ * command line win over the file.
+ *
+ * Sinks beyond the zmq, exec, file, es, statsd and sql flags go in TOML
+ * [[sink]] tables, one per sink, with the keys of a -sinks file entry:
+ *
+ *   [[sink]]
+ *   type = "zmq"
+ *   addr = "tcp://10.0.0.1:7388"
+ *   batch = 100
+ *
+ * They are used along with those the flags ask for. Once a table starts,
+ * every setting up to the next one belongs to it, as in TOML. Other tables
+ * and lists aren't supported.
+ *
+ * SIGHUP reads the file again and applies the settings that can change
+ * without restarting the capture: the capture filter (-P, -bpf), sampling,
+ * slow query and monitoring filters and the sinks. Any other change is
+ * logged and waits for a restart.
+ */
+
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var configFile string
+
+// configSinks are the sinks of the config file's [[sink]] tables.
+var configSinks []sinkSpec
+
+// commandLine holds the flags given on the command line.
+var commandLine map[string]bool = make(map[string]bool)
+
+// The flags a reload applies.
+var reloadable = map[string]bool{
+    "P": true, "bpf": true, "sample": true, "max_qps": true, "slow_ms": true, "hide_monitoring": true,
+}
+
+// The flags the sinks are built from.
+var sinkFlags = map[string]bool{
+    "sinks": true, "zmq_addr": true, "zmq_user": true, "zmq_password": true,
+    "zmq_batch": true, "zmq_compress": true, "zmq_format": true, "spool_dir": true,
+    "spool_max_mb": true, "spool_max_age": true, "sign": true, "exec": true, "out": true,
+    "out_rotate_mb": true, "out_rotate_age": true, "out_keep": true, "es_url": true,
+    "es_index": true, "es_user": true, "es_password": true, "statsd_addr": true,
+    "statsd_prefix": true, "statsd_tags": true, "sql_table": true, "sql_out": true,
+    "sql_exec": true,
+}
+
+// parseConfig reads name/value pairs from a config file, and the sinks of
+// its [[sink]] tables.
+func parseConfig(r io.Reader) (map[string]string, []sinkSpec, error) {
+    settings := make(map[string]string)
+    var specs []sinkSpec
+    var table map[string]json.RawMessage
+    endTable := func(n int) error {
+        if table == nil {
+            return nil
+        }
+        spec, err := tableSink(table)
+        if err != nil {
+            return fmt.Errorf("line %d: sink: %s", n, err)
+        }
+        specs = append(specs, spec)
+        return nil
+    }
+    scanner := bufio.NewScanner(r)
+    n := 1
+    for ; scanner.Scan(); n++ {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || line[0] == '#' || line == "---" {
+            continue
+        }
+        if line[0] == '[' {
+            if j := strings.Index(line, " #"); j >= 0 {
+                line = strings.TrimSpace(line[:j])
+            }
+            if line != "[[sink]]" {
+                return nil, nil, fmt.Errorf("line %d: only [[sink]] tables are supported", n)
+            }
+            if err := endTable(n - 1); err != nil {
+                return nil, nil, err
+            }
+            table = make(map[string]json.RawMessage)
+            continue
+        }
+        i := strings.IndexAny(line, "=:")
+        if i <= 0 {
+            return nil, nil, fmt.Errorf("line %d: expected name = value", n)
+        }
+        name := strings.TrimSpace(line[:i])
+        value := strings.TrimSpace(line[i+1:])
+        quoted := len(value) > 0 && (value[0] == '"' || value[0] == '\'')
+        if quoted {
+            end := strings.IndexByte(value[1:], value[0])
+            if end < 0 {
+                return nil, nil, fmt.Errorf("line %d: unterminated quote", n)
+            }
+            value = value[1 : end+1]
+        } else if j := strings.Index(value, " #"); j >= 0 {
+            value = strings.TrimSpace(value[:j])
+        }
+        if table == nil {
+            settings[name] = value
+            continue
+        }
+        if _, err := strconv.ParseFloat(value, 64); !quoted && (err == nil || value == "true" || value == "false") {
+            table[name] = json.RawMessage(value)
+        } else {
+            table[name], _ = json.Marshal(value)
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, nil, err
+    }
+    if err := endTable(n - 1); err != nil {
+        return nil, nil, err
+    }
+    return settings, specs, nil
+}
+
+// tableSink decodes a [[sink]] table as a -sinks file entry.
+func tableSink(table map[string]json.RawMessage) (sinkSpec, error) {
+    var spec sinkSpec
+    doc, _ := json.Marshal(table)
+    dec := json.NewDecoder(bytes.NewReader(doc))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&spec); err != nil {
+        return spec, err
+    }
+    if spec.Type == "" {
+        return spec, fmt.Errorf("no type")
+    }
+    return spec, nil
+}
+
+func readConfig(path string) (map[string]string, []sinkSpec, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer f.Close()
+    settings, specs, err := parseConfig(f)
+    if err != nil {
+        return nil, nil, err
+    }
+    for name := range settings {
+        if flag.Lookup(name) == nil {
+            return nil, nil, fmt.Errorf("unknown setting %s", name)
+        }
+    }
+    return settings, specs, nil
+}
+
+// sameSinks reports whether two lists of sink specs are the same.
+func sameSinks(a, b []sinkSpec) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// applyConfig sets the flags from the config file at path that weren't
+// given on the command line. Called once, right after parsing the flags.
+func applyConfig(path string) error {
+    flag.Visit(func(f *flag.Flag) {
+        commandLine[f.Name] = true
+    })
+    settings, specs, err := readConfig(path)
+    if err != nil {
+        return err
+    }
+    configSinks = specs
+    for name, value := range settings {
+        if commandLine[name] {
+            continue
+        }
+        if err := flag.Set(name, value); err != nil {
+            return fmt.Errorf("%s: %s", name, err)
+        }
+    }
+    return nil
+}
+
+func flagValue(name string) interface{} {
+    return flag.Lookup(name).Value.(flag.Getter).Get()
+}
+
+// flagSinks returns the sinks the flags and the config file's [[sink]]
+// tables ask for.
+func flagSinks() []sinkSpec {
+    var specs []sinkSpec
+    sign := flagValue("sign").(string)
+    if addr := flagValue("zmq_addr").(string); addr != "" {
+        specs = append(specs, sinkSpec{Type: "zmq", Addr: addr,
+            User: flagValue("zmq_user").(string), Password: flagValue("zmq_password").(string),
+            Batch: flagValue("zmq_batch").(int), Compress: flagValue("zmq_compress").(string),
+            Format: flagValue("zmq_format").(string),
+            Spool: flagValue("spool_dir").(string), SpoolMaxMB: flagValue("spool_max_mb").(int),
+            SpoolMaxAge: flagValue("spool_max_age").(string), Sign: sign})
+    }
+    if cmd := flagValue("exec").(string); cmd != "" {
+        specs = append(specs, sinkSpec{Type: "exec", Command: cmd, Sign: sign})
+    }
+    if path := flagValue("out").(string); path != "" {
+        specs = append(specs, sinkSpec{Type: "file", Path: path,
+            RotateMB: flagValue("out_rotate_mb").(int), RotateAge: flagValue("out_rotate_age").(string),
+            Keep: flagValue("out_keep").(int), Sign: sign})
+    }
+    if addr := flagValue("es_url").(string); addr != "" {
+        specs = append(specs, sinkSpec{Type: "elasticsearch", Addr: addr,
+            Index: flagValue("es_index").(string), User: flagValue("es_user").(string),
+            Password: flagValue("es_password").(string), Sign: sign})
+    }
+    if addr := flagValue("statsd_addr").(string); addr != "" {
+        specs = append(specs, sinkSpec{Type: "statsd", Addr: addr,
+            Prefix: flagValue("statsd_prefix").(string), NoTags: !flagValue("statsd_tags").(bool)})
+    }
+    if table := flagValue("sql_table").(string); table != "" {
+        specs = append(specs, sinkSpec{Type: "sql", Table: table, Path: flagValue("sql_out").(string),
+            Command: flagValue("sql_exec").(string)})
+    }
+    return append(specs, configSinks...)
+}
+
+// reloadConfig reads the config file again and applies what it can. On an
+// error nothing changes.
+func reloadConfig() error {
+    settings, specs, err := readConfig(configFile)
+    if err != nil {
+        return err
+    }
+    old := make(map[string]string)
+    oldSinks := configSinks
+    restore := func() {
+        for name, value := range old {
+            flag.Set(name, value)
+        }
+        configSinks = oldSinks
+    }
+    sinksChanged := !sameSinks(specs, configSinks)
+    configSinks = specs
+    // A setting taken out of the file goes back to its default.
+    for name := range reloadable {
+        if _, ok := settings[name]; !ok {
+            settings[name] = flag.Lookup(name).DefValue
+        }
+    }
+    for name := range sinkFlags {
+        if _, ok := settings[name]; !ok {
+            settings[name] = flag.Lookup(name).DefValue
+        }
+    }
+    for name, value := range settings {
+        f := flag.Lookup(name)
+        if commandLine[name] || f.Value.String() == value {
+            continue
+        }
+        if !reloadable[name] && !sinkFlags[name] {
+            log.Printf("Config: %s changed, restart to apply", name)
+            continue
+        }
+        old[name] = f.Value.String()
+        if err := flag.Set(name, value); err != nil {
+            restore()
+            return fmt.Errorf("%s: %s", name, err)
+        }
+        sinksChanged = sinksChanged || sinkFlags[name]
+    }
+    if err := validSampleRate(flagValue("sample").(float64)); err != nil {
+        restore()
+        return err
+    }
+
+    if sinksChanged {
+        sinksFile = flagValue("sinks").(string)
+        if sinksFile != "" {
+            err = loadSinksFile(sinksFile)
+        } else {
+            err = replaceSinks(flagSinks())
+        }
+        if err != nil {
+            restore()
+            return err
+        }
+    }
+
+    stateLock.Lock()
+    defer stateLock.Unlock()
+    if p := uint16(flagValue("P").(int)); p != port {
+        port = p
+        filterStale = true
+    }
+    if f := flagValue("bpf").(string); f != userFilter {
+        userFilter = f
+        filterStale = true
+    }
+    sampleRate = flagValue("sample").(float64)
+    maxQPS = flagValue("max_qps").(int)
+    slowThreshold = time.Duration(flagValue("slow_ms").(float64) * float64(time.Millisecond))
+    hideMonitoring = flagValue("hide_monitoring").(bool)
+    return nil
+}
@@ -0,0 +1,175 @@
+/*
+ * config_test.go
+ *
+ * Reading, checking and reloading the config file.
+ */
+
+package main
+
+import (
+    "flag"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParseConfig(t *testing.T) {
+    settings, specs, err := parseConfig(strings.NewReader(`# TOML
+zmq_addr = "tcp://10.0.0.1:7388"  # broker
+sample = 0.5
+---
+slow_ms: 250 # YAML
+topic: 'a # b'
+`))
+    want := map[string]string{"zmq_addr": "tcp://10.0.0.1:7388", "sample": "0.5",
+        "slow_ms": "250", "topic": "a # b"}
+    if err != nil || !reflect.DeepEqual(settings, want) || specs != nil {
+        t.Errorf("got %v, %v, %v", settings, specs, err)
+    }
+    for _, bad := range []string{"[sinks]\n", "[[sink]]\naddr = \"x\"\n", "[[sink]]\ntype = \"file\"\nrotate = 1\n",
+        "[[sink]]\ntype = \"zmq\"\nbatch = \"ten\"\n"} {
+        if _, _, err := parseConfig(strings.NewReader(bad)); err == nil {
+            t.Errorf("%q accepted", bad)
+        }
+    }
+}
+
+func TestConfigSinks(t *testing.T) {
+    dir, err := ioutil.TempDir("", "config")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    settings, specs, err := parseConfig(strings.NewReader(`slow_ms = 250
+
+[[sink]]
+type = "file"
+path = "`+filepath.Join(dir, "events.log")+`"
+rotate_mb = 64
+redact = "fingerprint" # no literals on disk
+
+[[sink]]
+type = "statsd"
+addr = "127.0.0.1:8125"
+no_tags = true
+`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []sinkSpec{
+        {Type: "file", Path: filepath.Join(dir, "events.log"), RotateMB: 64, Redact: REDACT_FINGERPRINT},
+        {Type: "statsd", Addr: "127.0.0.1:8125", NoTags: true},
+    }
+    if settings["slow_ms"] != "250" || !reflect.DeepEqual(specs, want) {
+        t.Fatalf("got %v, %+v", settings, specs)
+    }
+
+    resetState()
+    defer replaceSinks(nil)
+    if err := replaceSinks(specs); err != nil {
+        t.Fatal(err)
+    }
+    if len(sinks) != 2 || sinks[0].String() != "file:"+filepath.Join(dir, "events.log") ||
+        sinkSpecs[0].Redact != REDACT_FINGERPRINT {
+        t.Errorf("sinks %v", sinks)
+    }
+}
+
+func TestCheckSink(t *testing.T) {
+    dir, err := ioutil.TempDir("", "check")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "events.log")
+    good := []sinkSpec{
+        {Type: "file", Path: path, RotateAge: "24h"},
+        {Type: "statsd", Addr: "127.0.0.1:8125"},
+        {Type: "sql", Table: "stats.top", Command: "cat"},
+        {Type: "zmq", Addr: "tcp://127.0.0.1:7388", Batch: 10, Compress: "gzip", Format: "cbor"},
+        {Type: "exec", Command: "cat > /dev/null", Sign: "hmac-sha256:env:PATH"},
+    }
+    for _, spec := range good {
+        if err := checkSink(spec); err != nil {
+            t.Errorf("%+v: %s", spec, err)
+        }
+    }
+    if _, err := os.Stat(path); !os.IsNotExist(err) {
+        t.Errorf("check opened %s", path)
+    }
+    bad := []sinkSpec{
+        {Type: "file", Path: filepath.Join(dir, "missing", "events.log")},
+        {Type: "file", Path: path, RotateAge: "daily"},
+        {Type: "file", Path: path, Redact: "some"},
+        {Type: "zmq", Addr: "tcp://127.0.0.1:7388", Compress: "lz4"},
+        {Type: "zmq", Addr: "tcp://127.0.0.1:7388", Password: "env:CHECK_NO_SUCH_VAR"},
+        {Type: "elasticsearch", Addr: "es:9200"},
+        {Type: "statsd", Addr: "127.0.0.1"},
+        {Type: "sql", Table: "top", Command: "no-such-command-anywhere"},
+        {Type: "exec", Command: "cat", Sign: "rot13:env:PATH"},
+    }
+    for _, spec := range bad {
+        if err := checkSink(spec); err == nil {
+            t.Errorf("%+v passed", spec)
+        }
+    }
+}
+
+// reloadFlags registers what reloadConfig reads on a fresh flag set.
+func reloadFlags() *flag.FlagSet {
+    fs := flag.NewFlagSet("test", flag.ContinueOnError)
+    fs.Int("P", 3306, "")
+    fs.String("bpf", "", "")
+    fs.Float64("sample", 1, "")
+    fs.Int("max_qps", 0, "")
+    fs.Float64("slow_ms", 0, "")
+    fs.Bool("hide_monitoring", false, "")
+    for name := range sinkFlags {
+        switch name {
+        case "zmq_batch", "spool_max_mb", "out_rotate_mb", "out_keep":
+            fs.Int(name, 0, "")
+        case "statsd_tags":
+            fs.Bool(name, true, "")
+        default:
+            fs.String(name, "", "")
+        }
+    }
+    return fs
+}
+
+func TestReloadConfig(t *testing.T) {
+    dir, err := ioutil.TempDir("", "config")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    defer func(fs *flag.FlagSet, cl map[string]bool) {
+        flag.CommandLine, commandLine, configFile = fs, cl, ""
+        slowThreshold, userFilter, maxQPS, filterStale = 0, "", 0, false
+    }(flag.CommandLine, commandLine)
+    flag.CommandLine = reloadFlags()
+    flag.Set("max_qps", "5")
+    commandLine = map[string]bool{"max_qps": true}
+    configFile = filepath.Join(dir, "sniffer.conf")
+
+    write := func(config string) {
+        if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+            t.Fatal(err)
+        }
+        if err := reloadConfig(); err != nil {
+            t.Fatal(err)
+        }
+    }
+    write("slow_ms = 250\nbpf = \"net 10.0.0.0/8\"\nmax_qps = 100\n")
+    if slowThreshold != 250*time.Millisecond || userFilter != "net 10.0.0.0/8" || maxQPS != 5 {
+        t.Fatalf("reloaded to slow %s, bpf %q, max_qps %d", slowThreshold, userFilter, maxQPS)
+    }
+    write("bpf = \"net 10.0.0.0/8\"\n")
+    if slowThreshold != 0 || userFilter != "net 10.0.0.0/8" || maxQPS != 5 {
+        t.Errorf("after removing slow_ms: slow %s, bpf %q, max_qps %d", slowThreshold, userFilter, maxQPS)
+    }
+}
@@ -0,0 +1,133 @@
+/*
+ * connrate.go
+ *
+ * Connect storms. A pool that doesn't pool, or a fleet restarting at once,
+ * shows up as a flood of new connections long before the server's
+ * Connections counter is graphed. Every -connect_window we count the SYNs
+ * to the server, overall and per client IP, and publish an alert when the
+ * rate goes over -max_connect_rate (connects a second, overall) or
+ * -max_client_connect_rate (per client). Each alert names the clients
+ * connecting most. An alert is raised when a rate goes over its limit and
+ * not again until it has been back under for a window.
+ *
+ *   mysql-sniffer -connect_window 10s -max_connect_rate 200 -max_client_connect_rate 50
+ */
+
+package main
+
+import (
+    "fmt"
+    "io"
+    "log"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+// Clients counted per window; further ones are lumped together.
+const CONNECT_MAX_CLIENTS = 10000
+const CONNECT_TOP = 5
+
+var connectWindow time.Duration
+var maxConnectRate float64
+var maxClientConnectRate float64
+
+var connectsByClient map[string]uint64 = make(map[string]uint64)
+var connectStorm bool
+var clientStorms map[string]bool = make(map[string]bool)
+
+// The rates over the last window, for /metrics.
+var connectRate float64
+var clientConnectRates []sortable
+
+// noteConnect counts a SYN from client to the server.
+func noteConnect(client string) {
+    ip := clientIP(client)
+    if _, ok := connectsByClient[ip]; !ok && len(connectsByClient) >= CONNECT_MAX_CLIENTS {
+        ip = ATTRIBUTION_OTHER
+    }
+    connectsByClient[ip]++
+}
+
+// checkConnectRates closes a window of secs seconds, alerting on the rates
+// over their limits. Must be called with stateLock held.
+func checkConnectRates(secs float64) {
+    var total uint64
+    var list sortableSlice
+    for ip, n := range connectsByClient {
+        total += n
+        list = append(list, sortable{value: -float64(n) / secs, line: ip})
+    }
+    sort.Sort(list)
+    connectsByClient = make(map[string]uint64)
+    connectRate = float64(total) / secs
+    clientConnectRates = list
+
+    top := make([]string, 0, CONNECT_TOP)
+    for i := 0; i < len(list) && i < CONNECT_TOP; i++ {
+        top = append(top, fmt.Sprintf("%s %.1f/s", list[i].line, -list[i].value))
+    }
+    over := maxConnectRate > 0 && connectRate > maxConnectRate
+    if over && !connectStorm {
+        alertConnects("connect_storm", "", connectRate, maxConnectRate,
+            fmt.Sprintf("%.1f connects/s to the server, limit %g; most from %s", connectRate,
+                maxConnectRate, strings.Join(top, ", ")))
+    }
+    connectStorm = over
+
+    if maxClientConnectRate <= 0 {
+        return
+    }
+    storms := make(map[string]bool)
+    for _, item := range list {
+        rate := -item.value
+        if rate <= maxClientConnectRate {
+            break
+        }
+        storms[item.line] = true
+        if !clientStorms[item.line] {
+            alertConnects("client_connect_storm", item.line, rate, maxClientConnectRate,
+                fmt.Sprintf("%.1f connects/s from %s, limit %g", rate, item.line, maxClientConnectRate))
+        }
+    }
+    clientStorms = storms
+}
+
+func alertConnects(kind string, client string, rate float64, limit float64, message string) {
+    log.Printf("Connect storm: %s", message)
+    publish(&event.AlertEvent{
+        Type:      event.TYPE_ALERT,
+        ServiceId: service_id,
+        TenantId:  tenant_id,
+        Kind:      kind,
+        Message:   message,
+        Value:     rate,
+        Limit:     limit,
+        Client:    client,
+    })
+}
+
+// runConnectRates closes a connect window every connectWindow.
+func runConnectRates() {
+    for _ = range time.Tick(connectWindow) {
+        stateLock.Lock()
+        checkConnectRates(connectWindow.Seconds())
+        stateLock.Unlock()
+    }
+}
+
+// writeConnectRateMetrics writes the connect rates over the last window,
+// overall and for the clients connecting most.
+func writeConnectRateMetrics(w io.Writer) {
+    if connectWindow <= 0 {
+        return
+    }
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_connect_rate gauge\nmysql_sniffer_connect_rate %g\n", connectRate)
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_client_connect_rate gauge\n")
+    for i := 0; i < len(clientConnectRates) && i < CONNECT_TOP; i++ {
+        fmt.Fprintf(w, "mysql_sniffer_client_connect_rate{%s} %g\n",
+            metricLabels("client", clientConnectRates[i].line), -clientConnectRates[i].value)
+    }
+}
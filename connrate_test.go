@@ -0,0 +1,60 @@
+/*
+ * connrate_test.go
+ *
+ * Alerts on connect storms, overall and per client.
+ */
+
+package main
+
+import (
+    "fmt"
+    "reflect"
+    "testing"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+func TestConnectStorm(t *testing.T) {
+    mem := resetState()
+    maxConnectRate, maxClientConnectRate = 5, 3
+    connectsByClient = make(map[string]uint64)
+    defer func() {
+        maxConnectRate, maxClientConnectRate = 0, 0
+        connectStorm, clientStorms = false, make(map[string]bool)
+    }()
+    window := func(clients map[string]int) {
+        for client, n := range clients {
+            for i := 0; i < n; i++ {
+                noteConnect(fmt.Sprintf("%s:%d", client, 40000+i))
+            }
+        }
+        checkConnectRates(10)
+    }
+    kinds := func() []string {
+        var list []string
+        for _, payload := range mem.payloads {
+            if ev, err := event.Decode(payload); err == nil {
+                list = append(list, ev.(*event.AlertEvent).Kind+" "+ev.(*event.AlertEvent).Client)
+            }
+        }
+        mem.payloads = nil
+        return list
+    }
+
+    window(map[string]int{"10.0.0.1": 20, "10.0.0.2": 10})
+    if got := kinds(); len(got) != 0 {
+        t.Errorf("alerts under the limits: %v", got)
+    }
+    window(map[string]int{"10.0.0.1": 40, "10.0.0.2": 20})
+    if got, want := kinds(), []string{"connect_storm ", "client_connect_storm 10.0.0.1"}; !reflect.DeepEqual(got, want) {
+        t.Errorf("alerts %v, want %v", got, want)
+    }
+    if connectRate != 6 {
+        t.Errorf("rate %g", connectRate)
+    }
+    // Still over: no repeats.
+    window(map[string]int{"10.0.0.1": 40, "10.0.0.2": 20})
+    if got := kinds(); len(got) != 0 {
+        t.Errorf("repeated alerts %v", got)
+    }
+}
@@ -0,0 +1,140 @@
+/*
+ * conntrack.go
+ *
+ * Original client addresses for NATed connections. When clients reach the
+ * server through DNAT plus masquerading (Docker published ports, kube-proxy,
+ * a load balancer on the host) what we capture comes from the NAT address.
+ * With -conntrack each new stream is looked up in the kernel's connection
+ * tracking table, /proc/net/nf_conntrack, and attributed (tenant, per-IP
+ * limits, attribution, #i) to the client that opened it. docker-proxy, the
+ * userland proxy, opens a connection of its own and can't be seen through;
+ * run dockerd with --userland-proxy=false for this to work.
+ */
+
+package main
+
+import (
+    "bufio"
+    "io"
+    "log"
+    "net"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const CONNTRACK_FILE = "/proc/net/nf_conntrack"
+
+// The table is read again on a miss at most this often.
+const CONNTRACK_REFRESH = time.Second
+
+var useConntrack bool
+var conntrackFile string = CONNTRACK_FILE
+
+// natClients maps the ip:port a connection comes from after NAT to the IP
+// of the client that opened it.
+var natClients map[string]string = make(map[string]string)
+var conntrackRead time.Time
+var warnedConntrack bool
+
+type conntrackTuple struct {
+    src, dst     string
+    sport, dport uint16
+}
+
+// parseConntrack reads an nf_conntrack table and returns, for each TCP
+// connection whose source was rewritten on the way to a server port, the
+// client's original IP by the address the server sees it come from.
+func parseConntrack(r io.Reader) map[string]string {
+    clients := make(map[string]string)
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        fields := strings.Fields(scanner.Text())
+        if len(fields) < 4 || fields[2] != "tcp" {
+            continue
+        }
+        // The original direction comes first, then the reply.
+        var tuples [2]conntrackTuple
+        n := -1
+        for _, f := range fields {
+            kv := strings.SplitN(f, "=", 2)
+            if len(kv) != 2 {
+                continue
+            }
+            if kv[0] == "src" {
+                if n++; n > 1 {
+                    break
+                }
+            }
+            if n < 0 {
+                continue
+            }
+            t := &tuples[n]
+            switch kv[0] {
+            case "src":
+                t.src = normalIP(kv[1])
+            case "dst":
+                t.dst = normalIP(kv[1])
+            case "sport", "dport":
+                p, _ := strconv.ParseUint(kv[1], 10, 16)
+                if kv[0] == "sport" {
+                    t.sport = uint16(p)
+                } else {
+                    t.dport = uint16(p)
+                }
+            }
+        }
+        orig, reply := tuples[0], tuples[1]
+        if n < 1 || !isServerPort(reply.sport) || orig.src == reply.dst {
+            continue
+        }
+        // The server answers the address it saw the client come from.
+        seen := reply.dst
+        if strings.Contains(seen, ":") {
+            seen = "[" + seen + "]"
+        }
+        clients[seen+":"+strconv.Itoa(int(reply.dport))] = orig.src
+    }
+    return clients
+}
+
+// normalIP writes an IP the way endpointAddr does; the kernel writes IPv6
+// addresses in full.
+func normalIP(s string) string {
+    if ip := net.ParseIP(s); ip != nil {
+        return ip.String()
+    }
+    return s
+}
+
+// loadConntrack reads the connection tracking table into natClients.
+func loadConntrack() error {
+    f, err := os.Open(conntrackFile)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    natClients = parseConntrack(f)
+    return nil
+}
+
+// clientIP returns the IP a stream from src is attributed to: the original
+// client if -conntrack knows of a NAT in between, else src's own.
+func clientIP(src string) string {
+    if useConntrack {
+        ip, ok := natClients[src]
+        if !ok && time.Since(conntrackRead) >= CONNTRACK_REFRESH {
+            conntrackRead = time.Now()
+            if err := loadConntrack(); err != nil && !warnedConntrack {
+                warnedConntrack = true
+                log.Printf("Failed to read %s: %s", conntrackFile, err)
+            }
+            ip, ok = natClients[src]
+        }
+        if ok {
+            return ip
+        }
+    }
+    return hostOf(src)
+}
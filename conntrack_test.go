@@ -0,0 +1,42 @@
+/*
+ * conntrack_test.go
+ *
+ * Clients behind NAT are found through the conntrack table.
+ */
+
+package main
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestConntrack(t *testing.T) {
+    port = 3306
+    // 10.0.0.5 reaching a container through DNAT and masquerading, so the
+    // server sees 172.17.0.1:40001; and a connection that wasn't NATed.
+    table := "ipv4     2 tcp      6 431999 ESTABLISHED src=10.0.0.5 dst=192.168.1.10 sport=51000 dport=3306 " +
+        "src=172.17.0.2 dst=172.17.0.1 sport=3306 dport=40001 [ASSURED] mark=0 zone=0 use=2\n" +
+        "ipv4     2 tcp      6 431999 ESTABLISHED src=172.17.0.3 dst=172.17.0.2 sport=52000 dport=3306 " +
+        "src=172.17.0.2 dst=172.17.0.3 sport=3306 dport=52000 [ASSURED] mark=0 zone=0 use=2\n" +
+        "ipv6     10 tcp      6 300 ESTABLISHED src=fd00:0000:0000:0000:0000:0000:0000:0005 " +
+        "dst=fd00:0000:0000:0000:0000:0000:0000:0001 sport=51000 dport=3306 " +
+        "src=fd00:0000:0000:0000:0000:0000:0000:0002 dst=fd00:0000:0000:0000:0000:0000:0000:0001 sport=3306 dport=40002 [ASSURED] mark=0 zone=0 use=2\n" +
+        "ipv4     2 udp      17 29 src=10.0.0.5 dst=10.0.0.1 sport=5353 dport=53 src=10.0.0.1 dst=10.0.0.5 sport=53 dport=5353 mark=0 use=2\n"
+    got := parseConntrack(strings.NewReader(table))
+    want := map[string]string{"172.17.0.1:40001": "10.0.0.5", "[fd00::1]:40002": "fd00::5"}
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("got %v", got)
+    }
+
+    useConntrack, natClients, conntrackRead = true, want, time.Now()
+    defer func() { useConntrack, natClients = false, make(map[string]string) }()
+    if ip := clientIP("172.17.0.1:40001"); ip != "10.0.0.5" {
+        t.Errorf("NATed client %s", ip)
+    }
+    if ip := clientIP("172.17.0.3:52000"); ip != "172.17.0.3" {
+        t.Errorf("direct client %s", ip)
+    }
+}
@@ -0,0 +1,109 @@
+/*
+ * containers.go
+ *
+ * Container attribution on Docker hosts. With -docker pointing at the Docker
+ * socket the running containers are listed every -docker_interval and their
+ * IPs mapped to the container's name and image; query events then carry the
+ * containers at both ends of the connection, so usage per container shows
+ * up without Kubernetes. Containers on the host network share its IP and
+ * can't be told apart. containerd's own API is gRPC and isn't spoken here.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+    "time"
+)
+
+type containerInfo struct {
+    name  string
+    image string
+}
+
+var dockerSocket string
+var dockerInterval time.Duration
+var containersByIP map[string]containerInfo = make(map[string]containerInfo)
+
+// dockerClient returns an HTTP client talking to the Docker socket.
+func dockerClient(socket string) *http.Client {
+    return &http.Client{
+        Timeout: 10 * time.Second,
+        Transport: &http.Transport{
+            Dial: func(network, addr string) (net.Conn, error) {
+                return net.Dial("unix", socket)
+            },
+        },
+    }
+}
+
+// listContainers asks Docker for the running containers and maps their
+// IPs to them.
+func listContainers(client *http.Client) (map[string]containerInfo, error) {
+    resp, err := client.Get("http://docker/containers/json")
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("docker: %s", resp.Status)
+    }
+    var list []struct {
+        Names           []string
+        Image           string
+        NetworkSettings struct {
+            Networks map[string]struct {
+                IPAddress         string
+                GlobalIPv6Address string
+            }
+        }
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+        return nil, fmt.Errorf("docker: %s", err)
+    }
+    byIP := make(map[string]containerInfo)
+    for _, c := range list {
+        info := containerInfo{image: c.Image}
+        if len(c.Names) > 0 {
+            info.name = strings.TrimPrefix(c.Names[0], "/")
+        }
+        for _, n := range c.NetworkSettings.Networks {
+            for _, ip := range []string{n.IPAddress, n.GlobalIPv6Address} {
+                if ip != "" {
+                    byIP[ip] = info
+                }
+            }
+        }
+    }
+    return byIP, nil
+}
+
+// runContainerMapping refreshes containersByIP every dockerInterval.
+func runContainerMapping() {
+    client := dockerClient(dockerSocket)
+    failing := false
+    for {
+        byIP, err := listContainers(client)
+        if err != nil && !failing {
+            log.Printf("Failed to list containers: %s", err)
+        }
+        failing = err != nil
+        if err == nil {
+            stateLock.Lock()
+            containersByIP = byIP
+            stateLock.Unlock()
+        }
+        time.Sleep(dockerInterval)
+    }
+}
+
+// containerOf returns the container with the IP of addr, if any.
+func containerOf(addr string) (containerInfo, bool) {
+    info, ok := containersByIP[hostOf(addr)]
+    return info, ok
+}
@@ -0,0 +1,49 @@
+/*
+ * containers_test.go
+ *
+ * Clients named by the Docker containers they run in.
+ */
+
+package main
+
+import (
+    "io/ioutil"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestListContainers(t *testing.T) {
+    dir, err := ioutil.TempDir("", "docker")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    socket := filepath.Join(dir, "docker.sock")
+    ln, err := net.Listen("unix", socket)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ln.Close()
+    go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(`[{"Names": ["/shop-api"], "Image": "shop:1.2", "NetworkSettings": {"Networks":
+            {"bridge": {"IPAddress": "172.17.0.2", "GlobalIPv6Address": "fd00::2"}}}},
+            {"Names": ["/db"], "Image": "mysql:8", "NetworkSettings": {"Networks": {"host": {}}}}]`))
+    }))
+
+    byIP, err := listContainers(dockerClient(socket))
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := containerInfo{name: "shop-api", image: "shop:1.2"}
+    if len(byIP) != 2 || byIP["172.17.0.2"] != want || byIP["fd00::2"] != want {
+        t.Errorf("got %+v", byIP)
+    }
+    containersByIP = byIP
+    defer func() { containersByIP = make(map[string]containerInfo) }()
+    if c, ok := containerOf("[fd00::2]:41000"); !ok || c != want {
+        t.Errorf("containerOf: %+v %v", c, ok)
+    }
+}
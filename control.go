@@ -0,0 +1,142 @@
+/*
+ * control.go
+ *
+ * Runtime control of a running sniffer: a small HTTP API (enabled with
+ * -control host:port) and signal handling.
+ *
+ *   GET /sinks       current sink specs
+ *   PUT /sinks       replace the sinks with a JSON list of specs
+ *   GET /examples    slowest example of each slow fingerprint (see -slow_ms)
+ *   GET /connections tracked connections, how often each was reset and how
+ *                    long its request has waited
+ *   GET /history     last statements of each connection (?client= for one)
+ *   GET /metrics     Prometheus metrics
+ *   GET /attribution latency per client within each fingerprint (?q= to pick)
+ *   POST /purge      delete retained query text matching ?pattern= (see purge.go)
+ *   GET /unused      tables not queried within -table_usage_window (see unused.go)
+ *
+ * SIGHUP re-reads the -config file (see config.go), or else the -sinks file.
+ * Neither touches capture or the in-memory aggregates. SIGINT and SIGTERM stop the sniffer cleanly (see shutdown.go).
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "os"
+    "os/signal"
+    "syscall"
+)
+
+// startControl serves the control API on addr in the background.
+func startControl(addr string) {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/sinks", handleSinks)
+    mux.HandleFunc("/examples", handleExamples)
+    mux.HandleFunc("/connections", handleConnections)
+    mux.HandleFunc("/history", handleHistory)
+    mux.HandleFunc("/metrics", handleMetrics)
+    mux.HandleFunc("/attribution", handleAttribution)
+    mux.HandleFunc("/purge", handlePurge)
+    mux.HandleFunc("/unused", handleUnused)
+    go func() {
+        log.Printf("Control API listening on %s", addr)
+        if err := http.ListenAndServe(addr, mux); err != nil {
+            log.Fatalf("Control API failed: %s", err.Error())
+        }
+    }()
+}
+
+func handleSinks(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case "GET":
+    case "PUT", "POST":
+        var specs []sinkSpec
+        if err := json.NewDecoder(r.Body).Decode(&specs); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if err := replaceSinks(specs); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+    default:
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    sinksLock.Lock()
+    specs := make([]sinkSpec, len(sinkSpecs))
+    copy(specs, sinkSpecs)
+    sinksLock.Unlock()
+    for i := range specs {
+        specs[i] = redactSpec(specs[i])
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(specs)
+}
+
+func handleExamples(w http.ResponseWriter, r *http.Request) {
+    stateLock.Lock()
+    list := examples.all()
+    stateLock.Unlock()
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(list)
+}
+
+type connectionInfo struct {
+    Client   string     `json:"client"`
+    ThreadId uint32     `json:"thread_id,omitempty"`
+    User     string     `json:"user,omitempty"`
+    Db       string     `json:"db,omitempty"`
+    Tenant   string     `json:"tenant"`
+    Resets   uint64     `json:"resets"`
+    Writes   writeModes `json:"writes"`
+    Waiting  float64    `json:"waiting,omitempty"` // seconds the request has waited
+    TLS      bool       `json:"tls,omitempty"`
+}
+
+func handleConnections(w http.ResponseWriter, r *http.Request) {
+    var list []connectionInfo
+    stateLock.Lock()
+    now := requestClock()
+    eachStream(func(rs *source) {
+        list = append(list, connectionInfo{Client: rs.src, ThreadId: rs.threadId,
+            User: rs.user, Db: rs.loginDb, Tenant: rs.tenant, Resets: rs.resets,
+            Writes: rs.writes, Waiting: waiting(rs, now).Seconds(), TLS: rs.tls})
+    })
+    resets := stats.resets
+    stateLock.Unlock()
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "connections": list,
+        "resets":      resets,
+    })
+}
+
+// watchSignals handles SIGHUP for as long as the process runs.
+func watchSignals() {
+    ch := make(chan os.Signal, 1)
+    signal.Notify(ch, syscall.SIGHUP)
+    for _ = range ch {
+        if configFile != "" {
+            if err := reloadConfig(); err != nil {
+                log.Printf("Failed to reload %s: %s", configFile, err)
+            } else {
+                log.Printf("Reloaded %s", configFile)
+            }
+            continue
+        }
+        if sinksFile == "" {
+            log.Printf("SIGHUP received but no -sinks file to reload")
+            continue
+        }
+        if err := loadSinksFile(sinksFile); err != nil {
+            log.Printf("Failed to reload %s: %s", sinksFile, err)
+        } else {
+            log.Printf("Reloaded sinks from %s", sinksFile)
+        }
+    }
+}
@@ -0,0 +1,46 @@
+/*
+ * debug.go
+ *
+ * -debug-proto: hex dumps of segments the decoder couldn't make sense of, so
+ * a protocol bug report can carry the offending bytes instead of a whole
+ * pcap. Dumps are capped in size and number since a confused decoder tends
+ * to be confused about a lot of traffic at once.
+ */
+
+package main
+
+import (
+    "encoding/hex"
+    "log"
+)
+
+const (
+    DEBUG_DUMP_BYTES = 256
+    DEBUG_DUMP_MAX   = 1000
+)
+
+var debugProto bool = false
+var debugDumps int
+
+// pktSeq is the TCP sequence number of the segment being handled, which lets
+// a dump be matched up with the same segment in another tool.
+var pktSeq uint32
+
+// debugDump logs data, which couldn't be decoded for the given reason, from
+// the segment on connection conn starting at byte offset of its payload.
+func debugDump(conn string, offset int, why string, data []byte) {
+    if !debugProto || debugDumps > DEBUG_DUMP_MAX {
+        return
+    }
+    debugDumps++
+    if debugDumps > DEBUG_DUMP_MAX {
+        log.Printf("debug-proto: %d dumps logged, not logging any more", DEBUG_DUMP_MAX)
+        return
+    }
+    shown := data
+    if len(shown) > DEBUG_DUMP_BYTES {
+        shown = shown[:DEBUG_DUMP_BYTES]
+    }
+    log.Printf("debug-proto: %s: %s at seq %d offset %d, %d bytes (%d shown):\n%s",
+        conn, why, pktSeq, offset, len(data), len(shown), hex.Dump(shown))
+}
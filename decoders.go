@@ -0,0 +1,293 @@
+/*
+ * decoders.go
+ *
+ * Decoding off the capture loop (-workers N). The capture loop only copies
+ * each packet and queues it for one of N goroutines, picked by a hash of the
+ * connection's addresses and ports that is the same both ways, so a
+ * connection's packets stay in order; the workers do everything else. A
+ * slow stretch of canonicalizing or publishing then fills a queue instead of
+ * the kernel's buffer, and packets aren't dropped unless a queue stays full.
+ *
+ * Each worker owns a shard of the streams, the ones its connections hash
+ * to. Parsing frames, finding their stream and canonicalizing a statement
+ * that comes in one segment, the bulk of the work, it does holding only
+ * its shard's lock, so workers do that side by side. The protocol state
+ * machine, publishing and the totals they feed still run under stateLock,
+ * one worker at a time, so past the point where those keep one core busy
+ * more workers don't help; use -processes to go further. Every change to a
+ * shard holds both locks, so anything else reading the streams needs only
+ * stateLock.
+ */
+
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    DECODER_QUEUE    = 10000 // packets queued per worker
+    DECODER_REFILTER = 1024  // packets between looks at the capture filter
+)
+
+var decoderCount int
+var decoders *decoderPool
+
+// A streamShard holds the streams of one worker's connections.
+type streamShard struct {
+    lock    sync.Mutex // taken with stateLock held to change streams
+    streams map[string]*source
+}
+
+var shards = []*streamShard{newShard()}
+
+// pktShard is the shard of the packet being handled, where new streams go.
+var pktShard = shards[0]
+
+// pktDecoded is what the worker handling the packet already made of the
+// statement in it, or nil.
+var pktDecoded *decodedRequest
+
+// A decodedRequest is a COM_QUERY canonicalized before taking stateLock.
+type decodedRequest struct {
+    pdata      []byte // the statement, after the command byte
+    db         string // the schema it was normalized for
+    query      string
+    indexHints []string
+    optHints   []string
+}
+
+// A streamView is what predecode may know of a stream: a copy its worker
+// refreshes under the shard's lock after each frame.
+type streamView struct {
+    plain bool // synced, not compressed and not encrypted
+    db    string
+}
+
+func newShard() *streamShard {
+    return &streamShard{streams: make(map[string]*source)}
+}
+
+// resetStreams forgets every stream and makes n empty shards. Must be
+// called with stateLock held and no workers running.
+func resetStreams(n int) {
+    shards = make([]*streamShard, n)
+    for i := range shards {
+        shards[i] = newShard()
+    }
+    pktShard = shards[0]
+}
+
+// streamCount returns the streams tracked. Must be called with stateLock
+// held.
+func streamCount() int {
+    n := 0
+    for _, sh := range shards {
+        n += len(sh.streams)
+    }
+    return n
+}
+
+// eachStream calls fn for every stream, which fn may close. Must be called
+// with stateLock held.
+func eachStream(fn func(rs *source)) {
+    for _, sh := range shards {
+        for _, rs := range sh.streams {
+            fn(rs)
+        }
+    }
+}
+
+type decoderPool struct {
+    queues  []chan *capturedPacket
+    decoded []uint64 // statements canonicalized by each worker
+    free    sync.Pool
+    live    bool
+    done    sync.WaitGroup
+}
+
+// startDecoders starts n workers, each with a shard of its own. Streams
+// tracked before are forgotten.
+func startDecoders(n int, live bool) *decoderPool {
+    d := &decoderPool{queues: make([]chan *capturedPacket, n), decoded: make([]uint64, n), live: live}
+    d.free.New = func() interface{} { return new(capturedPacket) }
+    stateLock.Lock()
+    resetStreams(n)
+    stateLock.Unlock()
+    for i := range d.queues {
+        d.queues[i] = make(chan *capturedPacket, DECODER_QUEUE)
+        d.done.Add(1)
+        go d.decode(i, shards[i])
+    }
+    return d
+}
+
+// dispatch queues a copy of pkt, which is borrowed, for its worker.
+func (d *decoderPool) dispatch(pkt *capturedPacket) {
+    c := d.free.Get().(*capturedPacket)
+    c.Time, c.Caplen, c.Len = pkt.Time, pkt.Caplen, pkt.Len
+    c.Data = append(c.Data[:0], pkt.Data...)
+    d.queues[flowHash(pkt.Data)%uint32(len(d.queues))] <- c
+}
+
+// decode is worker i, which owns sh.
+func (d *decoderPool) decode(i int, sh *streamShard) {
+    defer d.done.Done()
+    for pkt := range d.queues[i] {
+        if traceStages && d.live {
+            noteStage(STAGE_CAPTURE, time.Since(pkt.Time))
+        }
+        started := stageStart()
+        f, ok := parseFrame(pkt)
+        var decoded *decodedRequest
+        if ok {
+            if decoded = predecode(sh, &f); decoded != nil {
+                atomic.AddUint64(&d.decoded[i], 1)
+            }
+        }
+        stateLock.Lock()
+        pktTime, pktShard, pktDecoded = pkt.Time, sh, decoded
+        if ok {
+            handleFrame(&f, started)
+            sh.refresh(f.srcaddr, f.dstaddr)
+        } else if f.ignored {
+            stats.packets.ignored++
+        }
+        pktDecoded = nil
+        stateLock.Unlock()
+        d.free.Put(pkt)
+    }
+}
+
+// refresh copies what predecode needs of the streams at addrs into their
+// views. Must be called with stateLock held.
+func (sh *streamShard) refresh(addrs ...string) {
+    sh.lock.Lock()
+    defer sh.lock.Unlock()
+    for _, addr := range addrs {
+        if rs := sh.streams[addr]; rs != nil {
+            rs.view = streamView{plain: rs.synced && !rs.compressed && !rs.tls, db: rs.db}
+        }
+    }
+}
+
+// predecode canonicalizes the statement in a segment that is one whole
+// COM_QUERY on a stream of sh in step, for processPacket to pick up. It
+// returns nil for anything else, which processPacket then decodes itself.
+func predecode(sh *streamShard, f *tcpFrame) *decodedRequest {
+    data := f.payload
+    if dirty || len(data) < 5 || data[3] != 0 || data[4] != COM_QUERY ||
+        int(data[0])|int(data[1])<<8|int(data[2])<<16 != len(data)-4 {
+        return nil
+    }
+    var view streamView
+    sh.lock.Lock()
+    if rs := sh.streams[f.srcaddr]; rs != nil {
+        view = rs.view
+    }
+    sh.lock.Unlock()
+    if !view.plain {
+        return nil
+    }
+    d := &decodedRequest{pdata: data[5:], db: view.db}
+    d.query = normalizeSchemas(cleanupQuery(d.pdata), d.db)
+    d.indexHints, d.optHints = extractHints(d.pdata)
+    return d
+}
+
+// decodedFor returns pktDecoded if it is for the COM_QUERY pdata on rs.
+func decodedFor(rs *source, ptype int, pdata []byte) *decodedRequest {
+    d := pktDecoded
+    if d == nil || ptype != COM_QUERY || d.db != rs.db || !bytes.Equal(d.pdata, pdata) {
+        return nil
+    }
+    return d
+}
+
+// stop waits for the workers to finish what's queued.
+func (d *decoderPool) stop() {
+    for _, queue := range d.queues {
+        close(queue)
+    }
+    d.done.Wait()
+}
+
+// flowHash hashes a frame's TCP connection, the same whichever way it
+// goes. Anything else hashes to 0.
+func flowHash(data []byte) uint32 {
+    pos := 12
+    for {
+        if len(data) < pos+2 {
+            return 0
+        }
+        etype := uint16(data[pos])<<8 + uint16(data[pos+1])
+        pos += 2
+        if etype == 0x8100 || etype == 0x88a8 {
+            pos += 2
+            continue
+        }
+        var a, b []byte
+        switch etype {
+        case 0x0800:
+            if len(data) < pos+20 {
+                return 0
+            }
+            a, b = data[pos+12:pos+16], data[pos+16:pos+20]
+            pos += int(data[pos]&0x0F) * 4
+        case 0x86dd:
+            ip6 := pos
+            var ok bool
+            if pos, ok = ipv6Payload(data, pos); !ok {
+                return 0
+            }
+            a, b = data[ip6+8:ip6+24], data[ip6+24:ip6+40]
+        default:
+            return 0
+        }
+        if len(data) < pos+4 {
+            return 0
+        }
+        return endpointHash(a, data[pos:pos+2]) ^ endpointHash(b, data[pos+2:pos+4])
+    }
+}
+
+// endpointHash is FNV-1a of an address and port.
+func endpointHash(ip []byte, port []byte) uint32 {
+    h := uint32(2166136261)
+    for _, part := range [][]byte{ip, port} {
+        for _, c := range part {
+            h = (h ^ uint32(c)) * 16777619
+        }
+    }
+    return h
+}
+
+// queued returns the packets waiting for the workers.
+func (d *decoderPool) queued() int {
+    n := 0
+    for _, queue := range d.queues {
+        n += len(queue)
+    }
+    return n
+}
+
+func writeDecoderMetrics(w io.Writer) {
+    if decoders == nil {
+        return
+    }
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_decoder_queue_packets gauge\n")
+    for i, queue := range decoders.queues {
+        fmt.Fprintf(w, "mysql_sniffer_decoder_queue_packets{%s} %d\n",
+            metricLabels("decoder", fmt.Sprint(i)), len(queue))
+    }
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_decoder_statements_total counter\n")
+    for i := range decoders.decoded {
+        fmt.Fprintf(w, "mysql_sniffer_decoder_statements_total{%s} %d\n",
+            metricLabels("decoder", fmt.Sprint(i)), atomic.LoadUint64(&decoders.decoded[i]))
+    }
+}
@@ -0,0 +1,84 @@
+/*
+ * decoders_test.go
+ *
+ * Packets decoded by several workers, split by flow.
+ */
+
+package main
+
+import (
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestDecoders(t *testing.T) {
+    resetState()
+    published := stats.published
+    decoders = startDecoders(4, false)
+    defer func() { decoders = nil }()
+    s, _ := newTestSoaker()
+    s.dispatch = decoders.dispatch
+    for i := 0; i < 20; i++ {
+        c := &soakConn{ip: [4]byte{10, 1, 0, byte(i + 1)}, port: 20000}
+        s.connect(c)
+        for j := 0; j < 5; j++ {
+            s.query(c)
+        }
+        s.close(c)
+    }
+    decoders.stop()
+    if n := stats.published - published; n != s.queries || streamCount() != 0 || stats.desyncs != 0 {
+        t.Errorf("%d of %d published, %d streams left, %d desyncs", n, s.queries, streamCount(), stats.desyncs)
+    }
+    if flowHash(s.buf) == 0 {
+        t.Error("no hash for a TCP frame")
+    }
+}
+
+func TestWorkersDecodeConcurrently(t *testing.T) {
+    resetState()
+    published := stats.published
+    decoders = startDecoders(2, false)
+    defer func() { decoders = nil }()
+    s, _ := newTestSoaker()
+    s.dispatch = decoders.dispatch
+
+    // A connection for each worker, synced by a first query.
+    var conns [2]*soakConn
+    for i := 1; conns[0] == nil || conns[1] == nil; i++ {
+        c := &soakConn{ip: [4]byte{10, 1, 0, byte(i)}, port: 20000}
+        s.connect(c)
+        if w := flowHash(s.buf) % 2; conns[w] == nil {
+            conns[w] = c
+            s.query(c)
+        }
+    }
+    waitFor := func(what string, done func() bool) {
+        for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(time.Millisecond) {
+            if time.Now().After(deadline) {
+                t.Fatalf("timed out waiting for %s", what)
+            }
+        }
+    }
+    waitFor("the first queries", func() bool {
+        stateLock.Lock()
+        defer stateLock.Unlock()
+        return stats.published-published == 2
+    })
+
+    // With stateLock held, both workers still canonicalize their query.
+    stateLock.Lock()
+    for _, c := range conns {
+        s.query(c)
+    }
+    waitFor("both workers to decode", func() bool {
+        return atomic.LoadUint64(&decoders.decoded[0]) == 1 && atomic.LoadUint64(&decoders.decoded[1]) == 1
+    })
+    stateLock.Unlock()
+
+    decoders.stop()
+    if n := stats.published - published; n != 4 || stats.desyncs != 0 {
+        t.Errorf("%d of 4 published, %d desyncs", n, stats.desyncs)
+    }
+}
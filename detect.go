@@ -0,0 +1,61 @@
+/*
+ * detect.go
+ *
+ * Finding MySQL by what it says rather than where (-detect-protocol). The
+ * capture takes every TCP segment the -bpf filter lets through instead of
+ * only -P's, and a server is recognised by the greeting it sends each new
+ * connection, so proxies and remapped ports (3307, ProxySQL's 6033, ...)
+ * are followed without naming them. Connections to an endpoint not yet
+ * known as a server are ignored until it greets, and those that were open
+ * before the sniffer started stay invisible, as on any port. Narrow -bpf
+ * down to the hosts or port ranges of interest: every packet it passes is
+ * looked at.
+ */
+
+package main
+
+var detectProtocol bool
+
+// isGreeting says if payload starts with a complete server greeting:
+// protocol 10, a printable version string starting with a digit, the
+// connection id and the first part of the auth data, then a NUL filler.
+func isGreeting(payload []byte) bool {
+    if len(payload) < 5 || payload[3] != 0 || payload[4] != PROTOCOL_VERSION_10 {
+        return false
+    }
+    size := int(payload[0]) | int(payload[1])<<8 | int(payload[2])<<16
+    if size > len(payload)-4 || len(payload) < 6 || payload[5] < '0' || payload[5] > '9' {
+        return false
+    }
+    end := 5
+    for end < 4+size && payload[end] != 0 {
+        if payload[end] < 0x20 || payload[end] > 0x7e || end > 5+64 {
+            return false
+        }
+        end++
+    }
+    // The version's NUL, the 4 byte id, 8 bytes of auth data and the filler.
+    filler := end + 1 + 4 + 8
+    return filler < 4+size && payload[end] == 0 && payload[filler] == 0
+}
+
+// knownServer says if segments between the two endpoints may be MySQL:
+// one is on a server port or has been seen greeting.
+func knownServer(srcaddr string, srcPort uint16, dstaddr string, dstPort uint16) bool {
+    return isServerPort(srcPort) || isServerPort(dstPort) || servers[srcaddr] || servers[dstaddr]
+}
+
+// detectServer looks for a greeting in a segment between endpoints not yet
+// known as MySQL. On finding one the sender becomes a server and the
+// connection is followed from there, as if its SYN had been seen. It
+// returns false if the segment should be ignored.
+func detectServer(srcaddr string, dstaddr string, payload []byte) bool {
+    if !isGreeting(payload) {
+        stats.packets.ignored++
+        return false
+    }
+    stats.detected++
+    stats.connects++
+    newSession(dstaddr, srcaddr)
+    return true
+}
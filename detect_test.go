@@ -0,0 +1,43 @@
+/*
+ * detect_test.go
+ *
+ * MySQL servers found by their greeting on any port.
+ */
+
+package main
+
+import (
+    "testing"
+)
+
+func TestDetectProtocol(t *testing.T) {
+    mem := resetState()
+    detectProtocol = true
+    defer func() { detectProtocol, stats.detected = false, 0 }()
+    if filter := captureFilter(); filter != "tcp" {
+        t.Errorf("filter %q", filter)
+    }
+    s, _ := newTestSoaker()
+    web := &soakConn{ip: [4]byte{10, 1, 0, 2}, port: 30000}
+    s.serverPort = 8080
+    s.packet(web, true, TCP_SYN, nil)
+    s.packet(web, true, TCP_ACK, []byte("GET / HTTP/1.1\r\n\r\n"))
+    s.packet(web, false, TCP_ACK, []byte("HTTP/1.1 200 OK\r\n\r\n"))
+    if streamCount() != 0 || len(servers) != 0 {
+        t.Fatalf("%d streams, servers %v", streamCount(), servers)
+    }
+
+    s.serverPort = 6033
+    for i := 0; i < 2; i++ {
+        c := &soakConn{ip: [4]byte{10, 1, 0, 1}, port: uint16(20000 + i)}
+        s.connect(c)
+        s.query(c)
+        s.close(c)
+    }
+    if stats.detected != 1 || !servers["10.0.0.1:6033"] || len(mem.payloads) != 2 {
+        t.Errorf("%d detected, servers %v, %d published", stats.detected, servers, len(mem.payloads))
+    }
+    if !isGreeting(mysqlPacket(0, fakeGreeting())) || isGreeting(mysqlPacket(0, []byte{0x0a, '5', 0})) {
+        t.Error("isGreeting")
+    }
+}
@@ -0,0 +1,112 @@
+/*
+ * direction.go
+ *
+ * Deciding which way a TCP segment is travelling. Normally the MySQL port
+ * tells us, but on mirrored/SPAN ports we can see both ends on the same
+ * port (replication, proxies) or traffic we weren't expecting, so fall back
+ * to looking at the MySQL protocol itself.
+ */
+
+package main
+
+import (
+    "log"
+)
+
+const (
+    SENDER_UNKNOWN = iota
+    SENDER_CLIENT
+    SENDER_SERVER
+
+    // After this many unanswered queries on one stream we assume we can
+    // only see the client side of the conversation.
+    ONEWAY_THRESHOLD = 3
+)
+
+// servers remembers endpoints (ip:port) that have identified themselves as
+// MySQL servers, either by sending a greeting or by being sent a command,
+// for as long as a stream is connected to them (see expireServers).
+var servers map[string]bool = make(map[string]bool)
+var warnedOneway bool = false
+
+// classifyDirection returns whether a segment from srcaddr to dstaddr is a
+// client request. ok is false if we can't tell, in which case the segment
+// should be dropped.
+func classifyDirection(srcaddr string, srcPort uint16, dstaddr string,
+    dstPort uint16, payload []byte) (request bool, ok bool) {
+
+    if servers[dstaddr] {
+        return true, true
+    }
+    if servers[srcaddr] {
+        return false, true
+    }
+    if srcPort != dstPort {
+        if isServerPort(dstPort) {
+            return true, true
+        }
+        if isServerPort(srcPort) {
+            return false, true
+        }
+    }
+
+    switch guessSender(payload) {
+    case SENDER_SERVER:
+        servers[srcaddr] = true
+        return false, true
+    case SENDER_CLIENT:
+        servers[dstaddr] = true
+        return true, true
+    }
+    return false, false
+}
+
+// guessSender looks at the first MySQL packet in a payload and decides who
+// most likely sent it. Only the unambiguous cases are answered: a server
+// greeting, a command at the start of a command phase, and OK/ERR replies.
+func guessSender(payload []byte) int {
+    if len(payload) < 5 {
+        return SENDER_UNKNOWN
+    }
+    size := uint32(payload[0]) + uint32(payload[1])<<8 + uint32(payload[2])<<16
+    seq := payload[3]
+    b := payload[4]
+    if size == 0 {
+        return SENDER_UNKNOWN
+    }
+
+    if seq == 0 {
+        // Protocol 10 greeting, followed by a version string like "5.7.33".
+        // COM_PROCESS_INFO shares the 0x0a byte but is a bare command.
+        if b == 0x0a && size > 1 && len(payload) > 5 &&
+            payload[5] >= '0' && payload[5] <= '9' {
+            return SENDER_SERVER
+        }
+        if b >= 0x01 && b <= 0x1f {
+            return SENDER_CLIENT
+        }
+        return SENDER_UNKNOWN
+    }
+
+    switch {
+    case b == 0xff && size >= 3:
+        return SENDER_SERVER // ERR
+    case b == 0x00 && size >= 7:
+        return SENDER_SERVER // OK
+    }
+    return SENDER_UNKNOWN
+}
+
+// noteUnanswered is called when a new query arrives on a stream whose previous
+// query never saw a response. If that keeps happening we are probably only
+// seeing one direction, so say so once rather than silently reporting no
+// latency data.
+func noteUnanswered(rs *source) {
+    stats.unanswered++
+    rs.unanswered++
+    if rs.unanswered >= ONEWAY_THRESHOLD && !warnedOneway {
+        warnedOneway = true
+        log.Printf("No responses seen for %d queries from %s; only one direction "+
+            "of traffic may be visible on this interface", rs.unanswered, rs.src)
+    }
+}
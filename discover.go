@@ -0,0 +1,173 @@
+/*
+ * discover.go
+ *
+ * Finding the MySQL servers on this host. With -discover every local
+ * process named in -discover_comm (mysqld, mariadbd) has its listening TCP
+ * ports looked up, at startup and then every -discover interval, and any
+ * port not seen before is added to the capture filter next to -P. The
+ * sockets are read from /proc/<pid>/net/tcp{,6} rather than /proc/net, so
+ * instances in containers with their own network namespace are found too.
+ * Ports are never taken out again: an instance that went away costs nothing.
+ */
+
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const PROC_ROOT = "/proc"
+
+var discoverInterval time.Duration
+var discoverComms map[string]bool = make(map[string]bool)
+
+// discovered holds the ports found besides -P; filterStale is set when the
+// capture filter should be rebuilt to include them.
+var discovered map[uint16]bool = make(map[uint16]bool)
+var filterStale bool
+
+// isServerPort says if port is one a MySQL server listens on.
+func isServerPort(p uint16) bool {
+    return p == port || discovered[p]
+}
+
+// portFilter is the part of the capture filter choosing the ports.
+func portFilter() string {
+    if len(discovered) == 0 {
+        return fmt.Sprintf("tcp port %d", port)
+    }
+    ports := []int{int(port)}
+    for p := range discovered {
+        ports = append(ports, int(p))
+    }
+    sort.Ints(ports)
+    var terms []string
+    for _, p := range ports {
+        terms = append(terms, fmt.Sprintf("port %d", p))
+    }
+    return "tcp and (" + strings.Join(terms, " or ") + ")"
+}
+
+// parseListeners reads a /proc/net/tcp style table and returns the local
+// port of every listening socket by inode.
+func parseListeners(r io.Reader) map[string]uint16 {
+    listeners := make(map[string]uint16)
+    scanner := bufio.NewScanner(r)
+    scanner.Scan() // header
+    for scanner.Scan() {
+        fields := strings.Fields(scanner.Text())
+        if len(fields) < 10 || fields[3] != "0A" { // TCP_LISTEN
+            continue
+        }
+        local := fields[1]
+        i := strings.LastIndex(local, ":")
+        p, err := strconv.ParseUint(local[i+1:], 16, 16)
+        if i < 0 || err != nil {
+            continue
+        }
+        listeners[fields[9]] = uint16(p)
+    }
+    return listeners
+}
+
+// socketInodes returns the inodes of the sockets a process has open.
+func socketInodes(pidDir string) map[string]bool {
+    inodes := make(map[string]bool)
+    fds, _ := ioutil.ReadDir(filepath.Join(pidDir, "fd"))
+    for _, fd := range fds {
+        link, err := os.Readlink(filepath.Join(pidDir, "fd", fd.Name()))
+        if err == nil && strings.HasPrefix(link, "socket:[") {
+            inodes[link[8:len(link)-1]] = true
+        }
+    }
+    return inodes
+}
+
+// discoverPorts returns the ports the processes named in comms listen on.
+func discoverPorts(proc string, comms map[string]bool) ([]uint16, error) {
+    dirs, err := ioutil.ReadDir(proc)
+    if err != nil {
+        return nil, err
+    }
+    found := make(map[uint16]bool)
+    for _, dir := range dirs {
+        if _, err := strconv.Atoi(dir.Name()); err != nil {
+            continue
+        }
+        pidDir := filepath.Join(proc, dir.Name())
+        comm, err := ioutil.ReadFile(filepath.Join(pidDir, "comm"))
+        if err != nil || !comms[strings.TrimSpace(string(comm))] {
+            continue
+        }
+        inodes := socketInodes(pidDir)
+        for _, table := range []string{"net/tcp", "net/tcp6"} {
+            f, err := os.Open(filepath.Join(pidDir, table))
+            if err != nil {
+                continue
+            }
+            for inode, p := range parseListeners(f) {
+                if inodes[inode] {
+                    found[p] = true
+                }
+            }
+            f.Close()
+        }
+    }
+    var ports []uint16
+    for p := range found {
+        ports = append(ports, p)
+    }
+    return ports, nil
+}
+
+// discover looks for new server ports and marks the filter stale if there
+// are any. Must be called with stateLock held.
+func discover() {
+    ports, err := discoverPorts(PROC_ROOT, discoverComms)
+    if err != nil {
+        log.Printf("Port discovery failed: %s", err)
+        return
+    }
+    for _, p := range ports {
+        if !isServerPort(p) {
+            log.Printf("Discovered a MySQL server on port %d", p)
+            discovered[p] = true
+            filterStale = true
+        }
+    }
+}
+
+// runDiscovery looks for new server ports every discoverInterval.
+func runDiscovery() {
+    for range time.Tick(discoverInterval) {
+        stateLock.Lock()
+        discover()
+        stateLock.Unlock()
+    }
+}
+
+// refilter installs a new capture filter if discovery found ports. It is
+// called from the capture loop so the source is only used by one goroutine.
+func refilter(source CaptureSource) {
+    stateLock.Lock()
+    stale := filterStale
+    filterStale = false
+    filter := captureFilter()
+    stateLock.Unlock()
+    if !stale {
+        return
+    }
+    if err := source.SetFilter(filter); err != nil {
+        log.Printf("Failed to set filter %q: %s", filter, err)
+    }
+}
@@ -0,0 +1,57 @@
+/*
+ * discover_test.go
+ *
+ * Finding the ports mysqld listens on from /proc.
+ */
+
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+func TestDiscoverPorts(t *testing.T) {
+    proc, err := ioutil.TempDir("", "proc")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(proc)
+    // mysqld listening on 3307 and, over IPv6, 36100; 3308 is
+    // someone else's socket.
+    pid := filepath.Join(proc, "42")
+    os.MkdirAll(filepath.Join(pid, "fd"), 0755)
+    os.MkdirAll(filepath.Join(pid, "net"), 0755)
+    ioutil.WriteFile(filepath.Join(pid, "comm"), []byte("mysqld\n"), 0644)
+    os.Symlink("socket:[1001]", filepath.Join(pid, "fd", "10"))
+    os.Symlink("socket:[1003]", filepath.Join(pid, "fd", "11"))
+    os.Symlink("/dev/null", filepath.Join(pid, "fd", "0"))
+    header := "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+    ioutil.WriteFile(filepath.Join(pid, "net", "tcp"), []byte(header+
+        "   0: 00000000:0CEB 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 1001 1 0 100 0 0 10 0\n"+
+        "   1: 00000000:0CEC 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 1002 1 0 100 0 0 10 0\n"+
+        "   2: 0100007F:0CEB 0100007F:D431 01 00000000:00000000 00:00000000 00000000   999        0 1004 1 0 100 0 0 10 0\n"), 0644)
+    ioutil.WriteFile(filepath.Join(pid, "net", "tcp6"), []byte(header+
+        "   0: 00000000000000000000000000000000:8D04 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 1003 1 0 100 0 0 10 0\n"), 0644)
+
+    ports, err := discoverPorts(proc, map[string]bool{"mysqld": true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    got := map[uint16]bool{}
+    for _, p := range ports {
+        got[p] = true
+    }
+    if !reflect.DeepEqual(got, map[uint16]bool{3307: true, 36100: true}) {
+        t.Errorf("got %v", ports)
+    }
+
+    port, discovered = 3306, map[uint16]bool{3307: true}
+    defer func() { discovered = make(map[uint16]bool) }()
+    if f := portFilter(); f != "tcp and (port 3306 or port 3307)" {
+        t.Errorf("filter %q", f)
+    }
+}
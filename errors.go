@@ -0,0 +1,140 @@
+/*
+ * errors.go
+ *
+ * Errors per fingerprint: how often each error code came back for it, and
+ * the last message with that code. They show up in the `errors` report and
+ * on /metrics. A fingerprint that had been succeeding and gets an error code
+ * it never got before, as after a schema change dropped a column it uses,
+ * raises a "new_query_error" alert, once per code.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "sort"
+    "strings"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+// Codes kept per fingerprint; later ones are only counted in errs.
+const ERRORS_MAX_CODES = 16
+
+// OKs a fingerprint needs before a new error code on it is alerted on.
+const ERRORS_MIN_OKS = 100
+
+type errorCount struct {
+    Code    uint16 `json:"code"`
+    State   string `json:"sql_state,omitempty"`
+    Count   uint64 `json:"count"`
+    Message string `json:"message"` // the last one, masked
+}
+
+// noteError counts the error rs's query just got. Called with the response
+// complete and the fingerprint's totals not yet updated.
+func noteError(rs *source) {
+    qdata, e := rs.qdata, rs.resp.err
+    if qdata == nil || e == nil {
+        return
+    }
+    ec, ok := qdata.codes[e.code]
+    if !ok {
+        if len(qdata.codes) >= ERRORS_MAX_CODES {
+            return
+        }
+        if qdata.codes == nil {
+            qdata.codes = make(map[uint16]*errorCount)
+        }
+        ec = &errorCount{Code: e.code, State: e.state}
+        qdata.codes[e.code] = ec
+        if qdata.oks >= ERRORS_MIN_OKS {
+            alertNewError(rs, e)
+        }
+    }
+    ec.Count++
+    ec.Message = maskMessage(e.message)
+}
+
+func alertNewError(rs *source, e *mysqlError) {
+    publish(&event.AlertEvent{
+        Type:      event.TYPE_ALERT,
+        ServiceId: service_id,
+        TenantId:  rs.tenant,
+        Kind:      "new_query_error",
+        Message: fmt.Sprintf("%s failed with error %d after %d successes: %s", labelOf(rs.qtext),
+            e.code, rs.qdata.oks, maskMessage(e.message)),
+        Value:       float64(e.code),
+        Fingerprint: rs.qtext,
+        Client:      rs.srcip,
+    })
+}
+
+// topErrors returns a fingerprint's error codes, most frequent first.
+func topErrors(qdata *queryData) []*errorCount {
+    list := make([]*errorCount, 0, len(qdata.codes))
+    for _, ec := range qdata.codes {
+        list = append(list, ec)
+    }
+    sort.Slice(list, func(i, j int) bool {
+        if list[i].Count != list[j].Count {
+            return list[i].Count > list[j].Count
+        }
+        return list[i].Code < list[j].Code
+    })
+    return list
+}
+
+// writeErrorMetrics writes the errors of each fingerprint by code.
+func writeErrorMetrics(w io.Writer) {
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_query_errors_total counter\n")
+    for _, key := range sortedQueries() {
+        for _, ec := range topErrors(qbuf[key]) {
+            fmt.Fprintf(w, "mysql_sniffer_query_errors_total{%s} %d\n",
+                metricLabels("fingerprint", key, "code", fmt.Sprint(ec.Code)), ec.Count)
+        }
+    }
+}
+
+type queryErrors struct {
+    Fingerprint string        `json:"fingerprint"`
+    Name        string        `json:"name,omitempty"`
+    Errors      uint64        `json:"errors"`
+    ErrorRate   float64       `json:"error_rate"`
+    Codes       []*errorCount `json:"codes"`
+}
+
+// reportErrors lists the fingerprints that got errors, most errors first.
+func reportErrors(w io.Writer, asJSON bool) {
+    var list []queryErrors
+    for _, key := range sortedQueries() {
+        qdata := qbuf[key]
+        if qdata.errs == 0 {
+            continue
+        }
+        list = append(list, queryErrors{Fingerprint: key, Name: nameOf(key), Errors: qdata.errs,
+            ErrorRate: float64(qdata.errs) / float64(qdata.oks+qdata.errs), Codes: topErrors(qdata)})
+    }
+    sort.SliceStable(list, func(i, j int) bool { return list[i].Errors > list[j].Errors })
+    if asJSON {
+        json.NewEncoder(w).Encode(map[string]interface{}{"errors": list})
+        return
+    }
+    fmt.Fprintf(w, "%s== errors (%d fingerprints)%s\n", color(COLOR_CYAN), len(list),
+        color(COLOR_DEFAULT))
+    fmt.Fprintf(w, "%10s %7s  %-24s  %s\n", "errors", "err%", "codes", "fingerprint")
+    for _, q := range list {
+        var codes []string
+        for _, ec := range q.Codes {
+            codes = append(codes, fmt.Sprintf("%dx%d", ec.Code, ec.Count))
+        }
+        fmt.Fprintf(w, "%10d %7.2f  %-24s  %s\n", q.Errors, q.ErrorRate*100,
+            strings.Join(codes, " "), labelOf(q.Fingerprint))
+        if len(q.Codes) > 0 {
+            fmt.Fprintf(w, "%10s last %d: %s\n", "", q.Codes[0].Code, q.Codes[0].Message)
+        }
+    }
+    fmt.Fprintf(w, "\n")
+}
@@ -0,0 +1,57 @@
+/*
+ * errors_test.go
+ *
+ * Errors per fingerprint and the alert on a new one.
+ */
+
+package main
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+func TestQueryErrors(t *testing.T) {
+    mem := resetState()
+    s, c := newTestSoaker()
+    s.connect(c)
+    query := mysqlPacket(0, append([]byte{COM_QUERY}, "SELECT b FROM t WHERE a = 1"...))
+    errPacket := mysqlPacket(1, append([]byte{0xff, 0x1e, 0x04, '#', '4', '2', 'S', '2', '2'},
+        "Unknown column 'b' in 'field list'"...))
+    for i := 0; i < ERRORS_MIN_OKS+3; i++ {
+        s.packet(c, true, TCP_ACK, query)
+        if i < ERRORS_MIN_OKS {
+            s.packet(c, false, TCP_ACK, mysqlPacket(1, selftestOK))
+        } else {
+            s.packet(c, false, TCP_ACK, errPacket)
+        }
+    }
+
+    qdata := qbuf["SELECT b FROM t WHERE a = ?"]
+    if qdata == nil {
+        t.Fatalf("fingerprint not found in %v", qbuf)
+    }
+    codes := topErrors(qdata)
+    if qdata.errs != 3 || len(codes) != 1 || codes[0].Code != 1054 || codes[0].Count != 3 ||
+        codes[0].State != "42S22" {
+        t.Errorf("%d errors, codes %+v", qdata.errs, codes)
+    }
+    alerts := 0
+    for _, payload := range mem.payloads {
+        if ev, err := event.Decode(payload); err == nil {
+            if alert, ok := ev.(*event.AlertEvent); ok && alert.Kind == "new_query_error" {
+                alerts++
+            }
+        }
+    }
+    if alerts != 1 {
+        t.Errorf("%d new_query_error alerts, want 1", alerts)
+    }
+    var out strings.Builder
+    writeErrorMetrics(&out)
+    if want := `mysql_sniffer_query_errors_total{fingerprint="SELECT b FROM t WHERE a = ?",code="1054"} 3`; !strings.Contains(out.String(), want) {
+        t.Errorf("no %s in\n%s", want, out.String())
+    }
+}
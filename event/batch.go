@@ -0,0 +1,173 @@
+/*
+ * batch.go
+ *
+ * Batched and compressed payloads. Sinks may be configured to send several
+ * events in one message, optionally gzipped; such a payload starts with an
+ * envelope instead of the plain prefix:
+ *
+ *   APPS sniff;enc=gzip;batch=3 <gzip of the three JSON documents, one per line>
+ *
+ * A fmt=cbor field says the body is CBOR instead of JSON lines (see cbor.go).
+ */
+
+package event
+
+import (
+    "bytes"
+    "compress/gzip"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "strconv"
+    "strings"
+)
+
+// EnvelopePrefix starts every batched payload.
+const EnvelopePrefix = "APPS sniff;"
+
+// Batch packs plain payloads (as returned by Encode) into one, compressed
+// with enc, which is "gzip" or "" for none.
+func Batch(payloads []string, enc string) (string, error) {
+    return BatchFormat(payloads, enc, FORMAT_JSON)
+}
+
+// BatchFormat is Batch with the body in format, FORMAT_JSON or FORMAT_CBOR.
+func BatchFormat(payloads []string, enc string, format string) (string, error) {
+    var body bytes.Buffer
+    for i, payload := range payloads {
+        if !strings.HasPrefix(payload, Prefix) {
+            return "", ErrNoPrefix
+        }
+        switch format {
+        case FORMAT_JSON:
+            if i > 0 {
+                body.WriteByte('\n')
+            }
+            body.WriteString(payload[len(Prefix):])
+        case FORMAT_CBOR:
+            if err := jsonToCBOR(&body, []byte(payload[len(Prefix):])); err != nil {
+                return "", err
+            }
+        default:
+            return "", errors.New("event: unsupported format " + format)
+        }
+    }
+
+    header := EnvelopePrefix
+    if format != FORMAT_JSON {
+        header += "fmt=" + format + ";"
+    }
+    switch enc {
+    case "":
+        return fmt.Sprintf("%sbatch=%d ", header, len(payloads)) + body.String(), nil
+    case "gzip":
+        var out bytes.Buffer
+        zw := gzip.NewWriter(&out)
+        zw.Write(body.Bytes())
+        if err := zw.Close(); err != nil {
+            return "", err
+        }
+        return fmt.Sprintf("%senc=gzip;batch=%d ", header, len(payloads)) + out.String(), nil
+    }
+    return "", errors.New("event: unsupported encoding " + enc)
+}
+
+// Envelope is what the header of a payload says about its body.
+type Envelope struct {
+    Enc    string // "" or "gzip"
+    Format string // FORMAT_JSON or FORMAT_CBOR
+    Batch  int    // events in the body, -1 if not said
+    Sig    string // algorithm:signature, "" if unsigned
+}
+
+// ParseEnvelope reads the header of a payload, returning it and where the
+// body starts. A plain payload is one JSON event with no header.
+func ParseEnvelope(payload string) (Envelope, int, error) {
+    env := Envelope{Format: FORMAT_JSON, Batch: -1}
+    if !strings.HasPrefix(payload, EnvelopePrefix) {
+        if !strings.HasPrefix(payload, Prefix) {
+            return env, 0, ErrNoPrefix
+        }
+        env.Batch = 1
+        return env, len(Prefix), nil
+    }
+    space := strings.IndexByte(payload[len(EnvelopePrefix):], ' ')
+    if space < 0 {
+        return env, 0, errors.New("event: unterminated envelope")
+    }
+    space += len(EnvelopePrefix)
+    for _, field := range strings.Split(payload[len(EnvelopePrefix):space], ";") {
+        kv := strings.SplitN(field, "=", 2)
+        if len(kv) != 2 {
+            return env, 0, errors.New("event: bad envelope field " + field)
+        }
+        switch kv[0] {
+        case "enc":
+            env.Enc = kv[1]
+        case "fmt":
+            env.Format = kv[1]
+        case "sig":
+            env.Sig = kv[1]
+        case "batch":
+            n, err := strconv.Atoi(kv[1])
+            if err != nil || n < 0 {
+                return env, 0, errors.New("event: bad batch size " + kv[1])
+            }
+            env.Batch = n
+        }
+        // Unknown fields are for newer consumers; skip them.
+    }
+    return env, space + 1, nil
+}
+
+// Unbatch returns the plain payloads carried by a message, which may be a
+// batch or a single plain payload.
+func Unbatch(payload string) ([]string, error) {
+    if !strings.HasPrefix(payload, EnvelopePrefix) {
+        if !strings.HasPrefix(payload, Prefix) {
+            return nil, ErrNoPrefix
+        }
+        return []string{payload}, nil
+    }
+    env, start, err := ParseEnvelope(payload)
+    if err != nil {
+        return nil, err
+    }
+
+    body := []byte(payload[start:])
+    switch env.Enc {
+    case "":
+    case "gzip":
+        zr, err := gzip.NewReader(bytes.NewReader(body))
+        if err != nil {
+            return nil, err
+        }
+        if body, err = ioutil.ReadAll(zr); err != nil {
+            return nil, err
+        }
+    default:
+        return nil, errors.New("event: unsupported encoding " + env.Enc)
+    }
+
+    var docs []string
+    switch env.Format {
+    case FORMAT_JSON:
+        if len(body) > 0 {
+            docs = strings.Split(string(body), "\n")
+        }
+    case FORMAT_CBOR:
+        if docs, err = cborToJSON(body); err != nil {
+            return nil, err
+        }
+    default:
+        return nil, errors.New("event: unsupported format " + env.Format)
+    }
+    var payloads []string
+    for _, doc := range docs {
+        payloads = append(payloads, Prefix+doc)
+    }
+    if env.Batch >= 0 && env.Batch != len(payloads) {
+        return nil, fmt.Errorf("event: batch of %d carries %d events", env.Batch, len(payloads))
+    }
+    return payloads, nil
+}
@@ -0,0 +1,33 @@
+/*
+ * batch_test.go
+ *
+ * Batches must come apart into exactly the payloads that went in.
+ */
+
+package event
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestBatchRoundTrip(t *testing.T) {
+    payloads := []string{Prefix + `{"type":"query","sql":"SELECT 1"}`,
+        Prefix + `{"type":"query","sql":"SELECT 2"}`}
+    for _, enc := range []string{"", "gzip"} {
+        batch, err := Batch(payloads, enc)
+        if err != nil {
+            t.Fatalf("%q: %s", enc, err)
+        }
+        got, err := Unbatch(batch)
+        if err != nil || !reflect.DeepEqual(got, payloads) {
+            t.Errorf("%q: unbatched %q, %v", enc, got, err)
+        }
+    }
+    if got, err := Unbatch(payloads[0]); err != nil || len(got) != 1 {
+        t.Errorf("plain payload unbatched to %q, %v", got, err)
+    }
+    if _, err := Batch(payloads, "zstd"); err == nil {
+        t.Errorf("zstd: no error")
+    }
+}
@@ -0,0 +1,296 @@
+/*
+ * cbor.go
+ *
+ * CBOR (RFC 8949) bodies, for consumers that have a CBOR decoder but no
+ * protobuf runtime. A CBOR batch carries each event's JSON document as the
+ * equivalent CBOR map, one after another (a CBOR sequence, RFC 8742):
+ *
+ *   APPS sniff;fmt=cbor;batch=3 <three CBOR maps>
+ *
+ * Only what JSON can hold is written: maps with text keys (sorted), arrays,
+ * text, integers, floats (as float32 where that is exact), booleans and
+ * null. Unbatch turns such a body back into plain JSON payloads.
+ */
+
+package event
+
+import (
+    "bytes"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "sort"
+    "strconv"
+)
+
+const (
+    FORMAT_JSON = "json"
+    FORMAT_CBOR = "cbor"
+)
+
+// CBOR major types.
+const (
+    cborUint   = 0
+    cborNegint = 1
+    cborBytes  = 2
+    cborText   = 3
+    cborArray  = 4
+    cborMap    = 5
+    cborSimple = 7
+)
+
+// cborMaxDepth bounds the nesting a decoded body may have.
+const cborMaxDepth = 32
+
+// jsonToCBOR appends the CBOR form of one JSON document to out.
+func jsonToCBOR(out *bytes.Buffer, doc []byte) error {
+    dec := json.NewDecoder(bytes.NewReader(doc))
+    dec.UseNumber()
+    var v interface{}
+    if err := dec.Decode(&v); err != nil {
+        return err
+    }
+    return writeCBOR(out, v)
+}
+
+func writeCBORHead(out *bytes.Buffer, major byte, n uint64) {
+    major <<= 5
+    switch {
+    case n < 24:
+        out.WriteByte(major | byte(n))
+    case n <= math.MaxUint8:
+        out.WriteByte(major | 24)
+        out.WriteByte(byte(n))
+    case n <= math.MaxUint16:
+        out.WriteByte(major | 25)
+        binary.Write(out, binary.BigEndian, uint16(n))
+    case n <= math.MaxUint32:
+        out.WriteByte(major | 26)
+        binary.Write(out, binary.BigEndian, uint32(n))
+    default:
+        out.WriteByte(major | 27)
+        binary.Write(out, binary.BigEndian, n)
+    }
+}
+
+func writeCBOR(out *bytes.Buffer, v interface{}) error {
+    switch v := v.(type) {
+    case nil:
+        out.WriteByte(0xf6)
+    case bool:
+        if v {
+            out.WriteByte(0xf5)
+        } else {
+            out.WriteByte(0xf4)
+        }
+    case string:
+        writeCBORHead(out, cborText, uint64(len(v)))
+        out.WriteString(v)
+    case json.Number:
+        if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+            if n < 0 {
+                writeCBORHead(out, cborNegint, uint64(-(n + 1)))
+            } else {
+                writeCBORHead(out, cborUint, uint64(n))
+            }
+            return nil
+        }
+        if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+            writeCBORHead(out, cborUint, n)
+            return nil
+        }
+        f, err := v.Float64()
+        if err != nil {
+            return err
+        }
+        if float64(float32(f)) == f {
+            out.WriteByte(0xfa)
+            binary.Write(out, binary.BigEndian, math.Float32bits(float32(f)))
+        } else {
+            out.WriteByte(0xfb)
+            binary.Write(out, binary.BigEndian, math.Float64bits(f))
+        }
+    case []interface{}:
+        writeCBORHead(out, cborArray, uint64(len(v)))
+        for _, item := range v {
+            if err := writeCBOR(out, item); err != nil {
+                return err
+            }
+        }
+    case map[string]interface{}:
+        keys := make([]string, 0, len(v))
+        for key := range v {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        writeCBORHead(out, cborMap, uint64(len(keys)))
+        for _, key := range keys {
+            writeCBORHead(out, cborText, uint64(len(key)))
+            out.WriteString(key)
+            if err := writeCBOR(out, v[key]); err != nil {
+                return err
+            }
+        }
+    default:
+        return fmt.Errorf("event: no CBOR form for %T", v)
+    }
+    return nil
+}
+
+var errCBORShort = errors.New("event: truncated CBOR")
+
+// readCBOR decodes one CBOR item from the start of data into the values
+// encoding/json uses, and returns it with what follows it.
+func readCBOR(data []byte, depth int) (interface{}, []byte, error) {
+    if depth > cborMaxDepth {
+        return nil, nil, errors.New("event: CBOR nested too deep")
+    }
+    if len(data) == 0 {
+        return nil, nil, errCBORShort
+    }
+    major, info := data[0]>>5, data[0]&0x1f
+    data = data[1:]
+
+    if major == cborSimple {
+        var size int
+        switch info {
+        case 20:
+            return false, data, nil
+        case 21:
+            return true, data, nil
+        case 22, 23:
+            return nil, data, nil
+        case 25:
+            size = 2
+        case 26:
+            size = 4
+        case 27:
+            size = 8
+        default:
+            return nil, nil, fmt.Errorf("event: unsupported CBOR simple value %d", info)
+        }
+        if len(data) < size {
+            return nil, nil, errCBORShort
+        }
+        var f float64
+        switch size {
+        case 2:
+            f = halfToFloat(binary.BigEndian.Uint16(data))
+        case 4:
+            f = float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+        case 8:
+            f = math.Float64frombits(binary.BigEndian.Uint64(data))
+        }
+        if math.IsNaN(f) || math.IsInf(f, 0) {
+            return nil, data[size:], nil
+        }
+        return f, data[size:], nil
+    }
+
+    var n uint64
+    switch {
+    case info < 24:
+        n = uint64(info)
+    case info <= 27:
+        size := 1 << (info - 24)
+        if len(data) < size {
+            return nil, nil, errCBORShort
+        }
+        for _, b := range data[:size] {
+            n = n<<8 | uint64(b)
+        }
+        data = data[size:]
+    default:
+        return nil, nil, errors.New("event: indefinite-length CBOR is not supported")
+    }
+
+    switch major {
+    case cborUint:
+        return json.Number(strconv.FormatUint(n, 10)), data, nil
+    case cborNegint:
+        if n > math.MaxInt64 {
+            return nil, nil, errors.New("event: CBOR integer out of range")
+        }
+        return json.Number(strconv.FormatInt(-int64(n)-1, 10)), data, nil
+    case cborBytes, cborText:
+        if uint64(len(data)) < n {
+            return nil, nil, errCBORShort
+        }
+        return string(data[:n]), data[n:], nil
+    case cborArray:
+        if uint64(len(data)) < n {
+            return nil, nil, errCBORShort
+        }
+        list := make([]interface{}, 0, n)
+        for i := uint64(0); i < n; i++ {
+            item, rest, err := readCBOR(data, depth+1)
+            if err != nil {
+                return nil, nil, err
+            }
+            list = append(list, item)
+            data = rest
+        }
+        return list, data, nil
+    case cborMap:
+        if uint64(len(data)) < n*2 {
+            return nil, nil, errCBORShort
+        }
+        m := make(map[string]interface{}, n)
+        for i := uint64(0); i < n; i++ {
+            key, rest, err := readCBOR(data, depth+1)
+            if err != nil {
+                return nil, nil, err
+            }
+            name, ok := key.(string)
+            if !ok {
+                return nil, nil, errors.New("event: CBOR map key is not text")
+            }
+            if m[name], data, err = readCBOR(rest, depth+1); err != nil {
+                return nil, nil, err
+            }
+        }
+        return m, data, nil
+    }
+    return nil, nil, fmt.Errorf("event: unsupported CBOR major type %d", major)
+}
+
+func halfToFloat(h uint16) float64 {
+    exp, frac := int(h>>10)&0x1f, float64(h&0x3ff)
+    var f float64
+    switch exp {
+    case 0:
+        f = math.Ldexp(frac, -24)
+    case 31:
+        if frac == 0 {
+            f = math.Inf(1)
+        } else {
+            f = math.NaN()
+        }
+    default:
+        f = math.Ldexp(frac+1024, exp-25)
+    }
+    if h&0x8000 != 0 {
+        f = -f
+    }
+    return f
+}
+
+// cborToJSON splits a CBOR sequence into JSON documents.
+func cborToJSON(body []byte) ([]string, error) {
+    var docs []string
+    for len(body) > 0 {
+        v, rest, err := readCBOR(body, 0)
+        if err != nil {
+            return nil, err
+        }
+        doc, err := json.Marshal(v)
+        if err != nil {
+            return nil, err
+        }
+        docs = append(docs, string(doc))
+        body = rest
+    }
+    return docs, nil
+}
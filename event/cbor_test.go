@@ -0,0 +1,102 @@
+/*
+ * cbor_test.go
+ *
+ * CBOR bodies must decode to the same events as JSON, and be what a stock
+ * CBOR decoder expects.
+ */
+
+package event
+
+import (
+    "bytes"
+    "encoding/hex"
+    "math"
+    "reflect"
+    "testing"
+)
+
+func TestCBORBytes(t *testing.T) {
+    for _, c := range []struct {
+        doc  string
+        want string
+    }{
+        {`{"a":1}`, "a1616101"},
+        {`[0,23,24,-1,-25,1000000]`, "86001718182038181a000f4240"},
+        {`18446744073709551615`, "1bffffffffffffffff"},
+        {`[1.5,0.1]`, "82fa3fc00000fb3fb999999999999a"},
+        {`{"b":[true,false,null],"a":"xy"}`, "a26161627879616283f5f4f6"},
+    } {
+        var out bytes.Buffer
+        if err := jsonToCBOR(&out, []byte(c.doc)); err != nil {
+            t.Fatalf("%s: %s", c.doc, err)
+        }
+        if got := hex.EncodeToString(out.Bytes()); got != c.want {
+            t.Errorf("%s: got %s, want %s", c.doc, got, c.want)
+        }
+    }
+
+    // Half precision and indefinite lengths come from other encoders.
+    if v, _, err := readCBOR([]byte{0xf9, 0x3e, 0x00}, 0); err != nil || v != 1.5 {
+        t.Errorf("half float read as %v, %v", v, err)
+    }
+    if _, _, err := readCBOR([]byte{0x9f, 0x01, 0xff}, 0); err == nil {
+        t.Errorf("indefinite array: no error")
+    }
+    if _, _, err := readCBOR([]byte{0xa1, 0x61}, 0); err == nil {
+        t.Errorf("truncated map: no error")
+    }
+    deep := bytes.Repeat([]byte{0x81}, cborMaxDepth+2)
+    if _, _, err := readCBOR(append(deep, 0x01), 0); err == nil {
+        t.Errorf("deep nesting: no error")
+    }
+}
+
+func TestCBORRoundTrip(t *testing.T) {
+    took, total := 1.25, math.Pi
+    events := []*QueryEvent{
+        {Type: "query", Sql: "SELECT 1", Size: 12, Time: &took, Total: &total,
+            ResultRows: []uint64{3, 1 << 40}},
+        {Type: "query", Sql: "SELECT '<é>'", IndexHints: []string{"USE INDEX (a)"}},
+    }
+    var payloads []string
+    for _, e := range events {
+        payload, err := Encode(e)
+        if err != nil {
+            t.Fatal(err)
+        }
+        payloads = append(payloads, payload)
+    }
+    for _, enc := range []string{"", "gzip"} {
+        batch, err := BatchFormat(payloads, enc, FORMAT_CBOR)
+        if err != nil {
+            t.Fatalf("%q: %s", enc, err)
+        }
+        json, _ := BatchFormat(payloads, enc, FORMAT_JSON)
+        if enc == "" && len(batch) >= len(json) {
+            t.Errorf("CBOR batch is %d bytes, JSON %d", len(batch), len(json))
+        }
+        got, err := Unbatch(batch)
+        if err != nil || len(got) != len(events) {
+            t.Fatalf("%q: unbatched %q, %v", enc, got, err)
+        }
+        for i, payload := range got {
+            e, err := DecodeQuery(payload)
+            if err != nil || !reflect.DeepEqual(e, events[i]) {
+                t.Errorf("%q: event %d came back as %+v, %v", enc, i, e, err)
+            }
+        }
+    }
+
+    // A signature covers the CBOR body like any other.
+    batch, _ := BatchFormat(payloads[:1], "", FORMAT_CBOR)
+    signed, err := Sign(batch, HMACKey("secret"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := Verify(signed, HMACKey("secret")); err != nil {
+        t.Errorf("signed CBOR batch: %s", err)
+    }
+    if _, err := BatchFormat(payloads, "", "msgpack"); err == nil {
+        t.Errorf("msgpack: no error")
+    }
+}
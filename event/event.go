@@ -0,0 +1,652 @@
+/*
+ * event.go
+ *
+ * The records mysql-sniffer publishes. Go consumers of the stream can import
+ * this package instead of re-implementing the payload format.
+ */
+
+// Package event defines the events published by mysql-sniffer and helpers to
+// encode and decode them. A published message is the topic followed by a
+// payload of the form "APPS sniff {json}".
+package event
+
+import (
+    "encoding/json"
+    "errors"
+    "strings"
+    "time"
+)
+
+// Prefix starts every published payload.
+const Prefix = "APPS sniff "
+
+const (
+    TYPE_QUERY       = "query"
+    TYPE_CONNECTION  = "connection"
+    TYPE_USAGE       = "usage"
+    TYPE_ALERT       = "alert"
+    TYPE_PROCEDURE   = "procedure"
+    TYPE_WATERMARK   = "watermark"
+    TYPE_SUMMARY     = "summary"
+    TYPE_CAPACITY    = "capacity"
+    TYPE_BANDWIDTH   = "bandwidth"
+    TYPE_TRANSACTION = "transaction"
+)
+
+var ErrNoPrefix = errors.New("event: payload does not start with " + Prefix)
+
+// Sequenced is implemented by every event. A sniffer numbers the events it
+// publishes 1, 2, 3, ... so consumers can spot gaps; the numbering restarts
+// with the sniffer, which watermarks make visible.
+type Sequenced interface {
+    Sequence() uint64
+    SetSequence(seq uint64)
+}
+
+// Timestamped is implemented by every event. Ts is the wall clock time the
+// event was published, in unix seconds; Mono is seconds since the sniffer
+// started on a clock NTP can't move, and Started is the sniffer's start in
+// unix seconds. Started + Mono orders events even across wall clock jumps.
+type Timestamped interface {
+    SetTimes(ts float64, mono float64, started int64)
+}
+
+// QueryEvent describes one query seen on the wire.
+type QueryEvent struct {
+    Type      string `json:"type,omitempty" protobuf:"bytes,1,opt,name=type"`
+    ServiceId string `json:"service_id" protobuf:"bytes,2,opt,name=service_id"`
+    TenantId  string `json:"tenant_id" protobuf:"bytes,3,opt,name=tenant_id"`
+    Sql       string `json:"sql" protobuf:"bytes,4,opt,name=sql"`
+    Operate   string `json:"operate" protobuf:"bytes,5,opt,name=operate"`
+    Size      uint64 `json:"size" protobuf:"varint,6,opt,name=size"`
+
+    // Time is the server response time in microseconds. It is absent, and
+    // LatencyAvailable is false, when the response wasn't observed.
+    Time             *float64 `json:"time,omitempty" protobuf:"fixed64,7,opt,name=time"`
+    LatencyAvailable *bool    `json:"latency_available,omitempty" protobuf:"varint,8,opt,name=latency_available"`
+
+    // Total is the time until the last byte of the response, in
+    // microseconds. For large responses SlowSide says whether the time went
+    // on the "server" producing it or the "client" reading it.
+    Total    *float64 `json:"total,omitempty" protobuf:"fixed64,12,opt,name=total"`
+    SlowSide string   `json:"slow_side,omitempty" protobuf:"bytes,13,opt,name=slow_side"`
+
+    // ResultRows has the row count of each result set when there was more
+    // than one, as from a stored procedure.
+    ResultRows []uint64 `json:"result_rows,omitempty" protobuf:"varint,14,rep,name=result_rows"`
+
+    // Hints overriding the optimizer: index hints like "FORCE INDEX (a)" and
+    // the contents of /*+ */ comments like "BKA(t1)".
+    IndexHints     []string `json:"index_hints,omitempty" protobuf:"bytes,15,rep,name=index_hints"`
+    OptimizerHints []string `json:"optimizer_hints,omitempty" protobuf:"bytes,16,rep,name=optimizer_hints"`
+
+    // ThreadId is the server's id for the connection, as in SHOW
+    // PROCESSLIST, when the connection was seen from its start.
+    ThreadId uint32 `json:"thread_id,omitempty" protobuf:"varint,17,opt,name=thread_id"`
+
+    // User is who the connection logged in as and Db the database it named
+    // then (or with a later COM_CHANGE_USER). Schema is where it is now.
+    User string `json:"user,omitempty" protobuf:"bytes,24,opt,name=user"`
+    Db   string `json:"db,omitempty" protobuf:"bytes,25,opt,name=db"`
+
+    // How complex the query's fingerprint is: its length, JOINs, nesting
+    // of subqueries, predicates in WHERE/ON/HAVING, ORDER BY and GROUP BY.
+    QueryLength   int  `json:"query_length,omitempty" protobuf:"varint,26,opt,name=query_length"`
+    Joins         int  `json:"joins,omitempty" protobuf:"varint,27,opt,name=joins"`
+    SubqueryDepth int  `json:"subquery_depth,omitempty" protobuf:"varint,28,opt,name=subquery_depth"`
+    Predicates    int  `json:"predicates,omitempty" protobuf:"varint,29,opt,name=predicates"`
+    OrderBy       bool `json:"order_by,omitempty" protobuf:"varint,30,opt,name=order_by"`
+    GroupBy       bool `json:"group_by,omitempty" protobuf:"varint,31,opt,name=group_by"`
+
+    // AntiPatterns names the anti-pattern rules the statement breaks, like
+    // "order_by_rand" or "large_offset".
+    AntiPatterns []string `json:"anti_patterns,omitempty" protobuf:"bytes,32,rep,name=anti_patterns"`
+
+    // Offset is the LIMIT offset of a paginated statement, as sent.
+    Offset uint64 `json:"offset,omitempty" protobuf:"varint,33,opt,name=offset"`
+
+    // Slow is set when the round trip took -slow_ms or longer; a
+    // "slow_query" alert goes out for it as well.
+    Slow bool `json:"slow,omitempty" protobuf:"varint,34,opt,name=slow"`
+
+    // The containers at each end of the connection, with -docker.
+    ClientContainer string `json:"client_container,omitempty" protobuf:"bytes,35,opt,name=client_container"`
+    ClientImage     string `json:"client_image,omitempty" protobuf:"bytes,36,opt,name=client_image"`
+    ServerContainer string `json:"server_container,omitempty" protobuf:"bytes,37,opt,name=server_container"`
+    ServerImage     string `json:"server_image,omitempty" protobuf:"bytes,38,opt,name=server_image"`
+
+    // SampleRate is the fraction of queries like this one published, when
+    // the sniffer samples.
+    SampleRate float64 `json:"sample_rate,omitempty" protobuf:"fixed64,39,opt,name=sample_rate"`
+
+    // On a ProxySQL host, whether the statement went from the application
+    // to the proxy ("frontend") or from the proxy to MySQL ("backend"), and
+    // for the frontend the microseconds the proxy added to the round trip.
+    ProxySide string   `json:"proxy_side,omitempty" protobuf:"bytes,40,opt,name=proxy_side"`
+    ProxyTime *float64 `json:"proxy_time,omitempty" protobuf:"fixed64,41,opt,name=proxy_time"`
+
+    // What the response said, when it was followed to the end: rows
+    // returned over all result sets, rows affected and the last insert id
+    // from OK packets, or the error from an ERR packet.
+    RowsReturned *uint64 `json:"rows_returned,omitempty" protobuf:"varint,18,opt,name=rows_returned"`
+    RowsAffected *uint64 `json:"rows_affected,omitempty" protobuf:"varint,19,opt,name=rows_affected"`
+    LastInsertId uint64  `json:"last_insert_id,omitempty" protobuf:"varint,20,opt,name=last_insert_id"`
+    ErrorCode    uint16  `json:"error_code,omitempty" protobuf:"varint,21,opt,name=error_code"`
+    SqlState     string  `json:"sql_state,omitempty" protobuf:"bytes,22,opt,name=sql_state"`
+    Error        string  `json:"error,omitempty" protobuf:"bytes,23,opt,name=error"`
+
+    // Schema is the session's default database, when the server reports it
+    // through session_track_schema.
+    Schema string `json:"schema,omitempty" protobuf:"bytes,9,opt,name=schema"`
+
+    // Name is the friendly name given to the query's fingerprint, if any.
+    Name string `json:"name,omitempty" protobuf:"bytes,10,opt,name=name"`
+
+    // Class is "monitoring" for queries from monitoring agents, otherwise
+    // empty.
+    Class string `json:"class,omitempty" protobuf:"bytes,11,opt,name=class"`
+
+    // See Sequenced and Timestamped.
+    Seq     uint64  `json:"seq,omitempty" protobuf:"varint,100,opt,name=seq"`
+    Ts      float64 `json:"ts,omitempty" protobuf:"fixed64,101,opt,name=ts"`
+    Mono    float64 `json:"mono,omitempty" protobuf:"fixed64,102,opt,name=mono"`
+    Started int64   `json:"started,omitempty" protobuf:"varint,103,opt,name=started"`
+}
+
+func (e *QueryEvent) Sequence() uint64       { return e.Seq }
+func (e *QueryEvent) SetSequence(seq uint64) { e.Seq = seq }
+func (e *QueryEvent) SetTimes(ts float64, mono float64, started int64) {
+    e.Ts, e.Mono, e.Started = ts, mono, started
+}
+
+// Latency returns the response time of the query, if it is known.
+func (e *QueryEvent) Latency() (time.Duration, bool) {
+    if e.Time == nil {
+        return 0, false
+    }
+    return time.Duration(*e.Time * float64(time.Microsecond)), true
+}
+
+// Validate checks that the event carries the fields every query event must
+// have.
+func (e *QueryEvent) Validate() error {
+    switch {
+    case e.ServiceId == "":
+        return errors.New("event: missing service_id")
+    case e.TenantId == "":
+        return errors.New("event: missing tenant_id")
+    case e.Sql == "":
+        return errors.New("event: missing sql")
+    case e.Operate == "":
+        return errors.New("event: missing operate")
+    case e.Time == nil && e.LatencyAvailable == nil:
+        return errors.New("event: neither time nor latency_available set")
+    }
+    return nil
+}
+
+// ConnectionEvent describes a client connection opening or closing.
+type ConnectionEvent struct {
+    Type       string `json:"type" protobuf:"bytes,1,opt,name=type"`
+    ServiceId  string `json:"service_id" protobuf:"bytes,2,opt,name=service_id"`
+    TenantId   string `json:"tenant_id" protobuf:"bytes,3,opt,name=tenant_id"`
+    State      string `json:"state" protobuf:"bytes,4,opt,name=state"` // "open" or "close"
+    Client     string `json:"client" protobuf:"bytes,5,opt,name=client"`
+    Generation uint64 `json:"generation" protobuf:"varint,6,opt,name=generation"`
+
+    // Only set when State is "close".
+    Duration float64 `json:"duration,omitempty" protobuf:"fixed64,7,opt,name=duration"` // seconds
+    Queries  uint64  `json:"queries,omitempty" protobuf:"varint,8,opt,name=queries"`
+    Bytes    uint64  `json:"bytes,omitempty" protobuf:"varint,9,opt,name=bytes"`
+    Resets   uint64  `json:"resets,omitempty" protobuf:"varint,10,opt,name=resets"` // COM_RESET_CONNECTIONs
+
+    // See Sequenced and Timestamped.
+    Seq     uint64  `json:"seq,omitempty" protobuf:"varint,100,opt,name=seq"`
+    Ts      float64 `json:"ts,omitempty" protobuf:"fixed64,101,opt,name=ts"`
+    Mono    float64 `json:"mono,omitempty" protobuf:"fixed64,102,opt,name=mono"`
+    Started int64   `json:"started,omitempty" protobuf:"varint,103,opt,name=started"`
+}
+
+func (e *ConnectionEvent) Sequence() uint64       { return e.Seq }
+func (e *ConnectionEvent) SetSequence(seq uint64) { e.Seq = seq }
+func (e *ConnectionEvent) SetTimes(ts float64, mono float64, started int64) {
+    e.Ts, e.Mono, e.Started = ts, mono, started
+}
+
+// Validate checks that the event carries the fields every connection event
+// must have.
+func (e *ConnectionEvent) Validate() error {
+    switch {
+    case e.ServiceId == "":
+        return errors.New("event: missing service_id")
+    case e.TenantId == "":
+        return errors.New("event: missing tenant_id")
+    case e.State != "open" && e.State != "close":
+        return errors.New("event: bad state " + e.State)
+    case e.Client == "":
+        return errors.New("event: missing client")
+    }
+    return nil
+}
+
+// TransactionEvent describes a transaction that just ended: by COMMIT, by
+// ROLLBACK, or implicitly, e.g. by DDL.
+type TransactionEvent struct {
+    Type       string  `json:"type" protobuf:"bytes,1,opt,name=type"`
+    ServiceId  string  `json:"service_id" protobuf:"bytes,2,opt,name=service_id"`
+    TenantId   string  `json:"tenant_id" protobuf:"bytes,3,opt,name=tenant_id"`
+    Client     string  `json:"client" protobuf:"bytes,4,opt,name=client"`
+    ThreadId   uint32  `json:"thread_id,omitempty" protobuf:"varint,5,opt,name=thread_id"`
+    User       string  `json:"user,omitempty" protobuf:"bytes,6,opt,name=user"`
+    Schema     string  `json:"schema,omitempty" protobuf:"bytes,7,opt,name=schema"`
+    Outcome    string  `json:"outcome" protobuf:"bytes,8,opt,name=outcome"` // "commit", "rollback" or "implicit"
+    Statements uint64  `json:"statements" protobuf:"varint,9,opt,name=statements"`
+    Begin      float64 `json:"begin" protobuf:"fixed64,10,opt,name=begin"`       // unix seconds
+    Duration   float64 `json:"duration" protobuf:"fixed64,11,opt,name=duration"` // to the COMMIT or ROLLBACK, milliseconds
+
+    // See Sequenced and Timestamped.
+    Seq     uint64  `json:"seq,omitempty" protobuf:"varint,100,opt,name=seq"`
+    Ts      float64 `json:"ts,omitempty" protobuf:"fixed64,101,opt,name=ts"`
+    Mono    float64 `json:"mono,omitempty" protobuf:"fixed64,102,opt,name=mono"`
+    Started int64   `json:"started,omitempty" protobuf:"varint,103,opt,name=started"`
+}
+
+func (e *TransactionEvent) Sequence() uint64       { return e.Seq }
+func (e *TransactionEvent) SetSequence(seq uint64) { e.Seq = seq }
+func (e *TransactionEvent) SetTimes(ts float64, mono float64, started int64) {
+    e.Ts, e.Mono, e.Started = ts, mono, started
+}
+
+// Validate checks that the event carries the fields every transaction event
+// must have.
+func (e *TransactionEvent) Validate() error {
+    switch {
+    case e.ServiceId == "":
+        return errors.New("event: missing service_id")
+    case e.Client == "":
+        return errors.New("event: missing client")
+    case e.Outcome != "commit" && e.Outcome != "rollback" && e.Outcome != "implicit":
+        return errors.New("event: bad outcome " + e.Outcome)
+    }
+    return nil
+}
+
+// UsageEvent reports what one tenant used during a window, for chargeback.
+type UsageEvent struct {
+    Type        string  `json:"type" protobuf:"bytes,1,opt,name=type"`
+    ServiceId   string  `json:"service_id" protobuf:"bytes,2,opt,name=service_id"`
+    TenantId    string  `json:"tenant_id" protobuf:"bytes,3,opt,name=tenant_id"`
+    WindowStart int64   `json:"window_start" protobuf:"varint,4,opt,name=window_start"` // unix seconds
+    WindowSecs  float64 `json:"window_secs" protobuf:"fixed64,5,opt,name=window_secs"`
+    Queries     uint64  `json:"queries" protobuf:"varint,6,opt,name=queries"`
+    Bytes       uint64  `json:"bytes" protobuf:"varint,7,opt,name=bytes"`
+    Time        float64 `json:"time" protobuf:"fixed64,8,opt,name=time"` // server time, milliseconds
+    OverQuota   bool    `json:"over_quota,omitempty" protobuf:"varint,9,opt,name=over_quota"`
+
+    // See Sequenced and Timestamped.
+    Seq     uint64  `json:"seq,omitempty" protobuf:"varint,100,opt,name=seq"`
+    Ts      float64 `json:"ts,omitempty" protobuf:"fixed64,101,opt,name=ts"`
+    Mono    float64 `json:"mono,omitempty" protobuf:"fixed64,102,opt,name=mono"`
+    Started int64   `json:"started,omitempty" protobuf:"varint,103,opt,name=started"`
+}
+
+func (e *UsageEvent) Sequence() uint64       { return e.Seq }
+func (e *UsageEvent) SetSequence(seq uint64) { e.Seq = seq }
+func (e *UsageEvent) SetTimes(ts float64, mono float64, started int64) {
+    e.Ts, e.Mono, e.Started = ts, mono, started
+}
+
+// Validate checks that the event carries the fields every usage event must
+// have.
+func (e *UsageEvent) Validate() error {
+    switch {
+    case e.ServiceId == "":
+        return errors.New("event: missing service_id")
+    case e.TenantId == "":
+        return errors.New("event: missing tenant_id")
+    case e.WindowSecs <= 0:
+        return errors.New("event: missing window_secs")
+    }
+    return nil
+}
+
+// AlertEvent is raised when something crosses a configured threshold. Kind
+// says what (e.g. "quota"); Value and Limit are in whatever unit the kind
+// uses, and Message is meant for humans. Alerts about one query
+// ("slow_query") also say which, from where and how big.
+type AlertEvent struct {
+    Type      string  `json:"type" protobuf:"bytes,1,opt,name=type"`
+    ServiceId string  `json:"service_id" protobuf:"bytes,2,opt,name=service_id"`
+    TenantId  string  `json:"tenant_id" protobuf:"bytes,3,opt,name=tenant_id"`
+    Kind      string  `json:"kind" protobuf:"bytes,4,opt,name=kind"`
+    Message   string  `json:"message" protobuf:"bytes,5,opt,name=message"`
+    Value     float64 `json:"value" protobuf:"fixed64,6,opt,name=value"`
+    Limit     float64 `json:"limit" protobuf:"fixed64,7,opt,name=limit"`
+
+    Fingerprint string `json:"fingerprint,omitempty" protobuf:"bytes,8,opt,name=fingerprint"`
+    Client      string `json:"client,omitempty" protobuf:"bytes,9,opt,name=client"` // IP
+    Size        uint64 `json:"size,omitempty" protobuf:"varint,10,opt,name=size"`   // request bytes
+
+    // See Sequenced and Timestamped.
+    Seq     uint64  `json:"seq,omitempty" protobuf:"varint,100,opt,name=seq"`
+    Ts      float64 `json:"ts,omitempty" protobuf:"fixed64,101,opt,name=ts"`
+    Mono    float64 `json:"mono,omitempty" protobuf:"fixed64,102,opt,name=mono"`
+    Started int64   `json:"started,omitempty" protobuf:"varint,103,opt,name=started"`
+}
+
+func (e *AlertEvent) Sequence() uint64       { return e.Seq }
+func (e *AlertEvent) SetSequence(seq uint64) { e.Seq = seq }
+func (e *AlertEvent) SetTimes(ts float64, mono float64, started int64) {
+    e.Ts, e.Mono, e.Started = ts, mono, started
+}
+
+// Validate checks that the event carries the fields every alert must have.
+func (e *AlertEvent) Validate() error {
+    switch {
+    case e.ServiceId == "":
+        return errors.New("event: missing service_id")
+    case e.Kind == "":
+        return errors.New("event: missing kind")
+    }
+    return nil
+}
+
+// ProcedureEvent totals the calls of one stored procedure or function during
+// a window.
+type ProcedureEvent struct {
+    Type        string  `json:"type" protobuf:"bytes,1,opt,name=type"`
+    ServiceId   string  `json:"service_id" protobuf:"bytes,2,opt,name=service_id"`
+    TenantId    string  `json:"tenant_id" protobuf:"bytes,3,opt,name=tenant_id"`
+    Name        string  `json:"name" protobuf:"bytes,4,opt,name=name"`
+    Kind        string  `json:"kind" protobuf:"bytes,5,opt,name=kind"`                  // "procedure" or "function"
+    WindowStart int64   `json:"window_start" protobuf:"varint,6,opt,name=window_start"` // unix seconds
+    WindowSecs  float64 `json:"window_secs" protobuf:"fixed64,7,opt,name=window_secs"`
+    Calls       uint64  `json:"calls" protobuf:"varint,8,opt,name=calls"`
+    Time        float64 `json:"time" protobuf:"fixed64,9,opt,name=time"` // total, milliseconds
+    Rows        uint64  `json:"rows" protobuf:"varint,10,opt,name=rows"`
+
+    // See Sequenced and Timestamped.
+    Seq     uint64  `json:"seq,omitempty" protobuf:"varint,100,opt,name=seq"`
+    Ts      float64 `json:"ts,omitempty" protobuf:"fixed64,101,opt,name=ts"`
+    Mono    float64 `json:"mono,omitempty" protobuf:"fixed64,102,opt,name=mono"`
+    Started int64   `json:"started,omitempty" protobuf:"varint,103,opt,name=started"`
+}
+
+func (e *ProcedureEvent) Sequence() uint64       { return e.Seq }
+func (e *ProcedureEvent) SetSequence(seq uint64) { e.Seq = seq }
+func (e *ProcedureEvent) SetTimes(ts float64, mono float64, started int64) {
+    e.Ts, e.Mono, e.Started = ts, mono, started
+}
+
+// Validate checks that the event carries the fields every procedure event
+// must have.
+func (e *ProcedureEvent) Validate() error {
+    switch {
+    case e.ServiceId == "":
+        return errors.New("event: missing service_id")
+    case e.Name == "":
+        return errors.New("event: missing name")
+    case e.Kind != "procedure" && e.Kind != "function":
+        return errors.New("event: bad kind " + e.Kind)
+    }
+    return nil
+}
+
+// SummaryEvent lists the top fingerprints of a window, ranked by SortBy:
+// "count", "time" or "bytes".
+type SummaryEvent struct {
+    Type        string         `json:"type" protobuf:"bytes,1,opt,name=type"`
+    ServiceId   string         `json:"service_id" protobuf:"bytes,2,opt,name=service_id"`
+    TenantId    string         `json:"tenant_id" protobuf:"bytes,3,opt,name=tenant_id"`
+    WindowStart int64          `json:"window_start" protobuf:"varint,4,opt,name=window_start"` // unix seconds
+    WindowSecs  float64        `json:"window_secs" protobuf:"fixed64,5,opt,name=window_secs"`
+    SortBy      string         `json:"sort_by" protobuf:"bytes,6,opt,name=sort_by"`
+    Top         []SummaryEntry `json:"top" protobuf:"bytes,7,rep,name=top"`
+
+    // Commands counts the commands of each kind ("query", "ping", ...)
+    // sent during the window, and Connects the connections opened.
+    Commands map[string]uint64 `json:"commands,omitempty" protobuf:"bytes,8,rep,name=commands" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+    Connects uint64            `json:"connects" protobuf:"varint,9,opt,name=connects"`
+
+    // See Sequenced and Timestamped.
+    Seq     uint64  `json:"seq,omitempty" protobuf:"varint,100,opt,name=seq"`
+    Ts      float64 `json:"ts,omitempty" protobuf:"fixed64,101,opt,name=ts"`
+    Mono    float64 `json:"mono,omitempty" protobuf:"fixed64,102,opt,name=mono"`
+    Started int64   `json:"started,omitempty" protobuf:"varint,103,opt,name=started"`
+}
+
+type SummaryEntry struct {
+    Fingerprint string  `json:"fingerprint" protobuf:"bytes,1,opt,name=fingerprint"`
+    Name        string  `json:"name,omitempty" protobuf:"bytes,2,opt,name=name"`
+    Count       uint64  `json:"count" protobuf:"varint,3,opt,name=count"`
+    Time        float64 `json:"time" protobuf:"fixed64,4,opt,name=time"` // milliseconds
+    Bytes       uint64  `json:"bytes" protobuf:"varint,5,opt,name=bytes"`
+    P50         float64 `json:"p50" protobuf:"fixed64,6,opt,name=p50"` // milliseconds
+    P95         float64 `json:"p95" protobuf:"fixed64,7,opt,name=p95"`
+    P99         float64 `json:"p99" protobuf:"fixed64,8,opt,name=p99"`
+    Errors      uint64  `json:"errors,omitempty" protobuf:"varint,9,opt,name=errors"`
+    // OK responses over all responses; unset if none were seen.
+    SuccessRate *float64 `json:"success_rate,omitempty" protobuf:"fixed64,10,opt,name=success_rate"`
+}
+
+func (e *SummaryEvent) Sequence() uint64       { return e.Seq }
+func (e *SummaryEvent) SetSequence(seq uint64) { e.Seq = seq }
+func (e *SummaryEvent) SetTimes(ts float64, mono float64, started int64) {
+    e.Ts, e.Mono, e.Started = ts, mono, started
+}
+
+// Validate checks that the event carries the fields every summary must have.
+func (e *SummaryEvent) Validate() error {
+    switch {
+    case e.ServiceId == "":
+        return errors.New("event: missing service_id")
+    case e.WindowSecs <= 0:
+        return errors.New("event: missing window_secs")
+    case e.SortBy != "count" && e.SortBy != "time" && e.SortBy != "bytes":
+        return errors.New("event: bad sort_by " + e.SortBy)
+    }
+    return nil
+}
+
+// CapacityEvent is the periodic capacity report: load over the period that
+// just ended and how fast it grows from period to period, overall and for
+// the fastest growing fingerprints. Growth is a fraction of the current
+// load per period, e.g. 0.05 for 5% more each period.
+type CapacityEvent struct {
+    Type        string  `json:"type" protobuf:"bytes,1,opt,name=type"`
+    ServiceId   string  `json:"service_id" protobuf:"bytes,2,opt,name=service_id"`
+    TenantId    string  `json:"tenant_id" protobuf:"bytes,3,opt,name=tenant_id"`
+    WindowStart int64   `json:"window_start" protobuf:"varint,4,opt,name=window_start"` // unix seconds
+    WindowSecs  float64 `json:"window_secs" protobuf:"fixed64,5,opt,name=window_secs"`
+    Periods     int     `json:"periods" protobuf:"varint,6,opt,name=periods"` // the trends are fitted over
+
+    QPS         float64 `json:"qps" protobuf:"fixed64,7,opt,name=qps"`
+    QPSGrowth   float64 `json:"qps_growth" protobuf:"fixed64,8,opt,name=qps_growth"`
+    AvgMs       float64 `json:"avg_ms" protobuf:"fixed64,9,opt,name=avg_ms"`
+    AvgMsGrowth float64 `json:"avg_ms_growth" protobuf:"fixed64,10,opt,name=avg_ms_growth"`
+    BytesPerSec float64 `json:"bytes_per_sec" protobuf:"fixed64,11,opt,name=bytes_per_sec"`
+    BytesGrowth float64 `json:"bytes_growth" protobuf:"fixed64,12,opt,name=bytes_growth"`
+
+    // SaturationDays is when QPS would reach the configured limit if the
+    // trend holds, absent if there's no limit or QPS isn't growing.
+    SaturationDays *float64 `json:"saturation_days,omitempty" protobuf:"fixed64,13,opt,name=saturation_days"`
+
+    Top []CapacityEntry `json:"top" protobuf:"bytes,14,rep,name=top"`
+
+    // See Sequenced and Timestamped.
+    Seq     uint64  `json:"seq,omitempty" protobuf:"varint,100,opt,name=seq"`
+    Ts      float64 `json:"ts,omitempty" protobuf:"fixed64,101,opt,name=ts"`
+    Mono    float64 `json:"mono,omitempty" protobuf:"fixed64,102,opt,name=mono"`
+    Started int64   `json:"started,omitempty" protobuf:"varint,103,opt,name=started"`
+}
+
+type CapacityEntry struct {
+    Fingerprint string  `json:"fingerprint" protobuf:"bytes,1,opt,name=fingerprint"`
+    Name        string  `json:"name,omitempty" protobuf:"bytes,2,opt,name=name"`
+    QPS         float64 `json:"qps" protobuf:"fixed64,3,opt,name=qps"`
+    QPSGrowth   float64 `json:"qps_growth" protobuf:"fixed64,4,opt,name=qps_growth"`
+}
+
+func (e *CapacityEvent) Sequence() uint64       { return e.Seq }
+func (e *CapacityEvent) SetSequence(seq uint64) { e.Seq = seq }
+func (e *CapacityEvent) SetTimes(ts float64, mono float64, started int64) {
+    e.Ts, e.Mono, e.Started = ts, mono, started
+}
+
+// Validate checks that the event carries the fields every capacity report
+// must have.
+func (e *CapacityEvent) Validate() error {
+    switch {
+    case e.ServiceId == "":
+        return errors.New("event: missing service_id")
+    case e.WindowSecs <= 0:
+        return errors.New("event: missing window_secs")
+    }
+    return nil
+}
+
+// BandwidthEvent gives the bytes seen on the wire each way during a window,
+// in total and for the busiest clients (by IP). In is client to server.
+type BandwidthEvent struct {
+    Type        string  `json:"type" protobuf:"bytes,1,opt,name=type"`
+    ServiceId   string  `json:"service_id" protobuf:"bytes,2,opt,name=service_id"`
+    TenantId    string  `json:"tenant_id" protobuf:"bytes,3,opt,name=tenant_id"`
+    WindowStart int64   `json:"window_start" protobuf:"varint,4,opt,name=window_start"` // unix seconds
+    WindowSecs  float64 `json:"window_secs" protobuf:"fixed64,5,opt,name=window_secs"`
+
+    Total   BandwidthEntry   `json:"total" protobuf:"bytes,6,opt,name=total"`
+    Clients int              `json:"clients" protobuf:"varint,7,opt,name=clients"` // seen, not just listed
+    Top     []BandwidthEntry `json:"top" protobuf:"bytes,8,rep,name=top"`
+
+    // See Sequenced and Timestamped.
+    Seq     uint64  `json:"seq,omitempty" protobuf:"varint,100,opt,name=seq"`
+    Ts      float64 `json:"ts,omitempty" protobuf:"fixed64,101,opt,name=ts"`
+    Mono    float64 `json:"mono,omitempty" protobuf:"fixed64,102,opt,name=mono"`
+    Started int64   `json:"started,omitempty" protobuf:"varint,103,opt,name=started"`
+}
+
+type BandwidthEntry struct {
+    Client     string  `json:"client,omitempty" protobuf:"bytes,1,opt,name=client"`
+    InBytes    uint64  `json:"in_bytes" protobuf:"varint,2,opt,name=in_bytes"`
+    OutBytes   uint64  `json:"out_bytes" protobuf:"varint,3,opt,name=out_bytes"`
+    InPackets  uint64  `json:"in_packets" protobuf:"varint,4,opt,name=in_packets"`
+    OutPackets uint64  `json:"out_packets" protobuf:"varint,5,opt,name=out_packets"`
+    InPerSec   float64 `json:"in_per_sec" protobuf:"fixed64,6,opt,name=in_per_sec"`
+    OutPerSec  float64 `json:"out_per_sec" protobuf:"fixed64,7,opt,name=out_per_sec"`
+}
+
+func (e *BandwidthEvent) Sequence() uint64       { return e.Seq }
+func (e *BandwidthEvent) SetSequence(seq uint64) { e.Seq = seq }
+func (e *BandwidthEvent) SetTimes(ts float64, mono float64, started int64) {
+    e.Ts, e.Mono, e.Started = ts, mono, started
+}
+
+// Validate checks that the event carries the fields every bandwidth event
+// must have.
+func (e *BandwidthEvent) Validate() error {
+    switch {
+    case e.ServiceId == "":
+        return errors.New("event: missing service_id")
+    case e.WindowSecs <= 0:
+        return errors.New("event: missing window_secs")
+    }
+    return nil
+}
+
+// WatermarkEvent is published periodically so consumers can tell how many
+// events they should have received. Seq is the watermark's own number, so
+// every event published before it has a lower one. Instance changes when the
+// sniffer restarts and its numbering starts over.
+type WatermarkEvent struct {
+    Type      string  `json:"type" protobuf:"bytes,1,opt,name=type"`
+    ServiceId string  `json:"service_id" protobuf:"bytes,2,opt,name=service_id"`
+    TenantId  string  `json:"tenant_id" protobuf:"bytes,3,opt,name=tenant_id"`
+    Instance  string  `json:"instance" protobuf:"bytes,4,opt,name=instance"`
+    Started   int64   `json:"started" protobuf:"varint,5,opt,name=started"` // unix seconds
+    Seq       uint64  `json:"seq" protobuf:"varint,100,opt,name=seq"`
+    Ts        float64 `json:"ts,omitempty" protobuf:"fixed64,101,opt,name=ts"`
+    Mono      float64 `json:"mono,omitempty" protobuf:"fixed64,102,opt,name=mono"`
+}
+
+func (e *WatermarkEvent) Sequence() uint64       { return e.Seq }
+func (e *WatermarkEvent) SetSequence(seq uint64) { e.Seq = seq }
+func (e *WatermarkEvent) SetTimes(ts float64, mono float64, started int64) {
+    e.Ts, e.Mono, e.Started = ts, mono, started
+}
+
+// Validate checks that the event carries the fields every watermark must
+// have.
+func (e *WatermarkEvent) Validate() error {
+    switch {
+    case e.ServiceId == "":
+        return errors.New("event: missing service_id")
+    case e.Instance == "":
+        return errors.New("event: missing instance")
+    case e.Seq == 0:
+        return errors.New("event: missing seq")
+    }
+    return nil
+}
+
+// Encode returns the payload for an event.
+func Encode(e interface{}) (string, error) {
+    data, err := json.Marshal(e)
+    if err != nil {
+        return "", err
+    }
+    return Prefix + string(data), nil
+}
+
+// decoders maps each event type to a constructor for its struct.
+var decoders = map[string]func() interface{}{
+    "":               func() interface{} { return &QueryEvent{} },
+    TYPE_QUERY:       func() interface{} { return &QueryEvent{} },
+    TYPE_CONNECTION:  func() interface{} { return &ConnectionEvent{} },
+    TYPE_USAGE:       func() interface{} { return &UsageEvent{} },
+    TYPE_ALERT:       func() interface{} { return &AlertEvent{} },
+    TYPE_PROCEDURE:   func() interface{} { return &ProcedureEvent{} },
+    TYPE_WATERMARK:   func() interface{} { return &WatermarkEvent{} },
+    TYPE_SUMMARY:     func() interface{} { return &SummaryEvent{} },
+    TYPE_CAPACITY:    func() interface{} { return &CapacityEvent{} },
+    TYPE_BANDWIDTH:   func() interface{} { return &BandwidthEvent{} },
+    TYPE_TRANSACTION: func() interface{} { return &TransactionEvent{} },
+}
+
+// Decode parses a payload into a pointer to the struct for its type, e.g.
+// *QueryEvent. Payloads without a type are query events, as published by
+// older sniffers.
+func Decode(payload string) (interface{}, error) {
+    if !strings.HasPrefix(payload, Prefix) {
+        return nil, ErrNoPrefix
+    }
+    data := []byte(payload[len(Prefix):])
+
+    var probe struct {
+        Type string `json:"type"`
+    }
+    if err := json.Unmarshal(data, &probe); err != nil {
+        return nil, err
+    }
+    ctor, ok := decoders[probe.Type]
+    if !ok {
+        return nil, errors.New("event: unknown type " + probe.Type)
+    }
+    e := ctor()
+    if err := json.Unmarshal(data, e); err != nil {
+        return nil, err
+    }
+    return e, nil
+}
+
+// DecodeQuery parses a payload that must be a query event.
+func DecodeQuery(payload string) (*QueryEvent, error) {
+    e, err := Decode(payload)
+    if err != nil {
+        return nil, err
+    }
+    q, ok := e.(*QueryEvent)
+    if !ok {
+        return nil, errors.New("event: not a query event")
+    }
+    return q, nil
+}
@@ -0,0 +1,133 @@
+/*
+ * sign.go
+ *
+ * Signed payloads. A sniffer may sign each message it sends, single event or
+ * batch, so consumers can tell it wasn't altered on the way or while
+ * spooled. The signature goes in the envelope; a single event is sent as a
+ * batch of one for that:
+ *
+ *   APPS sniff;batch=1;sig=hmac-sha256:<base64> {json}
+ *
+ * It covers the message as it would be without the sig field, i.e.
+ * "APPS sniff;batch=1 {json}" above.
+ */
+
+package event
+
+import (
+    "crypto/ed25519"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "errors"
+    "strings"
+)
+
+const (
+    SIG_HMAC_SHA256 = "hmac-sha256"
+    SIG_ED25519     = "ed25519"
+)
+
+var ErrUnsigned = errors.New("event: payload is not signed")
+var ErrBadSignature = errors.New("event: bad signature")
+
+type Signer interface {
+    Algorithm() string
+    Sign(message []byte) []byte
+}
+
+type Verifier interface {
+    Algorithm() string
+    Verify(message []byte, sig []byte) bool
+}
+
+// HMACKey signs and verifies with HMAC-SHA256.
+type HMACKey []byte
+
+func (self HMACKey) Algorithm() string { return SIG_HMAC_SHA256 }
+
+func (self HMACKey) Sign(message []byte) []byte {
+    mac := hmac.New(sha256.New, self)
+    mac.Write(message)
+    return mac.Sum(nil)
+}
+
+func (self HMACKey) Verify(message []byte, sig []byte) bool {
+    return hmac.Equal(self.Sign(message), sig)
+}
+
+// Ed25519Signer signs with an ed25519 private key; consumers only need the
+// public key, as an Ed25519Verifier.
+type Ed25519Signer ed25519.PrivateKey
+
+func (self Ed25519Signer) Algorithm() string { return SIG_ED25519 }
+
+func (self Ed25519Signer) Sign(message []byte) []byte {
+    return ed25519.Sign(ed25519.PrivateKey(self), message)
+}
+
+type Ed25519Verifier ed25519.PublicKey
+
+func (self Ed25519Verifier) Algorithm() string { return SIG_ED25519 }
+
+func (self Ed25519Verifier) Verify(message []byte, sig []byte) bool {
+    return ed25519.Verify(ed25519.PublicKey(self), message, sig)
+}
+
+// splitEnvelope returns the header of a payload, without the sig field, the
+// sig field's value ("" if none) and the body. A plain payload is treated
+// as a batch of one.
+func splitEnvelope(payload string) (header string, sig string, body string, err error) {
+    if strings.HasPrefix(payload, Prefix) {
+        return EnvelopePrefix + "batch=1", "", payload[len(Prefix):], nil
+    }
+    if !strings.HasPrefix(payload, EnvelopePrefix) {
+        return "", "", "", ErrNoPrefix
+    }
+    space := strings.IndexByte(payload[len(EnvelopePrefix):], ' ')
+    if space < 0 {
+        return "", "", "", errors.New("event: unterminated envelope")
+    }
+    space += len(EnvelopePrefix)
+    var fields []string
+    for _, field := range strings.Split(payload[len(EnvelopePrefix):space], ";") {
+        if strings.HasPrefix(field, "sig=") {
+            sig = field[len("sig="):]
+        } else {
+            fields = append(fields, field)
+        }
+    }
+    return EnvelopePrefix + strings.Join(fields, ";"), sig, payload[space+1:], nil
+}
+
+// Sign returns the payload, plain or batched, with a signature by s.
+func Sign(payload string, s Signer) (string, error) {
+    header, _, body, err := splitEnvelope(payload)
+    if err != nil {
+        return "", err
+    }
+    sig := s.Sign([]byte(header + " " + body))
+    return header + ";sig=" + s.Algorithm() + ":" + base64.StdEncoding.EncodeToString(sig) +
+        " " + body, nil
+}
+
+// Verify checks the signature on a payload. Unbatch reads a signed payload
+// like any other.
+func Verify(payload string, v Verifier) error {
+    header, sig, body, err := splitEnvelope(payload)
+    if err != nil {
+        return err
+    }
+    if sig == "" {
+        return ErrUnsigned
+    }
+    parts := strings.SplitN(sig, ":", 2)
+    if len(parts) != 2 || parts[0] != v.Algorithm() {
+        return errors.New("event: signed with " + parts[0] + ", not " + v.Algorithm())
+    }
+    raw, err := base64.StdEncoding.DecodeString(parts[1])
+    if err != nil || !v.Verify([]byte(header+" "+body), raw) {
+        return ErrBadSignature
+    }
+    return nil
+}
@@ -0,0 +1,53 @@
+/*
+ * sign_test.go
+ *
+ * Signatures must survive batching and catch any change to the message.
+ */
+
+package event
+
+import (
+    "crypto/ed25519"
+    "testing"
+)
+
+func TestSignVerify(t *testing.T) {
+    pub, priv, _ := ed25519.GenerateKey(nil)
+    plain := Prefix + `{"type":"query","sql":"SELECT 1"}`
+    batch, _ := Batch([]string{plain, plain}, "gzip")
+    for _, keys := range []struct {
+        s Signer
+        v Verifier
+    }{{HMACKey("secret"), HMACKey("secret")}, {Ed25519Signer(priv), Ed25519Verifier(pub)}} {
+        for _, payload := range []string{plain, batch} {
+            signed, err := Sign(payload, keys.s)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if err := Verify(signed, keys.v); err != nil {
+                t.Errorf("%s: %s", keys.s.Algorithm(), err)
+            }
+            if got, err := Unbatch(signed); err != nil || len(got) == 0 || got[0] != plain {
+                t.Errorf("%s: unbatched %q, %v", keys.s.Algorithm(), got, err)
+            }
+            tampered := signed[:len(signed)-2] + "X" + signed[len(signed)-1:]
+            if Verify(tampered, keys.v) != ErrBadSignature {
+                t.Errorf("%s: tampered payload verified", keys.s.Algorithm())
+            }
+        }
+        if Verify(plain, keys.v) != ErrUnsigned {
+            t.Errorf("%s: unsigned payload verified", keys.s.Algorithm())
+        }
+    }
+    if Verify(mustSign(t, plain, HMACKey("secret")), HMACKey("other")) != ErrBadSignature {
+        t.Errorf("wrong key verified")
+    }
+}
+
+func mustSign(t *testing.T, payload string, s Signer) string {
+    signed, err := Sign(payload, s)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return signed
+}
@@ -0,0 +1,123 @@
+/*
+ * examples.go
+ *
+ * The worst-example store. Canonicalizing throws away the literal values in a
+ * query, which is exactly what a developer needs to reproduce a slow one. For
+ * queries slower than -slow_ms we keep the literals of the slowest execution
+ * seen per fingerprint, masked according to -example_mask:
+ *
+ *   none     keep every literal as is
+ *   strings  replace quoted strings with their length (the default)
+ *   all      replace every literal with its type and length
+ *
+ * Each of those queries also raises a "slow_query" alert as it is answered,
+ * for alarms that can't wait for the aggregates.
+ */
+
+package main
+
+import (
+    "fmt"
+    "sort"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+type example struct {
+    Fingerprint string    `json:"fingerprint"`
+    Name        string    `json:"name,omitempty"`
+    Query       string    `json:"query"`
+    Values      []string  `json:"values"`
+    Latency     float64   `json:"latency_ms"`
+    Source      string    `json:"source"`
+    Seen        time.Time `json:"seen"`
+}
+
+// exampleStore keeps the slowest example per fingerprint.
+type exampleStore struct {
+    worst map[string]*example
+}
+
+var examples = &exampleStore{worst: make(map[string]*example)}
+var slowThreshold time.Duration
+var exampleMask string = "strings"
+
+// offer records an execution if it's the slowest one for its fingerprint.
+func (es *exampleStore) offer(fingerprint string, query string, values []string,
+    latency time.Duration, src string) {
+
+    ms := latency.Seconds() * 1000
+    if old, ok := es.worst[fingerprint]; ok && old.Latency >= ms {
+        return
+    }
+    es.worst[fingerprint] = &example{
+        Fingerprint: fingerprint,
+        Name:        nameOf(fingerprint),
+        Query:       query,
+        Values:      values,
+        Latency:     ms,
+        Source:      src,
+        Seen:        pktTime,
+    }
+}
+
+// alertSlow publishes a slow_query alert for the query just answered on rs.
+func alertSlow(rs *source, reqtime uint64) {
+    ms := float64(reqtime) / 1e6
+    limit := slowThreshold.Seconds() * 1000
+    publish(&event.AlertEvent{
+        Type:        event.TYPE_ALERT,
+        ServiceId:   service_id,
+        TenantId:    rs.tenant,
+        Kind:        "slow_query",
+        Message:     fmt.Sprintf("%s from %s took %.1fms", labelOf(rs.qtext), rs.srcip, ms),
+        Value:       ms,
+        Limit:       limit,
+        Fingerprint: rs.qtext,
+        Client:      rs.srcip,
+        Size:        rs.qbytes,
+    })
+}
+
+// all returns the stored examples, slowest first.
+func (es *exampleStore) all() []*example {
+    list := make([]*example, 0, len(es.worst))
+    for _, ex := range es.worst {
+        list = append(list, ex)
+    }
+    sort.Sort(examplesBySlowest(list))
+    return list
+}
+
+type examplesBySlowest []*example
+
+func (self examplesBySlowest) Len() int           { return len(self) }
+func (self examplesBySlowest) Less(i, j int) bool { return self[i].Latency > self[j].Latency }
+func (self examplesBySlowest) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+
+// extractLiterals returns the literal values canonicalization would replace
+// with "?", in order, masked according to -example_mask.
+func extractLiterals(query []byte) []string {
+    var values []string
+    for i := 0; i < len(query); {
+        length, toktype := scanToken(query[i:])
+        if toktype == TOKEN_NUMBER || toktype == TOKEN_QUOTE {
+            values = append(values, maskLiteral(string(query[i:i+length]), toktype))
+        }
+        i += length
+    }
+    return values
+}
+
+func maskLiteral(value string, toktype int) string {
+    switch {
+    case exampleMask == "none":
+        return value
+    case toktype == TOKEN_QUOTE:
+        return fmt.Sprintf("<string:%d>", len(value)-2)
+    case exampleMask == "all":
+        return fmt.Sprintf("<number:%d>", len(value))
+    }
+    return value
+}
@@ -0,0 +1,237 @@
+/*
+ * fixtures_test.go
+ *
+ * Runs the decoder over the recorded conversations in testdata/fixtures.
+ * Each fixture is a list of TCP segments, one per line as hex bytes, prefixed
+ * with ">" when sent by the client and "<" when sent by the server. A line
+ * ". n" is a bare ACK from the client advertising a window of n. A line
+ * starting with "=" is a JSON object the next published event must match
+ * (fields set to null must be absent); "#" starts a comment.
+ */
+
+package main
+
+import (
+    "bufio"
+    "encoding/hex"
+    "encoding/json"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+// memorySink keeps what it is sent.
+type memorySink struct {
+    payloads []string
+}
+
+func (m *memorySink) Send(topic string, payload string) error {
+    m.payloads = append(m.payloads, payload)
+    return nil
+}
+
+func (m *memorySink) Close() error   { return nil }
+func (m *memorySink) String() string { return "memory" }
+
+type fixtureLine struct {
+    lineno  int
+    request bool
+    data    []byte
+    ack     bool
+    window  uint16
+    expect  map[string]interface{}
+}
+
+func readFixture(t *testing.T, path string) []fixtureLine {
+    f, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+
+    var lines []fixtureLine
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(nil, 1<<20)
+    for n := 1; scanner.Scan(); n++ {
+        text := strings.TrimSpace(scanner.Text())
+        if text == "" || text[0] == '#' {
+            continue
+        }
+        line := fixtureLine{lineno: n}
+        switch text[0] {
+        case '>', '<':
+            line.request = text[0] == '>'
+            data, err := hex.DecodeString(strings.Join(strings.Fields(text[1:]), ""))
+            if err != nil {
+                t.Fatalf("%s:%d: %s", path, n, err)
+            }
+            line.data = data
+        case '.':
+            window, err := strconv.ParseUint(strings.TrimSpace(text[1:]), 10, 16)
+            if err != nil {
+                t.Fatalf("%s:%d: %s", path, n, err)
+            }
+            line.ack, line.window = true, uint16(window)
+        case '=':
+            if err := json.Unmarshal([]byte(text[1:]), &line.expect); err != nil {
+                t.Fatalf("%s:%d: %s", path, n, err)
+            }
+        default:
+            t.Fatalf("%s:%d: bad line", path, n)
+        }
+        lines = append(lines, line)
+    }
+    if err := scanner.Err(); err != nil {
+        t.Fatal(err)
+    }
+    return lines
+}
+
+// resetState puts the globals the decoder uses back to a known state.
+func resetState() *memorySink {
+    resetStreams(1)
+    qbuf = make(map[string]*queryData)
+    ipStreams = make(map[string]int)
+    servers = make(map[string]bool)
+    format, _ = parseFormat("#q")
+    service_id, tenant_id = "test", "test"
+    port = 3306
+    pktTime = time.Unix(0, 0)
+
+    mem := &memorySink{}
+    sinks, sinkSpecs = []sink{mem}, []sinkSpec{{Type: "memory"}}
+    return mem
+}
+
+// newTestSoaker returns a soaker for a server at 10.0.0.1 and one client
+// of it, not yet connected.
+func newTestSoaker() (*soaker, *soakConn) {
+    s := &soaker{server: [4]byte{10, 0, 0, 1}, now: time.Unix(100, 0), rnd: rand.New(rand.NewSource(1))}
+    return s, &soakConn{ip: [4]byte{10, 1, 0, 1}, port: 20000}
+}
+
+func TestFixtures(t *testing.T) {
+    paths, err := filepath.Glob(filepath.Join("testdata", "fixtures", "*.txt"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(paths) == 0 {
+        t.Fatal("no fixtures found")
+    }
+    for _, path := range paths {
+        mem := resetState()
+        rs := newSource("10.0.0.1:50000")
+        next := 0
+        for _, line := range readFixture(t, path) {
+            if line.expect == nil {
+                pktTime = pktTime.Add(time.Millisecond)
+                if line.ack {
+                    noteClientAck(rs, line.window)
+                } else {
+                    processPacket(rs.src, rs, line.request, line.data)
+                }
+                continue
+            }
+            if next >= len(mem.payloads) {
+                t.Errorf("%s:%d: expected an event, none published", path, line.lineno)
+                continue
+            }
+            payload := mem.payloads[next]
+            next++
+            var got map[string]interface{}
+            if err := json.Unmarshal([]byte(strings.TrimPrefix(payload, event.Prefix)), &got); err != nil {
+                t.Errorf("%s:%d: %s", path, line.lineno, err)
+                continue
+            }
+            for key, want := range line.expect {
+                if !reflect.DeepEqual(got[key], want) {
+                    t.Errorf("%s:%d: %s is %v, want %v", path, line.lineno, key, got[key], want)
+                }
+            }
+        }
+        for _, extra := range mem.payloads[next:] {
+            t.Errorf("%s: unexpected event %s", path, extra)
+        }
+    }
+}
+
+func TestRedactLevels(t *testing.T) {
+    mem := resetState()
+    fingerprint := &memorySink{}
+    sinks = append(sinks, fingerprint)
+    sinkSpecs = append(sinkSpecs, sinkSpec{Type: "memory", Redact: REDACT_FINGERPRINT})
+    dirty = true
+    defer func() { dirty = false }()
+
+    rs := newSource("10.0.0.1:50000")
+    for _, line := range readFixture(t, filepath.Join("testdata", "fixtures", "query.txt")) {
+        if line.expect == nil {
+            processPacket(rs.src, rs, line.request, line.data)
+        }
+    }
+    for _, c := range []struct {
+        sink *memorySink
+        want string
+    }{
+        {mem, "SELECT id, name FROM users WHERE email = 'a@example.com'"},
+        {fingerprint, "SELECT id, name FROM users WHERE email = ?"},
+    } {
+        if len(c.sink.payloads) == 0 {
+            t.Fatalf("nothing published")
+        }
+        ev, err := event.Decode(c.sink.payloads[0])
+        if err != nil {
+            t.Fatal(err)
+        }
+        if sql := ev.(*event.QueryEvent).Sql; sql != c.want {
+            t.Errorf("sql %q, want %q", sql, c.want)
+        }
+    }
+}
+
+func TestSlowAlert(t *testing.T) {
+    mem := resetState()
+    slowThreshold = 500 * time.Microsecond
+    defer func() { slowThreshold = 0 }()
+
+    rs := newSource("10.0.0.1:50000")
+    for _, line := range readFixture(t, filepath.Join("testdata", "fixtures", "query.txt")) {
+        if line.expect == nil {
+            pktTime = pktTime.Add(time.Millisecond)
+            processPacket(rs.src, rs, line.request, line.data)
+        }
+    }
+    var alert *event.AlertEvent
+    var query *event.QueryEvent
+    for _, payload := range mem.payloads {
+        ev, err := event.Decode(payload)
+        if err != nil {
+            t.Fatal(err)
+        }
+        switch ev := ev.(type) {
+        case *event.AlertEvent:
+            if alert == nil {
+                alert = ev
+            }
+        case *event.QueryEvent:
+            if query == nil {
+                query = ev
+            }
+        }
+    }
+    if query == nil || !query.Slow {
+        t.Errorf("query not marked slow: %+v", query)
+    }
+    if alert == nil || alert.Kind != "slow_query" || alert.Value != 1 || alert.Limit != 0.5 ||
+        alert.Client != "10.0.0.1" || alert.Fingerprint != "SELECT id, name FROM users WHERE email = ?" ||
+        alert.Size == 0 {
+        t.Errorf("alert %+v", alert)
+    }
+}
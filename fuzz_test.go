@@ -0,0 +1,127 @@
+/*
+ * fuzz_test.go
+ *
+ * Fuzz targets for the parts that see untrusted input: query text, login
+ * packets and prepared statement parameters off the wire, and the -f format
+ * string. Run one with e.g.
+ *
+ *   go test -run XXX -fuzz FuzzCleanupQuery
+ */
+
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+var fuzzQueries = []string{
+    "SELECT * FROM t WHERE a = 'x' AND b = 12",
+    "SELECT /* host:route */ 1",
+    "INSERT INTO t VALUES (1, 2, 3), (4, 5, 6)",
+    `SELECT 'it\'s', "a\\", 'b\\\\'`,
+    "SELECT 'unterminated",
+    "\x00\xff\t\n SELECT\xc3\xa9",
+    strings.Repeat("'", 1000),
+    strings.Repeat(`\`, 1000),
+    strings.Repeat("?, ", 1000),
+}
+
+func FuzzScanToken(f *testing.F) {
+    for _, q := range fuzzQueries {
+        f.Add([]byte(q))
+    }
+    f.Fuzz(func(t *testing.T, query []byte) {
+        length, toktype := scanToken(query)
+        if len(query) == 0 {
+            if length != 0 {
+                t.Fatalf("empty query gave length %d", length)
+            }
+            return
+        }
+        if length < 1 || length > len(query) {
+            t.Fatalf("length %d for %d bytes", length, len(query))
+        }
+        switch toktype {
+        case TOKEN_WORD, TOKEN_QUOTE, TOKEN_NUMBER, TOKEN_WHITESPACE, TOKEN_OTHER:
+        default:
+            t.Fatalf("bad token type %d", toktype)
+        }
+    })
+}
+
+func FuzzCleanupQuery(f *testing.F) {
+    for _, q := range fuzzQueries {
+        f.Add([]byte(q))
+    }
+    f.Fuzz(func(t *testing.T, query []byte) {
+        clean := cleanupQuery(query)
+        if len(clean) > len(query) {
+            t.Fatalf("cleaned query is longer than the original: %q -> %q", query, clean)
+        }
+    })
+}
+
+func FuzzExpandExecute(f *testing.F) {
+    f.Add(executeSeed)
+    f.Fuzz(func(t *testing.T, pdata []byte) {
+        expandExecute(newExecuteSource(), pdata)
+    })
+}
+
+func FuzzParseFormat(f *testing.F) {
+    for _, s := range []string{"#s:#q", "##q", "#", "#x#i#r", "a#", "###", "", "#{20}q", `\#\\\t`, "#{}q", "#{3"} {
+        f.Add(s)
+    }
+    f.Fuzz(func(t *testing.T, formatstr string) {
+        before := len(format)
+        parsed, err := parseFormat(formatstr)
+        if len(format) != before {
+            t.Fatalf("parseFormat changed the global format")
+        }
+        if err != nil {
+            return
+        }
+        if len(parsed) == 0 {
+            t.Fatalf("%q parsed to nothing", formatstr)
+        }
+        for _, item := range parsed {
+            switch v := item.(type) {
+            case formatField:
+                if v.kind != F_QUERY && v.kind != F_ROUTE && v.kind != F_SOURCE && v.kind != F_SOURCEIP {
+                    t.Fatalf("%q: bad field %d", formatstr, v.kind)
+                }
+                if v.width < 0 {
+                    t.Fatalf("%q: negative width", formatstr)
+                }
+            case string:
+                if v == "" {
+                    t.Fatalf("%q: empty literal", formatstr)
+                }
+            default:
+                t.Fatalf("%q: bad item %v", formatstr, item)
+            }
+        }
+    })
+}
+
+func FuzzMaskQuery(f *testing.F) {
+    for _, q := range fuzzQueries {
+        f.Add(q)
+    }
+    maskRules = &maskPolicy{Default: MASK_TRUNCATE, Rules: []*maskRule{{Column: "a", Action: MASK_HASH}}}
+    defer func() { maskRules = nil }()
+    f.Fuzz(func(t *testing.T, q string) {
+        maskQuery([]byte(q))
+        maskMessage(q)
+    })
+}
+
+func FuzzLoginUser(f *testing.F) {
+    f.Add([]byte("\x3c\x00\x00\x01\x8d\xa6\xff\x00\x00\x00\x00\x01\x21" + strings.Repeat("\x00", 23) +
+        "app\x00\x02ab" + "shop\x00"))
+    f.Fuzz(func(t *testing.T, data []byte) {
+        loginUser(data)
+        changeUser(data)
+    })
+}
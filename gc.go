@@ -0,0 +1,51 @@
+/*
+ * gc.go
+ *
+ * Garbage collector tuning. At high packet rates a collection pausing the
+ * capture loop looks like packets dropped for no reason. -gogc sets the GC
+ * target percentage (higher is fewer collections for more memory) and
+ * -ballast_mb allocates a block that is never touched, so the heap the
+ * target is a percentage of starts out bigger and a small working set
+ * isn't collected over and over. The pauses are on /metrics to check the
+ * effect.
+ */
+
+package main
+
+import (
+    "fmt"
+    "io"
+    "log"
+    "runtime"
+    "runtime/debug"
+)
+
+var ballast []byte
+
+// tuneGC applies -gogc (0 leaves the default or GOGC) and -ballast_mb.
+func tuneGC(gogc int, ballastMB int) {
+    if gogc != 0 {
+        old := debug.SetGCPercent(gogc)
+        log.Printf("GC target %d%% (was %d%%)", gogc, old)
+    }
+    if ballastMB > 0 {
+        // Never written, so it takes no resident memory.
+        ballast = make([]byte, ballastMB<<20)
+        log.Printf("GC ballast of %dMB", ballastMB)
+    }
+}
+
+func writeGCMetrics(w io.Writer) {
+    var m runtime.MemStats
+    runtime.ReadMemStats(&m)
+    var last uint64
+    if m.NumGC > 0 {
+        last = m.PauseNs[(m.NumGC+255)%256]
+    }
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_gc_runs_total counter\nmysql_sniffer_gc_runs_total %d\n", m.NumGC)
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_gc_pause_seconds_total counter\nmysql_sniffer_gc_pause_seconds_total %.9f\n",
+        float64(m.PauseTotalNs)/1e9)
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_gc_last_pause_seconds gauge\nmysql_sniffer_gc_last_pause_seconds %.9f\n",
+        float64(last)/1e9)
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_heap_bytes gauge\nmysql_sniffer_heap_bytes %d\n", m.HeapAlloc)
+}
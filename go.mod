@@ -0,0 +1,12 @@
+module github.com/elvis2002/mysql-sniffer
+
+go 1.21
+
+require (
+	github.com/google/gopacket v1.1.19
+	github.com/klauspost/compress v1.17.11
+	github.com/pebbe/zmq4 v1.4.0
+	github.com/yuin/gopher-lua v1.1.1
+)
+
+require golang.org/x/sys v0.0.0-20190412213103-97732733099d // indirect
@@ -0,0 +1,153 @@
+/*
+ * hints.go
+ *
+ * Index hints (USE/FORCE/IGNORE INDEX) and optimizer hints (comments opening
+ * with a plus) are where an application overrides the optimizer, and worth auditing. They are
+ * pulled out of each statement into the event and counted per fingerprint
+ * for the `hints` report.
+ */
+
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "sort"
+    "strings"
+)
+
+// extractHints returns the index hints in a query, normalized like
+// "FORCE INDEX FOR ORDER BY (a, b)", and its optimizer hints like "BKA(t1)".
+func extractHints(query []byte) (index []string, optimizer []string) {
+    // Optimizer hints live in /*+ */ comments.
+    rest := query
+    for {
+        start := bytes.Index(rest, []byte("/*+"))
+        if start < 0 {
+            break
+        }
+        end := bytes.Index(rest[start:], []byte("*/"))
+        if end < 0 {
+            break
+        }
+        optimizer = append(optimizer, splitHints(string(rest[start+3:start+end]))...)
+        rest = rest[start+end+2:]
+    }
+
+    var words []string // words and punctuation, no whitespace
+    for i := 0; i < len(query); {
+        length, toktype := scanToken(query[i:])
+        if toktype != TOKEN_WHITESPACE && string(query[i:i+length]) != "`" {
+            words = append(words, string(query[i:i+length]))
+        }
+        i += length
+    }
+    for i := 0; i+2 < len(words); i++ {
+        verb, kind := strings.ToUpper(words[i]), strings.ToUpper(words[i+1])
+        if (verb != "USE" && verb != "FORCE" && verb != "IGNORE") || (kind != "INDEX" && kind != "KEY") {
+            continue
+        }
+        hint := verb + " INDEX"
+        j := i + 2
+        if strings.EqualFold(words[j], "FOR") && j+1 < len(words) {
+            switch target := strings.ToUpper(words[j+1]); target {
+            case "JOIN":
+                hint += " FOR JOIN"
+                j += 2
+            case "ORDER", "GROUP":
+                hint += " FOR " + target + " BY"
+                j += 3
+            }
+        }
+        if j >= len(words) || words[j] != "(" {
+            continue
+        }
+        var names []string
+        for j++; j < len(words) && words[j] != ")"; j++ {
+            if words[j] != "," {
+                names = append(names, words[j])
+            }
+        }
+        index = append(index, hint+" ("+strings.Join(names, ", ")+")")
+        i = j
+    }
+    return index, optimizer
+}
+
+// splitHints splits the inside of a /*+ */ comment into separate hints.
+func splitHints(text string) []string {
+    var hints []string
+    depth, start := 0, -1
+    for i, c := range text {
+        switch {
+        case c == '(':
+            depth++
+        case c == ')':
+            depth--
+        case depth == 0 && (c == ' ' || c == '\t' || c == '\n' || c == '\r'):
+            if start >= 0 {
+                hints = append(hints, text[start:i])
+                start = -1
+            }
+            continue
+        }
+        if start < 0 {
+            start = i
+        }
+    }
+    if start >= 0 {
+        hints = append(hints, text[start:])
+    }
+    return hints
+}
+
+// noteHints counts a statement's hints against its fingerprint.
+func noteHints(qdata *queryData, index []string, optimizer []string) {
+    if len(index)+len(optimizer) == 0 {
+        return
+    }
+    if qdata.hints == nil {
+        qdata.hints = make(map[string]uint64)
+    }
+    for _, hint := range index {
+        qdata.hints[hint]++
+    }
+    for _, hint := range optimizer {
+        qdata.hints["/*+ "+hint+" */"]++
+    }
+}
+
+func reportHints(w io.Writer, asJSON bool) {
+    type hinted struct {
+        Fingerprint string            `json:"fingerprint"`
+        Name        string            `json:"name,omitempty"`
+        Count       uint64            `json:"count"`
+        Hints       map[string]uint64 `json:"hints"`
+    }
+    var out []hinted
+    for _, key := range sortedQueries() {
+        if qdata := qbuf[key]; len(qdata.hints) > 0 {
+            out = append(out, hinted{Fingerprint: key, Name: nameOf(key), Count: qdata.count,
+                Hints: qdata.hints})
+        }
+    }
+    if asJSON {
+        json.NewEncoder(w).Encode(map[string]interface{}{"hints": out})
+        return
+    }
+    for _, h := range out {
+        fmt.Fprintf(w, "%s== hints: %s (%d queries)%s\n", color(COLOR_CYAN),
+            labelOf(h.Fingerprint), h.Count, color(COLOR_DEFAULT))
+        var hints []string
+        for hint := range h.Hints {
+            hints = append(hints, hint)
+        }
+        sort.Strings(hints)
+        for _, hint := range hints {
+            fmt.Fprintf(w, "%10d  %s\n", h.Hints[hint], hint)
+        }
+        fmt.Fprintf(w, "\n")
+    }
+}
@@ -0,0 +1,23 @@
+/*
+ * hints_test.go
+ *
+ * Index and optimizer hints are read out of the query text.
+ */
+
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestExtractHints(t *testing.T) {
+    index, optimizer := extractHints([]byte("SELECT /*+ BKA(t1) NO_ICP(t1 idx_a) */ * FROM t1 " +
+        "FORCE INDEX FOR ORDER BY (`idx_a`, idx_b) JOIN t2 use key (PRIMARY) WHERE x = 'use index (no)'"))
+    if want := []string{"FORCE INDEX FOR ORDER BY (idx_a, idx_b)", "USE INDEX (PRIMARY)"}; !reflect.DeepEqual(index, want) {
+        t.Errorf("index hints %q, want %q", index, want)
+    }
+    if want := []string{"BKA(t1)", "NO_ICP(t1 idx_a)"}; !reflect.DeepEqual(optimizer, want) {
+        t.Errorf("optimizer hints %q, want %q", optimizer, want)
+    }
+}
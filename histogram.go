@@ -0,0 +1,98 @@
+/*
+ * histogram.go
+ *
+ * Cumulative histograms in the Prometheus style: fixed upper bounds, a count
+ * per bound, plus the sum and count of everything observed.
+ */
+
+package main
+
+import (
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+)
+
+type histogram struct {
+    bounds []float64 // upper bounds, ascending; +Inf is implied
+    counts []uint64  // per bucket, not cumulative; one more than bounds
+    sum    float64
+    count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+    return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+    i := 0
+    for i < len(h.bounds) && v > h.bounds[i] {
+        i++
+    }
+    h.counts[i]++
+    h.sum += v
+    h.count++
+}
+
+// mean returns the average observed value.
+func (h *histogram) mean() float64 {
+    if h.count == 0 {
+        return 0
+    }
+    return h.sum / float64(h.count)
+}
+
+// quantile estimates the q-quantile (0..1) by interpolating inside the bucket
+// it falls in.
+func (h *histogram) quantile(q float64) float64 {
+    if h.count == 0 {
+        return 0
+    }
+    rank := q * float64(h.count)
+    var seen uint64
+    for i, n := range h.counts {
+        if float64(seen+n) >= rank && n > 0 {
+            lower := 0.0
+            if i > 0 {
+                lower = h.bounds[i-1]
+            }
+            if i == len(h.bounds) {
+                return lower
+            }
+            return lower + (h.bounds[i]-lower)*(rank-float64(seen))/float64(n)
+        }
+        seen += n
+    }
+    return h.bounds[len(h.bounds)-1]
+}
+
+// metricLabels renders label pairs, given as name, value, name, value...
+func metricLabels(pairs ...string) string {
+    var parts []string
+    for i := 0; i+1 < len(pairs); i += 2 {
+        value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+        parts = append(parts, pairs[i]+`="`+value+`"`)
+    }
+    return strings.Join(parts, ",")
+}
+
+// writeMetric writes the histogram in the Prometheus text format. labels is
+// as made by metricLabels.
+func (h *histogram) writeMetric(w io.Writer, name string, labels string) {
+    sep := ""
+    if labels != "" {
+        sep = ","
+    }
+    var cumulative uint64
+    for i, n := range h.counts {
+        cumulative += n
+        le := "+Inf"
+        if i < len(h.bounds) {
+            le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+        }
+        fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, le, cumulative)
+    }
+    fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+    fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
@@ -0,0 +1,98 @@
+/*
+ * history.go
+ *
+ * The last -history statements of each connection: fingerprint, when it was
+ * sent, and how long it took. When a connection turns up in an incident,
+ * GET /history?client=10.0.0.5:41234 shows what it was doing just before;
+ * without ?client= every tracked connection is listed.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "time"
+)
+
+var historySize int
+
+type historyEntry struct {
+    Fingerprint string    `json:"fingerprint"`
+    Name        string    `json:"name,omitempty"`
+    Sent        time.Time `json:"sent"`
+    Latency     *float64  `json:"latency_ms,omitempty"` // nil if the response wasn't seen
+    ErrorCode   uint16    `json:"error_code,omitempty"`
+}
+
+// A ring of the last historySize entries.
+type queryHistory struct {
+    entries []historyEntry
+    next    int
+}
+
+func (h *queryHistory) add(e historyEntry) {
+    if len(h.entries) < historySize {
+        h.entries = append(h.entries, e)
+        return
+    }
+    h.entries[h.next] = e
+    h.next = (h.next + 1) % len(h.entries)
+}
+
+// all returns the entries oldest first.
+func (h *queryHistory) all() []historyEntry {
+    if h == nil {
+        return nil
+    }
+    list := make([]historyEntry, 0, len(h.entries))
+    list = append(list, h.entries[h.next:]...)
+    return append(list, h.entries[:h.next]...)
+}
+
+// noteHistory records the statement just finished on rs. If timed is false
+// its response wasn't seen and it is recorded as sent now.
+func noteHistory(rs *source, timed bool) {
+    if historySize <= 0 || rs.qtext == "" {
+        return
+    }
+    if rs.history == nil {
+        rs.history = &queryHistory{}
+    }
+    e := historyEntry{Fingerprint: rs.qtext, Name: nameOf(rs.qtext), Sent: pktTime}
+    if timed {
+        e.Sent = rs.timing.firstByte.Add(-time.Duration(rs.timing.reqtime))
+        latency := float64(rs.timing.reqtime) / 1e6
+        e.Latency = &latency
+        if rs.resp.err != nil {
+            e.ErrorCode = rs.resp.err.code
+        }
+    }
+    rs.history.add(e)
+}
+
+type connectionHistory struct {
+    Client   string         `json:"client"`
+    ThreadId uint32         `json:"thread_id,omitempty"`
+    User     string         `json:"user,omitempty"`
+    History  []historyEntry `json:"history"`
+}
+
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+    client := r.URL.Query().Get("client")
+    list := []connectionHistory{}
+    stateLock.Lock()
+    eachStream(func(rs *source) {
+        if client == "" || client == rs.src {
+            list = append(list, connectionHistory{Client: rs.src, ThreadId: rs.threadId,
+                User: rs.user, History: rs.history.all()})
+        }
+    })
+    stateLock.Unlock()
+    if client != "" && len(list) == 0 {
+        http.Error(w, "no such connection", http.StatusNotFound)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,50 @@
+/*
+ * history_test.go
+ *
+ * The last statements of each connection.
+ */
+
+package main
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestHistory(t *testing.T) {
+    resetState()
+    historySize = 3
+    defer func() { historySize = 0 }()
+    s, c := newTestSoaker()
+    s.connect(c)
+    for i := 1; i <= 5; i++ {
+        s.now = s.now.Add(time.Second)
+        s.packet(c, true, TCP_ACK, mysqlPacket(0, append([]byte{COM_QUERY},
+            fmt.Sprintf("SELECT a FROM t%d", i)...)))
+        s.now = s.now.Add(time.Duration(i) * time.Millisecond)
+        s.packet(c, false, TCP_ACK, mysqlPacket(1, selftestOK))
+    }
+    rs := pktShard.streams[fmt.Sprintf("10.1.0.1:%d", c.port)]
+    if rs == nil {
+        t.Fatalf("connection not tracked: %v", pktShard.streams)
+    }
+    list := rs.history.all()
+    if len(list) != 3 {
+        t.Fatalf("%d entries, want 3: %+v", len(list), list)
+    }
+    for i, e := range list {
+        n := i + 3
+        if !strings.Contains(e.Fingerprint, fmt.Sprintf("t%d", n)) {
+            t.Errorf("entry %d is %q, want t%d", i, e.Fingerprint, n)
+        }
+        if e.Latency == nil || *e.Latency != float64(n) {
+            t.Errorf("entry %d latency %v, want %dms", i, e.Latency, n)
+        }
+        if sent := time.Unix(100, 0).Add(time.Duration(n)*time.Second +
+            time.Duration((n-1)*n/2)*time.Millisecond); !e.Sent.Equal(sent) {
+            t.Errorf("entry %d sent %s, want %s", i, e.Sent, sent)
+        }
+    }
+}
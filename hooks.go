@@ -0,0 +1,372 @@
+/*
+ * hooks.go
+ *
+ * Enrichment hooks: user code that sees every event before it's published and
+ * can add, change or remove fields, or drop the event, without forking the
+ * sniffer. Hooks are Lua scripts defining an enrich function:
+ *
+ *   function enrich(ev)
+ *       if ev.tenant_id == "default" then
+ *           ev.tenant_id = "shop"
+ *       end
+ *       ev.sql = nil
+ *       return true -- false drops the event
+ *   end
+ *
+ * loaded with -hook tenant.lua. Several hooks may be given, comma separated;
+ * they run in order.
+ *
+ * A hook runs sandboxed: only the base (without the functions that load
+ * code or files), string, table and math libraries are there, so it can't
+ * touch files, processes or the network, a call that takes longer than
+ * HOOK_TIMEOUT is stopped, and string.rep, string.format and string.gsub
+ * won't build a string longer than HOOK_MAX_STRING. A hook that fails or
+ * runs out of time is skipped for that event.
+ *
+ * What a hook leaves is decoded back into the event, so it must still be a
+ * valid one; fields the event doesn't have are added to what is published.
+ */
+
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "math"
+    "reflect"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+    lua "github.com/yuin/gopher-lua"
+)
+
+const (
+    HOOK_TIMEOUT    = 10 * time.Millisecond // per call, and for loading a script
+    HOOK_MAX_DEPTH  = 32                    // nesting of the tables a hook returns
+    HOOK_MAX_STRING = 1 << 20               // longest string one library call may build
+)
+
+// The base library functions a hook doesn't get.
+var hookUnsafe = []string{"dofile", "loadfile", "load", "loadstring", "require", "module",
+    "collectgarbage", "print", "_printregs"}
+
+type luaHook struct {
+    path   string
+    lock   sync.Mutex // a Lua state is for one goroutine at a time
+    state  *lua.LState
+    enrich *lua.LFunction
+}
+
+var hooks []*luaHook
+
+// newLuaHook loads the script at path into a sandboxed Lua state.
+func newLuaHook(path string) (*luaHook, error) {
+    L := lua.NewState(lua.Options{SkipOpenLibs: true, CallStackSize: 256})
+    for _, lib := range []struct {
+        name string
+        open lua.LGFunction
+    }{
+        {lua.BaseLibName, lua.OpenBase},
+        {lua.TabLibName, lua.OpenTable},
+        {lua.StringLibName, lua.OpenString},
+        {lua.MathLibName, lua.OpenMath},
+    } {
+        L.Push(L.NewFunction(lib.open))
+        L.Push(lua.LString(lib.name))
+        L.Call(1, 0)
+    }
+    for _, name := range hookUnsafe {
+        L.SetGlobal(name, lua.LNil)
+    }
+    limitString(L, "rep", func(L *lua.LState) float64 {
+        return float64(len(L.CheckString(1))) * float64(L.CheckInt(2))
+    })
+    limitString(L, "format", formatSize)
+    limitString(L, "gsub", func(L *lua.LState) float64 {
+        repl, ok := L.Get(3).(lua.LString)
+        if !ok {
+            return 0
+        }
+        n := float64(len(L.CheckString(1)) + 1)
+        return n + n*float64(len(repl))
+    })
+
+    h := &luaHook{path: path, state: L}
+    fn, err := L.LoadFile(path)
+    if err == nil {
+        err = h.call(fn)
+    }
+    if err != nil {
+        L.Close()
+        return nil, err
+    }
+    L.Pop(1)
+    enrich, ok := L.GetGlobal("enrich").(*lua.LFunction)
+    if !ok {
+        L.Close()
+        return nil, fmt.Errorf("%s: no enrich function", path)
+    }
+    h.enrich = enrich
+    return h, nil
+}
+
+// limitString wraps a string library function that can build a long string
+// in one call, so it refuses calls whose result could be longer than
+// HOOK_MAX_STRING. size returns that longest result.
+func limitString(L *lua.LState, name string, size func(L *lua.LState) float64) {
+    lib := L.GetGlobal(lua.StringLibName).(*lua.LTable)
+    fn := lib.RawGetString(name).(*lua.LFunction).GFunction
+    lib.RawSetString(name, L.NewFunction(func(L *lua.LState) int {
+        if size(L) > HOOK_MAX_STRING {
+            L.RaiseError("string.%s: result longer than %d bytes", name, HOOK_MAX_STRING)
+        }
+        return fn(L)
+    }))
+}
+
+// formatSize returns the longest string.format could make of its arguments:
+// the format, every argument as a string, and every width and precision.
+func formatSize(L *lua.LState) float64 {
+    spec := L.CheckString(1)
+    size := float64(len(spec))
+    for i := 2; i <= L.GetTop(); i++ {
+        size += float64(len(L.Get(i).String()))
+    }
+    for i := 0; i < len(spec); i++ {
+        if spec[i] != '%' {
+            continue
+        }
+        for i++; i < len(spec) && strings.IndexByte("-+ #0", spec[i]) >= 0; i++ {
+        }
+        for digits := 0; i < len(spec); i++ {
+            if spec[i] == '.' {
+                digits = 0
+                continue
+            }
+            if spec[i] < '0' || spec[i] > '9' {
+                break
+            }
+            if digits++; digits > 2 {
+                return math.Inf(1)
+            }
+        }
+        size += 2 * 99
+    }
+    return size
+}
+
+// call runs fn with args within HOOK_TIMEOUT, leaving its one result on
+// the stack.
+func (h *luaHook) call(fn *lua.LFunction, args ...lua.LValue) error {
+    ctx, cancel := context.WithTimeout(context.Background(), HOOK_TIMEOUT)
+    defer cancel()
+    h.state.SetContext(ctx)
+    defer h.state.RemoveContext()
+    return h.state.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, args...)
+}
+
+// run passes an event's fields through the hook, replacing them with what
+// it leaves in the table. It returns false if the hook dropped the event.
+func (h *luaHook) run(fields map[string]interface{}) (bool, error) {
+    h.lock.Lock()
+    defer h.lock.Unlock()
+    ev := toLua(h.state, fields).(*lua.LTable)
+    if err := h.call(h.enrich, ev); err != nil {
+        return true, err
+    }
+    keep := h.state.Get(-1) != lua.LFalse
+    h.state.Pop(1)
+    for name := range fields {
+        delete(fields, name)
+    }
+    ev.ForEach(func(k, v lua.LValue) {
+        if name, ok := k.(lua.LString); ok {
+            if value := fromLua(v, 0); value != nil {
+                fields[string(name)] = value
+            }
+        }
+    })
+    return keep, nil
+}
+
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+    switch v := v.(type) {
+    case bool:
+        return lua.LBool(v)
+    case string:
+        return lua.LString(v)
+    case json.Number:
+        f, _ := v.Float64()
+        return lua.LNumber(f)
+    case []interface{}:
+        t := L.CreateTable(len(v), 0)
+        for _, item := range v {
+            t.Append(toLua(L, item))
+        }
+        return t
+    case map[string]interface{}:
+        t := L.CreateTable(0, len(v))
+        for name, item := range v {
+            t.RawSetString(name, toLua(L, item))
+        }
+        return t
+    }
+    return lua.LNil
+}
+
+// fromLua returns what a Lua value is in JSON: a table with only the keys
+// 1..n is a list, any other one an object of its string keys.
+func fromLua(v lua.LValue, depth int) interface{} {
+    switch v := v.(type) {
+    case lua.LBool:
+        return bool(v)
+    case lua.LString:
+        return string(v)
+    case lua.LNumber:
+        f := float64(v)
+        if math.IsNaN(f) || math.IsInf(f, 0) {
+            return nil
+        }
+        if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+            return json.Number(strconv.FormatInt(int64(f), 10))
+        }
+        return f
+    case *lua.LTable:
+        if depth >= HOOK_MAX_DEPTH {
+            return nil
+        }
+        keys := 0
+        v.ForEach(func(lua.LValue, lua.LValue) { keys++ })
+        if n := v.MaxN(); n > 0 && n == keys {
+            list := make([]interface{}, 0, n)
+            for i := 1; i <= n; i++ {
+                list = append(list, fromLua(v.RawGetInt(i), depth+1))
+            }
+            return list
+        }
+        m := make(map[string]interface{})
+        v.ForEach(func(k, item lua.LValue) {
+            if name, ok := k.(lua.LString); ok {
+                if value := fromLua(item, depth+1); value != nil {
+                    m[string(name)] = value
+                }
+            }
+        })
+        return m
+    }
+    return nil
+}
+
+// loadHooks loads each script in a comma separated list.
+func loadHooks(paths string) error {
+    for _, path := range strings.Split(paths, ",") {
+        path = strings.TrimSpace(path)
+        if path == "" {
+            continue
+        }
+        h, err := newLuaHook(path)
+        if err != nil {
+            return err
+        }
+        hooks = append(hooks, h)
+        log.Printf("Loaded enrichment hook %s", path)
+    }
+    return nil
+}
+
+// runHooks passes an event through every hook. It returns the (possibly
+// modified) event to publish and the fields hooks added that its type
+// doesn't have, or false if a hook dropped it. A hook whose result isn't a
+// valid event is skipped like one that failed.
+func runHooks(ev interface{}) (interface{}, map[string]interface{}, bool) {
+    var extra map[string]interface{}
+    for _, h := range hooks {
+        fields, err := hookFields(ev, extra)
+        if err != nil {
+            break
+        }
+        keep, err := h.run(fields)
+        if err == nil && !keep {
+            return nil, nil, false
+        }
+        var next interface{}
+        if err == nil {
+            next, err = hookEvent(fields)
+        }
+        if err != nil {
+            stats.hook_errors++
+            log.Printf("Enrichment hook %s failed: %s", h.path, err)
+            continue
+        }
+        ev, extra = next, extraFields(next, fields)
+    }
+    return ev, extra, true
+}
+
+// hookFields returns the fields a hook sees: the event's and those earlier
+// hooks added.
+func hookFields(ev interface{}, extra map[string]interface{}) (map[string]interface{}, error) {
+    data, err := json.Marshal(ev)
+    if err != nil {
+        return nil, err
+    }
+    var fields map[string]interface{}
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.UseNumber()
+    if err := dec.Decode(&fields); err != nil {
+        return nil, err
+    }
+    for name, value := range extra {
+        fields[name] = value
+    }
+    return fields, nil
+}
+
+// hookEvent decodes the fields a hook left back into an event.
+func hookEvent(fields map[string]interface{}) (interface{}, error) {
+    data, err := json.Marshal(fields)
+    if err != nil {
+        return nil, err
+    }
+    return event.Decode(event.Prefix + string(data))
+}
+
+// extraFields returns the fields ev's type doesn't have.
+func extraFields(ev interface{}, fields map[string]interface{}) map[string]interface{} {
+    known := make(map[string]bool)
+    t := reflect.TypeOf(ev).Elem()
+    for i := 0; i < t.NumField(); i++ {
+        name := strings.SplitN(t.Field(i).Tag.Get("json"), ",", 2)[0]
+        known[strings.ToLower(name)] = true
+    }
+    var extra map[string]interface{}
+    for name, value := range fields {
+        if known[strings.ToLower(name)] {
+            continue
+        }
+        if extra == nil {
+            extra = make(map[string]interface{})
+        }
+        extra[name] = value
+    }
+    return extra
+}
+
+// encodeEvent returns the payload for an event with the fields hooks added.
+func encodeEvent(ev interface{}, extra map[string]interface{}) (string, error) {
+    payload, err := event.Encode(ev)
+    if err != nil || len(extra) == 0 {
+        return payload, err
+    }
+    data, err := json.Marshal(extra)
+    if err != nil {
+        return "", err
+    }
+    return payload[:len(payload)-1] + "," + string(data[1:]), nil
+}
@@ -0,0 +1,169 @@
+/*
+ * hooks_test.go
+ *
+ * Lua enrichment hooks change and drop events, stay in their sandbox, and
+ * what they leave is what every sink is sent.
+ */
+
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+func writeHook(t *testing.T, dir, name, script string) string {
+    path := filepath.Join(dir, name)
+    if err := ioutil.WriteFile(path, []byte(script), 0644); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+func TestLuaHooks(t *testing.T) {
+    dir, err := ioutil.TempDir("", "hooks")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    defer func() { hooks, stats.hook_errors = nil, 0 }()
+
+    tenant := writeHook(t, dir, "tenant.lua", `
+local tenants = {checkout = "shop"}
+
+function enrich(ev)
+    if ev.type ~= "query" then
+        return true
+    end
+    if string.find(ev.sql, "health_check", 1, true) then
+        return false
+    end
+    ev.tenant_id = tenants[ev.service_id] or ev.tenant_id
+    ev.sql = nil
+    ev.tags = {"enriched", string.upper(ev.tenant_id)}
+    ev.size = ev.size * 2
+    return true
+end
+`)
+    sandbox := writeHook(t, dir, "sandbox.lua", `
+function enrich(ev)
+    ev.escaped = io ~= nil or os ~= nil or dofile ~= nil or loadstring ~= nil or require ~= nil
+    if ev.service_id == "cart" then
+        while true do end
+    end
+    return true
+end
+`)
+    if err := loadHooks(tenant + ", " + sandbox); err != nil {
+        t.Fatal(err)
+    }
+
+    ev, extra, keep := runHooks(&event.QueryEvent{Type: event.TYPE_QUERY, Sql: "SELECT 1", Size: 21,
+        ServiceId: "checkout", TenantId: "default"})
+    q, _ := ev.(*event.QueryEvent)
+    if !keep || q == nil || q.TenantId != "shop" || q.Sql != "" || q.Size != 42 || extra["escaped"] != false ||
+        !reflect.DeepEqual(extra["tags"], []interface{}{"enriched", "SHOP"}) {
+        t.Errorf("enriched to %+v, %v, %v", ev, extra, keep)
+    }
+    if _, _, keep := runHooks(&event.QueryEvent{Type: event.TYPE_QUERY, Sql: "SELECT /* health_check */ 1"}); keep {
+        t.Errorf("health check kept")
+    }
+
+    // The second hook runs out of time; the first one's changes stand.
+    ev, extra, keep = runHooks(&event.QueryEvent{Type: event.TYPE_QUERY, Sql: "SELECT 1", ServiceId: "cart",
+        TenantId: "default"})
+    if !keep || stats.hook_errors != 1 || extra["tags"] == nil || extra["escaped"] != nil {
+        t.Errorf("after a timeout: %+v, %v, %v, %d errors", ev, extra, keep, stats.hook_errors)
+    }
+    if _, extra, keep := runHooks(&event.AlertEvent{Type: event.TYPE_ALERT}); !keep || extra["escaped"] != false {
+        t.Errorf("hook unusable after a timeout: %v", extra)
+    }
+
+    for _, bad := range []string{"function other() end", "enrich = 1", "while true do end", "syntax error"} {
+        if _, err := newLuaHook(writeHook(t, dir, "bad.lua", bad)); err == nil {
+            t.Errorf("%q loaded", bad)
+        }
+    }
+}
+
+func TestLuaHookLimits(t *testing.T) {
+    dir, err := ioutil.TempDir("", "hooks")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    defer func() { hooks, stats.hook_errors = nil, 0 }()
+
+    for _, script := range []string{
+        `local s = string.rep("x", 1e9)`,
+        `local s = ("x"):rep(1e9)`,
+        `local s = string.format("%999999999s", "x")`,
+        `local s = string.gsub(string.rep("x", 1e5), "", string.rep("y", 1e5))`,
+    } {
+        h, err := newLuaHook(writeHook(t, dir, "big.lua", "function enrich(ev)\n"+script+"\nreturn true end"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        fields := map[string]interface{}{"type": "alert"}
+        if _, err := h.run(fields); err == nil {
+            t.Errorf("%s ran", script)
+        }
+    }
+
+    // What a hook leaves must still decode as the event.
+    bad := writeHook(t, dir, "bad.lua", `function enrich(ev) ev.size = "big" return true end`)
+    if err := loadHooks(bad); err != nil {
+        t.Fatal(err)
+    }
+    ev, _, keep := runHooks(&event.QueryEvent{Type: event.TYPE_QUERY, Size: 21})
+    if q, _ := ev.(*event.QueryEvent); !keep || q == nil || q.Size != 21 || stats.hook_errors != 1 {
+        t.Errorf("bad result gave %+v, %v, %d errors", ev, keep, stats.hook_errors)
+    }
+}
+
+func TestPublishHooked(t *testing.T) {
+    dir, err := ioutil.TempDir("", "hooks")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    defer func() { hooks = nil }()
+    if err := loadHooks(writeHook(t, dir, "tag.lua", `
+function enrich(ev)
+    ev.tenant_id = "shop"
+    ev.team = "payments"
+    return true
+end
+`)); err != nil {
+        t.Fatal(err)
+    }
+
+    full := resetState()
+    redacted := &memorySink{}
+    sinks, sinkSpecs = append(sinks, redacted), append(sinkSpecs, sinkSpec{Type: "memory", Redact: REDACT_FINGERPRINT})
+    defer replaceSinks(nil)
+    queries := stats.queries_out
+    publishViews(&event.QueryEvent{Type: event.TYPE_QUERY, Sql: "SELECT * FROM t WHERE id = 42",
+        Error: "no id 42"}, &queryViews{raw: "SELECT * FROM t WHERE id = 42", query: "SELECT * FROM t WHERE id = ?",
+        message: "no id 42"})
+    if len(full.payloads) != 1 || len(redacted.payloads) != 1 || stats.queries_out-queries != 1 {
+        t.Fatalf("published %v and %v", full.payloads, redacted.payloads)
+    }
+    q, err := event.DecodeQuery(redacted.payloads[0])
+    if err != nil {
+        t.Fatal(err)
+    }
+    if q.Seq == 0 || q.TenantId != "shop" || q.Sql != "SELECT * FROM t WHERE id = ?" || q.Error != "" ||
+        !strings.Contains(redacted.payloads[0], `"team":"payments"`) {
+        t.Errorf("redacted payload %s", redacted.payloads[0])
+    }
+    if q, _ := event.DecodeQuery(full.payloads[0]); q == nil || q.Seq == 0 || q.Sql != "SELECT * FROM t WHERE id = 42" {
+        t.Errorf("full payload %s", full.payloads[0])
+    }
+}
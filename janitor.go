@@ -0,0 +1,86 @@
+/*
+ * janitor.go
+ *
+ * Bounds on what a long-running sniffer holds. Streams whose FIN or RST we
+ * never saw would be tracked forever; every JANITOR_INTERVAL the ones
+ * idle for -idle_timeout are closed as if they had ended (a pooled
+ * connection idle that long loses its prepared statements and resyncs on
+ * its next query). Servers no stream is connected to any more are
+ * forgotten, and learnt again from their next connection. With
+ * -max_queries the fingerprints in qbuf are capped: reaching the cap
+ * evicts the least recently seen tenth of them, along with their report
+ * totals.
+ */
+
+package main
+
+import (
+    "sort"
+    "time"
+)
+
+const JANITOR_INTERVAL = 10 * time.Second
+
+var idleTimeout time.Duration
+var maxQueries int
+
+// expireSources closes streams idle since before now - idleTimeout. Must
+// be called with stateLock held.
+func expireSources(now time.Time) {
+    eachStream(func(rs *source) {
+        if now.Sub(rs.lastSeen) >= idleTimeout {
+            endStream(rs)
+            stats.expired++
+        }
+    })
+}
+
+// expireServers forgets the servers no stream is connected to. Must be
+// called with stateLock held.
+func expireServers() {
+    used := make(map[string]bool, len(servers))
+    eachStream(func(rs *source) {
+        used[rs.server] = true
+    })
+    for addr := range servers {
+        if !used[addr] {
+            delete(servers, addr)
+        }
+    }
+}
+
+// runJanitor expires idle streams and unused servers every
+// JANITOR_INTERVAL. Idle time is measured in capture time, so no stream
+// expires while no packets arrive.
+func runJanitor() {
+    for range time.Tick(JANITOR_INTERVAL) {
+        stateLock.Lock()
+        if idleTimeout > 0 {
+            expireSources(pktTime)
+        }
+        expireServers()
+        pruneProxyCalls(pktTime)
+        stateLock.Unlock()
+    }
+}
+
+// evictQueries makes room in qbuf for a new fingerprint if it's at
+// -max_queries. Must be called with stateLock held.
+func evictQueries() {
+    if maxQueries <= 0 || len(qbuf) < maxQueries {
+        return
+    }
+    var list sortableSlice
+    for key, qdata := range qbuf {
+        list = append(list, sortable{value: float64(qdata.lastSeen.UnixNano()), line: key})
+    }
+    sort.Sort(list)
+    n := len(qbuf) - maxQueries + maxQueries/10 + 1
+    if n > len(list) {
+        n = len(list)
+    }
+    for _, item := range list[:n] {
+        delete(qbuf, item.line)
+        stats.evicted++
+    }
+}
@@ -0,0 +1,48 @@
+/*
+ * janitor_test.go
+ *
+ * Idle streams are expired and fingerprints evicted.
+ */
+
+package main
+
+import (
+    "fmt"
+    "testing"
+    "time"
+)
+
+func TestJanitor(t *testing.T) {
+    resetState()
+    defer func() { maxQueries, stats.expired, stats.evicted = 0, 0, 0 }()
+    idle := newSource("10.0.0.1:50000")
+    pktTime = pktTime.Add(time.Hour)
+    newSource("10.0.0.2:50000")
+    idleTimeout = time.Minute
+    expireSources(pktTime)
+    if _, ok := pktShard.streams[idle.src]; ok || streamCount() != 1 || stats.expired != 1 {
+        t.Errorf("streams %v, %d expired", pktShard.streams, stats.expired)
+    }
+
+    // A server is forgotten once nothing is connected to it.
+    pktShard.streams["10.0.0.2:50000"].server = "10.0.0.9:3306"
+    servers["10.0.0.9:3306"], servers["10.0.0.8:3306"] = true, true
+    expireServers()
+    if !servers["10.0.0.9:3306"] || len(servers) != 1 {
+        t.Errorf("servers %v", servers)
+    }
+
+    maxQueries = 10
+    for i := 0; i < 25; i++ {
+        pktTime = pktTime.Add(time.Second)
+        processPacket(idle.src, idle, true, mysqlPacket(0, append([]byte{COM_QUERY},
+            fmt.Sprintf("SELECT c%d FROM t", i)...)))
+        idle.synced, idle.reqSent = true, nil
+    }
+    if len(qbuf) > maxQueries {
+        t.Errorf("%d fingerprints kept", len(qbuf))
+    }
+    if _, ok := qbuf["SELECT c24 FROM t"]; !ok {
+        t.Errorf("newest evicted: %v", qbuf)
+    }
+}
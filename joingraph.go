@@ -0,0 +1,178 @@
+/*
+ * joingraph.go
+ *
+ * The join graph: which tables queries actually join, weighted by how often
+ * and for how long. Each fingerprint's joins are worked out once from its
+ * text, pairing the tables on either side of equalities like a.id = b.a_id
+ * (aliases resolved) in ON and WHERE, and a JOIN ... USING with the table
+ * before it. The `joingraph` report writes Graphviz DOT, or JSON with
+ * -report_format json:
+ *
+ *   mysql-sniffer report -r prod.pcap joingraph | dot -Tsvg > joins.svg
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "sort"
+)
+
+// A joinEdge is a pair of tables, in name order.
+type joinEdge struct {
+    a, b string
+}
+
+// Words that can't be a table alias.
+var joinKeywords = map[string]bool{"on": true, "using": true, "join": true, "left": true,
+    "right": true, "inner": true, "outer": true, "cross": true, "natural": true,
+    "straight_join": true, "where": true, "group": true, "order": true, "limit": true,
+    "having": true, "union": true, "set": true, "for": true, "lock": true, "force": true,
+    "use": true, "ignore": true, "partition": true, "window": true}
+
+// joinEdges returns the joins in a canonical query, each once.
+func joinEdges(query string) []joinEdge {
+    words := queryWords([]byte(query))
+
+    // Tables and their aliases, and the JOIN ... USING pairs on the way.
+    tables := make(map[string]string) // alias or name -> table
+    seen := make(map[joinEdge]bool)
+    var edges []joinEdge
+    add := func(a, b string) {
+        if a == "" || b == "" || a == b {
+            return
+        }
+        if b < a {
+            a, b = b, a
+        }
+        if e := (joinEdge{a, b}); !seen[e] {
+            seen[e] = true
+            edges = append(edges, e)
+        }
+    }
+    previous := ""
+    depth, fromDepth, inFrom := 0, 0, false
+    for i := 0; i < len(words); i++ {
+        w := words[i]
+        switch w {
+        case "(":
+            depth++
+            continue
+        case ")":
+            if depth--; depth < fromDepth {
+                inFrom = false
+            }
+            continue
+        case "from":
+            inFrom, fromDepth, previous = true, depth, ""
+        case "join":
+        case ",":
+            if !inFrom || depth != fromDepth {
+                continue
+            }
+        case "where", "group", "order", "having", "limit", "union", "set":
+            if depth == fromDepth {
+                inFrom = false
+            }
+            continue
+        default:
+            continue
+        }
+        if i+1 >= len(words) || words[i+1] == "(" {
+            continue
+        }
+        j := i + 1
+        name := words[j]
+        if j+2 < len(words) && words[j+1] == "." {
+            j += 2
+            name = words[j]
+        }
+        tables[name] = name
+        alias := ""
+        if j+1 < len(words) && words[j+1] == "as" {
+            j++
+        }
+        if j+1 < len(words) && !joinKeywords[words[j+1]] && isIdentifier(words[j+1]) {
+            alias = words[j+1]
+            tables[alias] = name
+            j++
+        }
+        if w == "join" && j+1 < len(words) && words[j+1] == "using" {
+            add(previous, name)
+        }
+        previous = name
+        i = j
+    }
+
+    // a.x = b.y
+    for i := 0; i+6 < len(words); i++ {
+        if words[i+1] == "." && words[i+3] == "=" && words[i+5] == "." &&
+            isIdentifier(words[i]) && isIdentifier(words[i+4]) {
+            add(tables[words[i]], tables[words[i+4]])
+        }
+    }
+    return edges
+}
+
+func isIdentifier(word string) bool {
+    if word == "" {
+        return false
+    }
+    b := word[0]
+    return b == '_' || (b >= 'a' && b <= 'z')
+}
+
+type joinWeight struct {
+    From    string  `json:"from"`
+    To      string  `json:"to"`
+    Queries uint64  `json:"queries"`
+    TimeMs  float64 `json:"time_ms"`
+}
+
+type joinsByQueries []*joinWeight
+
+func (self joinsByQueries) Len() int { return len(self) }
+func (self joinsByQueries) Less(i, j int) bool {
+    if self[i].Queries != self[j].Queries {
+        return self[i].Queries > self[j].Queries
+    }
+    return self[i].From+" "+self[i].To < self[j].From+" "+self[j].To
+}
+func (self joinsByQueries) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+
+// joinGraph adds up the joins of every fingerprint.
+func joinGraph() []*joinWeight {
+    weights := make(map[joinEdge]*joinWeight)
+    var list joinsByQueries
+    for _, key := range sortedQueries() {
+        qdata := qbuf[key]
+        for _, e := range qdata.joins {
+            w, ok := weights[e]
+            if !ok {
+                w = &joinWeight{From: e.a, To: e.b}
+                weights[e] = w
+                list = append(list, w)
+            }
+            w.Queries += qdata.count
+            w.TimeMs += float64(qdata.timeTotal) / 1e6
+        }
+    }
+    sort.Sort(list)
+    return list
+}
+
+func reportJoinGraph(w io.Writer, asJSON bool) {
+    graph := joinGraph()
+    if asJSON {
+        json.NewEncoder(w).Encode(map[string]interface{}{"joins": graph})
+        return
+    }
+    fmt.Fprintf(w, "graph joins {\n")
+    for _, e := range graph {
+        fmt.Fprintf(w, "  %q -- %q [label=\"%d queries, %.0fms\", weight=%d];\n", e.From, e.To,
+            e.Queries, e.TimeMs, e.Queries)
+    }
+    fmt.Fprintf(w, "}\n")
+}
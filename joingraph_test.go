@@ -0,0 +1,22 @@
+/*
+ * joingraph_test.go
+ *
+ * Which tables are joined to which.
+ */
+
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestJoinEdges(t *testing.T) {
+    edges := joinEdges("SELECT * FROM `shop`.`orders` o JOIN items USING (order_id) " +
+        "JOIN customers AS c ON o.customer_id = c.id, addresses a WHERE a.customer_id = c.id " +
+        "AND o.total > ? AND o.id IN (SELECT order_id FROM returns) GROUP BY o.id, c.id")
+    want := []joinEdge{{"items", "orders"}, {"customers", "orders"}, {"addresses", "customers"}}
+    if !reflect.DeepEqual(edges, want) {
+        t.Errorf("got %v, want %v", edges, want)
+    }
+}
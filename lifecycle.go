@@ -0,0 +1,39 @@
+/*
+ * lifecycle.go
+ *
+ * Connection events. With -connection_events each connection publishes a
+ * connection event when its SYN is seen and another when it goes, on a
+ * FIN, RST or COM_QUIT or because its port was reused, with how long it
+ * lasted and the statements and bytes it carried. A connection already
+ * open when capture started only gets the closing one, timed from when it
+ * was first seen.
+ */
+
+package main
+
+import (
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+var connectionEvents bool
+
+func publishConnection(rs *source, state string) {
+    if !connectionEvents {
+        return
+    }
+    ev := &event.ConnectionEvent{
+        Type:       event.TYPE_CONNECTION,
+        ServiceId:  service_id,
+        TenantId:   rs.tenant,
+        State:      state,
+        Client:     rs.src,
+        Generation: rs.gen,
+    }
+    if state == "close" {
+        ev.Duration = pktTime.Sub(rs.opened).Seconds()
+        ev.Queries = rs.queries
+        ev.Bytes = rs.bytes
+        ev.Resets = rs.resets
+    }
+    publish(ev)
+}
@@ -0,0 +1,48 @@
+/*
+ * lifecycle_test.go
+ *
+ * Connection open and close events.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+func TestConnectionEvents(t *testing.T) {
+    mem := resetState()
+    connectionEvents = true
+    defer func() { connectionEvents = false }()
+    s, c := newTestSoaker()
+    s.connect(c)
+    s.query(c)
+    s.now = s.now.Add(2 * time.Second)
+    s.close(c)
+
+    var states []string
+    var closed *event.ConnectionEvent
+    for _, payload := range mem.payloads {
+        var ev event.ConnectionEvent
+        json.Unmarshal([]byte(strings.TrimPrefix(payload, event.Prefix)), &ev)
+        if ev.Type == event.TYPE_CONNECTION {
+            states = append(states, ev.State)
+            closed = &ev
+        }
+    }
+    if !reflect.DeepEqual(states, []string{"open", "close"}) {
+        t.Fatalf("states %v", states)
+    }
+    if closed.Client != "10.1.0.1:20001" || closed.Queries != 1 || closed.Duration < 2 || closed.Bytes == 0 {
+        t.Errorf("close event %+v", closed)
+    }
+    if streamCount() != 0 {
+        t.Errorf("%d streams left", streamCount())
+    }
+}
@@ -0,0 +1,206 @@
+/*
+ * literals.go
+ *
+ * Opt-in profiling of literal values. For a named fingerprint (see -names)
+ * and a literal position, e.g. -literal_profile get_orders@1 for the first
+ * literal of the query named get_orders, the values seen there are counted
+ * to find hot keys behind skew. Counts go into a count-min sketch of fixed
+ * size and only the -literal_profile_top heaviest values are kept, so memory
+ * stays bounded however many distinct values show up. Values are hashed
+ * before anything is stored unless -literal_profile_mask is none.
+ */
+
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "hash/fnv"
+    "io"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+const CMS_DEPTH = 4
+const CMS_WIDTH = 2048
+
+type literalProfile struct {
+    name     string
+    position int // 1-based, in the order canonicalization replaces literals
+    total    uint64
+    sketch   [CMS_DEPTH][CMS_WIDTH]uint32
+    top      map[string]uint64 // at most literalProfileTop values
+}
+
+// Keyed by fingerprint name.
+var literalProfiles map[string]*literalProfile = make(map[string]*literalProfile)
+var literalProfileTop int = 20
+var literalProfileMask string = "hash"
+
+// parseLiteralProfiles sets up profiles from a -literal_profile value, a
+// comma separated list of name@position.
+func parseLiteralProfiles(spec string) error {
+    if literalProfileMask != "hash" && literalProfileMask != "none" {
+        return fmt.Errorf("unknown mask %s", literalProfileMask)
+    }
+    if literalProfileTop < 1 {
+        return fmt.Errorf("-literal_profile_top must be positive")
+    }
+    profiles := make(map[string]*literalProfile)
+    for _, entry := range strings.Split(spec, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        at := strings.LastIndex(entry, "@")
+        if at <= 0 {
+            return fmt.Errorf("%s: want name@position", entry)
+        }
+        position, err := strconv.Atoi(entry[at+1:])
+        if err != nil || position < 1 {
+            return fmt.Errorf("%s: bad position", entry)
+        }
+        profiles[entry[:at]] = &literalProfile{name: entry[:at], position: position,
+            top: make(map[string]uint64)}
+    }
+    literalProfiles = profiles
+    return nil
+}
+
+// noteLiterals feeds a query's literal to the profile for its fingerprint,
+// if there is one.
+func noteLiterals(fingerprint string, query []byte) {
+    if len(literalProfiles) == 0 {
+        return
+    }
+    profile, ok := literalProfiles[nameOf(fingerprint)]
+    if !ok {
+        return
+    }
+    n := 0
+    for i := 0; i < len(query); {
+        length, toktype := scanToken(query[i:])
+        if toktype == TOKEN_NUMBER || toktype == TOKEN_QUOTE {
+            if n++; n == profile.position {
+                profile.observe(maskProfiled(string(query[i:i+length]), toktype))
+                return
+            }
+        }
+        i += length
+    }
+}
+
+func maskProfiled(value string, toktype int) string {
+    if toktype == TOKEN_QUOTE {
+        value = value[1 : len(value)-1]
+    }
+    if literalProfileMask == "none" {
+        return value
+    }
+    return hashLiteral(value)
+}
+
+// hashLiteral is the short hash that stands in for a masked value.
+func hashLiteral(value string) string {
+    sum := sha256.Sum256([]byte(value))
+    return hex.EncodeToString(sum[:8])
+}
+
+// observe counts one value and keeps it among the top values if its
+// estimated count is high enough.
+func (self *literalProfile) observe(value string) {
+    self.total++
+    h := fnv.New64a()
+    h.Write([]byte(value))
+    sum := h.Sum64()
+    estimate := ^uint32(0)
+    for row := 0; row < CMS_DEPTH; row++ {
+        // Derive each row's index from the two halves of one hash.
+        col := (uint32(sum) + uint32(row)*uint32(sum>>32)) % CMS_WIDTH
+        self.sketch[row][col]++
+        if self.sketch[row][col] < estimate {
+            estimate = self.sketch[row][col]
+        }
+    }
+
+    if _, ok := self.top[value]; ok || len(self.top) < literalProfileTop {
+        self.top[value] = uint64(estimate)
+        return
+    }
+    var minValue string
+    var minCount uint64
+    for v, count := range self.top {
+        if minValue == "" || count < minCount {
+            minValue, minCount = v, count
+        }
+    }
+    if uint64(estimate) > minCount {
+        delete(self.top, minValue)
+        self.top[value] = uint64(estimate)
+    }
+}
+
+type hotValue struct {
+    Value string  `json:"value"`
+    Count uint64  `json:"count"`
+    Share float64 `json:"share"`
+}
+
+// hottest returns the kept values, highest estimated count first.
+func (self *literalProfile) hottest() []hotValue {
+    var list []hotValue
+    for value, count := range self.top {
+        list = append(list, hotValue{Value: value, Count: count,
+            Share: float64(count) / float64(self.total)})
+    }
+    sort.Sort(hotValues(list))
+    return list
+}
+
+type hotValues []hotValue
+
+func (self hotValues) Len() int      { return len(self) }
+func (self hotValues) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+func (self hotValues) Less(i, j int) bool {
+    if self[i].Count != self[j].Count {
+        return self[i].Count > self[j].Count
+    }
+    return self[i].Value < self[j].Value
+}
+
+func reportLiterals(w io.Writer, asJSON bool) {
+    var names []string
+    for name := range literalProfiles {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    if asJSON {
+        type profileOut struct {
+            Name     string     `json:"name"`
+            Position int        `json:"position"`
+            Total    uint64     `json:"total"`
+            Masked   bool       `json:"masked"`
+            Values   []hotValue `json:"values"`
+        }
+        var out []profileOut
+        for _, name := range names {
+            p := literalProfiles[name]
+            out = append(out, profileOut{Name: name, Position: p.position, Total: p.total,
+                Masked: literalProfileMask != "none", Values: p.hottest()})
+        }
+        json.NewEncoder(w).Encode(map[string]interface{}{"literals": out})
+        return
+    }
+    for _, name := range names {
+        p := literalProfiles[name]
+        fmt.Fprintf(w, "%s== literals: %s position %d (%d values)%s\n", color(COLOR_CYAN),
+            name, p.position, p.total, color(COLOR_DEFAULT))
+        for _, hot := range p.hottest() {
+            fmt.Fprintf(w, "%10d %6.2f%%  %s\n", hot.Count, hot.Share*100, hot.Value)
+        }
+        fmt.Fprintf(w, "\n")
+    }
+}
@@ -0,0 +1,36 @@
+/*
+ * literals_test.go
+ *
+ * The hottest literal values of profiled fingerprints.
+ */
+
+package main
+
+import (
+    "testing"
+)
+
+func TestLiteralProfile(t *testing.T) {
+    fingerprintNames = map[string]string{"SELECT * FROM orders WHERE shop = ? AND id = ?": "orders"}
+    literalProfileTop, literalProfileMask = 2, "none"
+    defer func() {
+        fingerprintNames, literalProfiles = make(map[string]string), make(map[string]*literalProfile)
+        literalProfileMask = "hash"
+    }()
+    if err := parseLiteralProfiles("orders@1"); err != nil {
+        t.Fatal(err)
+    }
+    for _, shop := range []string{"'a'", "'b'", "'a'", "'c'", "'a'", "'b'"} {
+        noteLiterals("SELECT * FROM orders WHERE shop = ? AND id = ?",
+            []byte("SELECT * FROM orders WHERE shop = "+shop+" AND id = 7"))
+    }
+    hot := literalProfiles["orders"].hottest()
+    if len(hot) != 2 || hot[0].Value != "a" || hot[0].Count != 3 || hot[1].Value != "b" {
+        t.Errorf("hottest %+v", hot)
+    }
+    for _, bad := range []string{"orders", "@1", "orders@0", "orders@x"} {
+        if parseLiteralProfiles(bad) == nil {
+            t.Errorf("%q: no error", bad)
+        }
+    }
+}
@@ -0,0 +1,305 @@
+/*
+ * masking.go
+ *
+ * The masking policy. Without -u we publish canonical SQL and nothing leaks,
+ * with it every literal goes out as is. -mask_rules sits in between: queries
+ * are still aggregated by fingerprint, but the SQL we publish keeps its
+ * literals, each run through the first matching rule:
+ *
+ *   {"default": "keep",
+ *    "rules": [{"table": "users", "column": "email", "action": "hash"},
+ *              {"match": "^[0-9]{13,19}$", "action": "redact"},
+ *              {"column": "comment", "action": "truncate", "keep": 8}]}
+ *
+ * A rule matches on any of table, column (case insensitive) and match, a
+ * regexp on the unquoted value; the ones it leaves out match anything.
+ * Actions are keep, hash (a short SHA-256, quoted), redact (a bare ?) and
+ * truncate (the first keep characters, quoted). Literals no rule matches get
+ * the default action.
+ *
+ * Columns are worked out from the text alone: the identifier a literal is
+ * compared to (a = 1, a IN (1, 2), a BETWEEN 1 AND 2, SET a = 1) or its
+ * position in INSERT ... (a, b) VALUES (1, 2). A table rule matches if the
+ * table is named anywhere in the statement, since aliases aren't resolved.
+ * Quoted values in error messages are masked too, without a column.
+ */
+
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "regexp"
+    "strings"
+)
+
+const (
+    MASK_KEEP     = "keep"
+    MASK_HASH     = "hash"
+    MASK_REDACT   = "redact"
+    MASK_TRUNCATE = "truncate"
+)
+
+type maskRule struct {
+    Table  string `json:"table,omitempty"`
+    Column string `json:"column,omitempty"`
+    Match  string `json:"match,omitempty"`
+    Action string `json:"action"`
+    Keep   int    `json:"keep,omitempty"` // truncate
+
+    re *regexp.Regexp
+}
+
+type maskPolicy struct {
+    Default string      `json:"default"`
+    Rules   []*maskRule `json:"rules"`
+}
+
+// maskRules is nil unless -mask_rules is given.
+var maskRules *maskPolicy
+
+func validMaskAction(action string) bool {
+    switch action {
+    case MASK_KEEP, MASK_HASH, MASK_REDACT, MASK_TRUNCATE:
+        return true
+    }
+    return false
+}
+
+// loadMaskRules reads and compiles a masking policy.
+func loadMaskRules(path string) error {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    policy := &maskPolicy{Default: MASK_KEEP}
+    if err := json.Unmarshal(data, policy); err != nil {
+        return fmt.Errorf("%s: %s", path, err)
+    }
+    if !validMaskAction(policy.Default) {
+        return fmt.Errorf("%s: unknown default action %q", path, policy.Default)
+    }
+    for i, rule := range policy.Rules {
+        if !validMaskAction(rule.Action) {
+            return fmt.Errorf("%s: rule %d: unknown action %q", path, i, rule.Action)
+        }
+        if rule.Action == MASK_TRUNCATE && rule.Keep <= 0 {
+            return fmt.Errorf("%s: rule %d: truncate needs keep > 0", path, i)
+        }
+        if rule.Match != "" {
+            if rule.re, err = regexp.Compile(rule.Match); err != nil {
+                return fmt.Errorf("%s: rule %d: %s", path, i, err)
+            }
+        }
+        rule.Table, rule.Column = strings.ToLower(rule.Table), strings.ToLower(rule.Column)
+    }
+    maskRules = policy
+    return nil
+}
+
+// action returns what to do with a literal found in tables, compared to
+// column ("" if not known).
+func (self *maskPolicy) action(tables []string, column string, value string) *maskRule {
+    for _, rule := range self.Rules {
+        if rule.Column != "" && rule.Column != column {
+            continue
+        }
+        if rule.Table != "" && !containsString(tables, rule.Table) {
+            continue
+        }
+        if rule.re != nil && !rule.re.MatchString(value) {
+            continue
+        }
+        return rule
+    }
+    return &maskRule{Action: self.Default}
+}
+
+func containsString(list []string, s string) bool {
+    for _, item := range list {
+        if item == s {
+            return true
+        }
+    }
+    return false
+}
+
+// literalValue strips the quotes off a literal token.
+func literalValue(token string, toktype int) string {
+    if toktype == TOKEN_QUOTE {
+        return token[1 : len(token)-1]
+    }
+    return token
+}
+
+// apply rewrites one literal token.
+func (self *maskRule) apply(token string, toktype int) string {
+    value := literalValue(token, toktype)
+    switch self.Action {
+    case MASK_HASH:
+        return "'" + hashLiteral(value) + "'"
+    case MASK_REDACT:
+        return "?"
+    case MASK_TRUNCATE:
+        if len(value) > self.Keep {
+            value = value[:self.Keep] + "..."
+        }
+        return "'" + strings.Replace(value, "'", "''", -1) + "'"
+    }
+    return token
+}
+
+// maskToken is a token of a query being masked, whitespace left out.
+type maskToken struct {
+    start, end int
+    toktype    int
+    word       string // lower cased, for TOKEN_WORD and TOKEN_OTHER
+}
+
+// maskTokens splits a query into tokens, leaving out whitespace and
+// backquotes.
+func maskTokens(query []byte) []maskToken {
+    var tokens []maskToken
+    for i := 0; i < len(query); {
+        length, toktype := scanToken(query[i:])
+        if toktype != TOKEN_WHITESPACE && !(toktype == TOKEN_OTHER && query[i] == '`') {
+            tokens = append(tokens, maskToken{start: i, end: i + length, toktype: toktype,
+                word: strings.ToLower(string(query[i : i+length]))})
+        }
+        i += length
+    }
+    return tokens
+}
+
+// Words that may sit between a column and the literal compared to it.
+var maskOperators = map[string]bool{"=": true, "<": true, ">": true, "!": true,
+    "in": true, "not": true, "like": true, "between": true, "and": true, "is": true, "-": true}
+
+// maskQuery returns the query with the masking policy applied to each
+// literal, and the masked literals in order.
+func maskQuery(query []byte) (string, []string) {
+    tokens := maskTokens(query)
+    tables := maskTables(tokens)
+
+    var out bytes.Buffer
+    var values []string
+    out.Grow(len(query))
+    last := 0
+    var candidate string // identifier a following literal may be compared to
+    var compared bool    // an operator came after it
+    var columns []string // INSERT column list
+    inColumns, inValues, depth, pos := false, false, 0, 0
+    for i, tok := range tokens {
+        switch tok.toktype {
+        case TOKEN_NUMBER, TOKEN_QUOTE:
+            if tok.toktype == TOKEN_QUOTE && (tok.end-tok.start < 2 || query[tok.end-1] != query[tok.start]) {
+                // Unterminated, so probably not a literal at all.
+                continue
+            }
+            column := ""
+            if inValues && depth == 1 && pos < len(columns) {
+                column = columns[pos]
+            } else if compared {
+                column = candidate
+            }
+            token := string(query[tok.start:tok.end])
+            masked := maskRules.action(tables, column, literalValue(token, tok.toktype)).apply(token, tok.toktype)
+            out.Write(query[last:tok.start])
+            out.WriteString(masked)
+            last = tok.end
+            values = append(values, masked)
+            continue
+        }
+
+        switch {
+        case tok.word == "(":
+            depth++
+            if inValues && depth == 1 {
+                pos = 0
+            }
+            if !inValues && followsInto(tokens[:i]) {
+                inColumns = true
+            }
+        case tok.word == ")":
+            depth--
+            inColumns = false
+            candidate, compared = "", false
+        case tok.word == ",":
+            if inValues && depth == 1 {
+                pos++
+            }
+        case tok.word == "values" || tok.word == "value":
+            inValues, depth = true, 0
+        case maskOperators[tok.word]:
+            compared = candidate != ""
+        case tok.toktype == TOKEN_WORD:
+            if inColumns {
+                columns = append(columns, tok.word)
+            } else if inValues && depth == 0 {
+                inValues = false
+            }
+            candidate, compared = tok.word, false
+        case tok.word == ".":
+        default:
+            candidate, compared = "", false
+        }
+    }
+    out.Write(query[last:])
+    return out.String(), values
+}
+
+// maskTables returns the tables a statement names: whatever follows FROM,
+// JOIN, UPDATE or INTO, without the schema.
+func maskTables(tokens []maskToken) []string {
+    var tables []string
+    for i := 0; i+1 < len(tokens); i++ {
+        switch tokens[i].word {
+        case "from", "join", "into":
+        case "update":
+            if i > 0 && tokens[i-1].word == "key" {
+                continue // ON DUPLICATE KEY UPDATE
+            }
+        default:
+            continue
+        }
+        j := i + 1
+        if tokens[j].toktype != TOKEN_WORD {
+            continue
+        }
+        if j+2 < len(tokens) && tokens[j+1].word == "." && tokens[j+2].toktype == TOKEN_WORD {
+            j += 2
+        }
+        tables = append(tables, tokens[j].word)
+    }
+    return tables
+}
+
+// followsInto says if tokens end with INTO and a table name.
+func followsInto(tokens []maskToken) bool {
+    n := len(tokens)
+    if n >= 4 && tokens[n-2].word == "." {
+        n -= 2
+    }
+    return n >= 2 && tokens[n-1].toktype == TOKEN_WORD && tokens[n-2].word == "into"
+}
+
+// maskMessage masks the quoted values in an error message.
+func maskMessage(message string) string {
+    if maskRules == nil {
+        return message
+    }
+    var out bytes.Buffer
+    data := []byte(message)
+    for i := 0; i < len(data); {
+        length, toktype := scanToken(data[i:])
+        token := string(data[i : i+length])
+        if toktype == TOKEN_QUOTE && length >= 2 && data[i+length-1] == data[i] {
+            token = maskRules.action(nil, "", literalValue(token, toktype)).apply(token, toktype)
+        }
+        out.WriteString(token)
+        i += length
+    }
+    return out.String()
+}
@@ -0,0 +1,48 @@
+/*
+ * masking_test.go
+ *
+ * Literals are masked per table and column by the -mask rules.
+ */
+
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestMaskQuery(t *testing.T) {
+    dir, err := ioutil.TempDir("", "mask")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "mask.json")
+    ioutil.WriteFile(path, []byte(`{"default": "redact", "rules": [
+        {"table": "users", "column": "email", "action": "hash"},
+        {"column": "id", "action": "keep"},
+        {"match": "^[a-z]+$", "action": "truncate", "keep": 2}]}`), 0644)
+    defer func() { maskRules = nil }()
+    if err := loadMaskRules(path); err != nil {
+        t.Fatal(err)
+    }
+    email := "'" + hashLiteral("a@example.com") + "'"
+    for _, c := range []struct{ query, want string }{
+        {"SELECT * FROM users WHERE email = 'a@example.com' AND id IN (1, 2) LIMIT 10",
+            "SELECT * FROM users WHERE email = " + email + " AND id IN (1, 2) LIMIT ?"},
+        {"INSERT INTO `shop`.`users` (id, email, name) VALUES (3, 'a@example.com', 'carol'), (4, 'x', 'Dan')",
+            "INSERT INTO `shop`.`users` (id, email, name) VALUES (3, " + email + ", 'ca...'), (4, '" +
+                hashLiteral("x") + "', ?)"},
+        {"UPDATE orders SET email = 'a@example.com' WHERE id = -5",
+            "UPDATE orders SET email = ? WHERE id = -5"},
+    } {
+        if got, _ := maskQuery([]byte(c.query)); got != c.want {
+            t.Errorf("%s\n got %s\nwant %s", c.query, got, c.want)
+        }
+    }
+    if got := maskMessage("Duplicate entry 'bob' for key 'users.email'"); got != "Duplicate entry 'bo...' for key ?" {
+        t.Errorf("message %q", got)
+    }
+}
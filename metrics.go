@@ -0,0 +1,66 @@
+/*
+ * metrics.go
+ *
+ * GET /metrics on the control API, in the Prometheus text format.
+ */
+
+package main
+
+import (
+    "fmt"
+    "net/http"
+)
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    stateLock.Lock()
+    defer stateLock.Unlock()
+
+    for _, c := range []struct {
+        name  string
+        value uint64
+    }{
+        {"mysql_sniffer_packets_total", stats.packets.rcvd},
+        {"mysql_sniffer_desyncs_total", stats.desyncs},
+        {"mysql_sniffer_published_total", stats.published},
+        {"mysql_sniffer_publish_errors_total", stats.publish_errors},
+        {"mysql_sniffer_published_queries_total", stats.queries_out},
+        {"mysql_sniffer_resets_total", stats.resets},
+        {"mysql_sniffer_expired_streams_total", stats.expired},
+        {"mysql_sniffer_evicted_queries_total", stats.evicted},
+        {"mysql_sniffer_detected_servers_total", stats.detected},
+        {"mysql_sniffer_compressed_streams_total", stats.compressed},
+        {"mysql_sniffer_zstd_streams_total", stats.zstd},
+        {"mysql_sniffer_tls_connections_total", stats.tls},
+        {"mysql_sniffer_sampled_out_total", stats.sampled_out},
+        {"mysql_sniffer_bytes_in_total", stats.wire.in},
+        {"mysql_sniffer_bytes_out_total", stats.wire.out},
+    } {
+        fmt.Fprintf(w, "# TYPE %s counter\n%s %d\n", c.name, c.name, c.value)
+    }
+    sinksLock.Lock()
+    spooled, dropped := spoolTotals()
+    queued, zdropped, retries := zmqTotals()
+    bdropped := batchDropped()
+    sinksLock.Unlock()
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_spooled_total counter\nmysql_sniffer_spooled_total %d\n", spooled)
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_spool_dropped_total counter\nmysql_sniffer_spool_dropped_total %d\n", dropped)
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_zmq_queued gauge\nmysql_sniffer_zmq_queued %d\n", queued)
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_zmq_dropped_total counter\nmysql_sniffer_zmq_dropped_total %d\n", zdropped)
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_batch_dropped_total counter\nmysql_sniffer_batch_dropped_total %d\n", bdropped)
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_zmq_reconnects_total counter\nmysql_sniffer_zmq_reconnects_total %d\n", retries)
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_streams gauge\nmysql_sniffer_streams %d\n", stats.streams)
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_oldest_request_seconds gauge\nmysql_sniffer_oldest_request_seconds %.6f\n",
+        oldestRequest(requestClock()).Seconds())
+    writeTxMetrics(w)
+    writeCommandMetrics(w)
+    writeStageMetrics(w)
+    writeGCMetrics(w)
+    writeSuccessMetrics(w)
+    writeErrorMetrics(w)
+    writeBurstMetrics(w)
+    writeConnectRateMetrics(w)
+    writeDecoderMetrics(w)
+    writeProxyMetrics(w)
+    writeSelfMetrics(w)
+}
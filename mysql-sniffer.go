@@ -4,32 +4,31 @@
  * A straightforward program for sniffing MySQL query streams and providing
  * diagnostic information on the realtime queries your database is handling.
  *
- * FIXME: this assumes IPv4.
- * FIXME: tokenizer doesn't handle negative numbers or floating points.
- * FIXME: canonicalizer should collapse "IN (?,?,?,?)" and "VALUES (?,?,?,?)"
  * FIXME: tokenizer breaks on '"' or similarly embedded quotes
  * FIXME: tokenizer parses numbers in words wrong, i.e. s2compiled -> s?compiled
  *
  * written by Mark Smith <mark@qq.is>
  *
- * requires the gopcap library to be installed from:
- *   https://github.com/akrennmair/gopcap
+ * requires libpcap and libzmq to build (through gopacket/pcap and pebbe/zmq4;
+ * see go.mod).
  *
  */
 
 package main
 
 import (
+    "bytes"
     "flag"
     "fmt"
-    "./gopcap"
-    _ "./go-spew/spew"
+    "io"
     "log"
-    "math/rand"
+    "os"
+    "strconv"
     "strings"
+    "sync"
     "time"
-    "encoding/json"
-    zmq "./zmq4"
+
+    "github.com/elvis2002/mysql-sniffer/event"
 )
 
 const (
@@ -39,9 +38,6 @@ const (
     TOKEN_WHITESPACE = 3
     TOKEN_OTHER      = 4
 
-    // Internal tuning
-    TIME_BUCKETS = 10000
-
     // ANSI colors
     COLOR_RED     = "\x1b[31m"
     COLOR_GREEN   = "\x1b[32m"
@@ -51,7 +47,16 @@ const (
     COLOR_DEFAULT = "\x1b[39m"
 
     // MySQL packet types
-    COM_QUERY = 3
+    COM_QUERY            = 3
+    COM_STMT_PREPARE     = 0x16
+    COM_CHANGE_USER      = 0x11
+    COM_RESET_CONNECTION = 0x1f
+
+    // TCP flags
+    TCP_FIN = 0x01
+    TCP_SYN = 0x02
+    TCP_RST = 0x04
+    TCP_ACK = 0x10
 
     // These are used for formatting outputs
     F_NONE = iota
@@ -73,53 +78,169 @@ type sortable struct {
 type sortableSlice []sortable
 
 type source struct {
-    src       string
-    srcip     string
-    synced    bool
-    reqbuffer []byte
-    resbuffer []byte
-    reqSent   *time.Time
-    reqTimes  [TIME_BUCKETS]uint64
-    qbytes    uint64
-    qdata     *queryData
-    qtext     string
+    src        string
+    server     string // ip:port
+    srcip      string
+    shard      *streamShard
+    view       streamView // for predecode, under shard.lock
+    synced     bool
+    reqbuffer  []byte
+    resbuffer  []byte
+    reqSent    *time.Time
+    qbytes     uint64
+    qdata      *queryData
+    qtext      string
+    query      string
+    shown      string // the query as published, if -mask_rules rewrote it
+    raw        string // the query as sent, for sinks given all of it
+    literals   []string
+    indexHints []string
+    optHints   []string
+    violations []string // anti-pattern rules the current query breaks
+    offset     uint64   // its LIMIT offset, if any
+    resbytes   uint64 // response bytes so far for the current query
+    command    int    // command of the current request
+    pending    bool   // response started, query not published yet
+    resp       respFramer
+    txcmd      int    // TX_* of the current request
+    inTx       bool   // in an explicit transaction
+    txStmts    uint64 // statements so far in it
+    txStart    time.Time
+    implicitTx bool   // autocommit is off, so writes are transactional
+    writes     writeModes
+    timing     responseTiming
+    unanswered uint64
+    gen        uint64
+    tenant     string
+    db         string // current schema, if the server told us
+    sdata      *sourceData
+    resets     uint64 // COM_RESET_CONNECTIONs seen, i.e. pool checkouts
+    threadId   uint32 // from the server greeting, 0 if we missed it
+    user       string // from the login or COM_CHANGE_USER, if we saw it
+    loginDb    string // the database given with the user
+    stmts      map[uint32]*preparedStmt
+    preparing  *preparedStmt // until the server says its id
+    opened     time.Time     // SYN, or when first seen
+    handshake  bool          // opened by a SYN and nothing since
+    queries    uint64        // statements run on the connection
+    lastSeen   time.Time     // its last packet
+    bytes      uint64        // payload both ways
+    history    *queryHistory // its last statements, with -history
+    compress   int           // COMPRESS_* the login asked for
+    compressed bool          // and the server agreed; see compress.go
+    zreq, zres []byte        // partial compressed frames each way
+    tls        bool          // encrypted, so not decoded; see tls.go
+    tlsRecords int           // segments that looked like TLS before sync
 }
 
 type queryData struct {
+    sql   string // the statement as first seen, canonicalized unless -u
+    class string // "" or CLASS_MONITORING
+    hints map[string]uint64
+
+    complexity queryComplexity
+    joins      []joinEdge
+    tables     []tableRef
+    patterns   []*antiPattern    // anti-pattern rules it may break
+    violations map[string]uint64 // statements breaking each
+    paginated  bool                 // has a LIMIT
+    offsets    *offsetStats
     count uint64
     bytes uint64
-    times [TIME_BUCKETS]uint64
+    times latencyDigest
+    hours [24][HEAT_BUCKETS]uint64 // hour of day x latency bucket
+    scan  scanStats
+
+    timed     uint64 // responses seen, and their total time
+    timeTotal uint64
+    bySource  map[string]*sourceLatency // the same split by client IP
+    lastSeen  time.Time                 // for -max_queries
+    oks       uint64                    // responses that were OK or a result
+    errs      uint64                    // and that were an ERR
+    codes     map[uint16]*errorCount    // those by error code
+    arrivals  arrivalStats
 }
 
 var start int64 = UnixNow()
 var qbuf map[string]*queryData = make(map[string]*queryData)
 var querycount int
-var chmap map[string]*source = make(map[string]*source)
+
+// stateLock guards everything the capture loop touches (streams, qbuf, stats,
+// ...) against the timers and control API that also read or flush it.
+var stateLock sync.Mutex
+
+// pktTime is the capture timestamp of the packet being handled. Timings use
+// it rather than the clock so that reading a pcap file gives real latencies.
+var pktTime time.Time
+var generation uint64
+var ipStreams map[string]int = make(map[string]int)
+var maxStreams int
+var maxStreamsPerIP int
+var warnedLimit bool = false
 var verbose bool = false
 var noclean bool = false
+var requestOnly bool = false
 var dirty bool = false
 var format []interface{}
 var port uint16
-var times [TIME_BUCKETS]uint64
-var puber *zmq.Socket
+var userFilter string
 var service_id string = ""
 var tenant_id string = ""
 var zmqaddr string = ""
+var subaddr string = ""
 var topic string = ""
 
 var stats struct {
     packets struct {
         rcvd      uint64
         rcvd_sync uint64
+        ignored   uint64
+    }
+    wire struct {
+        in  uint64 // bytes client->server
+        out uint64
     }
-    desyncs uint64
-    streams uint64
+    desyncs      uint64
+    streams      uint64
+    connects     uint64
+    unclassified uint64
+    unanswered   uint64
+    reused       uint64
+    rejected     uint64
+    resets       uint64
+    expired      uint64 // streams closed by -idle_timeout
+    evicted      uint64 // fingerprints dropped for -max_queries
+    detected     uint64 // servers found by -detect-protocol
+    compressed   uint64 // streams using the compressed protocol
+    zstd         uint64 // of which zstd
+    tls          uint64 // streams found to be encrypted
+
+    published      uint64 // sends that succeeded, per event and sink
+    publish_errors uint64
+    queries_out    uint64 // query events handed to the sinks
+    hook_dropped   uint64
+    sampled_out    uint64
+    hook_errors    uint64
 }
 
 func UnixNow() int64 {
     return time.Now().Unix()
 }
 
+// captureFilter returns the BPF expression handed to pcap: the server
+// ports, narrowed by -shard and -bpf.
+func captureFilter() string {
+    filter := portFilter()
+    if detectProtocol {
+        filter = "tcp"
+    }
+    filter += shardFilter()
+    if userFilter != "" {
+        filter += " and (" + userFilter + ")"
+    }
+    return filter
+}
+
 func main() {
     var lport *int = flag.Int("P", 3306, "MySQL port to use")
     var eth *string = flag.String("i", "eth0", "Interface to sniff")
@@ -131,57 +252,424 @@ func main() {
     var sid *string = flag.String("service_id", "default", "service_id")
     var tid *string = flag.String("tenant_id", "default", "tenant_id")
     var tpc *string  = flag.String("topic", "", "topic")
-    
-    flag.Parse()
+    flag.String("zmq_user", "", "zmq PLAIN username")
+    flag.String("zmq_password", "", "zmq PLAIN password, as file:/path or env:NAME")
+    var reqonly *bool = flag.Bool("request_only", false, "Publish on request without waiting for responses (no latency)")
+    var maxstr *int = flag.Int("max-streams", 0, "Maximum number of tracked streams (0 = unlimited)")
+    var maxstrip *int = flag.Int("max-streams-per-ip", 0, "Maximum number of tracked streams per client IP (0 = unlimited)")
+    flag.Int("zmq_batch", 1, "Events per zmq message; batches are flushed at least every second")
+    flag.String("zmq_compress", "", "Compression of zmq messages: gzip, or empty for none")
+    flag.String("zmq_format", "", "Body of zmq messages: json, or cbor for consumers without a JSON parser")
+    flag.String("spool_dir", "", "Spool zmq events to this directory while the broker is unreachable (disabled if empty)")
+    flag.Int("spool_max_mb", 256, "Most disk the spool may use, in megabytes; the oldest events go first")
+    flag.String("spool_max_age", "", "Spooled events older than this are dropped instead of sent (e.g. 24h; empty = no limit)")
+    flag.String("sign", "", "Sign zmq and exec events with algorithm:key, e.g. hmac-sha256:env:NAME or ed25519:file:/path (see sink_sign.go)")
+    var sad *string = flag.String("sub_addr", "", "zmq address subscribers connect to, if it differs from -zmq_addr")
+    var smatch *string = flag.String("match", "", "subscribe: only show queries containing this text")
+    var soperate *string = flag.String("operate", "", "subscribe: only show this operation (select, insert, ...)")
+    var smin *float64 = flag.Float64("min_ms", 0, "subscribe: only show queries slower than this many milliseconds")
+    var verify *string = flag.String("verify", "", "subscribe: check signatures with algorithm:key (the public key for ed25519)")
+    flag.String("exec", "", "Command to start and stream events to as JSON lines on stdin")
+    flag.String("out", "", "Append events to this file as JSON lines (disabled if empty)")
+    flag.Int("out_rotate_mb", 100, "Rotate the -out file past this many megabytes (0 = no limit)")
+    flag.String("out_rotate_age", "", "Rotate the -out file when it is this old, e.g. 1h (empty = no limit)")
+    flag.Int("out_keep", 10, "Rotated -out files to keep (0 = all)")
+    flag.String("es_url", "", "Index query events into Elasticsearch at this URL, e.g. http://localhost:9200 (disabled if empty)")
+    flag.String("es_index", "mysql-sniffer", "Elasticsearch index for -es_url")
+    flag.String("es_user", "", "User for basic auth to -es_url")
+    flag.String("es_password", "", "Password for -es_user, as file:/path or env:NAME")
+    flag.String("statsd_addr", "", "Send query counts and times to StatsD at this host:port (disabled if empty)")
+    flag.String("statsd_prefix", "mysql_sniffer", "Prefix of -statsd_addr metric names")
+    flag.Bool("statsd_tags", true, "Tag -statsd_addr metrics DogStatsD style; false puts service, tenant and operation in the name")
+    flag.String("sql_table", "", "Write each -interval summary as INSERTs into this [schema.]table, via -sql_out or -sql_exec (disabled if empty)")
+    flag.String("sql_out", "", "Append the -sql_table statements to this file")
+    flag.String("sql_exec", "", "Pipe the -sql_table statements to this command, e.g. 'mysql -h warehouse'")
+    var sinksfile *string = flag.String("sinks", "", "JSON file listing sinks; overrides -zmq_addr/-exec and is re-read on SIGHUP")
+    var control *string = flag.String("control", "", "Address to serve the HTTP control API on (disabled if empty)")
+    var gogc *int = flag.Int("gogc", 0, "GC target percentage, e.g. 400 for fewer collections (0 = GOGC or the default)")
+    var ballastmb *int = flag.Int("ballast_mb", 0, "Allocate this many megabytes of never-used heap so the GC runs less often (0 = none)")
+    var tstages *bool = flag.Bool("trace_stages", false, "Time each stage of the pipeline, on /metrics")
+    var pprofaddr *string = flag.String("pprof", "", "Address to serve net/http/pprof on, e.g. localhost:6060 (disabled if empty)")
+    var profpath *string = flag.String("profile", "", "Write CPU and heap profiles to this path .cpu and .heap (disabled if empty)")
+    var proffor *time.Duration = flag.Duration("profile_for", 30*time.Second, "How long -profile profiles the CPU for")
+    var tmap *string = flag.String("tenant_map", "", "JSON file mapping schemas/CIDRs to tenants, with optional quotas")
+    var twindow *time.Duration = flag.Duration("tenant_window", 0, "Publish per-tenant usage every this often (0 = off)")
+    var slowms *float64 = flag.Float64("slow_ms", 0, "Queries slower than this many milliseconds are slow and raise an alert (0 = off)")
+    var exmask *string = flag.String("example_mask", "strings", "Masking of literals kept for slow query examples: none, strings or all")
+    var readfile *string = flag.String("r", "", "Read packets from a pcap file instead of sniffing -i")
+    var duration *time.Duration = flag.Duration("duration", 0, "report: stop capturing after this long (0 = until the capture ends); soak: run this long (0 = a minute)")
+    var rformat *string = flag.String("report_format", "text", "report: output format, text or json")
+    var cwindow *time.Duration = flag.Duration("connect_window", 10*time.Second, "Window over which connect rates are measured (0 = off)")
+    var maxcrate *float64 = flag.Float64("max_connect_rate", 0, "Alert when more connections than this a second are opened to the server (0 = off)")
+    var maxccrate *float64 = flag.Float64("max_client_connect_rate", 0, "Alert when a client IP opens more connections than this a second (0 = off)")
+    var swindow *time.Duration = flag.Duration("scan_window", time.Hour, "Window for full scan detection from result sizes (0 = off)")
+    var namesfile *string = flag.String("names", "", "JSON file mapping fingerprints to friendly names")
+    var pwindow *time.Duration = flag.Duration("procedure_window", 0, "Publish per stored procedure/function totals every this often (0 = off)")
+    var litprof *string = flag.String("literal_profile", "", "Comma separated name@position: count values of that literal in the named fingerprint (see -names)")
+    var littop *int = flag.Int("literal_profile_top", 20, "Hottest values kept per -literal_profile entry")
+    var litmask *string = flag.String("literal_profile_mask", "hash", "Masking of profiled literal values: hash or none")
+    var wminterval *time.Duration = flag.Duration("watermark_interval", 10*time.Second, "Publish a watermark with the last event sequence number every this often (0 = off)")
+    var nprocs *int = flag.Int("processes", 0, "Capture with this many worker processes, each taking a share of the connections (0 = capture in this process)")
+    var shard *string = flag.String("shard", "", "Capture only shard i/N of the connections; set by -processes")
+    var maskfile *string = flag.String("mask_rules", "", "JSON masking policy: publish SQL with literals masked by table, column or value instead of canonicalized (see masking.go)")
+    var hookpaths *string = flag.String("hook", "", "Comma separated Lua scripts whose enrich function sees every event before publishing")
+    var dbgproto *bool = flag.Bool("debug-proto", false, "Log capped hex dumps of segments that can't be decoded")
+    var hidemon *bool = flag.Bool("hide_monitoring", false, "Leave monitoring queries (information_schema, SHOW STATUS, ...) out of per-query reports")
+    var nocolor *bool = flag.Bool("no-color", false, "Never use colors, even on a terminal")
+    var sinterval *time.Duration = flag.Duration("interval", 0, "Print and publish the top queries of each interval this long (0 = off)")
+    var stop *int = flag.Int("top", 10, "Queries in each -interval summary")
+    var stopby *string = flag.String("top_by", "count", "Rank -interval summaries by count, time or bytes")
+    var tusage *string = flag.String("table_usage", "", "File remembering when each table was last queried, for the unused report (disabled if empty)")
+    var tuwindow *time.Duration = flag.Duration("table_usage_window", 30*24*time.Hour, "Tables not queried for this long count as unused")
+    var stables *string = flag.String("schema_tables", "", "The server's tables for the unused report, as file:/path or exec:command printing schema.table lines")
+    var detect *bool = flag.Bool("detect-protocol", false, "Find MySQL servers on any port -bpf lets through by their greetings, not just -P")
+    var nworkers *int = flag.Int("workers", 0, "Decode on this many goroutines fed by the capture loop, each owning a share of the connections; the protocol state machine and publishing still run one at a time (0 = decode in the capture loop)")
+    var nhistory *int = flag.Int("history", 16, "Statements remembered per connection for GET /history (0 = none)")
+    var pxport *int = flag.Int("proxysql_port", 0, "On a ProxySQL host, also capture this frontend port and publish the latency the proxy adds to each statement (0 = off)")
+    var sinterval2 *time.Duration = flag.Duration("stats_interval", 0, "Log packets seen and dropped, desyncs, streams, fingerprints and queued events every this often (0 = off)")
+    var capkind *string = flag.String("capture", "libpcap", "Live capture backend: libpcap, or afpacket for Linux's zero-copy TPACKET_V3 ring")
+    var snames *string = flag.String("schema_names", "keep", "Schema-qualified tables in fingerprints: keep them as sent, strip the schema, or qualify unqualified ones with the current schema")
+    var awindow *time.Duration = flag.Duration("access_window", 0, "Alert when a table's reads and writes change a lot from one window this long to the next (0 = off)")
+    var achange *float64 = flag.Float64("access_change", 5, "Change in a table's statement rate, as a factor, that -access_window alerts on")
+    var apoffset *int = flag.Int("antipattern_offset", 10000, "LIMIT/OFFSET offsets from this on are reported as the large_offset anti-pattern")
+    var cinterval *time.Duration = flag.Duration("capacity_interval", 0, "Publish a capacity report with load trends every this often, e.g. 168h (0 = off)")
+    var cqps *float64 = flag.Float64("capacity_qps", 0, "QPS the server can take, for projecting when -capacity_interval trends reach it (0 = don't project)")
+    var dinterval *time.Duration = flag.Duration("discover", 0, "Also capture the ports local -discover_comm processes listen on, looking again this often (0 = off)")
+    var dcomm *string = flag.String("discover_comm", "mysqld,mariadbd", "Comma-separated process names -discover looks for")
+    var dsock *string = flag.String("docker", "", "Docker socket, e.g. /var/run/docker.sock, for naming the containers in query events (disabled if empty)")
+    var dkinterval *time.Duration = flag.Duration("docker_interval", 30*time.Second, "How often -docker lists the containers")
+    var ctrack *bool = flag.Bool("conntrack", false, "Attribute NATed connections to the original client from "+CONNTRACK_FILE)
+    var srate *float64 = flag.Float64("sample", 1, "Publish this fraction of query events, e.g. 0.1; slow queries and errors always go out")
+    var mqps *int = flag.Int("max_qps", 0, "Publish at most this many query events a second (0 = no limit)")
+    var bwindow *time.Duration = flag.Duration("bandwidth_window", 0, "Publish bytes per second each way, overall and per client, every this often (0 = off)")
+    var bpf *string = flag.String("bpf", "", "BPF expression narrowing the capture further, e.g. \"net 10.0.0.0/24 and not host 10.0.0.9\"")
+    var sconns *int = flag.Int("soak_conns", 10000, "soak: concurrent synthetic connections")
+    var smaxmb *int = flag.Int("soak_max_mb", 0, "soak: fail if the heap grows past this many megabytes (0 = don't check)")
+    var connevents *bool = flag.Bool("connection_events", false, "Publish an event when each connection opens and closes")
+    var idlettl *time.Duration = flag.Duration("idle_timeout", time.Hour, "Forget connections with no packets for this long (0 = never)")
+    var maxq *int = flag.Int("max_queries", 0, "Most fingerprints kept; the least recently seen go first (0 = unlimited)")
+    var cfgfile *string = flag.String("config", "", "File of name = value flag settings (TOML or YAML style); SIGHUP applies changes to -P, sampling, filters and sinks")
+    var validate *bool = flag.Bool("validate", false, "Check the configuration, print it as JSON and exit (same as the check command)")
+
+    // An optional command may come before the flags.
+    command := ""
+    args := os.Args[1:]
+    if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+        command, args = args[0], args[1:]
+    }
+    flag.CommandLine.Parse(args)
+    configFile = *cfgfile
+    if configFile != "" {
+        if err := applyConfig(configFile); err != nil && command != "check" {
+            log.Fatalf("Bad -config %s: %s", configFile, err.Error())
+        }
+    }
+    if *validate {
+        command = "check"
+    }
+    reportKinds := flag.Args()
+    if command == "names" {
+        command, reportKinds = "report", []string{"names"}
+    }
     
     verbose = *doverbose
     noclean = *nocleanquery
+    requestOnly = *reqonly
+    maxStreams = *maxstr
+    maxStreamsPerIP = *maxstrip
     port = uint16(*lport)
     dirty = *ldirty
     service_id = *sid
     tenant_id = *tid
     topic = *tpc
     zmqaddr = *zad
+    subaddr = *sad
+    sinksFile = *sinksfile
+    tenantWindow = *twindow
+    slowThreshold = time.Duration(*slowms * float64(time.Millisecond))
+    exampleMask = *exmask
+    reportFormat = *rformat
+    scanWindow = *swindow
+    connectWindow, maxConnectRate, maxClientConnectRate = *cwindow, *maxcrate, *maxccrate
+    procedureWindow = *pwindow
+    watermarkInterval = *wminterval
+    debugProto = *dbgproto
+    hideMonitoring = *hidemon
+    literalProfileTop = *littop
+    literalProfileMask = *litmask
+    summaryInterval = *sinterval
+    summaryTop = *stop
+    summaryBy = *stopby
+    tableUsageFile = *tusage
+    tableUsageWindow = *tuwindow
+    schemaTables = *stables
+    schemaNames = *snames
+    detectProtocol = *detect
+    captureKind = *capkind
+    decoderCount = *nworkers
+    historySize = *nhistory
+    statsInterval = *sinterval2
+    if *pxport > 0 {
+        proxyPort = uint16(*pxport)
+        discovered[proxyPort] = true
+    }
+    accessWindow = *awindow
+    accessChange = *achange
+    antiPatternOffset = *apoffset
+    capacityInterval = *cinterval
+    capacityQPS = *cqps
+    bandwidthWindow = *bwindow
+    userFilter = *bpf
+    soakConns = *sconns
+    connectionEvents = *connevents
+    idleTimeout = *idlettl
+    maxQueries = *maxq
+    soakMaxMB = *smaxmb
+    discoverInterval = *dinterval
+    dockerSocket = *dsock
+    useConntrack = *ctrack
+    traceStages = *tstages
+    sampleRate = *srate
+    maxQPS = *mqps
+    dockerInterval = *dkinterval
+    for _, comm := range strings.Split(*dcomm, ",") {
+        if comm = strings.TrimSpace(comm); comm != "" {
+            discoverComms[comm] = true
+        }
+    }
+    if exampleMask != "none" && exampleMask != "strings" && exampleMask != "all" {
+        log.Fatalf("Unknown -example_mask %s", exampleMask)
+    }
+    if err := validTopBy(summaryBy); err != nil && command != "check" {
+        log.Fatal(err)
+    }
+    if err := validSampleRate(sampleRate); err != nil && command != "check" {
+        log.Fatal(err)
+    }
+    if err := validSchemaNames(schemaNames); err != nil && command != "check" {
+        log.Fatal(err)
+    }
+    if err := validCapture(captureKind); err != nil && command != "check" {
+        log.Fatal(err)
+    }
     if topic==""{
         topic = "cep.mysql.sniff."+tenant_id
     }
     
-    parseFormat(*formatstr)
+    var err error
+    if format, err = parseFormat(*formatstr); err != nil && command != "check" {
+        log.Fatalf("Bad -f format: %s", err.Error())
+    }
+    sourceField = sourceFieldOf(format)
+    if err := parseShard(*shard); err != nil {
+        log.Fatalf("Bad -shard: %s", err.Error())
+    }
+    if err := parseLiteralProfiles(*litprof); err != nil && command != "check" {
+        log.Fatalf("Bad -literal_profile: %s", err.Error())
+    }
     
-    rand.Seed(time.Now().UnixNano())
-
     log.SetPrefix("")
     log.SetFlags(0)
+    setupColor(*nocolor)
+
+    switch command {
+    case "":
+    case "check":
+        if !runCheck(*eth) {
+            os.Exit(1)
+        }
+        return
+    case "selftest", "report", "soak":
+    case "subscribe":
+        runSubscribe(*smatch, *soperate, *smin, *verify)
+        return
+    case "purge":
+        runPurge(*control, reportKinds)
+        return
+    default:
+        log.Fatalf("Unknown command %s", command)
+    }
     
-    tem_puber, _ := zmq.NewSocket(zmq.PUB)
-    puber = tem_puber
-    puber.Connect(zmqaddr)
-    
-    log.Printf("Initializing zeromq address %s", zmqaddr)
+    if *nprocs > 0 && command == "" {
+        runWorkers(*nprocs, *control)
+    }
 
-    log.Printf("Initializing MySQL sniffing on %s:%d", *eth, port)
-    iface, err := pcap.Openlive(*eth, 1024, false, 0)
-    if iface == nil || err != nil {
-        msg := "unknown error"
-        if err != nil {
-            msg = err.Error()
+    if err := loadHooks(*hookpaths); err != nil {
+        log.Fatalf("Failed to load hook: %s", err.Error())
+    }
+
+    if command == "report" || command == "soak" {
+        // Reports are local; nothing is published. soak discards its events.
+    } else if sinksFile != "" {
+        if err := loadSinksFile(sinksFile); err != nil {
+            log.Fatalf("Failed to set up sinks: %s", err.Error())
         }
-        log.Fatalf("Failed to open device: %s", msg)
+    } else {
+        specs := flagSinks()
+        if zmqaddr != "" {
+            log.Printf("Initializing zeromq address %s", zmqaddr)
+        }
+        if err := replaceSinks(specs); err != nil {
+            log.Fatalf("Failed to set up sinks: %s", err.Error())
+        }
+    }
+    go watchSignals()
+    go watchShutdown()
+    if *namesfile != "" {
+        if err := loadNames(*namesfile); err != nil {
+            log.Fatalf("Failed to load names: %s", err.Error())
+        }
+    }
+    if *maskfile != "" {
+        if err := loadMaskRules(*maskfile); err != nil {
+            log.Fatalf("Failed to load masking rules: %s", err.Error())
+        }
+    }
+    if *tmap != "" {
+        if err := loadTenantMap(*tmap); err != nil {
+            log.Fatalf("Failed to load tenant map: %s", err.Error())
+        }
+    }
+    if tenantWindow > 0 {
+        go runTenantAccounting()
+    }
+    if scanWindow > 0 && command != "report" {
+        go runScanDetection()
+    }
+    if connectWindow > 0 && command != "report" {
+        go runConnectRates()
+    }
+    initInstance()
+    if watermarkInterval > 0 && command != "report" {
+        go runWatermarks()
+    }
+    if procedureWindow > 0 && command != "report" {
+        go runRoutineAccounting()
+    }
+    if summaryInterval > 0 && command != "report" {
+        go runSummaries()
+    }
+    if capacityInterval > 0 && command != "report" {
+        go runCapacityReports()
+    }
+    if dockerSocket != "" && command != "report" {
+        go runContainerMapping()
+    }
+    if command == "" {
+        go runJanitor()
+    }
+    if statsInterval > 0 && command == "" {
+        go runStatsLog()
+    }
+    if bandwidthWindow > 0 && command != "report" {
+        go runBandwidthAccounting()
+    }
+    if accessWindow > 0 && command != "report" {
+        go runAccessChecks()
+    }
+    if tableUsageFile != "" {
+        if err := loadTableUsage(tableUsageFile); err != nil {
+            log.Fatalf("Failed to load table usage: %s", err.Error())
+        }
+        if command != "report" {
+            go runTableUsage()
+        }
+    }
+    tuneGC(*gogc, *ballastmb)
+    if *pprofaddr != "" {
+        startPprof(*pprofaddr)
+    }
+    if *profpath != "" {
+        if err := startProfile(*profpath, *proffor); err != nil {
+            log.Fatalf("Failed to start profiling: %s", err.Error())
+        }
+    }
+    if *control != "" {
+        startControl(*control)
     }
 
-    err = iface.Setfilter(fmt.Sprintf("tcp port %d", port))
+    if command == "soak" {
+        if !runSoak(*duration) {
+            os.Exit(1)
+        }
+        return
+    }
+    if command == "selftest" {
+        if !runSelftest() {
+            os.Exit(1)
+        }
+        return
+    }
+
+    var source CaptureSource
+    if *readfile != "" {
+        log.Printf("Reading MySQL traffic on port %d from %s", port, *readfile)
+        source, err = openCaptureFile(*readfile)
+    } else {
+        log.Printf("Initializing MySQL sniffing on %s:%d with %s", *eth, port, captureKind)
+        measureLatency = true
+        source, err = openCapture(*eth)
+    }
+    if err != nil {
+        log.Fatalf("Failed to open device: %s", err)
+    }
+
+    if discoverInterval > 0 && *readfile == "" {
+        discover()
+        if command != "report" {
+            go runDiscovery()
+        }
+    }
+    err = source.SetFilter(captureFilter())
     if err != nil {
         log.Fatalf("Failed to set port filter: %s", err.Error())
     }
-    
-    var pkt *pcap.Packet = nil
-    var rv int32 = 0
 
-    for rv = 0; rv >= 0; {
-        for pkt, rv = iface.NextEx(); pkt != nil; pkt, rv = iface.NextEx() {
-            handlePacket(pkt)
+    if command == "report" {
+        runReport(source, *duration, reportKinds)
+        stopProfile()
+        return
+    }
+    
+    // The packet is borrowed from the capture and reused; see handlePacket.
+    var pkt capturedPacket
+    if decoderCount > 0 {
+        decoders = startDecoders(decoderCount, *readfile == "")
+    }
+    for n := 0; !stopping(); n++ {
+        pollCaptureStats(source)
+        if err := source.Next(&pkt); err != nil {
+            if err != io.EOF {
+                log.Printf("Capture failed: %s", err)
+            }
+            break
+        }
+        if pkt.Data == nil {
+            refilter(source)
+            continue
+        }
+        if decoders != nil {
+            decoders.dispatch(&pkt)
+            if n%DECODER_REFILTER == 0 {
+                refilter(source)
+            }
+            continue
+        }
+        stateLock.Lock()
+        if traceStages && *readfile == "" {
+            noteStage(STAGE_CAPTURE, time.Since(pkt.Time))
         }
+        handlePacket(&pkt)
+        stale := filterStale
+        stateLock.Unlock()
+        if stale {
+            refilter(source)
+        }
+    }
+    if decoders != nil {
+        decoders.stop()
     }
+    shutdown(source)
 }
 
 // Do something with a packet for a source.
@@ -191,6 +679,25 @@ func processPacket(src string, rs *source, request bool, data []byte) {
     if rs.synced {
         stats.packets.rcvd_sync++
     }
+    rs.bytes += uint64(len(data))
+    rs.lastSeen = pktTime
+    rs.handshake = false
+
+    // Without responses there's nothing to match against; don't let them
+    // disturb the request buffers either.
+    if rs.tls || (!rs.synced && noteTLS(rs, request, data)) {
+        return
+    }
+    if requestOnly && !request {
+        return
+    }
+
+    if rs.compressed {
+        var ok bool
+        if data, ok = inflateFrames(rs, request, data); !ok {
+            return
+        }
+    }
 
     var ptype int = -1
     var pdata []byte
@@ -203,13 +710,27 @@ func processPacket(src string, rs *source, request bool, data []byte) {
         }
         rs.reqbuffer = data
         ptype, pdata = carvePacket(&rs.reqbuffer)
+        if rs.reqbuffer != nil {
+            // What's left of the segment outlives the packet it's borrowed from.
+            rs.reqbuffer = append([]byte(nil), rs.reqbuffer...)
+        }
     } else {
         rs.resbuffer = nil
         ptype, pdata = 0, data
     }
 
     if !rs.synced {
-        if !(request && ptype == COM_QUERY) {
+        if id, ok := greetingThreadId(data); ok && !request {
+            rs.threadId = id
+        }
+        if user, db, ok := loginUser(data); ok && request {
+            noteLogin(rs, user, db)
+            rs.compress = loginCompression(data)
+        }
+        if !request {
+            noteCompression(rs, data)
+        }
+        if !(request && syncCommand(ptype, pdata)) {
             rs.reqbuffer, rs.resbuffer = nil, nil
             return
         }
@@ -217,92 +738,161 @@ func processPacket(src string, rs *source, request bool, data []byte) {
     }
     
     if ptype == -1 {
+        debugDump(src+" -> server", 0, "incomplete request packet", data)
         return
     }
     plen := uint64(len(pdata))
 
     var reqtime uint64
     if !request {
+        rs.resbytes += plen
         if rs.reqSent == nil {
             if rs.qdata != nil {
                 rs.qdata.bytes += plen
             }
+            if rs.sdata != nil {
+                rs.sdata.bytes += plen
+            }
+            accountTenant(rs.tenant, 0, plen, 0)
+            if rs.pending {
+                noteResponseSegment(rs)
+                if rs.resp.feed(rs, pdata) {
+                    finishResponse(rs)
+                }
+            }
             return
         }
-        reqtime = uint64(time.Since(*rs.reqSent).Nanoseconds())
+        reqtime = uint64(pktTime.Sub(*rs.reqSent).Nanoseconds())
+        rs.unanswered = 0
+        accountTenant(rs.tenant, 0, plen, reqtime)
+        noteTxResponse(rs, reqtime)
+        if slowThreshold > 0 && time.Duration(reqtime) >= slowThreshold {
+            query := rs.query
+            if rs.shown != "" {
+                query = rs.shown
+            }
+            examples.offer(rs.qtext, query, rs.literals, time.Duration(reqtime), rs.src)
+        }
 
-        randn := rand.Intn(TIME_BUCKETS)
-        rs.reqTimes[randn] = reqtime
-        times[randn] = reqtime
+        if rs.sdata != nil {
+            rs.sdata.bytes += plen
+            rs.sdata.timed++
+            rs.sdata.timeTotal += reqtime
+        }
         if rs.qdata != nil {
-            rs.qdata.times[randn] = reqtime
+            rs.qdata.timed++
+            rs.qdata.timeTotal += reqtime
+            noteAttribution(rs.qdata, rs, reqtime)
+            rs.qdata.times.observe(reqtime)
             rs.qdata.bytes += plen
+            rs.qdata.hours[pktTime.Hour()][heatBucket(time.Duration(reqtime))]++
         }
         rs.reqSent = nil
-        if len(rs.qtext) > 0 {
-            sql := strings.ToLower(rs.qtext)                    
-            if strings.Index(sql,"select")>=0 || strings.Index(sql,"update")>=0 || strings.Index(sql,"insert")>=0 || strings.Index(sql,"delete")>=0 || strings.Index(sql,"truncate")>=0 {
-                temsqls := strings.Split(rs.qtext,":")
-                sql = temsqls[2]
-                datas := make(map[string]interface{})
-                datas["service_id"]=service_id
-                datas["tenant_id"]=tenant_id
-                datas["sql"]=sql
-                datas["time"]=float64(reqtime)/1000
-                datas["size"]=rs.qbytes
-                datas["operate"]=strings.ToLower(strings.Split(sql," ")[0])
-                jsonString, _ := json.Marshal(datas)
-                jsonm :=string(jsonString)
-                jsonm = "APPS sniff "+jsonm
-                if verbose{
-                    log.Printf(topic+"="+jsonm)
-                }               
-                puber.Send(topic, zmq.SNDMORE)
-                puber.Send(jsonm, zmq.DONTWAIT)
-                rs.qdata = nil
-                rs=nil
-                delete(chmap,src)
-                stats.streams--
-                //fmt.Println(len(chmap))
-            }
+        startResponse(rs, reqtime)
+        if rs.resp.feed(rs, pdata) {
+            finishResponse(rs)
         }
         return
     }
-    tnow := time.Now()
-    rs.reqSent = &tnow
+    rs.command = ptype
+    noteCommand(ptype)
+    if ptype == COM_QUIT {
+        closeStream(src)
+        return
+    }
+    if ptype == COM_RESET_CONNECTION || ptype == COM_CHANGE_USER {
+        resetSession(rs)
+        if user, db, ok := changeUser(pdata); ok && ptype == COM_CHANGE_USER {
+            noteLogin(rs, user, db)
+        }
+        return
+    }
+    if !requestOnly {
+        if rs.reqSent != nil {
+            noteUnanswered(rs)
+        }
+        tnow := pktTime
+        rs.reqSent = &tnow
+    }
 
+    finishResponse(rs)
+    var prepared []byte
+    switch ptype {
+    case COM_STMT_PREPARE:
+        // Only executions count; the statement is kept for them.
+        noteTxRequest(rs, ptype, pdata)
+        notePrepare(rs, pdata)
+        rs.qdata, rs.qtext, rs.query, rs.sdata = nil, "", "", nil
+        return
+    case COM_STMT_CLOSE, COM_STMT_SEND_LONG_DATA:
+        // No response.
+        if ptype == COM_STMT_CLOSE {
+            closeStatement(rs, pdata)
+        } else {
+            noteLongData(rs, pdata)
+        }
+        rs.reqSent = nil
+        return
+    case COM_STMT_EXECUTE:
+        var sql []byte
+        var ok bool
+        if sql, prepared, ok = expandExecute(rs, pdata); !ok {
+            noteTxRequest(rs, ptype, pdata)
+            rs.qdata, rs.qtext, rs.query, rs.sdata = nil, "", "", nil
+            return
+        }
+        // From here on it's the same as sending the SQL as text.
+        pdata = sql
+        noteTxRequest(rs, COM_QUERY, pdata)
+    case COM_STMT_RESET:
+        resetStatement(rs, pdata)
+        noteTxRequest(rs, ptype, pdata)
+    default:
+        noteTxRequest(rs, ptype, pdata)
+    }
     querycount++
+    rs.queries++
+    accountTenant(rs.tenant, 1, plen, 0)
+    decoded := decodedFor(rs, ptype, pdata)
+    var query string
+    switch {
+    case dirty:
+        query = string(pdata)
+    case decoded != nil:
+        query = decoded.query
+    case prepared != nil:
+        // A NULL parameter would otherwise change the fingerprint.
+        query = normalizeSchemas(cleanupQuery(prepared), rs.db)
+    default:
+        query = normalizeSchemas(cleanupQuery(pdata), rs.db)
+    }
     var text string
     for _, item := range format {
         switch item.(type) {
-        case int:
-            switch item.(int) {
-            case F_NONE:
-                log.Fatalf("F_NONE in format string")
+        case formatField:
+            var value string
+            switch item.(formatField).kind {
             case F_QUERY:
-                if dirty {
-                    text += string(pdata)
-                } else {
-                    text += cleanupQuery(pdata)
-                }
+                value = query
             case F_ROUTE:
                 parts := strings.SplitN(string(pdata), " ", 5)
                 if len(parts) >= 4 && parts[1] == "/*" && parts[3] == "*/" {
                     if strings.Contains(parts[2], ":") {
-                        text += strings.SplitN(parts[2], ":", 2)[1]
+                        value = strings.SplitN(parts[2], ":", 2)[1]
                     } else {
-                        text += parts[2]
+                        value = parts[2]
                     }
                 } else {
-                    text += "(unknown) " + cleanupQuery(pdata)
+                    value = "(unknown) " + cleanupQuery(pdata)
                 }
             case F_SOURCE:
-                text += rs.src
+                value = rs.src
             case F_SOURCEIP:
-                text += rs.srcip
+                value = rs.srcip
             default:
                 log.Fatalf("Unknown F_XXXXXX int in format string")
             }
+            text += item.(formatField).truncate(value)
         case string:
             text += item.(string)
         default:
@@ -311,12 +901,188 @@ func processPacket(src string, rs *source, request bool, data []byte) {
     }
     qdata, ok := qbuf[text]
     if !ok {
-        qdata = &queryData{}
+        evictQueries()
+        canonical := query
+        if dirty {
+            canonical = normalizeSchemas(cleanupQuery(pdata), rs.db)
+        }
+        qdata = &queryData{sql: query, class: classifyQuery(query),
+            complexity: measureComplexity(canonical), joins: joinEdges(canonical),
+            tables: tablesIn(canonical), patterns: fingerprintPatterns(canonical),
+            paginated: hasWord("limit")(queryWords([]byte(canonical)))}
         qbuf[text] = qdata
     }
     qdata.count++
     qdata.bytes += plen
-    rs.qtext, rs.qdata, rs.qbytes = text, qdata, plen
+    qdata.lastSeen = pktTime
+    noteArrival(qdata, pktTime)
+    attributionFor(qdata, rs).count++
+    noteTableUsage(rs, qdata)
+    noteTableAccess(rs, qdata)
+    if sourceField != F_NONE {
+        noteSource(rs, text, plen)
+    }
+    rs.qtext, rs.qdata, rs.qbytes, rs.query, rs.raw = text, qdata, plen, query, string(pdata)
+    if decoded != nil {
+        rs.indexHints, rs.optHints = decoded.indexHints, decoded.optHints
+    } else {
+        rs.indexHints, rs.optHints = extractHints(pdata)
+    }
+    noteHints(qdata, rs.indexHints, rs.optHints)
+    var words []string
+    if qdata.paginated || needsWords(qdata) {
+        words = queryWords(pdata)
+    }
+    rs.violations = noteAntiPatterns(qdata, words)
+    rs.offset = 0
+    if qdata.paginated {
+        rs.offset, _ = noteOffset(qdata, words)
+    }
+    noteLiterals(text, pdata)
+    if maskRules != nil {
+        rs.shown, rs.literals = maskQuery(pdata)
+    } else if slowThreshold > 0 && !dirty {
+        rs.literals = extractLiterals(pdata)
+    }
+
+    if requestOnly {
+        noteHistory(rs, false)
+        publishQuery(src, rs, 0, false)
+    }
+}
+
+// publishQuery sends the query last seen on a stream to the sinks. When timed
+// is false we never saw the response, so
+// the record says latency is unavailable instead of carrying a time.
+// Otherwise the response timing on the stream is complete.
+func publishQuery(src string, rs *source, reqtime uint64, timed bool) {
+    if len(rs.query) == 0 {
+        return
+    }
+    sql := strings.ToLower(rs.query)
+    if strings.Index(sql, "select") < 0 && strings.Index(sql, "update") < 0 &&
+        strings.Index(sql, "insert") < 0 && strings.Index(sql, "delete") < 0 &&
+        strings.Index(sql, "truncate") < 0 && strings.Index(sql, "call") < 0 {
+        return
+    }
+
+    ev := &event.QueryEvent{
+        Type:      event.TYPE_QUERY,
+        ServiceId: service_id,
+        TenantId:  rs.tenant,
+        Schema:    rs.db,
+        Name:      nameOf(rs.qtext),
+        Class:     classifyQuery(rs.query),
+        Sql:       rs.query,
+        Size:      rs.qbytes,
+        Operate:   strings.ToLower(strings.SplitN(rs.query, " ", 2)[0]),
+
+        IndexHints:     rs.indexHints,
+        OptimizerHints: rs.optHints,
+        ThreadId:       rs.threadId,
+        AntiPatterns:   rs.violations,
+        Offset:         rs.offset,
+        User:           rs.user,
+        Db:             rs.loginDb,
+    }
+    if rs.qdata != nil {
+        c := rs.qdata.complexity
+        ev.QueryLength, ev.Joins, ev.SubqueryDepth = c.length, c.joins, c.depth
+        ev.Predicates, ev.OrderBy, ev.GroupBy = c.predicates, c.orderBy, c.groupBy
+    }
+    if rs.shown != "" {
+        ev.Sql = rs.shown
+    }
+    if c, ok := containerOf(rs.src); ok {
+        ev.ClientContainer, ev.ClientImage = c.name, c.image
+    }
+    if c, ok := containerOf(rs.server); ok {
+        ev.ServerContainer, ev.ServerImage = c.name, c.image
+    }
+    if timed {
+        t := float64(reqtime) / 1000
+        ev.Time = &t
+        total := t + float64(rs.timing.lastByte.Sub(rs.timing.firstByte).Nanoseconds())/1000
+        ev.Total = &total
+        ev.SlowSide = slowSide(rs)
+        if len(rs.resp.sets) > 1 {
+            ev.ResultRows = rs.resp.sets
+        }
+        if rows, ok := rs.resp.rowsReturned(); ok {
+            ev.RowsReturned = &rows
+        }
+        if rs.resp.oks > 0 {
+            affected := rs.resp.affected
+            ev.RowsAffected, ev.LastInsertId = &affected, rs.resp.insertId
+        }
+        if e := rs.resp.err; e != nil {
+            ev.ErrorCode, ev.SqlState, ev.Error = e.code, e.state, maskMessage(e.message)
+        }
+        if slowThreshold > 0 && time.Duration(reqtime) >= slowThreshold {
+            ev.Slow = true
+            alertSlow(rs, reqtime)
+        }
+        noteProxy(rs, ev)
+    } else {
+        available := false
+        ev.LatencyAvailable = &available
+    }
+    if !keepSample(ev) {
+        return
+    }
+    views := &queryViews{raw: rs.raw, shown: rs.shown, query: rs.query}
+    if rs.resp.err != nil {
+        views.message = rs.resp.err.message
+    }
+    publishViews(ev, views)
+}
+
+// publish encodes an event and hands it to the sinks.
+func publish(ev interface{}) {
+    publishViews(ev, nil)
+}
+
+// publishViews publishes an event which, given views, sinks may see more or
+// less of (see redact.go).
+func publishViews(ev interface{}, views *queryViews) {
+    defer stageEnd(STAGE_PUBLISH, stageStart())
+    stampEvent(ev)
+    var extra map[string]interface{}
+    if len(hooks) > 0 {
+        var keep bool
+        before := ev
+        if ev, extra, keep = runHooks(ev); !keep {
+            stats.hook_dropped++
+            return
+        }
+        views = hookedViews(before, ev, views)
+    }
+    jsonm, err := encodeEvent(ev, extra)
+    if err != nil {
+        log.Printf("Failed to encode event: %s", err)
+        return
+    }
+    if verbose {
+        // Someone watching a terminal gets a readable line instead of JSON.
+        if q, ok := ev.(*event.QueryEvent); ok && colorStderr {
+            latency := "-"
+            if d, ok := q.Latency(); ok {
+                latency = d.String()
+            }
+            log.Printf("%s%10s%s %s", COLOR_WHITE, latency, COLOR_DEFAULT,
+                highlightSQL(q.Sql, true))
+        } else {
+            log.Printf(topic + "=" + jsonm)
+        }
+    }
+    payloads := map[string]string{"": jsonm}
+    sendAll(topic, func(level string) string {
+        return payloadFor(ev, extra, views, level, payloads)
+    })
+    if _, ok := ev.(*event.QueryEvent); ok {
+        stats.queries_out++
+        notePublished()
+    }
 }
 
 func carvePacket(buf *[]byte) (int, []byte) {
@@ -341,49 +1107,280 @@ func carvePacket(buf *[]byte) (int, []byte) {
     return ptype, data
 }
 
-func handlePacket(pkt *pcap.Packet) {
-    var pos byte = 14
-    srcIP := pkt.Data[pos+12 : pos+16]
-    dstIP := pkt.Data[pos+16 : pos+20]
+// handlePacket decodes a captured packet in place. pkt.Data is only
+// borrowed for the call: the few things that must outlive it (a partial
+// MySQL packet, a statement being prepared) are copied, everything else is
+// read from it directly or turned into strings.
+func handlePacket(pkt *capturedPacket) {
+    pktTime = pkt.Time
+    started := stageStart()
+    f, ok := parseFrame(pkt)
+    if !ok {
+        if f.ignored {
+            stats.packets.ignored++
+        }
+        return
+    }
+    handleFrame(&f, started)
+}
 
-    pos += pkt.Data[pos] & 0x0F * 4
+// A tcpFrame is a captured TCP segment with its headers read.
+type tcpFrame struct {
+    srcaddr, dstaddr string
+    srcPort, dstPort uint16
+    seq              uint32
+    flags            byte
+    window           uint16
+    payload          []byte
+    len              uint32 // on the wire
+    ignored          bool   // not IP, or IPv6 we can't follow to TCP
+}
 
-    srcPort := uint16(pkt.Data[pos])<<8 + uint16(pkt.Data[pos+1])
-    dstPort := uint16(pkt.Data[pos+2])<<8 + uint16(pkt.Data[pos+3])
+// parseFrame reads the headers of a TCP segment. It touches no state, so
+// workers call it without stateLock. It returns false for anything that
+// isn't a segment worth handling.
+func parseFrame(pkt *capturedPacket) (f tcpFrame, ok bool) {
+    data := pkt.Data
 
-    pos += byte(pkt.Data[pos+12]) >> 4 * 4
+    // Walk past the ethernet header and any 802.1Q/802.1ad tags, which are
+    // common on mirror ports.
+    pos := 12
+    var etype uint16
+    for {
+        if len(data) < pos+2 {
+            return f, false
+        }
+        etype = uint16(data[pos])<<8 + uint16(data[pos+1])
+        pos += 2
+        if etype == 0x8100 || etype == 0x88a8 {
+            pos += 2
+            continue
+        }
+        if etype != 0x0800 && etype != 0x86dd {
+            f.ignored = true
+            return f, false
+        }
+        break
+    }
+
+    var srcIP, dstIP []byte
+    if etype == 0x0800 {
+        if len(data) < pos+20 {
+            return f, false
+        }
+        srcIP = data[pos+12 : pos+16]
+        dstIP = data[pos+16 : pos+20]
+        pos += int(data[pos]&0x0F) * 4
+    } else {
+        ip6 := pos
+        var ok bool
+        if pos, ok = ipv6Payload(data, pos); !ok {
+            f.ignored = true
+            return f, false
+        }
+        srcIP = data[ip6+8 : ip6+24]
+        dstIP = data[ip6+24 : ip6+40]
+    }
+    if len(data) < pos+20 {
+        return f, false
+    }
+
+    f.srcPort = uint16(data[pos])<<8 + uint16(data[pos+1])
+    f.dstPort = uint16(data[pos+2])<<8 + uint16(data[pos+3])
+
+    f.seq = uint32(data[pos+4])<<24 | uint32(data[pos+5])<<16 |
+        uint32(data[pos+6])<<8 | uint32(data[pos+7])
+    f.flags = data[pos+13]
+    f.window = uint16(data[pos+14])<<8 | uint16(data[pos+15])
+    pos += int(data[pos+12]>>4) * 4
 
-    if len(pkt.Data[pos:]) <= 0 {
+    if pos > len(data) {
+        pos = len(data)
+    }
+    if len(data) == pos && f.flags&(TCP_SYN|TCP_FIN|TCP_RST|TCP_ACK) == 0 {
+        return f, false
+    }
+
+    f.srcaddr = endpointAddr(srcIP, f.srcPort)
+    f.dstaddr = endpointAddr(dstIP, f.dstPort)
+    f.payload = data[pos:]
+    f.len = pkt.Len
+    return f, true
+}
+
+// handleFrame decodes a segment parseFrame read; reassembly started at
+// started.
+func handleFrame(f *tcpFrame, started time.Time) {
+    srcaddr, srcPort, dstaddr, dstPort := f.srcaddr, f.srcPort, f.dstaddr, f.dstPort
+    flags, payload := f.flags, f.payload
+    pktSeq = f.seq
+    noteBandwidth(srcaddr, srcPort, dstaddr, dstPort, f.len)
+
+    if detectProtocol && !knownServer(srcaddr, srcPort, dstaddr, dstPort) {
+        // Nothing's known of these ends yet; only a greeting will do.
+        if flags&TCP_SYN != 0 || len(payload) == 0 || !detectServer(srcaddr, dstaddr, payload) {
+            return
+        }
+    }
+    if flags&TCP_SYN != 0 {
+        if flags&TCP_ACK == 0 {
+            stats.connects++
+            noteConnect(srcaddr)
+            newSession(srcaddr, dstaddr)
+        } else {
+            newSession(dstaddr, srcaddr)
+        }
+        return
+    }
+    if len(payload) == 0 && flags&(TCP_FIN|TCP_RST) == 0 {
+        // A bare ACK. The client's ACKs pace the server's sending.
+        if rs, ok := pktShard.streams[srcaddr]; ok {
+            noteClientAck(rs, f.window)
+        }
+        return
+    }
+    if len(payload) == 0 {
+        // A bare FIN or RST; either end may send it.
+        if _, ok := pktShard.streams[srcaddr]; ok {
+            closeStream(srcaddr)
+        } else if _, ok := pktShard.streams[dstaddr]; ok {
+            closeStream(dstaddr)
+        }
+        return
+    }
+
+    request, ok := classifyDirection(srcaddr, srcPort, dstaddr, dstPort, payload)
+    if !ok {
+        stats.unclassified++
+        debugDump(srcaddr+" -> "+dstaddr, 0, "direction unknown", payload)
         return
     }
 
     var src string
-    var request bool = false
-    if srcPort == port {
-        src = fmt.Sprintf("%d.%d.%d.%d:%d", dstIP[0], dstIP[1], dstIP[2],
-            dstIP[3], dstPort)
-    } else if dstPort == port {
-        src = fmt.Sprintf("%d.%d.%d.%d:%d", srcIP[0], srcIP[1], srcIP[2],
-            srcIP[3], srcPort)
-        request = true
+    if request {
+        src = srcaddr
     } else {
-        log.Fatalf("got packet src = %d, dst = %d", srcPort, dstPort)
+        src = dstaddr
     }
 
-    rs, ok := chmap[src]
+    rs, ok := pktShard.streams[src]
     if !ok {
-        srcip := src[0:strings.Index(src, ":")]
-        rs = &source{src: src, srcip: srcip, synced: false}
+        if rs = newSource(src); rs == nil {
+            return
+        }
+    }
+
+    if request {
+        rs.server = dstaddr
+    } else {
+        rs.server = srcaddr
+    }
+    stageEnd(STAGE_REASSEMBLY, started)
+    started = stageStart()
+    processPacket(src, rs, request, payload)
+    stageEnd(STAGE_DECODE, started)
+    if flags&(TCP_FIN|TCP_RST) != 0 {
+        closeStream(src)
+    }
+}
+
+// closeStream is called when a connection of the packet's shard goes away.
+func closeStream(src string) {
+    if rs, ok := pktShard.streams[src]; ok {
+        endStream(rs)
+    }
+}
+
+// endStream publishes what's left of a stream and stops tracking it.
+func endStream(rs *source) {
+    finishResponse(rs)
+    publishConnection(rs, "close")
+    dropSource(rs)
+}
+
+// newSource starts tracking a client ip:port under a new generation, in the
+// packet's shard. It returns nil if that would go over -max-streams or the
+// per-IP cap, in which case the connection is ignored.
+func newSource(src string) *source {
+    sh := pktShard
+    srcip := clientIP(src)
+    if _, ok := sh.streams[src]; !ok {
+        if (maxStreams > 0 && streamCount() >= maxStreams) ||
+            (maxStreamsPerIP > 0 && ipStreams[srcip] >= maxStreamsPerIP) {
+            stats.rejected++
+            if !warnedLimit {
+                warnedLimit = true
+                log.Printf("Stream limit reached at %s (%d streams, %d from %s); "+
+                    "ignoring new connections", src, streamCount(), ipStreams[srcip], srcip)
+            }
+            return nil
+        }
         stats.streams++
-        chmap[src] = rs
+        ipStreams[srcip]++
     }
+    generation++
+    rs := &source{src: src, srcip: srcip, synced: false, gen: generation, shard: sh,
+        tenant: tenantFor(srcip, ""), opened: pktTime, lastSeen: pktTime}
+    sh.lock.Lock()
+    sh.streams[src] = rs
+    sh.lock.Unlock()
+    return rs
+}
 
-    processPacket(src, rs, request, pkt.Data[pos:])
+// dropSource stops tracking a stream.
+func dropSource(rs *source) {
+    sh := rs.shard
+    if sh.streams[rs.src] != rs {
+        return
+    }
+    sh.lock.Lock()
+    delete(sh.streams, rs.src)
+    sh.lock.Unlock()
+    stats.streams--
+    if ipStreams[rs.srcip]--; ipStreams[rs.srcip] <= 0 {
+        delete(ipStreams, rs.srcip)
+    }
+}
+
+// newSession is called when we see a connection being opened. Clients reuse
+// ephemeral ports quickly, so anything we still hold for the same ip:port
+// belongs to an earlier connection and must not leak into this one.
+func newSession(client, server string) {
+    servers[server] = true
+    if rs, ok := pktShard.streams[client]; ok {
+        if rs.synced || rs.reqbuffer != nil || rs.reqSent != nil {
+            stats.reused++
+        } else if rs.handshake {
+            // The SYN/ACK to the SYN that made it, or a retransmission.
+            return
+        }
+        publishConnection(rs, "close")
+    }
+    if rs := newSource(client); rs != nil {
+        rs.server, rs.handshake = server, true
+        publishConnection(rs, "open")
+    }
 }
 
+// resetSession handles COM_RESET_CONNECTION, which pools send when a
+// connection is handed back or out. Everything the session had set up is gone
+// afterwards, so start over as if it had just connected, keeping the stream.
+func resetSession(rs *source) {
+    finishResponse(rs)
+    rs.reqSent = nil
+    rs.qdata, rs.qtext, rs.query, rs.shown, rs.raw, rs.qbytes, rs.literals = nil, "", "", "", "", 0, nil
+    rs.db, rs.inTx, rs.implicitTx = "", false, false
+    rs.stmts, rs.preparing = nil, nil
+    rs.tenant = tenantFor(rs.srcip, "")
+    rs.resets++
+    stats.resets++
+}
+
+// scanToken returns the length and type of the token query starts with. An
+// empty query is a zero length TOKEN_OTHER.
 func scanToken(query []byte) (length int, thistype int) {
     if len(query) < 1 {
-        log.Fatalf("scanToken called with empty query")
+        return 0, TOKEN_OTHER
     }
 
     if verbose && noclean {
@@ -403,7 +1400,7 @@ func scanToken(query []byte) (length int, thistype int) {
                 }
                 return i + 1, TOKEN_QUOTE
             case 92:
-                escaped = true
+                escaped = !escaped
             default:
                 escaped = false
             }
@@ -411,15 +1408,12 @@ func scanToken(query []byte) (length int, thistype int) {
         return len(query), TOKEN_QUOTE
 
     case b >= 48 && b <= 57: // 0-9
-        for i := 1; i < len(query); i++ {
-            switch {
-            case query[i] >= 48 && query[i] <= 57: // 0-9
-                // do nothing
-            default:
-                return i, TOKEN_NUMBER
-            }
-        }
-        return len(query), TOKEN_NUMBER
+        return scanNumber(query)
+
+    case (b == 'x' || b == 'X' || b == 'b' || b == 'B') && len(query) > 1 && query[1] == 39:
+        // X'1F' and b'101'
+        length, _ := scanToken(query[1:])
+        return length + 1, TOKEN_NUMBER
 
     case b == 32 || (b >= 9 && b <= 13): // whitespace
         for i := 1; i < len(query); i++ {
@@ -450,27 +1444,121 @@ func scanToken(query []byte) (length int, thistype int) {
     default: // everything else
         return 1, TOKEN_OTHER
     }
+}
+
+// scanNumber scans a number: an integer, a decimal like 3.14, one with an
+// exponent like 1e10 or 2.5E-3, or hex like 0xABC. Something starting with
+// digits that goes on with letters is an identifier (MySQL allows 1col).
+func scanNumber(query []byte) (int, int) {
+    isDigit := func(i int) bool { return i < len(query) && query[i] >= 48 && query[i] <= 57 }
+    isWordByte := func(i int) bool {
+        if i >= len(query) {
+            return false
+        }
+        c := query[i]
+        return (c >= 48 && c <= 57) || (c >= 65 && c <= 90) || (c >= 97 && c <= 122) ||
+            c == 36 || c == 95
+    }
+    isHex := func(i int) bool {
+        return isDigit(i) || (i < len(query) && ((query[i] >= 65 && query[i] <= 70) ||
+            (query[i] >= 97 && query[i] <= 102)))
+    }
+    word := func(i int) (int, int) {
+        for isWordByte(i) {
+            i++
+        }
+        return i, TOKEN_WORD
+    }
+
+    i := 1
+    if query[0] == '0' && len(query) > 2 && (query[1] == 'x' || query[1] == 'X') && isHex(2) {
+        for i = 2; isHex(i); i++ {
+        }
+        if isWordByte(i) {
+            return word(i)
+        }
+        return i, TOKEN_NUMBER
+    }
+    for isDigit(i) {
+        i++
+    }
+    if i < len(query) && query[i] == '.' && isDigit(i+1) {
+        for i++; isDigit(i); i++ {
+        }
+    }
+    if i < len(query) && (query[i] == 'e' || query[i] == 'E') {
+        j := i + 1
+        if j < len(query) && (query[j] == '+' || query[j] == '-') {
+            j++
+        }
+        if isDigit(j) {
+            for i = j; isDigit(i); i++ {
+            }
+        }
+    }
+    if isWordByte(i) && !bytes.ContainsAny(query[:i], ".eE") {
+        return word(i)
+    }
+    return i, TOKEN_NUMBER
+}
+
+// Words after which a - or + starts a number rather than subtracting.
+var signKeywords = map[string]bool{"select": true, "where": true, "and": true, "or": true,
+    "not": true, "by": true, "values": true, "value": true, "in": true, "between": true,
+    "when": true, "then": true, "else": true, "limit": true, "offset": true, "set": true,
+    "like": true, "is": true, "on": true, "having": true, "return": true, "interval": true}
 
-    // shouldn't get here
-    log.Fatalf("scanToken failure: [%s]", query)
-    return
+// signLength returns the length of a sign at the start of query that belongs
+// to the number after it, given the token before (prev, prevtype), or 0.
+func signLength(query []byte, prev []byte, prevtype int) int {
+    if len(query) < 2 || (query[0] != '-' && query[0] != '+') {
+        return 0
+    }
+    if _, toktype := scanToken(query[1:]); toktype != TOKEN_NUMBER || query[1] == 'x' ||
+        query[1] == 'X' || query[1] == 'b' || query[1] == 'B' {
+        return 0
+    }
+    switch prevtype {
+    case TOKEN_NUMBER, TOKEN_QUOTE:
+        return 0 // a - 1
+    case TOKEN_WORD:
+        if !signKeywords[strings.ToLower(string(prev))] {
+            return 0 // col - 1
+        }
+    case TOKEN_OTHER:
+        if len(prev) == 1 && (prev[0] == ')' || prev[0] == '?' || prev[0] == '`') {
+            return 0
+        }
+    }
+    return 1
 }
 
 func cleanupQuery(query []byte) string {
+    defer stageEnd(STAGE_CANONICALIZE, stageStart())
     // iterate until we hit the end of the query...
-    var qspace []string
+    var qspace bytes.Buffer
+    qspace.Grow(len(query))
+    var prev []byte
+    prevtype := -1
     for i := 0; i < len(query); {
         length, toktype := scanToken(query[i:])
+        if sign := signLength(query[i:], prev, prevtype); sign > 0 {
+            n, _ := scanToken(query[i+sign:])
+            length, toktype = sign+n, TOKEN_NUMBER
+        }
+        if toktype != TOKEN_WHITESPACE {
+            prev, prevtype = query[i:i+length], toktype
+        }
 
         switch toktype {
         case TOKEN_WORD, TOKEN_OTHER:
-            qspace = append(qspace, string(query[i:i+length]))
+            qspace.Write(query[i : i+length])
 
         case TOKEN_NUMBER, TOKEN_QUOTE:
-            qspace = append(qspace, "?")
+            qspace.WriteByte('?')
 
         case TOKEN_WHITESPACE:
-            qspace = append(qspace, " ")
+            qspace.WriteByte(' ')
 
         default:
             log.Fatalf("scanToken returned invalid token type %d", toktype)
@@ -480,7 +1568,7 @@ func cleanupQuery(query []byte) string {
     }
 
     // Remove hostname from the route information if it's present
-    tmp := strings.Join(qspace, "")
+    tmp := qspace.String()
 
     parts := strings.SplitN(tmp, " ", 5)
     if len(parts) >= 5 && parts[1] == "/*" && parts[3] == "*/" {
@@ -489,63 +1577,207 @@ func cleanupQuery(query []byte) string {
         }
     }
 
-    return strings.Replace(tmp, "?, ", "", -1)
+    return strings.Replace(collapseLists(tmp), "?, ", "", -1)
+}
+
+// collapseLists makes IN lists of two or more placeholders "IN (?+)" and
+// keeps only the first row of a multi-row VALUES, so statements that differ
+// only in how many values they carry share a fingerprint.
+func collapseLists(query string) string {
+    data := []byte(query)
+    var out bytes.Buffer
+    out.Grow(len(data))
+    for i := 0; i < len(data); {
+        length, toktype := scanToken(data[i:])
+        word := strings.ToLower(string(data[i : i+length]))
+        out.Write(data[i : i+length])
+        i += length
+        if toktype != TOKEN_WORD {
+            continue
+        }
+        switch word {
+        case "in":
+            j := skipSpace(data, i)
+            if end := parenEnd(data, j); end > 0 && placeholderList(data[j+1:end-1]) {
+                out.Write(data[i:j])
+                out.WriteString("(?+)")
+                i = end
+            }
+        case "values", "value":
+            j := skipSpace(data, i)
+            end := parenEnd(data, j)
+            if end < 0 {
+                continue
+            }
+            out.Write(data[i:end])
+            i = end
+            for {
+                j = skipSpace(data, i)
+                if j >= len(data) || data[j] != ',' {
+                    break
+                }
+                if end = parenEnd(data, skipSpace(data, j+1)); end < 0 {
+                    break
+                }
+                i = end
+            }
+        }
+    }
+    return out.String()
+}
+
+func skipSpace(data []byte, i int) int {
+    for i < len(data) && (data[i] == ' ' || (data[i] >= 9 && data[i] <= 13)) {
+        i++
+    }
+    return i
+}
+
+// parenEnd returns the index after the parenthesis closing the one at i, or
+// -1 if there's no opening parenthesis at i or it isn't closed.
+func parenEnd(data []byte, i int) int {
+    if i >= len(data) || data[i] != '(' {
+        return -1
+    }
+    depth := 0
+    for i < len(data) {
+        length, toktype := scanToken(data[i:])
+        if toktype == TOKEN_OTHER && data[i] == '(' {
+            depth++
+        } else if toktype == TOKEN_OTHER && data[i] == ')' {
+            if depth--; depth == 0 {
+                return i + 1
+            }
+        }
+        i += length
+    }
+    return -1
+}
+
+// placeholderList says if data is two or more ? separated by commas.
+func placeholderList(data []byte) bool {
+    n := 0
+    expect := byte('?')
+    for _, b := range data {
+        switch {
+        case b == ' ' || (b >= 9 && b <= 13):
+        case b == expect && b == '?':
+            n, expect = n+1, ','
+        case b == expect:
+            expect = '?'
+        default:
+            return false
+        }
+    }
+    return n >= 2 && expect == ','
+}
+
+// formatField is a #x field in a format string. width, if not zero, is the
+// most characters of the value to keep.
+type formatField struct {
+    kind  int
+    width int
+}
+
+func (f formatField) truncate(value string) string {
+    if f.width <= 0 || len(value) <= f.width {
+        return value
+    }
+    n := 0
+    for i := range value {
+        if n == f.width {
+            return value[:i]
+        }
+        n++
+    }
+    return value
 }
 
-// parseFormat takes a string and parses it out into the given format slice
-// that we later use to build up a string. This might actually be an overcomplicated
-// solution?
-func parseFormat(formatstr string) {
+// parseFormat takes a string and parses it out into a format slice
+// that we later use to build up a string. Fields are #s (source ip:port),
+// #i (source ip), #r (route comment) and #q (query), optionally with a width
+// to truncate to, as in #{20}q. ## or \# is a literal #, and \\, \t and \n
+// are the usual escapes.
+func parseFormat(formatstr string) ([]interface{}, error) {
+    var format []interface{}
     formatstr = strings.TrimSpace(formatstr)
     if formatstr == "" {
-        formatstr = "#b:#k"
+        formatstr = "#s:#q"
     }
 
-    is_special := false
     curstr := ""
-    do_append := F_NONE
-    for _, char := range formatstr {
-        if char == '#' {
-            if is_special {
-                curstr += string(char)
-                is_special = false
-            } else {
-                is_special = true
-            }
-            continue
+    flush := func() {
+        if curstr != "" {
+            format = append(format, curstr)
+            curstr = ""
         }
-
-        if is_special {
-            switch strings.ToLower(string(char)) {
-            case "s":
-                do_append = F_SOURCE
-            case "i":
-                do_append = F_SOURCEIP
-            case "r":
-                do_append = F_ROUTE
-            case "q":
-                do_append = F_QUERY
+    }
+    for i := 0; i < len(formatstr); i++ {
+        char := formatstr[i]
+        if char == '\\' {
+            if i+1 == len(formatstr) {
+                return nil, fmt.Errorf("format ends in an escape")
+            }
+            i++
+            switch formatstr[i] {
+            case '\\', '#':
+                curstr += string(formatstr[i])
+            case 't':
+                curstr += "\t"
+            case 'n':
+                curstr += "\n"
             default:
-                curstr += "#" + string(char)
+                return nil, fmt.Errorf("unknown escape \\%c at %d", formatstr[i], i)
             }
-            is_special = false
-        } else {
+            continue
+        }
+        if char != '#' {
             curstr += string(char)
+            continue
         }
 
-        if do_append != F_NONE {
-            if curstr != "" {
-                format = append(format, curstr, do_append)
-                curstr = ""
-            } else {
-                format = append(format, do_append)
+        at := i
+        if i+1 == len(formatstr) {
+            return nil, fmt.Errorf("format ends in #; use ## for a literal #")
+        }
+        i++
+        if formatstr[i] == '#' {
+            curstr += "#"
+            continue
+        }
+        field := formatField{}
+        if formatstr[i] == '{' {
+            end := strings.IndexByte(formatstr[i:], '}')
+            if end < 0 {
+                return nil, fmt.Errorf("unterminated #{ at %d", at)
+            }
+            width, err := strconv.Atoi(formatstr[i+1 : i+end])
+            if err != nil || width <= 0 {
+                return nil, fmt.Errorf("bad width %q at %d", formatstr[i+1:i+end], at)
+            }
+            field.width = width
+            i += end + 1
+            if i == len(formatstr) {
+                return nil, fmt.Errorf("missing field after #{%d} at %d", width, at)
             }
-            do_append = F_NONE
         }
+        switch formatstr[i] {
+        case 's', 'S':
+            field.kind = F_SOURCE
+        case 'i', 'I':
+            field.kind = F_SOURCEIP
+        case 'r', 'R':
+            field.kind = F_ROUTE
+        case 'q', 'Q':
+            field.kind = F_QUERY
+        default:
+            return nil, fmt.Errorf("unknown field #%c at %d", formatstr[i], at)
+        }
+        flush()
+        format = append(format, field)
     }
-    if curstr != "" {
-        format = append(format, curstr)
-    }
+    flush()
+    return format, nil
 }
 
 func (self sortableSlice) Len() int {
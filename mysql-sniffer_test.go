@@ -0,0 +1,92 @@
+/*
+ * mysql-sniffer_test.go
+ *
+ * Query cleanup, the -f format string, the capture filter and publishing.
+ */
+
+package main
+
+import (
+    "reflect"
+    "testing"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+func TestParseFormat(t *testing.T) {
+    tests := []struct {
+        in   string
+        want []interface{}
+    }{
+        {"#s:#q", []interface{}{formatField{kind: F_SOURCE}, ":", formatField{kind: F_QUERY}}},
+        {"##q", []interface{}{"#q"}},
+        {`\#q \\`, []interface{}{`#q \`}},
+        {"#{20}q", []interface{}{formatField{kind: F_QUERY, width: 20}}},
+        {"x#{5}i", []interface{}{"x", formatField{kind: F_SOURCEIP, width: 5}}},
+    }
+    for _, test := range tests {
+        got, err := parseFormat(test.in)
+        if err != nil {
+            t.Errorf("%q: %s", test.in, err)
+        } else if !reflect.DeepEqual(got, test.want) {
+            t.Errorf("%q parsed to %#v, want %#v", test.in, got, test.want)
+        }
+    }
+    for _, bad := range []string{"#", "#x", "#{}q", "#{0}q", "#{3", "#{3}", `\`, `\z`} {
+        if _, err := parseFormat(bad); err == nil {
+            t.Errorf("%q: no error", bad)
+        }
+    }
+    if got := (formatField{kind: F_QUERY, width: 3}).truncate("héllo"); got != "hél" {
+        t.Errorf("truncate gave %q", got)
+    }
+}
+
+func TestCollapseLists(t *testing.T) {
+    for query, want := range map[string]string{
+        "SELECT * FROM t WHERE id IN (1,2,3) AND b IN ('x', 'y')": "SELECT * FROM t WHERE id IN (?+) AND b IN (?+)",
+        "SELECT * FROM t WHERE id IN (7)":                        "SELECT * FROM t WHERE id IN (?)",
+        "SELECT * FROM t WHERE id IN (SELECT a FROM u)":          "SELECT * FROM t WHERE id IN (SELECT a FROM u)",
+        "INSERT INTO t (a, b) VALUES (1, 'x'), (2, 'y'),(3,'z')": "INSERT INTO t (a, b) VALUES (?)",
+        "INSERT INTO t VALUES (1, NOW()), (2, NOW()) ON DUPLICATE KEY UPDATE b = VALUES(b), c = ?": "INSERT INTO t VALUES (NOW()) ON DUPLICATE KEY UPDATE b = VALUES(b), c = ?",
+    } {
+        if got := cleanupQuery([]byte(query)); got != want {
+            t.Errorf("%s: got %q, want %q", query, got, want)
+        }
+    }
+}
+
+func TestCleanupNumbers(t *testing.T) {
+    for query, want := range map[string]string{
+        "SELECT * FROM t WHERE a = -3.14 AND b > 1e10 AND c < 2.5E-3":    "SELECT * FROM t WHERE a = ? AND b > ? AND c < ?",
+        "SELECT * FROM t WHERE a-1 > b - 2 AND (c)-3 < -4 OR d = 0xABC OR e = X'1F'": "SELECT * FROM t WHERE a-? > b - ? AND (c)-? < ? OR d = ? OR e = ?",
+        "SELECT 1col, s2compiled FROM t WHERE f IN (-1, +2)":                "SELECT 1col, s2compiled FROM t WHERE f IN (?+)",
+    } {
+        if got := cleanupQuery([]byte(query)); got != want {
+            t.Errorf("%s: got %q, want %q", query, got, want)
+        }
+    }
+}
+
+func TestCaptureFilter(t *testing.T) {
+    userFilter = "net 10.0.0.0/24"
+    defer func() { userFilter = "" }()
+    if got := captureFilter(); got != "tcp port 3306 and (net 10.0.0.0/24)" {
+        t.Errorf("filter %q", got)
+    }
+}
+
+func TestPublishedQueries(t *testing.T) {
+    resetState()
+    sinks, sinkSpecs = append(sinks, &memorySink{}), append(sinkSpecs, sinkSpec{Type: "memory"})
+    defer replaceSinks(nil)
+    published, queries := stats.published, stats.queries_out
+    publishViews(&event.QueryEvent{Type: event.TYPE_QUERY, Sql: "select 1"}, nil)
+    publishViews(&event.AlertEvent{Type: event.TYPE_ALERT, Kind: "test"}, nil)
+    if n := stats.queries_out - queries; n != 1 {
+        t.Errorf("%d query events counted", n)
+    }
+    if n := stats.published - published; n != 4 {
+        t.Errorf("%d sends counted", n)
+    }
+}
@@ -0,0 +1,66 @@
+/*
+ * names.go
+ *
+ * Friendly names for fingerprints. A -names file maps aggregation keys to
+ * names like "get_user_by_id":
+ *
+ *   {"SELECT * FROM users WHERE id = ?": "get_user_by_id"}
+ *
+ * Names show up in reports and events next to the fingerprint. The `names`
+ * command captures like `report` and prints a skeleton file with every key
+ * seen, keeping names already given, for a team to fill in.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+)
+
+var fingerprintNames map[string]string = make(map[string]string)
+
+// loadNames reads a -names file.
+func loadNames(path string) error {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    names := make(map[string]string)
+    if err := json.Unmarshal(data, &names); err != nil {
+        return fmt.Errorf("%s: %s", path, err)
+    }
+    fingerprintNames = names
+    return nil
+}
+
+// nameOf returns the friendly name of a fingerprint, or "" if it has none.
+func nameOf(fingerprint string) string {
+    return fingerprintNames[fingerprint]
+}
+
+// labelOf is how a fingerprint is shown in text reports.
+func labelOf(fingerprint string) string {
+    if name := nameOf(fingerprint); name != "" {
+        return "[" + name + "] " + fingerprint
+    }
+    return fingerprint
+}
+
+// reportNames writes a names file covering everything captured. It is JSON
+// either way since the output is meant to be edited and fed back in.
+func reportNames(w io.Writer, asJSON bool) {
+    skeleton := make(map[string]string)
+    for key, name := range fingerprintNames {
+        skeleton[key] = name
+    }
+    for key := range qbuf {
+        if _, ok := skeleton[key]; !ok {
+            skeleton[key] = ""
+        }
+    }
+    out, _ := json.MarshalIndent(skeleton, "", "  ")
+    fmt.Fprintf(w, "%s\n", out)
+}
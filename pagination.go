@@ -0,0 +1,138 @@
+/*
+ * pagination.go
+ *
+ * LIMIT/OFFSET pagination. A deep page costs the server every row before it,
+ * yet once literals are replaced with "?" page 1 and page 5000 look the same.
+ * So the offsets of each fingerprint's statements are kept as sent, and the
+ * `offsets` report shows how they are spread, deepest pagers first.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "sort"
+    "strconv"
+)
+
+// Upper bounds of the offset ranges counted; the last range has no bound.
+var offsetBounds = []uint64{1, 100, 1000, 10000, 100000}
+var offsetLabels = []string{"0", "<100", "<1k", "<10k", "<100k", ">=100k"}
+
+type offsetStats struct {
+    count  uint64
+    max    uint64
+    ranges [6]uint64
+    spread latencyDigest // not a latency, but the same histogram works
+}
+
+// statementOffset returns the offset of LIMIT offset, count or LIMIT count
+// OFFSET offset, or of LIMIT count alone (0).
+func statementOffset(words []string) (uint64, bool) {
+    for i := 0; i+1 < len(words); i++ {
+        offset := ""
+        switch {
+        case words[i] == "offset":
+            offset = words[i+1]
+        case words[i] == "limit" && i+3 < len(words) && words[i+2] == ",":
+            offset = words[i+1]
+        case words[i] == "limit" && (i+2 >= len(words) || words[i+2] != "offset"):
+            if _, err := strconv.ParseUint(words[i+1], 10, 64); err == nil {
+                offset = "0"
+            }
+        default:
+            continue
+        }
+        if n, err := strconv.ParseUint(offset, 10, 64); err == nil {
+            return n, true
+        }
+    }
+    return 0, false
+}
+
+// noteOffset counts the offset of a statement of a paginated fingerprint.
+func noteOffset(qdata *queryData, words []string) (uint64, bool) {
+    offset, ok := statementOffset(words)
+    if !ok {
+        return 0, false
+    }
+    if qdata.offsets == nil {
+        qdata.offsets = &offsetStats{}
+    }
+    o := qdata.offsets
+    o.count++
+    if offset > o.max {
+        o.max = offset
+    }
+    i := 0
+    for i < len(offsetBounds) && offset >= offsetBounds[i] {
+        i++
+    }
+    o.ranges[i]++
+    o.spread.observe(offset)
+    return offset, true
+}
+
+type offsetSpread struct {
+    Fingerprint string            `json:"fingerprint"`
+    Name        string            `json:"name,omitempty"`
+    Count       uint64            `json:"count"` // statements with a LIMIT
+    P50         uint64            `json:"p50"`
+    P95         uint64            `json:"p95"`
+    P99         uint64            `json:"p99"`
+    Max         uint64            `json:"max"`
+    Ranges      map[string]uint64 `json:"ranges"`
+}
+
+type spreadsByDepth []offsetSpread
+
+func (self spreadsByDepth) Len() int { return len(self) }
+func (self spreadsByDepth) Less(i, j int) bool {
+    if self[i].P95 != self[j].P95 {
+        return self[i].P95 > self[j].P95
+    }
+    return self[i].Max > self[j].Max
+}
+func (self spreadsByDepth) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+
+func reportOffsets(w io.Writer, asJSON bool) {
+    var out spreadsByDepth
+    for _, key := range sortedQueries() {
+        o := qbuf[key].offsets
+        if o == nil {
+            continue
+        }
+        s := offsetSpread{Fingerprint: key, Name: nameOf(key), Count: o.count,
+            P50: o.spread.quantile(0.50), P95: o.spread.quantile(0.95),
+            P99: o.spread.quantile(0.99), Max: o.max, Ranges: make(map[string]uint64)}
+        for i, n := range o.ranges {
+            if n > 0 {
+                s.Ranges[offsetLabels[i]] = n
+            }
+        }
+        out = append(out, s)
+    }
+    sort.Stable(out)
+    if asJSON {
+        json.NewEncoder(w).Encode(map[string]interface{}{"offsets": out})
+        return
+    }
+
+    fmt.Fprintf(w, "%s== offsets (%d paginated fingerprints)%s\n", color(COLOR_CYAN), len(out),
+        color(COLOR_DEFAULT))
+    fmt.Fprintf(w, "%10s %8s %8s %8s %10s", "count", "p50", "p95", "p99", "max")
+    for _, label := range offsetLabels {
+        fmt.Fprintf(w, " %8s", label)
+    }
+    fmt.Fprintf(w, "  %s\n", "fingerprint")
+    for _, s := range out {
+        fmt.Fprintf(w, "%10d %8d %8d %8d %10d", s.Count, s.P50, s.P95, s.P99, s.Max)
+        for _, label := range offsetLabels {
+            fmt.Fprintf(w, " %8d", s.Ranges[label])
+        }
+        fmt.Fprintf(w, "  %s\n", labelOf(s.Fingerprint))
+    }
+    fmt.Fprintf(w, "\n")
+}
@@ -0,0 +1,26 @@
+/*
+ * pagination_test.go
+ *
+ * The offset of LIMIT clauses.
+ */
+
+package main
+
+import (
+    "testing"
+)
+
+func TestStatementOffset(t *testing.T) {
+    for query, want := range map[string]uint64{
+        "SELECT * FROM t LIMIT 20":            0,
+        "SELECT * FROM t LIMIT 4000, 20":      4000,
+        "SELECT * FROM t LIMIT 20 OFFSET 980": 980,
+    } {
+        if got, ok := statementOffset(queryWords([]byte(query))); !ok || got != want {
+            t.Errorf("%s: got %d (%v), want %d", query, got, ok, want)
+        }
+    }
+    if _, ok := statementOffset(queryWords([]byte("SELECT * FROM t LIMIT ?"))); ok {
+        t.Errorf("placeholder offset read as a number")
+    }
+}
@@ -0,0 +1,271 @@
+/*
+ * prepared.go
+ *
+ * Prepared statements. COM_STMT_PREPARE carries the SQL and its OK response
+ * the statement id; each COM_STMT_EXECUTE names the id and carries the
+ * parameters in the binary protocol. Executions are counted and timed
+ * against the prepared statement's fingerprint, like the same query sent as
+ * text. To reuse the text path, the parameters are written back into the SQL
+ * as literals, which is also what -u shows and where examples get their
+ * values from. A parameter sent ahead with COM_STMT_SEND_LONG_DATA isn't in
+ * the COM_STMT_EXECUTE; it stays a ?.
+ */
+
+package main
+
+import (
+    "encoding/binary"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+)
+
+const (
+    COM_STMT_SEND_LONG_DATA = 0x18
+    COM_STMT_CLOSE          = 0x19
+    COM_STMT_RESET          = 0x1a
+)
+
+// Binary protocol column types.
+const (
+    MYSQL_TYPE_TINY      = 0x01
+    MYSQL_TYPE_SHORT     = 0x02
+    MYSQL_TYPE_LONG      = 0x03
+    MYSQL_TYPE_FLOAT     = 0x04
+    MYSQL_TYPE_DOUBLE    = 0x05
+    MYSQL_TYPE_NULL      = 0x06
+    MYSQL_TYPE_TIMESTAMP = 0x07
+    MYSQL_TYPE_LONGLONG  = 0x08
+    MYSQL_TYPE_INT24     = 0x09
+    MYSQL_TYPE_DATE      = 0x0a
+    MYSQL_TYPE_TIME      = 0x0b
+    MYSQL_TYPE_DATETIME  = 0x0c
+    MYSQL_TYPE_YEAR      = 0x0d
+    UNSIGNED_FLAG        = 0x80
+)
+
+// Most statements a connection may hold; clients that never close theirs
+// shouldn't grow without bound.
+const MAX_PREPARED_PER_CONN = 1024
+
+type preparedStmt struct {
+    sql    []byte // as sent, before canonicalization
+    params int
+    types  []byte // 2 bytes per parameter, as last bound
+    long   []bool // parameters sent as long data for the next execute
+}
+
+// notePrepare remembers the SQL of a COM_STMT_PREPARE until the server
+// answers with the statement id.
+func notePrepare(rs *source, pdata []byte) {
+    rs.preparing = &preparedStmt{sql: append([]byte{}, pdata...)}
+}
+
+// notePrepareOK files the statement being prepared under the id in the
+// server's COM_STMT_PREPARE_OK (payload including the 0x00 header).
+func notePrepareOK(rs *source, payload []byte) {
+    stmt := rs.preparing
+    rs.preparing = nil
+    if stmt == nil || len(payload) < 9 {
+        return
+    }
+    if rs.stmts == nil {
+        rs.stmts = make(map[uint32]*preparedStmt)
+    }
+    if len(rs.stmts) >= MAX_PREPARED_PER_CONN {
+        return
+    }
+    stmt.params = int(binary.LittleEndian.Uint16(payload[7:9]))
+    rs.stmts[binary.LittleEndian.Uint32(payload[1:5])] = stmt
+}
+
+// closeStatement forgets a statement on COM_STMT_CLOSE.
+func closeStatement(rs *source, pdata []byte) {
+    if len(pdata) >= 4 {
+        delete(rs.stmts, binary.LittleEndian.Uint32(pdata))
+    }
+}
+
+// noteLongData records the parameter a COM_STMT_SEND_LONG_DATA is for.
+func noteLongData(rs *source, pdata []byte) {
+    if len(pdata) < 6 {
+        return
+    }
+    stmt, ok := rs.stmts[binary.LittleEndian.Uint32(pdata)]
+    param := int(binary.LittleEndian.Uint16(pdata[4:]))
+    if !ok || param >= stmt.params {
+        return
+    }
+    if stmt.long == nil {
+        stmt.long = make([]bool, stmt.params)
+    }
+    stmt.long[param] = true
+}
+
+// resetStatement forgets the long data sent for a statement on
+// COM_STMT_RESET.
+func resetStatement(rs *source, pdata []byte) {
+    if len(pdata) < 4 {
+        return
+    }
+    if stmt, ok := rs.stmts[binary.LittleEndian.Uint32(pdata)]; ok {
+        stmt.long = nil
+    }
+}
+
+// expandExecute returns the SQL of the statement a COM_STMT_EXECUTE runs,
+// with its parameters filled in, and as prepared. It returns false if the
+// statement wasn't seen being prepared.
+func expandExecute(rs *source, pdata []byte) (sql []byte, prepared []byte, found bool) {
+    if len(pdata) < 9 {
+        return nil, nil, false
+    }
+    stmt, ok := rs.stmts[binary.LittleEndian.Uint32(pdata)]
+    if !ok {
+        return nil, nil, false
+    }
+    values, ok := executeParams(stmt, pdata[9:]) // past id, flags, iteration count
+    stmt.long = nil
+    if !ok {
+        return stmt.sql, stmt.sql, true
+    }
+
+    var out []byte
+    n := 0
+    for i := 0; i < len(stmt.sql); {
+        length, toktype := scanToken(stmt.sql[i:])
+        if toktype == TOKEN_OTHER && stmt.sql[i] == '?' && n < len(values) {
+            out = append(out, values[n]...)
+            n++
+        } else {
+            out = append(out, stmt.sql[i:i+length]...)
+        }
+        i += length
+    }
+    return out, stmt.sql, true
+}
+
+// executeParams decodes the parameter block of a COM_STMT_EXECUTE into SQL
+// literals.
+func executeParams(stmt *preparedStmt, data []byte) ([]string, bool) {
+    n := stmt.params
+    if n == 0 {
+        return nil, true
+    }
+    nullBytes := (n + 7) / 8
+    if len(data) < nullBytes+1 {
+        return nil, false
+    }
+    nulls, data := data[:nullBytes], data[nullBytes:]
+    if data[0] == 1 { // new types bound
+        if len(data) < 1+2*n {
+            return nil, false
+        }
+        stmt.types = append([]byte{}, data[1:1+2*n]...)
+        data = data[1+2*n:]
+    } else {
+        data = data[1:]
+    }
+    if len(stmt.types) != 2*n {
+        return nil, false
+    }
+
+    values := make([]string, n)
+    for i := 0; i < n; i++ {
+        if stmt.long != nil && stmt.long[i] {
+            values[i] = "?"
+            continue
+        }
+        if nulls[i/8]&(1<<uint(i%8)) != 0 {
+            values[i] = "NULL"
+            continue
+        }
+        value, used := binaryValue(stmt.types[2*i], stmt.types[2*i+1]&UNSIGNED_FLAG != 0, data)
+        if used == 0 {
+            return nil, false
+        }
+        values[i], data = value, data[used:]
+    }
+    return values, true
+}
+
+// binaryValue renders one binary protocol value as an SQL literal, returning
+// it and the bytes it took, or 0 bytes if data is too short.
+func binaryValue(typ byte, unsigned bool, data []byte) (string, int) {
+    integer := func(size int) (string, int) {
+        if len(data) < size {
+            return "", 0
+        }
+        var v uint64
+        for i := size - 1; i >= 0; i-- {
+            v = v<<8 | uint64(data[i])
+        }
+        if unsigned {
+            return strconv.FormatUint(v, 10), size
+        }
+        shift := uint(64 - 8*size)
+        return strconv.FormatInt(int64(v<<shift)>>shift, 10), size
+    }
+
+    switch typ {
+    case MYSQL_TYPE_NULL:
+        return "NULL", 0
+    case MYSQL_TYPE_TINY:
+        return integer(1)
+    case MYSQL_TYPE_SHORT, MYSQL_TYPE_YEAR:
+        return integer(2)
+    case MYSQL_TYPE_LONG, MYSQL_TYPE_INT24:
+        return integer(4)
+    case MYSQL_TYPE_LONGLONG:
+        return integer(8)
+    case MYSQL_TYPE_FLOAT:
+        if len(data) < 4 {
+            return "", 0
+        }
+        f := math.Float32frombits(binary.LittleEndian.Uint32(data))
+        return strconv.FormatFloat(float64(f), 'g', -1, 32), 4
+    case MYSQL_TYPE_DOUBLE:
+        if len(data) < 8 {
+            return "", 0
+        }
+        return strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(data)), 'g', -1, 64), 8
+    case MYSQL_TYPE_DATE, MYSQL_TYPE_DATETIME, MYSQL_TYPE_TIMESTAMP:
+        if len(data) < 1 || len(data) < 1+int(data[0]) {
+            return "", 0
+        }
+        d := data[1 : 1+int(data[0])]
+        var year, month, day, hour, min, sec int
+        if len(d) >= 4 {
+            year, month, day = int(binary.LittleEndian.Uint16(d)), int(d[2]), int(d[3])
+        }
+        if len(d) >= 7 {
+            hour, min, sec = int(d[4]), int(d[5]), int(d[6])
+        }
+        if typ == MYSQL_TYPE_DATE {
+            return fmt.Sprintf("'%04d-%02d-%02d'", year, month, day), 1 + len(d)
+        }
+        return fmt.Sprintf("'%04d-%02d-%02d %02d:%02d:%02d'", year, month, day, hour, min, sec), 1 + len(d)
+    case MYSQL_TYPE_TIME:
+        if len(data) < 1 || len(data) < 1+int(data[0]) {
+            return "", 0
+        }
+        d := data[1 : 1+int(data[0])]
+        if len(d) < 8 {
+            return "'00:00:00'", 1 + len(d)
+        }
+        sign := ""
+        if d[0] == 1 {
+            sign = "-"
+        }
+        hours := int(binary.LittleEndian.Uint32(d[1:]))*24 + int(d[5])
+        return fmt.Sprintf("'%s%02d:%02d:%02d'", sign, hours, d[6], d[7]), 1 + len(d)
+    }
+
+    // Everything else (strings, blobs, decimals, JSON, ...) is length encoded.
+    s, used := readLenencString(data)
+    if used == 0 {
+        return "", 0
+    }
+    return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(string(s)) + "'", used
+}
@@ -0,0 +1,56 @@
+/*
+ * prepared_test.go
+ *
+ * Prepared statements executed with their parameters filled back in.
+ */
+
+package main
+
+import (
+    "testing"
+)
+
+// executeSeed is COM_STMT_EXECUTE (without the command byte) of statement 1
+// with a BIGINT 7, a NULL and the string "it's".
+var executeSeed = []byte{1, 0, 0, 0, 0, 1, 0, 0, 0, 0x02, 1, 0x08, 0, 0xfe, 0, 0xfe, 0,
+    7, 0, 0, 0, 0, 0, 0, 0, 4, 'i', 't', '\'', 's'}
+
+func newExecuteSource() *source {
+    rs := &source{}
+    notePrepare(rs, []byte("SELECT * FROM t WHERE a = ? AND b = ? AND c = '?' AND d = ?"))
+    notePrepareOK(rs, []byte{0, 1, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0})
+    return rs
+}
+
+func TestExpandExecute(t *testing.T) {
+    sql, prepared, ok := expandExecute(newExecuteSource(), executeSeed)
+    want := `SELECT * FROM t WHERE a = 7 AND b = NULL AND c = '?' AND d = 'it\'s'`
+    if !ok || string(sql) != want || cleanupQuery(prepared) != "SELECT * FROM t WHERE a = ? AND b = ? AND c = ? AND d = ?" {
+        t.Errorf("expanded to %q (prepared %q, %v), want %q", sql, prepared, ok, want)
+    }
+}
+
+func TestExecuteLongData(t *testing.T) {
+    rs := newExecuteSource()
+    // The first parameter goes ahead as long data, so only the NULL and
+    // "it's" are in the execute.
+    noteLongData(rs, []byte{1, 0, 0, 0, 0, 0, 'b', 'l', 'o', 'b'})
+    execute := []byte{1, 0, 0, 0, 0, 1, 0, 0, 0, 0x02, 1, 0xfc, 0, 0xfe, 0, 0xfe, 0,
+        4, 'i', 't', '\'', 's'}
+    sql, _, ok := expandExecute(rs, execute)
+    want := `SELECT * FROM t WHERE a = ? AND b = NULL AND c = '?' AND d = 'it\'s'`
+    if !ok || string(sql) != want {
+        t.Errorf("expanded to %q, want %q", sql, want)
+    }
+
+    // Long data only holds for one execute, or until a reset.
+    want = `SELECT * FROM t WHERE a = 7 AND b = NULL AND c = '?' AND d = 'it\'s'`
+    if sql, _, _ := expandExecute(rs, executeSeed); string(sql) != want {
+        t.Errorf("next execute expanded to %q", sql)
+    }
+    noteLongData(rs, []byte{1, 0, 0, 0, 0, 0, 'b', 'l', 'o', 'b'})
+    resetStatement(rs, []byte{1, 0, 0, 0})
+    if sql, _, _ := expandExecute(rs, executeSeed); string(sql) != want {
+        t.Errorf("execute after a reset expanded to %q", sql)
+    }
+}
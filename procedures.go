@@ -0,0 +1,185 @@
+/*
+ * procedures.go
+ *
+ * Stored procedures and functions. A CALL or a query using a stored function
+ * hides the real work from anything looking at query text, so we at least
+ * account calls, time and rows per routine. Every -procedure_window the
+ * totals go out as "procedure" events.
+ *
+ * Function calls are told from built-in functions by name, which can't be
+ * exact; anything schema-qualified counts as a stored function.
+ */
+
+package main
+
+import (
+    "strings"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+const (
+    ROUTINE_PROCEDURE = "procedure"
+    ROUTINE_FUNCTION  = "function"
+)
+
+type routineStats struct {
+    kind      string
+    calls     uint64
+    timeTotal uint64 // nanoseconds, to the last byte
+    rows      uint64
+}
+
+var routines map[string]*routineStats = make(map[string]*routineStats)
+var procedureWindow time.Duration
+var procedureWindowStart time.Time
+
+// Built-in functions, which aren't interesting here.
+var builtinFunctions = map[string]bool{}
+
+func init() {
+    for _, name := range strings.Fields(`
+        ABS ADDDATE AES_DECRYPT AES_ENCRYPT ANY_VALUE ASCII AVG BIN BIT_AND
+        BIT_COUNT BIT_LENGTH BIT_OR BIT_XOR CAST CEIL CEILING CHAR CHAR_LENGTH
+        CHARSET COALESCE COLLATE CONCAT CONCAT_WS CONNECTION_ID CONV CONVERT
+        CONVERT_TZ COUNT CRC32 CURDATE CURRENT_DATE CURRENT_TIME
+        CURRENT_TIMESTAMP CURRENT_USER CURTIME DATABASE DATE DATE_ADD
+        DATE_FORMAT DATE_SUB DATEDIFF DAY DAYNAME DAYOFMONTH DAYOFWEEK
+        DAYOFYEAR DENSE_RANK ELT EXP EXTRACT FIELD FIND_IN_SET FLOOR FORMAT
+        FOUND_ROWS FROM_BASE64 FROM_DAYS FROM_UNIXTIME GET_LOCK GREATEST
+        GROUP_CONCAT HEX HOUR IF IFNULL INET_ATON INET_NTOA INSERT INSTR
+        INTERVAL ISNULL JSON_ARRAY JSON_ARRAYAGG JSON_CONTAINS JSON_EXTRACT
+        JSON_OBJECT JSON_OBJECTAGG JSON_SET JSON_UNQUOTE LAG LAST_DAY
+        LAST_INSERT_ID LCASE LEAD LEAST LEFT LENGTH LN LOCATE LOG LOWER LPAD
+        LTRIM MAKEDATE MAX MD5 MICROSECOND MIN MINUTE MOD MONTH MONTHNAME NOW
+        NULLIF OCT ORD PERIOD_DIFF PI POSITION POW POWER QUARTER QUOTE RAND
+        RANK RELEASE_LOCK REPEAT REPLACE REVERSE RIGHT ROUND ROW ROW_COUNT
+        ROW_NUMBER RPAD RTRIM SEC_TO_TIME SECOND SHA1 SHA2 SIGN SLEEP SPACE
+        SQRT STD STDDEV STR_TO_DATE STRCMP SUBDATE SUBSTR SUBSTRING
+        SUBSTRING_INDEX SUM SYSDATE TIME TIME_FORMAT TIME_TO_SEC TIMEDIFF
+        TIMESTAMP TIMESTAMPADD TIMESTAMPDIFF TO_BASE64 TO_DAYS TRIM TRUNCATE
+        UCASE UNHEX UNIX_TIMESTAMP UPPER USER UTC_DATE UTC_TIMESTAMP UUID
+        UUID_SHORT VALUES VARIANCE VERSION WEEK WEEKDAY YEAR YEARWEEK`) {
+        builtinFunctions[name] = true
+    }
+}
+
+// routinesIn returns the procedure a query CALLs, or the stored functions it
+// uses, with their kind.
+func routinesIn(query string) (names []string, kind string) {
+    data := []byte(query)
+    var words []string // identifier tokens, "." and "(" in order
+    for i := 0; i < len(data); {
+        length, toktype := scanToken(data[i:])
+        tok := string(data[i : i+length])
+        i += length
+        switch {
+        case toktype == TOKEN_WHITESPACE:
+        case toktype == TOKEN_WORD, tok == ".", tok == "(":
+            words = append(words, tok)
+        case tok == "`":
+            // quoting around identifiers
+        default:
+            words = append(words, "")
+        }
+    }
+
+    // name returns the possibly qualified identifier ending at words[end].
+    name := func(end int) string {
+        if end >= 2 && words[end-1] == "." && words[end-2] != "" {
+            return words[end-2] + "." + words[end]
+        }
+        return words[end]
+    }
+    if len(words) >= 2 && strings.EqualFold(words[0], "CALL") {
+        end := 1
+        if len(words) >= 4 && words[2] == "." {
+            end = 3
+        }
+        return []string{name(end)}, ROUTINE_PROCEDURE
+    }
+    seen := make(map[string]bool)
+    for i := 1; i < len(words); i++ {
+        if words[i] != "(" || words[i-1] == "" || words[i-1] == "." || words[i-1] == "(" {
+            continue
+        }
+        upper := strings.ToUpper(words[i-1])
+        fn := name(i - 1)
+        qualified := strings.Contains(fn, ".")
+        if !qualified && (builtinFunctions[upper] || sqlKeywords[upper]) {
+            continue
+        }
+        // Not a call but a column list, as in INSERT INTO t (a, b).
+        before := i - 2
+        if qualified {
+            before = i - 4
+        }
+        if before >= 0 && (tableKeywords[strings.ToUpper(words[before])] ||
+            strings.EqualFold(words[before], "INDEX") || strings.EqualFold(words[before], "KEY")) {
+            continue
+        }
+        if !seen[fn] {
+            seen[fn] = true
+            names = append(names, fn)
+        }
+    }
+    return names, ROUTINE_FUNCTION
+}
+
+// noteRoutines accounts a finished query to the routines it called.
+func noteRoutines(rs *source) {
+    if rs.command != COM_QUERY || rs.query == "" {
+        return
+    }
+    names, kind := routinesIn(rs.query)
+    if len(names) == 0 {
+        return
+    }
+    var rows uint64
+    for _, n := range rs.resp.sets {
+        rows += n
+    }
+    took := rs.timing.reqtime + uint64(rs.timing.lastByte.Sub(rs.timing.firstByte).Nanoseconds())
+    for _, name := range names {
+        r, ok := routines[name]
+        if !ok {
+            r = &routineStats{kind: kind}
+            routines[name] = r
+        }
+        r.calls++
+        r.timeTotal += took
+        r.rows += rows
+    }
+}
+
+// flushRoutines publishes the totals for the window that just ended. Must be
+// called with stateLock held.
+func flushRoutines(now time.Time) {
+    for name, r := range routines {
+        publish(&event.ProcedureEvent{
+            Type:        event.TYPE_PROCEDURE,
+            ServiceId:   service_id,
+            TenantId:    tenant_id,
+            Name:        name,
+            Kind:        r.kind,
+            WindowStart: procedureWindowStart.Unix(),
+            WindowSecs:  now.Sub(procedureWindowStart).Seconds(),
+            Calls:       r.calls,
+            Time:        float64(r.timeTotal) / 1e6,
+            Rows:        r.rows,
+        })
+    }
+    routines = make(map[string]*routineStats)
+    procedureWindowStart = now
+}
+
+// runRoutineAccounting closes a window every procedureWindow.
+func runRoutineAccounting() {
+    procedureWindowStart = time.Now()
+    for now := range time.Tick(procedureWindow) {
+        stateLock.Lock()
+        flushRoutines(now)
+        stateLock.Unlock()
+    }
+}
@@ -0,0 +1,89 @@
+/*
+ * profile.go
+ *
+ * Profiling the sniffer on real traffic. -pprof host:port serves the
+ * net/http/pprof handlers on a listener of their own (keep it on localhost;
+ * they are not on the control API). -profile path writes a CPU profile to
+ * path.cpu over the first -profile_for of the run, then a heap profile to
+ * path.heap, for when a pprof port can't be reached.
+ */
+
+package main
+
+import (
+    "log"
+    "net/http"
+    _ "net/http/pprof"
+    "os"
+    "runtime"
+    "runtime/pprof"
+    "sync"
+    "time"
+)
+
+var profileStop = make(chan struct{})
+var profileDone chan struct{} // closed once the profiles are written
+var profileOnce sync.Once
+
+// startPprof serves /debug/pprof/ on addr in the background.
+func startPprof(addr string) {
+    go func() {
+        log.Printf("pprof listening on %s", addr)
+        if err := http.ListenAndServe(addr, http.DefaultServeMux); err != nil {
+            log.Fatalf("pprof failed: %s", err.Error())
+        }
+    }()
+}
+
+// startProfile starts a CPU profile, to be stopped after duration or by
+// stopProfile, whichever is first.
+func startProfile(path string, duration time.Duration) error {
+    cpu, err := os.Create(path + ".cpu")
+    if err != nil {
+        return err
+    }
+    if err := pprof.StartCPUProfile(cpu); err != nil {
+        cpu.Close()
+        return err
+    }
+    log.Printf("Profiling for %s to %s.cpu", duration, path)
+    done := make(chan struct{})
+    go func() {
+        select {
+        case <-time.After(duration):
+        case <-profileStop:
+        }
+        pprof.StopCPUProfile()
+        cpu.Close()
+        if err := writeHeapProfile(path + ".heap"); err != nil {
+            log.Printf("Failed to write heap profile: %s", err)
+        } else {
+            log.Printf("Wrote %s.cpu and %s.heap", path, path)
+        }
+        close(done)
+    }()
+    profileDone = done
+    return nil
+}
+
+func writeHeapProfile(path string) error {
+    f, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    runtime.GC() // up to date statistics
+    if err := pprof.WriteHeapProfile(f); err != nil {
+        f.Close()
+        return err
+    }
+    return f.Close()
+}
+
+// stopProfile ends a profile still running and waits for it to be written.
+func stopProfile() {
+    if profileDone == nil {
+        return
+    }
+    profileOnce.Do(func() { close(profileStop) })
+    <-profileDone
+}
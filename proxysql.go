@@ -0,0 +1,114 @@
+/*
+ * proxysql.go
+ *
+ * Proxy overhead on a ProxySQL host (-proxysql_port). The frontend port the
+ * applications connect to is captured along with -P, the backend servers'
+ * port, so each statement is seen twice: from the application to the proxy
+ * and from the proxy to MySQL. When a frontend statement finishes, the
+ * backend call with the same text that started and ended within it is its
+ * match, and the difference between the two round trips, the time the
+ * proxy added, goes in the frontend event's proxy_time and the
+ * mysql_sniffer_proxy_latency_seconds histogram. Query events say which
+ * side they come from in proxy_side. Statements the proxy rewrites or
+ * answers from its cache have no match.
+ */
+
+package main
+
+import (
+    "fmt"
+    "io"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+const (
+    PROXY_FRONTEND = "frontend"
+    PROXY_BACKEND  = "backend"
+
+    PROXY_WINDOW      = 10 * time.Second // backend calls kept for matching
+    PROXY_MAX_PENDING = 100000
+)
+
+var proxyPort uint16
+
+// A backend call waiting for its frontend statement.
+type proxyCall struct {
+    start, end time.Time
+}
+
+var proxyCalls map[string][]proxyCall = make(map[string][]proxyCall)
+var proxyPending int
+
+var proxyLatencyBounds = []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005,
+    0.01, 0.025, 0.05, 0.1, 0.25, 0.5}
+var proxyLatency = newHistogram(proxyLatencyBounds)
+
+// noteProxy matches a finished statement on rs against the other side of
+// the proxy. Must be called with stateLock held.
+func noteProxy(rs *source, ev *event.QueryEvent) {
+    if proxyPort == 0 || ev.Total == nil {
+        return
+    }
+    end := rs.timing.lastByte
+    start := rs.timing.firstByte.Add(-time.Duration(rs.timing.reqtime))
+    if portOf(rs.server) != proxyPort {
+        ev.ProxySide = PROXY_BACKEND
+        if proxyPending >= PROXY_MAX_PENDING {
+            return
+        }
+        proxyCalls[rs.raw] = append(proxyCalls[rs.raw], proxyCall{start, end})
+        proxyPending++
+        return
+    }
+    ev.ProxySide = PROXY_FRONTEND
+    calls := proxyCalls[rs.raw]
+    match := -1
+    for i, call := range calls {
+        if !call.start.Before(start) && !call.end.After(end) &&
+            (match < 0 || call.start.Before(calls[match].start)) {
+            match = i
+        }
+    }
+    if match < 0 {
+        return
+    }
+    added := end.Sub(start) - calls[match].end.Sub(calls[match].start)
+    if calls = append(calls[:match], calls[match+1:]...); len(calls) == 0 {
+        delete(proxyCalls, rs.raw)
+    } else {
+        proxyCalls[rs.raw] = calls
+    }
+    proxyPending--
+    t := float64(added.Nanoseconds()) / 1000
+    ev.ProxyTime = &t
+    proxyLatency.observe(added.Seconds())
+}
+
+// pruneProxyCalls forgets backend calls that ended before now - PROXY_WINDOW.
+// Must be called with stateLock held.
+func pruneProxyCalls(now time.Time) {
+    for raw, calls := range proxyCalls {
+        kept := calls[:0]
+        for _, call := range calls {
+            if now.Sub(call.end) < PROXY_WINDOW {
+                kept = append(kept, call)
+            }
+        }
+        proxyPending -= len(calls) - len(kept)
+        if len(kept) == 0 {
+            delete(proxyCalls, raw)
+        } else {
+            proxyCalls[raw] = kept
+        }
+    }
+}
+
+func writeProxyMetrics(w io.Writer) {
+    if proxyPort == 0 {
+        return
+    }
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_proxy_latency_seconds histogram\n")
+    proxyLatency.writeMetric(w, "mysql_sniffer_proxy_latency_seconds", "")
+}
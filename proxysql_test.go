@@ -0,0 +1,57 @@
+/*
+ * proxysql_test.go
+ *
+ * Time spent in the proxy, from both sides of it.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "math/rand"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+func TestProxyLatency(t *testing.T) {
+    mem := resetState()
+    proxyPort, discovered[6033] = 6033, true
+    defer func() { proxyPort, discovered = 0, make(map[uint16]bool) }()
+    front, app := newTestSoaker()
+    back, proxy := newTestSoaker()
+    front.serverPort, back.server, back.rnd = 6033, [4]byte{10, 0, 0, 2}, rand.New(rand.NewSource(2))
+    proxy.ip, proxy.port = [4]byte{10, 0, 0, 1}, 30000
+    now := front.now
+    front.connect(app)
+    back.connect(proxy)
+    query := mysqlPacket(0, append([]byte{COM_QUERY}, "SELECT * FROM t WHERE id = 1"...))
+    at := func(s *soaker, us int) *soaker {
+        s.now = now.Add(time.Duration(us) * time.Microsecond)
+        return s
+    }
+    at(front, 0).packet(app, true, TCP_ACK, query)
+    at(back, 100).packet(proxy, true, TCP_ACK, query)
+    at(back, 1100).packet(proxy, false, TCP_ACK, mysqlPacket(1, selftestOK))
+    at(front, 1300).packet(app, false, TCP_ACK, mysqlPacket(1, selftestOK))
+
+    var sides []string
+    var proxyTime *float64
+    for _, payload := range mem.payloads {
+        var ev event.QueryEvent
+        json.Unmarshal([]byte(strings.TrimPrefix(payload, event.Prefix)), &ev)
+        sides = append(sides, ev.ProxySide)
+        if ev.ProxyTime != nil {
+            proxyTime = ev.ProxyTime
+        }
+    }
+    if !reflect.DeepEqual(sides, []string{"backend", "frontend"}) || proxyTime == nil || *proxyTime != 300 {
+        t.Errorf("sides %v, proxy time %v", sides, proxyTime)
+    }
+    if len(proxyCalls) != 0 || proxyPending != 0 {
+        t.Errorf("%d calls left", proxyPending)
+    }
+}
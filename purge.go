@@ -0,0 +1,177 @@
+/*
+ * purge.go
+ *
+ * Deleting retained query text on request, e.g. everything mentioning one
+ * person's email address. What we keep beyond the events already sent is:
+ * the slow query examples, the -literal_profile top values, the aggregates
+ * themselves when -u keeps raw queries as their keys, and events waiting in
+ * a spool or a zmq sink's queue. A purge removes whatever in those matches
+ * a regexp:
+ *
+ *   mysql-sniffer purge -control 127.0.0.1:7380 'alice@example\.com'
+ *
+ * which is POST /purge?pattern=... on the control API. Events are matched
+ * on their JSON. Literal profile values masked with the hash can't match
+ * anything but their hash.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "regexp"
+    "time"
+)
+
+type purgeResult struct {
+    Examples      int    `json:"examples"`
+    LiteralValues int    `json:"literal_values"`
+    Fingerprints  int    `json:"fingerprints"`
+    SpooledEvents int    `json:"spooled_events"`
+    Error         string `json:"error,omitempty"`
+}
+
+func (self *purgeResult) add(other purgeResult) {
+    self.Examples += other.Examples
+    self.LiteralValues += other.LiteralValues
+    self.Fingerprints += other.Fingerprints
+    self.SpooledEvents += other.SpooledEvents
+    if other.Error != "" {
+        self.Error = other.Error
+    }
+}
+
+// purgeRetained drops the in-memory state matching re. Must be called with
+// stateLock held.
+func purgeRetained(re *regexp.Regexp) purgeResult {
+    var res purgeResult
+    for key, ex := range examples.worst {
+        matched := re.MatchString(ex.Query)
+        for _, value := range ex.Values {
+            matched = matched || re.MatchString(value)
+        }
+        if matched {
+            delete(examples.worst, key)
+            res.Examples++
+        }
+    }
+    for _, profile := range literalProfiles {
+        for value := range profile.top {
+            if re.MatchString(value) {
+                delete(profile.top, value)
+                res.LiteralValues++
+            }
+        }
+    }
+
+    // Canonical fingerprints hold no values, so they are left alone.
+    if dirty {
+        for key, qdata := range qbuf {
+            if re.MatchString(key) || re.MatchString(qdata.sql) {
+                delete(qbuf, key)
+                res.Fingerprints++
+                for _, sdata := range sbuf {
+                    delete(sdata.fingerprints, key)
+                }
+            }
+        }
+    }
+    return res
+}
+
+// purgeSpools removes matching events from every spool, and from the
+// queues of zmq sinks waiting for their broker. Callers hold sinksLock.
+func purgeSpools(re *regexp.Regexp) (int, error) {
+    total := 0
+    var first error
+    walkSinks(func(s sink) {
+        switch w := s.(type) {
+        case *spoolSink:
+            if first != nil {
+                return
+            }
+            n, err := w.purge(re)
+            total += n
+            first = err
+        case *zmqSink:
+            total += w.purge(re)
+        }
+    })
+    return total, first
+}
+
+func handlePurge(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "POST" {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    pattern := r.FormValue("pattern")
+    if pattern == "" {
+        http.Error(w, "pattern required", http.StatusBadRequest)
+        return
+    }
+    re, err := regexp.Compile(pattern)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    stateLock.Lock()
+    res := purgeRetained(re)
+    stateLock.Unlock()
+    sinksLock.Lock()
+    res.SpooledEvents, err = purgeSpools(re)
+    sinksLock.Unlock()
+    if err != nil {
+        res.Error = err.Error()
+    }
+    log.Printf("Purged %q: %d examples, %d literal values, %d fingerprints, %d spooled events",
+        pattern, res.Examples, res.LiteralValues, res.Fingerprints, res.SpooledEvents)
+
+    w.Header().Set("Content-Type", "application/json")
+    if res.Error != "" {
+        w.WriteHeader(http.StatusInternalServerError)
+    }
+    json.NewEncoder(w).Encode(res)
+}
+
+// postPurge asks the control API at addr to purge pattern.
+func postPurge(addr string, pattern string) (purgeResult, error) {
+    var res purgeResult
+    client := http.Client{Timeout: time.Minute}
+    resp, err := client.PostForm("http://"+addr+"/purge", url.Values{"pattern": {pattern}})
+    if err != nil {
+        return res, err
+    }
+    defer resp.Body.Close()
+    body, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return res, err
+    }
+    if err := json.Unmarshal(body, &res); err != nil {
+        return res, fmt.Errorf("%s: %s", resp.Status, body)
+    }
+    return res, nil
+}
+
+// runPurge is the purge command.
+func runPurge(control string, patterns []string) {
+    if control == "" || len(patterns) != 1 {
+        log.Fatalf("Usage: mysql-sniffer purge -control host:port PATTERN")
+    }
+    res, err := postPurge(control, patterns[0])
+    if err != nil {
+        log.Fatalf("Purge failed: %s", err.Error())
+    }
+    out, _ := json.MarshalIndent(res, "", "  ")
+    fmt.Printf("%s\n", out)
+    if res.Error != "" {
+        os.Exit(1)
+    }
+}
@@ -0,0 +1,103 @@
+/*
+ * quantile.go
+ *
+ * Response time percentiles. Each fingerprint keeps a log-linear histogram of
+ * its response times in the HDR style: every power of two is split into
+ * DIGEST_SUB_BUCKETS buckets, so any percentile is within about 3% of the
+ * true value, every response counts, and a fingerprint needs a few KB at most
+ * instead of a random sample.
+ */
+
+package main
+
+import (
+    "math/bits"
+)
+
+const (
+    DIGEST_SUB_BITS    = 4
+    DIGEST_SUB_BUCKETS = 1 << DIGEST_SUB_BITS
+)
+
+type latencyDigest struct {
+    counts []uint64 // by digestIndex, grown as needed
+    count  uint64
+}
+
+// digestIndex returns the bucket of a value: values below 2*DIGEST_SUB_BUCKETS
+// have one each, above that the top DIGEST_SUB_BITS+1 bits pick it.
+func digestIndex(v uint64) int {
+    if v < 2*DIGEST_SUB_BUCKETS {
+        return int(v)
+    }
+    shift := bits.Len64(v) - DIGEST_SUB_BITS - 1
+    return (shift+1)*DIGEST_SUB_BUCKETS + int(v>>uint(shift)) - DIGEST_SUB_BUCKETS
+}
+
+// digestBounds returns the lowest value of a bucket and the lowest of the next.
+func digestBounds(i int) (uint64, uint64) {
+    if i < 2*DIGEST_SUB_BUCKETS {
+        return uint64(i), uint64(i) + 1
+    }
+    shift := uint(i/DIGEST_SUB_BUCKETS - 1)
+    m := uint64(i%DIGEST_SUB_BUCKETS + DIGEST_SUB_BUCKETS)
+    return m << shift, (m + 1) << shift
+}
+
+func (self *latencyDigest) observe(v uint64) {
+    i := digestIndex(v)
+    if i >= len(self.counts) {
+        counts := make([]uint64, i+1)
+        copy(counts, self.counts)
+        self.counts = counts
+    }
+    self.counts[i]++
+    self.count++
+}
+
+// quantile returns the q-quantile (0..1), interpolated inside its bucket.
+func (self *latencyDigest) quantile(q float64) uint64 {
+    if self.count == 0 {
+        return 0
+    }
+    rank := q * float64(self.count)
+    var seen uint64
+    for i, n := range self.counts {
+        if n > 0 && float64(seen+n) >= rank {
+            lower, upper := digestBounds(i)
+            within := (rank - float64(seen)) / float64(n)
+            if within < 0 {
+                within = 0
+            }
+            return lower + uint64(float64(upper-lower-1)*within)
+        }
+        seen += n
+    }
+    lower, _ := digestBounds(len(self.counts) - 1)
+    return lower
+}
+
+func (self *latencyDigest) clone() latencyDigest {
+    return latencyDigest{counts: append([]uint64(nil), self.counts...), count: self.count}
+}
+
+// since returns what was observed after an earlier clone of the digest.
+func (self *latencyDigest) since(earlier *latencyDigest) latencyDigest {
+    d := self.clone()
+    if earlier.count > d.count {
+        return d
+    }
+    for i, n := range earlier.counts {
+        if i < len(d.counts) && n <= d.counts[i] {
+            d.counts[i] -= n
+        }
+    }
+    d.count -= earlier.count
+    return d
+}
+
+// percentilesMs returns p50, p95 and p99 in milliseconds.
+func (self *latencyDigest) percentilesMs() (float64, float64, float64) {
+    return float64(self.quantile(0.50)) / 1e6, float64(self.quantile(0.95)) / 1e6,
+        float64(self.quantile(0.99)) / 1e6
+}
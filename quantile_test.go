@@ -0,0 +1,34 @@
+/*
+ * quantile_test.go
+ *
+ * The latency digest keeps quantiles within a few percent.
+ */
+
+package main
+
+import (
+    "testing"
+)
+
+func TestLatencyDigest(t *testing.T) {
+    var d latencyDigest
+    for v := uint64(1); v <= 100000; v++ {
+        d.observe(v * 1000)
+    }
+    for _, q := range []float64{0.5, 0.95, 0.99} {
+        got, want := float64(d.quantile(q)), q*1e8
+        if got < want*0.97 || got > want*1.03 {
+            t.Errorf("quantile %v = %v, want about %v", q, got, want)
+        }
+    }
+    for v := uint64(0); v < 1<<20; v += 7 {
+        if lower, upper := digestBounds(digestIndex(v)); v < lower || v >= upper {
+            t.Fatalf("%d not in bucket [%d, %d)", v, lower, upper)
+        }
+    }
+    earlier := d.clone()
+    d.observe(5e9)
+    if later := d.since(&earlier); later.count != 1 || later.quantile(0.5) < 4.8e9 {
+        t.Errorf("since: %d values, median %d", later.count, later.quantile(0.5))
+    }
+}
@@ -0,0 +1,116 @@
+/*
+ * redact.go
+ *
+ * How much of a query each sink is given. A sink's "redact" level in the
+ * -sinks file picks the SQL (and error text) its query events carry:
+ *
+ *   full         the query as the client sent it
+ *   masked       literals masked by -mask_rules (canonical without rules)
+ *   fingerprint  canonical SQL only, and no error text
+ *
+ * Sinks without a level get what -u and -mask_rules say. So one sniffer can
+ * feed the whole query to an audit store and only fingerprints to metrics.
+ * Other event types are the same for every sink.
+ */
+
+package main
+
+import (
+    "fmt"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+const (
+    REDACT_FULL        = "full"
+    REDACT_MASKED      = "masked"
+    REDACT_FINGERPRINT = "fingerprint"
+)
+
+func validRedactLevel(level string) error {
+    switch level {
+    case "", REDACT_FULL, REDACT_MASKED, REDACT_FINGERPRINT:
+        return nil
+    }
+    return fmt.Errorf("unknown redact level %q", level)
+}
+
+// queryViews has what the views of one query event are made from.
+type queryViews struct {
+    raw     string // as sent
+    shown   string // masked, if -mask_rules did so already
+    query   string // aggregation form: canonical unless -u
+    message string // error text as sent
+}
+
+func (self *queryViews) sql(level string) string {
+    switch level {
+    case REDACT_FULL:
+        return self.raw
+    case REDACT_MASKED:
+        if maskRules == nil {
+            break
+        }
+        if self.shown != "" {
+            return self.shown
+        }
+        sql, _ := maskQuery([]byte(self.raw))
+        return sql
+    }
+    if dirty {
+        return cleanupQuery([]byte(self.raw))
+    }
+    return self.query
+}
+
+func (self *queryViews) errorText(level string) string {
+    switch level {
+    case REDACT_FULL:
+        return self.message
+    case REDACT_MASKED:
+        return maskMessage(self.message)
+    }
+    return ""
+}
+
+// hookedViews returns the views of a query event after hooks turned before
+// into after. Where a hook rewrote the SQL or error text, every level is
+// made from what the hook left instead.
+func hookedViews(before, after interface{}, views *queryViews) *queryViews {
+    b, ok := before.(*event.QueryEvent)
+    a, ok2 := after.(*event.QueryEvent)
+    if views == nil || !ok || !ok2 {
+        return nil
+    }
+    hooked := *views
+    if a.Sql != b.Sql {
+        hooked.raw, hooked.shown, hooked.query = a.Sql, "", cleanupQuery([]byte(a.Sql))
+    }
+    if a.Error != b.Error {
+        hooked.message = a.Error
+    }
+    return &hooked
+}
+
+// payloadFor returns the payload for a sink at level, encoding each level
+// at most once. payloads has the default ("") encoding, and extra the
+// fields hooks added.
+func payloadFor(ev interface{}, extra map[string]interface{}, views *queryViews, level string,
+    payloads map[string]string) string {
+    if payload, ok := payloads[level]; ok {
+        return payload
+    }
+    payload := payloads[""]
+    if q, ok := ev.(*event.QueryEvent); ok && views != nil {
+        view := *q
+        view.Sql = views.sql(level)
+        if view.Error != "" {
+            view.Error = views.errorText(level)
+        }
+        if encoded, err := encodeEvent(&view, extra); err == nil {
+            payload = encoded
+        }
+    }
+    payloads[level] = payload
+    return payload
+}
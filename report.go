@@ -0,0 +1,319 @@
+/*
+ * report.go
+ *
+ * The `report` command: run the normal pipeline over a pcap file (-r) or a
+ * live interface for -duration, then print the named reports, e.g.
+ *
+ *   mysql-sniffer report -r capture.pcap queries heatmap examples
+ *
+ * Nothing is published to sinks in this mode. Reports register themselves in
+ * the reports map below.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Latency buckets for the time-of-day heatmap.
+var heatBuckets = []time.Duration{time.Millisecond, 10 * time.Millisecond,
+    100 * time.Millisecond, time.Second, 10 * time.Second}
+var heatLabels = []string{"<1ms", "<10ms", "<100ms", "<1s", "<10s", ">=10s"}
+
+const HEAT_BUCKETS = 6
+
+// A report writes itself as text or, if asJSON, as a JSON document.
+type reportFunc func(w io.Writer, asJSON bool)
+
+var reports = map[string]reportFunc{
+    "queries":      reportQueries,
+    "names":        reportNames,
+    "transactions": reportTransactions,
+    "autocommit":   reportAutocommit,
+    "hints":        reportHints,
+    "literals":     reportLiterals,
+    "workload":     reportWorkload,
+    "attribution":  reportAttribution,
+    "sysbench":     reportSysbench,
+    "oltpbench":    reportOLTPBench,
+    "joingraph":    reportJoinGraph,
+    "heatmap":      reportHeatmap,
+    "examples":     reportExamples,
+    "unused":       reportUnused,
+    "antipatterns": reportAntiPatterns,
+    "offsets":      reportOffsets,
+    "commands":     reportCommands,
+    "errors":       reportErrors,
+    "burstiness":   reportBurstiness,
+}
+
+var reportFormat string = "text"
+
+// reportSpan is how much capture time the report covers, for rates.
+var reportSpan time.Duration
+
+// heatBucket returns the heatmap column for a latency.
+func heatBucket(latency time.Duration) int {
+    for i, limit := range heatBuckets {
+        if latency < limit {
+            return i
+        }
+    }
+    return len(heatBuckets)
+}
+
+// runReport feeds packets from source through the pipeline until the capture
+// ends or duration passes (if non-zero), then writes the named reports.
+func runReport(source CaptureSource, duration time.Duration, names []string) {
+    if len(names) == 0 {
+        var all []string
+        for name := range reports {
+            all = append(all, name)
+        }
+        sort.Strings(all)
+        log.Fatalf("No reports named; available: %s", strings.Join(all, ", "))
+    }
+    for _, name := range names {
+        if _, ok := reports[name]; !ok {
+            log.Fatalf("Unknown report %s", name)
+        }
+    }
+
+    var deadline time.Time
+    if duration > 0 {
+        deadline = time.Now().Add(duration)
+    }
+    var first time.Time
+    var pkt capturedPacket
+    for {
+        if err := source.Next(&pkt); err != nil {
+            if err != io.EOF {
+                log.Printf("Capture failed: %s", err)
+            }
+            break
+        }
+        if pkt.Data != nil {
+            if first.IsZero() {
+                first = pkt.Time
+            }
+            reportSpan = pkt.Time.Sub(first)
+            stateLock.Lock()
+            handlePacket(&pkt)
+            stateLock.Unlock()
+        }
+        if stopping() || (!deadline.IsZero() && time.Now().After(deadline)) {
+            break
+        }
+    }
+
+    stateLock.Lock()
+    defer stateLock.Unlock()
+    if tableUsageFile != "" {
+        if err := saveTableUsage(); err != nil {
+            log.Printf("Failed to save table usage: %s", err)
+        }
+    }
+    for _, name := range names {
+        reports[name](os.Stdout, reportFormat == "json")
+    }
+}
+
+// sortedQueries returns the fingerprints in qbuf, most frequent first,
+// without any hidden by -hide_monitoring.
+func sortedQueries() []string {
+    var list sortableSlice
+    for key, qdata := range qbuf {
+        if qdata.count > 0 && !hiddenQuery(qdata) {
+            list = append(list, sortable{value: -float64(qdata.count), line: key})
+        }
+    }
+    sort.Sort(list)
+    keys := make([]string, len(list))
+    for i, item := range list {
+        keys[i] = item.line
+    }
+    return keys
+}
+
+func reportHeatmap(w io.Writer, asJSON bool) {
+    keys := sortedQueries()
+    if asJSON {
+        type heatmap struct {
+            Fingerprint string                       `json:"fingerprint"`
+            Name        string                       `json:"name,omitempty"`
+            Buckets     []string                     `json:"buckets"`
+            Hours       map[int][HEAT_BUCKETS]uint64 `json:"hours"`
+        }
+        var out []heatmap
+        for _, key := range keys {
+            hm := heatmap{Fingerprint: key, Name: nameOf(key), Buckets: heatLabels,
+                Hours: make(map[int][HEAT_BUCKETS]uint64)}
+            for hour, row := range qbuf[key].hours {
+                if row != [HEAT_BUCKETS]uint64{} {
+                    hm.Hours[hour] = row
+                }
+            }
+            out = append(out, hm)
+        }
+        json.NewEncoder(w).Encode(map[string]interface{}{"heatmap": out})
+        return
+    }
+
+    for _, key := range keys {
+        qdata := qbuf[key]
+        fmt.Fprintf(w, "%s== heatmap: %s (%d queries)%s\n", color(COLOR_CYAN), labelOf(key),
+            qdata.count, color(COLOR_DEFAULT))
+        fmt.Fprintf(w, "hour")
+        for _, label := range heatLabels {
+            fmt.Fprintf(w, " %8s", label)
+        }
+        fmt.Fprintf(w, "\n")
+        for hour, row := range qdata.hours {
+            if row == [HEAT_BUCKETS]uint64{} {
+                continue
+            }
+            fmt.Fprintf(w, "  %02d", hour)
+            for _, n := range row {
+                fmt.Fprintf(w, " %8d", n)
+            }
+            fmt.Fprintf(w, "\n")
+        }
+        fmt.Fprintf(w, "\n")
+    }
+}
+
+func reportExamples(w io.Writer, asJSON bool) {
+    var list []*example
+    for _, ex := range examples.all() {
+        if qdata, ok := qbuf[ex.Fingerprint]; !ok || !hiddenQuery(qdata) {
+            list = append(list, ex)
+        }
+    }
+    if asJSON {
+        json.NewEncoder(w).Encode(map[string]interface{}{"examples": list})
+        return
+    }
+    for _, ex := range list {
+        fmt.Fprintf(w, "%s== slowest: %s%s\n", color(COLOR_CYAN), labelOf(ex.Fingerprint),
+            color(COLOR_DEFAULT))
+        fmt.Fprintf(w, "   %.3fms from %s at %s\n", ex.Latency, ex.Source,
+            ex.Seen.Format(time.RFC3339))
+        fmt.Fprintf(w, "   %s\n   values: %s\n\n", highlightSQL(ex.Query, colorStdout), strings.Join(ex.Values, ", "))
+    }
+}
+
+// perSecond turns a count into a rate over the report span.
+func perSecond(n uint64) float64 {
+    if reportSpan <= 0 {
+        return 0
+    }
+    return float64(n) / reportSpan.Seconds()
+}
+
+// avgMs is the average of total nanoseconds over n, in milliseconds.
+func avgMs(total uint64, n uint64) float64 {
+    if n == 0 {
+        return 0
+    }
+    return float64(total) / float64(n) / 1e6
+}
+
+type queryTotals struct {
+    Fingerprint string   `json:"fingerprint"`
+    Name        string   `json:"name,omitempty"`
+    Count       uint64   `json:"count"`
+    QPS         float64  `json:"qps"`
+    Bytes       uint64   `json:"bytes"`
+    AvgMs       float64  `json:"avg_ms"`
+    P50Ms       float64  `json:"p50_ms"`
+    P95Ms       float64  `json:"p95_ms"`
+    P99Ms       float64  `json:"p99_ms"`
+    Errors      uint64   `json:"errors"`
+    SuccessRate *float64 `json:"success_rate,omitempty"`
+}
+
+type sourceTotals struct {
+    Source       string  `json:"source"`
+    Fingerprints int     `json:"fingerprints"`
+    Queries      uint64  `json:"queries"`
+    QPS          float64 `json:"qps"`
+    Bytes        uint64  `json:"bytes"`
+    AvgMs        float64 `json:"avg_ms"`
+}
+
+// reportQueries lists totals per fingerprint and, if the format key has #s
+// or #i in it, per source as well.
+func reportQueries(w io.Writer, asJSON bool) {
+    var queries []queryTotals
+    for _, key := range sortedQueries() {
+        qdata := qbuf[key]
+        q := queryTotals{Fingerprint: key, Name: nameOf(key), Count: qdata.count,
+            QPS: perSecond(qdata.count), Bytes: qdata.bytes,
+            AvgMs: avgMs(qdata.timeTotal, qdata.timed)}
+        q.P50Ms, q.P95Ms, q.P99Ms = qdata.times.percentilesMs()
+        q.Errors = qdata.errs
+        if rate, ok := successRate(qdata.oks, qdata.errs); ok {
+            q.SuccessRate = &rate
+        }
+        queries = append(queries, q)
+    }
+    var sources []sourceTotals
+    for _, key := range sortedSources() {
+        sdata := sbuf[key]
+        sources = append(sources, sourceTotals{Source: key,
+            Fingerprints: len(sdata.fingerprints), Queries: sdata.queries,
+            QPS: perSecond(sdata.queries), Bytes: sdata.bytes,
+            AvgMs: avgMs(sdata.timeTotal, sdata.timed)})
+    }
+
+    monFingerprints, monQueries := monitoringTotals()
+    if asJSON {
+        out := map[string]interface{}{"queries": queries,
+            "monitoring": map[string]interface{}{"fingerprints": monFingerprints,
+                "queries": monQueries, "hidden": hideMonitoring}}
+        if sourceField != F_NONE {
+            out["sources"] = sources
+        }
+        json.NewEncoder(w).Encode(out)
+        return
+    }
+
+    fmt.Fprintf(w, "%s== queries (%d fingerprints over %s)%s\n", color(COLOR_CYAN),
+        len(queries), reportSpan, color(COLOR_DEFAULT))
+    fmt.Fprintf(w, "%10s %10s %12s %10s %10s %10s %10s %7s  %s\n", "count", "qps", "bytes", "avg ms",
+        "p50 ms", "p95 ms", "p99 ms", "ok%", "fingerprint")
+    for _, q := range queries {
+        fmt.Fprintf(w, "%10d %10.2f %12d %10.3f %10.3f %10.3f %10.3f %7s  %s\n", q.Count, q.QPS, q.Bytes,
+            q.AvgMs, q.P50Ms, q.P95Ms, q.P99Ms, percentOf(q.SuccessRate), labelOf(q.Fingerprint))
+    }
+    if monQueries > 0 {
+        verb := "included above"
+        if hideMonitoring {
+            verb = "not shown"
+        }
+        fmt.Fprintf(w, "%10d monitoring queries in %d fingerprints, %s\n", monQueries,
+            monFingerprints, verb)
+    }
+    fmt.Fprintf(w, "\n")
+    if sourceField == F_NONE {
+        return
+    }
+
+    fmt.Fprintf(w, "%s== sources (%d)%s\n", color(COLOR_CYAN), len(sources),
+        color(COLOR_DEFAULT))
+    fmt.Fprintf(w, "%10s %10s %12s %10s %12s  %s\n", "queries", "qps", "bytes", "avg ms",
+        "fingerprints", "source")
+    for _, s := range sources {
+        fmt.Fprintf(w, "%10d %10.2f %12d %10.3f %12d  %s\n", s.Queries, s.QPS, s.Bytes,
+            s.AvgMs, s.Fingerprints, s.Source)
+    }
+    fmt.Fprintf(w, "\n")
+}
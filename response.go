@@ -0,0 +1,342 @@
+/*
+ * response.go
+ *
+ * Following responses to their end. Queries are published once their whole
+ * response has gone by rather than at its first byte, so the event can say
+ * how long the response took to drain and whose fault a slow one was.
+ *
+ * The framer walks the MySQL packets of a response: an OK or ERR on its own,
+ * or a result set (column count, column definitions, an EOF unless the client
+ * has CLIENT_DEPRECATE_EOF, rows, and a final EOF/OK). Rows are skipped over
+ * without being looked at or copied. If the status flags of the final OK/EOF
+ * say more results exist, another result set or OK follows. Along the way
+ * we keep what the response says happened: rows returned, rows affected
+ * and the last insert id from OKs, or the error from an ERR.
+ *
+ * While a large response drains, each gap between server segments is put
+ * down to the server if it just didn't send, or to the client if the server
+ * picked up right after a client ACK, i.e. it was waiting for window. A
+ * client advertising a zero window is slow to read by definition.
+ */
+
+package main
+
+import (
+    "time"
+)
+
+const (
+    RESP_START = iota
+    RESP_COLUMNS
+    RESP_COLUMNS_EOF
+    RESP_ROWS
+    RESP_DONE
+
+    // Packets we buffer to look at are OK/ERR/EOF and column counts; anything
+    // bigger than this is something we got wrong.
+    RESP_MAX_BUFFERED = 64 * 1024
+
+    // Responses smaller than this finish too fast to say anything about
+    // pacing.
+    DRAIN_MIN_BYTES = 64 * 1024
+
+    // A server segment this soon after a client ACK was waiting for it.
+    ACK_TRIGGER = time.Millisecond
+
+    SLOW_SERVER = "server"
+    SLOW_CLIENT = "client"
+)
+
+type respFramer struct {
+    state   int
+    columns uint64 // column definitions still to come
+    partial []byte // start of a packet split across segments
+    skip    int    // bytes left of a packet we aren't looking at
+    results int    // result sets and OKs so far
+    status  uint16 // flags from the last OK/EOF
+    flagged bool   // whether status was seen
+    inSet   bool   // in a result set rather than an OK
+    rows    uint64 // rows so far in the current result set
+    sets    []uint64
+
+    oks      int // OKs not ending a result set
+    affected uint64
+    insertId uint64
+    err      *mysqlError
+}
+
+type mysqlError struct {
+    code    uint16
+    state   string
+    message string
+}
+
+// parseErr reads an ERR packet (payload including the 0xff header).
+func parseErr(payload []byte) *mysqlError {
+    e := &mysqlError{}
+    if len(payload) >= 3 {
+        e.code = uint16(payload[1]) | uint16(payload[2])<<8
+        rest := payload[3:]
+        if len(rest) >= 6 && rest[0] == '#' {
+            e.state, rest = string(rest[1:6]), rest[6:]
+        }
+        e.message = string(rest)
+    }
+    return e
+}
+
+// rowsReturned totals the rows of all result sets so far.
+func (f *respFramer) rowsReturned() (uint64, bool) {
+    var total uint64
+    for _, n := range f.sets {
+        total += n
+    }
+    return total, len(f.sets) > 0
+}
+
+// requestClock is the time requests' ages are measured against: the wall
+// clock when capturing live, so a stalled server shows even when no packets
+// arrive, or the capture's clock when reading a file.
+func requestClock() time.Time {
+    if measureLatency {
+        return time.Now()
+    }
+    return pktTime
+}
+
+// waiting returns how long the request on rs has been waiting for the
+// first byte of its response, or 0 if there is none.
+func waiting(rs *source, now time.Time) time.Duration {
+    if rs.reqSent == nil {
+        return 0
+    }
+    return now.Sub(*rs.reqSent)
+}
+
+// oldestRequest returns how long the longest waiting request across all
+// streams has waited. A request whose response was never seen counts until
+// the next request on its stream or -idle_timeout. Must be called with
+// stateLock held.
+func oldestRequest(now time.Time) time.Duration {
+    var oldest time.Duration
+    eachStream(func(rs *source) {
+        if age := waiting(rs, now); age > oldest {
+            oldest = age
+        }
+    })
+    return oldest
+}
+
+// responseTiming is what we learn about pacing while a response drains.
+type responseTiming struct {
+    reqtime     uint64 // to the first byte, nanoseconds
+    firstByte   time.Time
+    lastByte    time.Time
+    lastAck     time.Time
+    serverWait  time.Duration
+    clientWait  time.Duration
+    zeroWindows uint64
+}
+
+// feed takes the next segment of a response and returns true once the
+// response is complete.
+func (f *respFramer) feed(rs *source, data []byte) bool {
+    for len(data) > 0 && f.state != RESP_DONE {
+        if f.skip > 0 {
+            n := f.skip
+            if n > len(data) {
+                n = len(data)
+            }
+            f.skip -= n
+            data = data[n:]
+            continue
+        }
+        if f.partial != nil {
+            data = append(f.partial, data...)
+            f.partial = nil
+        }
+        if len(data) < 4 {
+            f.partial = append([]byte(nil), data...)
+            return false
+        }
+        size := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+        if size == 0 {
+            // Only the tail of a packet split at 16M is empty, and a
+            // response never starts with one; there is nothing to read.
+            data = data[4:]
+            continue
+        }
+        if len(data) < 5 {
+            f.partial = append([]byte(nil), data...)
+            return false
+        }
+        first := data[4]
+
+        // Column definitions and rows are skipped, except for what may end
+        // the result set.
+        if f.state == RESP_COLUMNS_EOF && first != 0xfe && first != 0xff {
+            f.state = RESP_ROWS // CLIENT_DEPRECATE_EOF: straight to the rows
+        }
+        if f.state == RESP_COLUMNS || (f.state == RESP_ROWS && first != 0xfe && first != 0xff) {
+            if f.state == RESP_COLUMNS {
+                if f.columns--; f.columns == 0 {
+                    f.state = RESP_COLUMNS_EOF
+                }
+            } else {
+                f.rows++
+            }
+            f.skip = size + 4
+            continue
+        }
+        if len(data) < size+4 {
+            if size+4 > RESP_MAX_BUFFERED {
+                f.state = RESP_DONE
+                return true
+            }
+            f.partial = append([]byte(nil), data...)
+            return false
+        }
+        f.packet(rs, data[4:size+4])
+        data = data[size+4:]
+    }
+    return f.state == RESP_DONE
+}
+
+// end is called at the OK/EOF ending a result, with its status flags.
+func (f *respFramer) end(status uint16, valid bool) {
+    f.results++
+    if f.inSet {
+        f.sets = append(f.sets, f.rows)
+        f.inSet, f.rows = false, 0
+    }
+    f.status, f.flagged = status, valid
+    if valid && status&SERVER_MORE_RESULTS_EXISTS != 0 {
+        f.state = RESP_START
+    } else {
+        f.state = RESP_DONE
+    }
+}
+
+// packet handles one packet that decides where the response is going.
+func (f *respFramer) packet(rs *source, payload []byte) {
+    if len(payload) == 0 {
+        return
+    }
+    first := payload[0]
+    switch f.state {
+    case RESP_START:
+        switch first {
+        case 0x00:
+            if rs.command == COM_STMT_PREPARE {
+                notePrepareOK(rs, payload)
+                f.state = RESP_DONE // not a real OK
+                return
+            }
+            affected, used := readLenencInt(payload[1:])
+            insertId, used2 := readLenencInt(payload[1+used:])
+            if used > 0 && used2 > 0 {
+                f.oks++
+                f.affected += affected
+                if insertId != 0 {
+                    f.insertId = insertId
+                }
+            }
+            f.end(noteOK(rs, payload))
+        case 0xff:
+            f.err = parseErr(payload)
+            f.results++
+            f.state = RESP_DONE
+        case 0xfb: // LOCAL INFILE, which the client answers
+            f.results++
+            f.state = RESP_DONE
+        default:
+            n, used := readLenencInt(payload)
+            if used == 0 || n == 0 {
+                f.state = RESP_DONE
+                return
+            }
+            f.columns, f.state, f.inSet = n, RESP_COLUMNS, true
+        }
+    case RESP_COLUMNS_EOF:
+        f.state = RESP_ROWS
+        if first == 0xfe && len(payload) == 5 {
+            return // the EOF after the column definitions
+        }
+        f.packet(rs, payload) // an empty result set's terminator, or ERR
+    case RESP_ROWS:
+        switch {
+        case first == 0xff:
+            f.err = parseErr(payload)
+            f.results++
+            f.state = RESP_DONE
+        case len(payload) == 5:
+            f.end(uint16(payload[3])|uint16(payload[4])<<8, true)
+        default:
+            f.end(noteOK(rs, payload))
+        }
+    }
+}
+
+// startResponse is called on the first byte of the response to a query.
+func startResponse(rs *source, reqtime uint64) {
+    rs.pending = true
+    rs.timing = responseTiming{reqtime: reqtime, firstByte: pktTime, lastByte: pktTime}
+}
+
+// noteResponseSegment accounts the gap since the previous server segment of a
+// response to whoever caused it.
+func noteResponseSegment(rs *source) {
+    t := &rs.timing
+    gap := pktTime.Sub(t.lastByte)
+    if t.lastAck.After(t.lastByte) && pktTime.Sub(t.lastAck) < ACK_TRIGGER {
+        t.clientWait += gap
+    } else {
+        t.serverWait += gap
+    }
+    t.lastByte = pktTime
+}
+
+// noteClientAck is called for a bare ACK from the client of a stream.
+func noteClientAck(rs *source, window uint16) {
+    if !rs.pending {
+        return
+    }
+    rs.timing.lastAck = pktTime
+    if window == 0 {
+        rs.timing.zeroWindows++
+    }
+}
+
+// slowSide says whether a response was held up more by the server or by the
+// client reading it, or "" if it was too small to tell.
+func slowSide(rs *source) string {
+    t := &rs.timing
+    if rs.resbytes < DRAIN_MIN_BYTES {
+        return ""
+    }
+    drain := t.lastByte.Sub(t.firstByte)
+    if drain < time.Duration(t.reqtime) {
+        return SLOW_SERVER // waiting for the first byte dominates
+    }
+    if t.zeroWindows > 0 || t.clientWait > t.serverWait {
+        return SLOW_CLIENT
+    }
+    return SLOW_SERVER
+}
+
+// finishResponse closes off the response to the previous query on a stream,
+// once it's complete or we know no more of it is coming, and publishes the
+// query.
+func finishResponse(rs *source) {
+    if rs.pending {
+        rs.pending = false
+        noteOutcome(rs)
+        noteScanResponse(rs)
+        noteTxResponseEnd(rs)
+        noteRoutines(rs)
+        noteHistory(rs, true)
+        publishQuery(rs.src, rs, rs.timing.reqtime, true)
+    }
+    rs.resbytes = 0
+    rs.resp = respFramer{}
+}
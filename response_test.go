@@ -0,0 +1,56 @@
+/*
+ * response_test.go
+ *
+ * Response framing on what servers actually send, and on what they don't,
+ * and how long the oldest request has been waiting for one.
+ */
+
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestEmptyResponsePacket(t *testing.T) {
+    mem := resetState()
+    s, c := newTestSoaker()
+    s.connect(c)
+
+    // A zero-length packet where the response should start used to be
+    // read past its end.
+    s.packet(c, true, TCP_ACK, mysqlPacket(0, append([]byte{COM_QUERY}, "SELECT 1"...)))
+    s.packet(c, false, TCP_ACK, []byte{0, 0, 0, 1, 0, 0, 0})
+
+    // One ahead of a real response is skipped over.
+    s.packet(c, true, TCP_ACK, mysqlPacket(0, append([]byte{COM_QUERY}, "SELECT 2"...)))
+    s.packet(c, false, TCP_ACK, append(mysqlPacket(1, nil), mysqlPacket(2, selftestOK)...))
+    if len(mem.payloads) == 0 {
+        t.Errorf("nothing published after the empty packet")
+    }
+
+    var f respFramer
+    if !f.feed(newSource("10.1.0.2:40000"), append(mysqlPacket(1, nil), mysqlPacket(2, selftestOK)...)) {
+        t.Errorf("response not done: state %d", f.state)
+    }
+    f.packet(nil, nil)
+}
+
+func TestOldestRequest(t *testing.T) {
+    resetState()
+    s, a := newTestSoaker()
+    b := &soakConn{ip: [4]byte{10, 1, 0, 2}, port: 20000}
+    s.connect(a)
+    s.connect(b)
+    query := mysqlPacket(0, append([]byte{COM_QUERY}, "SELECT SLEEP(?)"...))
+    s.packet(a, true, TCP_ACK, query)
+    s.now = s.now.Add(2 * time.Second)
+    s.packet(b, true, TCP_ACK, query)
+    if age := oldestRequest(s.now.Add(time.Second)); age != 3*time.Second {
+        t.Errorf("oldest %s", age)
+    }
+    s.packet(a, false, TCP_ACK, mysqlPacket(1, selftestOK))
+    if age := oldestRequest(s.now.Add(time.Second)); age != time.Second {
+        t.Errorf("oldest %s after the first answer", age)
+    }
+}
@@ -0,0 +1,58 @@
+/*
+ * sampling.go
+ *
+ * Publishing only some of the query events on busy servers. -sample keeps
+ * each with the given probability and -max_qps caps how many go out per
+ * second; the counters, reports and summaries still see every query, since
+ * this is decided just before publishing. Slow queries and errors are always
+ * published. Sampled events carry the -sample rate so consumers can scale
+ * counts back up; what -max_qps drops isn't reflected there.
+ */
+
+package main
+
+import (
+    "fmt"
+    "math/rand"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+var sampleRate float64 = 1
+var maxQPS int
+var sampleSecond int64
+var sampleSent int
+
+func validSampleRate(rate float64) error {
+    if rate <= 0 || rate > 1 {
+        return fmt.Errorf("-sample %g: want a fraction above 0, up to 1", rate)
+    }
+    return nil
+}
+
+// keepSample says if a query event is to be published, setting its sample
+// rate if so.
+func keepSample(ev *event.QueryEvent) bool {
+    if ev.Slow || ev.ErrorCode != 0 {
+        return true
+    }
+    if sampleRate < 1 && rand.Float64() >= sampleRate {
+        stats.sampled_out++
+        return false
+    }
+    if maxQPS > 0 {
+        if now := time.Now().Unix(); now != sampleSecond {
+            sampleSecond, sampleSent = now, 0
+        }
+        if sampleSent >= maxQPS {
+            stats.sampled_out++
+            return false
+        }
+        sampleSent++
+    }
+    if sampleRate < 1 {
+        ev.SampleRate = sampleRate
+    }
+    return true
+}
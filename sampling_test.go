@@ -0,0 +1,45 @@
+/*
+ * sampling_test.go
+ *
+ * Sampling by -sample and -max_qps, keeping slow and failed queries.
+ */
+
+package main
+
+import (
+    "testing"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+func TestSampling(t *testing.T) {
+    defer func() { sampleRate, maxQPS, stats.sampled_out = 1, 0, 0 }()
+    sampleRate, maxQPS = 0.25, 0
+    kept := 0
+    for i := 0; i < 4000; i++ {
+        ev := &event.QueryEvent{}
+        if keepSample(ev) {
+            kept++
+            if ev.SampleRate != 0.25 {
+                t.Fatalf("sample rate %g", ev.SampleRate)
+            }
+        }
+    }
+    if kept < 800 || kept > 1200 || stats.sampled_out != uint64(4000-kept) {
+        t.Errorf("kept %d of 4000, %d counted out", kept, stats.sampled_out)
+    }
+    if !keepSample(&event.QueryEvent{Slow: true}) || !keepSample(&event.QueryEvent{ErrorCode: 1064}) {
+        t.Errorf("slow or failed query sampled out")
+    }
+
+    sampleRate, maxQPS, sampleSecond = 1, 10, 0
+    kept = 0
+    for i := 0; i < 100; i++ {
+        if keepSample(&event.QueryEvent{}) {
+            kept++
+        }
+    }
+    if kept < 10 || kept > 20 { // the second may turn over once
+        t.Errorf("kept %d with -max_qps 10", kept)
+    }
+}
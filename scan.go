@@ -0,0 +1,118 @@
+/*
+ * scan.go
+ *
+ * Spotting probable full table scans from the wire. If a fingerprint's
+ * average result size keeps growing window after window, roughly in a
+ * straight line, it is most likely reading a table that keeps growing rather
+ * than a bounded set of rows. That's only a heuristic, so what we publish is
+ * an advisory, not a verdict.
+ */
+
+package main
+
+import (
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+const (
+    SCAN_WINDOWS  = 6    // windows of history considered
+    SCAN_GROWTH   = 0.2  // required growth from first to last window
+    SCAN_MIN_FIT  = 0.9  // required r^2 of the linear fit
+    SCAN_MIN_RUNS = 10   // executions needed for a window to count
+)
+
+type scanStats struct {
+    bytes   uint64 // result bytes this window
+    count   uint64 // executions this window
+    history []float64
+    advised bool
+}
+
+var scanWindow time.Duration
+
+// noteScanResponse counts the size of a finished response.
+func noteScanResponse(rs *source) {
+    if rs.qdata != nil && rs.resbytes > 0 {
+        rs.qdata.scan.bytes += rs.resbytes
+        rs.qdata.scan.count++
+    }
+}
+
+// linearFit returns the slope and r^2 of a least squares line through ys
+// at x = 0, 1, 2, ...
+func linearFit(ys []float64) (slope float64, r2 float64) {
+    n := float64(len(ys))
+    var sx, sy, sxx, sxy, syy float64
+    for i, y := range ys {
+        x := float64(i)
+        sx += x
+        sy += y
+        sxx += x * x
+        sxy += x * y
+        syy += y * y
+    }
+    den := n*sxx - sx*sx
+    if den == 0 {
+        return 0, 0
+    }
+    cov := n*sxy - sx*sy
+    slope = cov / den
+    vy := n*syy - sy*sy
+    if vy == 0 {
+        return slope, 0
+    }
+    return slope, cov * cov / (den * vy)
+}
+
+// checkScans closes the current window for every fingerprint and publishes an
+// advisory for any that now look like full scans. Must be called with
+// stateLock held.
+func checkScans() {
+    for key, qdata := range qbuf {
+        sc := &qdata.scan
+        if sc.count < SCAN_MIN_RUNS {
+            sc.bytes, sc.count = 0, 0
+            continue
+        }
+        sc.history = append(sc.history, float64(sc.bytes)/float64(sc.count))
+        if len(sc.history) > SCAN_WINDOWS {
+            sc.history = sc.history[1:]
+        }
+        sc.bytes, sc.count = 0, 0
+        if len(sc.history) < SCAN_WINDOWS || sc.advised {
+            continue
+        }
+
+        first, last := sc.history[0], sc.history[len(sc.history)-1]
+        slope, r2 := linearFit(sc.history)
+        if slope <= 0 || first <= 0 || (last-first)/first < SCAN_GROWTH || r2 < SCAN_MIN_FIT {
+            continue
+        }
+        sc.advised = true
+        msg := fmt.Sprintf("result size of %s grew from %.0f to %.0f bytes over %d windows "+
+            "(r^2 %.2f); probable full scan", key, first, last, len(sc.history), r2)
+        log.Printf("Advisory: %s", msg)
+        publish(&event.AlertEvent{
+            Type:      event.TYPE_ALERT,
+            ServiceId: service_id,
+            TenantId:  tenant_id,
+            Kind:      "probable_full_scan",
+            Message:   msg,
+            Value:     last,
+            Limit:     first,
+        })
+    }
+}
+
+// runScanDetection closes a scan window every scanWindow.
+func runScanDetection() {
+    for _ = range time.Tick(scanWindow) {
+        stateLock.Lock()
+        checkScans()
+        stateLock.Unlock()
+    }
+}
@@ -0,0 +1,99 @@
+/*
+ * schemas.go
+ *
+ * Schema-qualified table names (-schema_names). By default "shop.orders" and
+ * "orders" sent after USE shop are different fingerprints. With "strip" the
+ * schema is dropped from every table a query names, so both read "orders"
+ * (as do other schemas' orders tables); with "qualify" tables named without
+ * a schema get the connection's current one, when it's known, so both read
+ * "shop.orders". Either way the fingerprint's tables, as the unused and
+ * access reports see them, follow.
+ */
+
+package main
+
+import (
+    "fmt"
+    "strings"
+)
+
+const (
+    SCHEMA_NAMES_KEEP    = "keep"
+    SCHEMA_NAMES_STRIP   = "strip"
+    SCHEMA_NAMES_QUALIFY = "qualify"
+)
+
+var schemaNames string = SCHEMA_NAMES_KEEP
+
+func validSchemaNames(mode string) error {
+    switch mode {
+    case SCHEMA_NAMES_KEEP, SCHEMA_NAMES_STRIP, SCHEMA_NAMES_QUALIFY:
+        return nil
+    }
+    return fmt.Errorf("unknown -schema_names %q", mode)
+}
+
+// normalizeSchemas rewrites the tables a canonical query names after FROM,
+// JOIN, UPDATE, INTO or TRUNCATE TABLE as -schema_names asks, db being the
+// connection's current schema.
+func normalizeSchemas(query string, db string) string {
+    if schemaNames == SCHEMA_NAMES_KEEP || (schemaNames == SCHEMA_NAMES_QUALIFY && db == "") {
+        return query
+    }
+    data := []byte(query)
+    tokens := maskTokens(data)
+    // Start of a token, with its opening backquote.
+    startOf := func(t maskToken) int {
+        if t.start > 0 && data[t.start-1] == '`' {
+            return t.start - 1
+        }
+        return t.start
+    }
+    isName := func(j int) bool {
+        return j < len(tokens) && tokens[j].toktype == TOKEN_WORD && isIdentifier(tokens[j].word) &&
+            !joinKeywords[tokens[j].word]
+    }
+
+    var out strings.Builder
+    out.Grow(len(query) + len(db))
+    last := 0
+    for i := 0; i+1 < len(tokens); i++ {
+        switch tokens[i].word {
+        case "from", "join", "into":
+        case "table":
+            if i == 0 || tokens[i-1].word != "truncate" {
+                continue
+            }
+        case "update":
+            if i > 0 && tokens[i-1].word == "key" {
+                continue // ON DUPLICATE KEY UPDATE
+            }
+        default:
+            continue
+        }
+        // FROM a, b lists more than one.
+        for j := i + 1; isName(j); {
+            if j+2 < len(tokens) && tokens[j+1].word == "." && tokens[j+2].toktype == TOKEN_WORD {
+                if schemaNames == SCHEMA_NAMES_STRIP {
+                    out.WriteString(query[last:startOf(tokens[j])])
+                    last = startOf(tokens[j+2])
+                }
+                j += 2
+            } else if schemaNames == SCHEMA_NAMES_QUALIFY {
+                out.WriteString(query[last:startOf(tokens[j])])
+                out.WriteString(db + ".")
+                last = startOf(tokens[j])
+            }
+            j++
+            for isName(j) {
+                j++ // alias
+            }
+            if j+1 >= len(tokens) || tokens[j].word != "," || tokens[i].word != "from" {
+                break
+            }
+            j++
+        }
+    }
+    out.WriteString(query[last:])
+    return out.String()
+}
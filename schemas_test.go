@@ -0,0 +1,32 @@
+/*
+ * schemas_test.go
+ *
+ * Schema names stripped from or added to fingerprints.
+ */
+
+package main
+
+import (
+    "testing"
+)
+
+func TestNormalizeSchemas(t *testing.T) {
+    defer func() { schemaNames = SCHEMA_NAMES_KEEP }()
+    for _, c := range []struct {
+        mode, db, query, want string
+    }{
+        {"keep", "shop", "SELECT * FROM shop.orders", "SELECT * FROM shop.orders"},
+        {"strip", "", "SELECT * FROM shop.orders o JOIN `shop`.`items` i ON o.id = i.oid",
+            "SELECT * FROM orders o JOIN `items` i ON o.id = i.oid"},
+        {"strip", "", "INSERT INTO shop.orders (id) VALUES (?)", "INSERT INTO orders (id) VALUES (?)"},
+        {"qualify", "shop", "SELECT * FROM orders, other.items WHERE orders.id = ?",
+            "SELECT * FROM shop.orders, other.items WHERE orders.id = ?"},
+        {"qualify", "shop", "UPDATE `orders` SET n = ?", "UPDATE shop.`orders` SET n = ?"},
+        {"qualify", "", "SELECT * FROM orders", "SELECT * FROM orders"},
+    } {
+        schemaNames = c.mode
+        if got := normalizeSchemas(c.query, c.db); got != c.want {
+            t.Errorf("%s %q: got %q, want %q", c.mode, c.query, got, c.want)
+        }
+    }
+}
@@ -0,0 +1,147 @@
+/*
+ * secrets.go
+ *
+ * Credentials for sinks. Passing a password as a flag value leaves it in ps
+ * output and shell history, so credential flags accept "file:/path" or
+ * "env:NAME" instead, and file-backed secrets are re-read when they change
+ * (e.g. when a secret manager rotates them). Only watched secrets are
+ * polled, until whatever watches them lets go, e.g. when its sink closes.
+ */
+
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+const SECRET_POLL = 5 * time.Second
+
+type secret struct {
+    name     string // flag the secret came from, for messages
+    path     string // only set for file: secrets
+    value    string
+    mtime    time.Time
+    onChange func(value string)
+}
+
+var secretsLock sync.Mutex
+var fileSecrets []*secret
+var watchingSecrets bool = false
+
+// loadSecret resolves the value of a credential flag. "file:/path" reads the
+// file (trailing newlines stripped), which watch can then follow; "env:NAME"
+// reads an environment variable. Anything else is used literally, with a
+// warning, since it is then visible to other users of the host.
+func loadSecret(name, spec string) (*secret, error) {
+    s := &secret{name: name}
+    switch {
+    case spec == "":
+        // no secret
+    case strings.HasPrefix(spec, "file:"):
+        s.path = spec[len("file:"):]
+        if err := s.read(); err != nil {
+            return nil, fmt.Errorf("-%s: %s", name, err)
+        }
+    case strings.HasPrefix(spec, "env:"):
+        v, ok := os.LookupEnv(spec[len("env:"):])
+        if !ok {
+            return nil, fmt.Errorf("-%s: environment variable %s is not set",
+                name, spec[len("env:"):])
+        }
+        s.value = v
+    default:
+        log.Printf("Warning: -%s given inline is visible in the process list; "+
+            "use file:/path or env:NAME instead", name)
+        s.value = spec
+    }
+    return s, nil
+}
+
+// watch calls onChange with the new value each time a file-backed secret
+// changes, until the returned release is called. Other secrets never change.
+func (s *secret) watch(onChange func(value string)) (release func()) {
+    if s.path == "" {
+        return func() {}
+    }
+    secretsLock.Lock()
+    defer secretsLock.Unlock()
+    s.onChange = onChange
+    fileSecrets = append(fileSecrets, s)
+    if !watchingSecrets {
+        watchingSecrets = true
+        go watchSecrets()
+    }
+    return func() {
+        secretsLock.Lock()
+        defer secretsLock.Unlock()
+        for i, w := range fileSecrets {
+            if w == s {
+                fileSecrets = append(fileSecrets[:i:i], fileSecrets[i+1:]...)
+                break
+            }
+        }
+        s.onChange = nil
+    }
+}
+
+// Value returns the current value of the secret.
+func (s *secret) Value() string {
+    secretsLock.Lock()
+    defer secretsLock.Unlock()
+    return s.value
+}
+
+// read loads a file-backed secret. Callers other than loadSecret must hold
+// secretsLock.
+func (s *secret) read() error {
+    fi, err := os.Stat(s.path)
+    if err != nil {
+        return err
+    }
+    data, err := ioutil.ReadFile(s.path)
+    if err != nil {
+        return err
+    }
+    s.value = strings.TrimRight(string(data), "\r\n")
+    s.mtime = fi.ModTime()
+    return nil
+}
+
+// watchSecrets polls file-backed secrets and re-reads any whose modification
+// time changed, notifying the owner if the value is different.
+func watchSecrets() {
+    for _ = range time.Tick(SECRET_POLL) {
+        var changed []*secret
+        var notify []func(string)
+        secretsLock.Lock()
+        for _, s := range fileSecrets {
+            fi, err := os.Stat(s.path)
+            if err != nil || fi.ModTime().Equal(s.mtime) {
+                continue
+            }
+            old := s.value
+            if err := s.read(); err != nil {
+                log.Printf("Failed to reload secret for -%s: %s", s.name, err)
+                continue
+            }
+            if s.value != old {
+                changed = append(changed, s)
+                notify = append(notify, s.onChange)
+            }
+        }
+        secretsLock.Unlock()
+
+        for i, s := range changed {
+            log.Printf("Reloaded secret for -%s", s.name)
+            if notify[i] != nil {
+                notify[i](s.Value())
+            }
+        }
+    }
+}
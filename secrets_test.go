@@ -0,0 +1,101 @@
+/*
+ * secrets_test.go
+ *
+ * File-backed secrets are only followed while something watches them, and
+ * literal ones are never shown.
+ */
+
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func watchedSecrets() int {
+    secretsLock.Lock()
+    defer secretsLock.Unlock()
+    return len(fileSecrets)
+}
+
+func TestSecretRelease(t *testing.T) {
+    dir, err := ioutil.TempDir("", "secrets")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "key")
+    if err := ioutil.WriteFile(path, []byte("secret\n"), 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    before := watchedSecrets()
+    s, err := loadSecret("sign", "file:"+path)
+    if err != nil || s.Value() != "secret" {
+        t.Fatalf("loaded %q, %v", s.Value(), err)
+    }
+    if n := watchedSecrets(); n != before {
+        t.Errorf("loading watched %d secrets", n-before)
+    }
+
+    sink, err := newSignSink(&memorySink{}, "hmac-sha256:file:"+path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := watchedSecrets(); n != before+1 {
+        t.Errorf("sign sink watches %d secrets", n-before)
+    }
+    sink.Close()
+    if n := watchedSecrets(); n != before {
+        t.Errorf("%d secrets still watched after close", n-before)
+    }
+
+    env, _ := loadSecret("sign", "env:PATH")
+    env.watch(func(string) { t.Errorf("env secret changed") })()
+}
+
+func TestZmqSinkReleasesPassword(t *testing.T) {
+    dir, err := ioutil.TempDir("", "secrets")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "password")
+    if err := ioutil.WriteFile(path, []byte("secret\n"), 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    before := watchedSecrets()
+    s, err := newZmqSink("tcp://127.0.0.1:1", "sniffer", "file:"+path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := watchedSecrets(); n != before+1 {
+        t.Errorf("zmq sink watches %d secrets", n-before)
+    }
+    s.Close()
+    if n := watchedSecrets(); n != before || !s.closed {
+        t.Errorf("%d secrets still watched after close", n-before)
+    }
+}
+
+func TestRedactSpec(t *testing.T) {
+    spec := redactSpec(sinkSpec{
+        Type:     "elasticsearch",
+        Addr:     "http://admin:hunter2@es:9200",
+        Password: "hunter2",
+        Sign:     "hmac-sha256:hunter2",
+    })
+    if spec.Password != "<redacted>" || spec.Sign != "hmac-sha256:<redacted>" {
+        t.Errorf("credentials not redacted: %+v", spec)
+    }
+    if spec.Addr != "http://admin:redacted@es:9200" {
+        t.Errorf("addr = %s", spec.Addr)
+    }
+    spec = redactSpec(sinkSpec{Password: "env:ES_PASSWORD", Sign: "ed25519:file:/etc/key"})
+    if spec.Password != "env:ES_PASSWORD" || spec.Sign != "ed25519:file:/etc/key" {
+        t.Errorf("references redacted: %+v", spec)
+    }
+}
@@ -0,0 +1,114 @@
+/*
+ * selfstats.go
+ *
+ * Watching the sniffer itself lose data. The capture loop reads the
+ * kernel's counts of packets passed to it and dropped every
+ * SELF_STATS_POLL; with -stats_interval a line like
+ *
+ *   Stats: 120443 packets, 12 dropped by the kernel (0.01%), 0 by the interface, 3 desyncs, 812 streams, 1933 fingerprints, 0 queued
+ *
+ * is logged that often, the counts being since the line before. Queued is
+ * events waiting in the sinks plus, with -workers, packets waiting to be
+ * decoded. /metrics has the same numbers as totals.
+ */
+
+package main
+
+import (
+    "fmt"
+    "io"
+    "log"
+    "time"
+)
+
+const SELF_STATS_POLL = time.Second
+
+var statsInterval time.Duration
+
+var captured captureStats // from the last poll
+var capturePolled time.Time
+
+// Sinks holding events they haven't sent yet implement this.
+type queuedSink interface {
+    Queued() int
+}
+
+// pollCaptureStats reads source's counts if SELF_STATS_POLL has passed. It
+// is called from the capture loop, the only user of source.
+func pollCaptureStats(source CaptureSource) {
+    if time.Since(capturePolled) < SELF_STATS_POLL {
+        return
+    }
+    capturePolled = time.Now()
+    st, err := source.Stats()
+    if err != nil {
+        return // not for files
+    }
+    stateLock.Lock()
+    captured = st
+    stateLock.Unlock()
+}
+
+// queuedEvents returns the events waiting in the sinks. Must be called with
+// sinksLock held.
+func queuedEvents() int {
+    n := 0
+    walkSinks(func(s sink) {
+        if q, ok := s.(queuedSink); ok {
+            n += q.Queued()
+        }
+    })
+    return n
+}
+
+func dropRate(st captureStats) float64 {
+    if st.received+st.dropped == 0 {
+        return 0
+    }
+    return float64(st.dropped) / float64(st.received+st.dropped) * 100
+}
+
+// runStatsLog logs what the sniffer saw and lost every -stats_interval.
+func runStatsLog() {
+    var last captureStats
+    var lastPackets, lastDesyncs uint64
+    for range time.Tick(statsInterval) {
+        stateLock.Lock()
+        st, packets, desyncs := captured, stats.packets.rcvd, stats.desyncs
+        streams, fingerprints := streamCount(), len(qbuf)
+        stateLock.Unlock()
+        sinksLock.Lock()
+        queued := queuedEvents()
+        sinksLock.Unlock()
+        if decoders != nil {
+            queued += decoders.queued()
+        }
+        delta := captureStats{st.received - last.received, st.dropped - last.dropped,
+            st.ifDropped - last.ifDropped}
+        log.Printf("Stats: %d packets, %d dropped by the kernel (%.2f%%), %d by the interface, "+
+            "%d desyncs, %d streams, %d fingerprints, %d queued", packets-lastPackets,
+            delta.dropped, dropRate(delta), delta.ifDropped, desyncs-lastDesyncs, streams,
+            fingerprints, queued)
+        last, lastPackets, lastDesyncs = st, packets, desyncs
+    }
+}
+
+// writeSelfMetrics writes the capture's counts and the sniffer's sizes.
+// Must be called with stateLock held.
+func writeSelfMetrics(w io.Writer) {
+    for _, c := range []struct {
+        name, kind string
+        value      uint64
+    }{
+        {"mysql_sniffer_capture_received_total", "counter", captured.received},
+        {"mysql_sniffer_capture_dropped_total", "counter", captured.dropped},
+        {"mysql_sniffer_capture_if_dropped_total", "counter", captured.ifDropped},
+        {"mysql_sniffer_fingerprints", "gauge", uint64(len(qbuf))},
+    } {
+        fmt.Fprintf(w, "# TYPE %s %s\n%s %d\n", c.name, c.kind, c.name, c.value)
+    }
+    sinksLock.Lock()
+    queued := queuedEvents()
+    sinksLock.Unlock()
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_queued_events gauge\nmysql_sniffer_queued_events %d\n", queued)
+}
@@ -0,0 +1,46 @@
+/*
+ * selfstats_test.go
+ *
+ * The capture's own drop counts.
+ */
+
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+type statsSource struct {
+    CaptureSource
+    st captureStats
+}
+
+func (s *statsSource) Stats() (captureStats, error) { return s.st, nil }
+
+func TestSelfStats(t *testing.T) {
+    resetState()
+    defer func() { captured, capturePolled = captureStats{}, time.Time{} }()
+    capturePolled = time.Time{}
+    source := &statsSource{st: captureStats{received: 990, dropped: 10, ifDropped: 2}}
+    pollCaptureStats(source)
+    source.st.dropped = 20
+    pollCaptureStats(source) // within SELF_STATS_POLL
+    if captured.dropped != 10 {
+        t.Fatalf("polled again too soon: %+v", captured)
+    }
+    if rate := dropRate(captured); rate != 1 {
+        t.Errorf("drop rate %g, want 1", rate)
+    }
+
+    var buf strings.Builder
+    writeSelfMetrics(&buf)
+    for _, want := range []string{"mysql_sniffer_capture_received_total 990\n",
+        "mysql_sniffer_capture_dropped_total 10\n", "mysql_sniffer_capture_if_dropped_total 2\n",
+        "mysql_sniffer_queued_events 0\n"} {
+        if !strings.Contains(buf.String(), want) {
+            t.Errorf("metrics missing %q:\n%s", want, buf.String())
+        }
+    }
+}
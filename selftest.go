@@ -0,0 +1,218 @@
+/*
+ * selftest.go
+ *
+ * The `selftest` command: a deploy-time smoke test. We run a tiny fake MySQL
+ * server and client over loopback, sniff that conversation through the normal
+ * capture path and check that the resulting events make it out of the sink.
+ * This exercises capture permissions, BPF, decoding and sink connectivity.
+ */
+
+package main
+
+import (
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "strings"
+    "time"
+    zmq "github.com/pebbe/zmq4"
+)
+
+const (
+    SELFTEST_QUERIES = 3
+    SELFTEST_MARKER  = "mysql-sniffer selftest"
+    SELFTEST_TIMEOUT = 10 * time.Second
+)
+
+// writeMySQLPacket frames payload as a MySQL packet with the given sequence id.
+func writeMySQLPacket(w io.Writer, seq byte, payload []byte) error {
+    size := len(payload)
+    hdr := []byte{byte(size), byte(size >> 8), byte(size >> 16), seq}
+    _, err := w.Write(append(hdr, payload...))
+    return err
+}
+
+// readMySQLPacket reads one MySQL packet and returns its payload.
+func readMySQLPacket(r io.Reader) ([]byte, error) {
+    hdr := make([]byte, 4)
+    if _, err := io.ReadFull(r, hdr); err != nil {
+        return nil, err
+    }
+    payload := make([]byte, int(hdr[0])|int(hdr[1])<<8|int(hdr[2])<<16)
+    _, err := io.ReadFull(r, payload)
+    return payload, err
+}
+
+var selftestOK = []byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00}
+
+// fakeGreeting returns the payload of a server greeting.
+func fakeGreeting() []byte {
+    greeting := []byte{0x0a}
+    greeting = append(greeting, "5.7.0-selftest\x00"...)
+    greeting = append(greeting, 0x01, 0x00, 0x00, 0x00)       // thread id
+    greeting = append(greeting, "12345678\x00"...)            // auth data 1
+    greeting = append(greeting, 0x00, 0x82, 0x21, 0x02, 0x00) // caps, charset, status
+    greeting = append(greeting, 0x00, 0x00, 0x15)             // caps, auth len
+    greeting = append(greeting, make([]byte, 10)...)          // reserved
+    greeting = append(greeting, "123456789012\x00"...)        // auth data 2
+    return greeting
+}
+
+// fakeLogin returns the payload of a login as user, without a password.
+func fakeLogin(user string) []byte {
+    login := []byte{0x00, 0x82, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x21}
+    login = append(login, make([]byte, 23)...)
+    return append(login, user+"\x00\x00"...)
+}
+
+// selftestServer accepts one connection and plays the server side of a
+// handshake followed by OK responses to every command.
+func selftestServer(ln net.Listener) error {
+    conn, err := ln.Accept()
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    if err := writeMySQLPacket(conn, 0, fakeGreeting()); err != nil {
+        return err
+    }
+    if _, err := readMySQLPacket(conn); err != nil {
+        return err
+    }
+    if err := writeMySQLPacket(conn, 2, selftestOK); err != nil {
+        return err
+    }
+    for {
+        if _, err := readMySQLPacket(conn); err != nil {
+            if err == io.EOF {
+                return nil
+            }
+            return err
+        }
+        if err := writeMySQLPacket(conn, 1, selftestOK); err != nil {
+            return err
+        }
+    }
+}
+
+// selftestClient logs in and runs SELFTEST_QUERIES queries.
+func selftestClient(addr string) error {
+    conn, err := net.Dial("tcp", addr)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    if _, err := readMySQLPacket(conn); err != nil {
+        return err
+    }
+    if err := writeMySQLPacket(conn, 1, fakeLogin("selftest")); err != nil {
+        return err
+    }
+    if _, err := readMySQLPacket(conn); err != nil {
+        return err
+    }
+    for i := 0; i < SELFTEST_QUERIES; i++ {
+        query := fmt.Sprintf("SELECT %d /* %s */", i, SELFTEST_MARKER)
+        if err := writeMySQLPacket(conn, 0, append([]byte{COM_QUERY}, query...)); err != nil {
+            return err
+        }
+        if _, err := readMySQLPacket(conn); err != nil {
+            return err
+        }
+        // Leave a gap so each response is seen before the next query.
+        time.Sleep(50 * time.Millisecond)
+    }
+    return nil
+}
+
+// runSelftest runs the smoke test against the configured sink. It returns
+// false if any step failed.
+func runSelftest() bool {
+    fail := func(step string, err error) bool {
+        log.Printf("selftest: %s: FAIL: %s", step, err)
+        return false
+    }
+
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        return fail("listen", err)
+    }
+    defer ln.Close()
+    port = uint16(ln.Addr().(*net.TCPAddr).Port)
+    log.Printf("selftest: fake server on %s", ln.Addr())
+
+    source, err := openCapture("lo")
+    if err != nil {
+        return fail("open loopback capture (are we root / CAP_NET_RAW?)", err)
+    }
+    defer source.Close()
+    if err := source.SetFilter(captureFilter()); err != nil {
+        return fail("set filter "+captureFilter(), err)
+    }
+    log.Printf("selftest: capture: ok")
+
+    var sub *zmq.Socket
+    if subaddr != "" {
+        sub, _ = zmq.NewSocket(zmq.SUB)
+        defer sub.Close()
+        sub.SetSubscribe(topic)
+        sub.SetRcvtimeo(SELFTEST_TIMEOUT)
+        if err := sub.Connect(subaddr); err != nil {
+            return fail("subscribe to "+subaddr, err)
+        }
+        // Give the subscription time to propagate through the broker.
+        time.Sleep(500 * time.Millisecond)
+    }
+
+    errs := make(chan error, 2)
+    go func() { errs <- selftestServer(ln) }()
+    go func() { errs <- selftestClient(ln.Addr().String()) }()
+
+    var pkt capturedPacket
+    queries, failed := stats.queries_out, stats.publish_errors
+    deadline := time.Now().Add(SELFTEST_TIMEOUT)
+    for stats.queries_out-queries < SELFTEST_QUERIES && time.Now().Before(deadline) {
+        if err := source.Next(&pkt); err != nil {
+            return fail("capture", err)
+        }
+        if pkt.Data != nil {
+            stateLock.Lock()
+            handlePacket(&pkt)
+            stateLock.Unlock()
+        }
+    }
+    for i := 0; i < 2; i++ {
+        select {
+        case err := <-errs:
+            if err != nil {
+                return fail("loopback conversation", err)
+            }
+        case <-time.After(time.Second):
+        }
+    }
+    if n := stats.publish_errors - failed; n > 0 {
+        return fail("publish", fmt.Errorf("%d events failed to send", n))
+    }
+    if n := stats.queries_out - queries; n < SELFTEST_QUERIES {
+        return fail("decode", fmt.Errorf("sniffed %d of %d queries", n, SELFTEST_QUERIES))
+    }
+    log.Printf("selftest: decode and publish: ok")
+
+    if sub != nil {
+        for {
+            msg, err := sub.RecvMessage(0)
+            if err != nil {
+                return fail("receive from "+subaddr, err)
+            }
+            if len(msg) == 2 && strings.Contains(msg[1], SELFTEST_MARKER) {
+                break
+            }
+        }
+        log.Printf("selftest: round trip through %s: ok", subaddr)
+    }
+    log.Printf("selftest: PASS")
+    return true
+}
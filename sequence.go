@@ -0,0 +1,60 @@
+/*
+ * sequence.go
+ *
+ * Every published event carries a sequence number, and a watermark event
+ * goes out every -watermark_interval with the number reached so far, so
+ * consumers can count what the transport lost (see event.Sequenced). Events
+ * are also stamped with wall clock and monotonic times (event.Timestamped).
+ */
+
+package main
+
+import (
+    "fmt"
+    "os"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+var eventSeq uint64
+var startTime time.Time = time.Now()
+var watermarkInterval time.Duration
+
+// instanceId identifies this run of the sniffer in watermarks.
+var instanceId string
+
+func initInstance() {
+    host, err := os.Hostname()
+    if err != nil {
+        host = "unknown"
+    }
+    instanceId = fmt.Sprintf("%s-%d-%d", host, os.Getpid(), start)
+}
+
+// stampEvent gives an event the next sequence number and the current time.
+// Callers hold stateLock.
+func stampEvent(ev interface{}) {
+    if seq, ok := ev.(event.Sequenced); ok {
+        eventSeq++
+        seq.SetSequence(eventSeq)
+    }
+    if ts, ok := ev.(event.Timestamped); ok {
+        now := time.Now()
+        ts.SetTimes(float64(now.UnixNano())/1e9, now.Sub(startTime).Seconds(), startTime.Unix())
+    }
+}
+
+// runWatermarks publishes a watermark every watermarkInterval.
+func runWatermarks() {
+    for _ = range time.Tick(watermarkInterval) {
+        stateLock.Lock()
+        publish(&event.WatermarkEvent{
+            Type:      event.TYPE_WATERMARK,
+            ServiceId: service_id,
+            TenantId:  tenant_id,
+            Instance:  instanceId,
+        })
+        stateLock.Unlock()
+    }
+}
@@ -0,0 +1,240 @@
+/*
+ * session.go
+ *
+ * Session state the server reports to the client. Every OK and EOF packet
+ * carries status flags saying whether a transaction is open, whether
+ * autocommit is on and whether more result sets follow. With
+ * session_track_schema on, the OK packet answering anything that changes the
+ * default database (USE, COM_INIT_DB, a stored procedure, ...) also says what
+ * it changed to, which is more reliable than trying to follow every way a
+ * client can switch. The server's greeting, before any of that, gives the
+ * connection's thread id as SHOW PROCESSLIST and the server logs know it,
+ * and the client's reply to it the user and the database it starts in. Over
+ * TLS the reply is encrypted and we only get the thread id.
+ */
+
+package main
+
+const (
+    SERVER_STATUS_IN_TRANS       = 0x0001
+    SERVER_STATUS_AUTOCOMMIT     = 0x0002
+    SERVER_MORE_RESULTS_EXISTS   = 0x0008
+    SERVER_SESSION_STATE_CHANGED = 0x4000
+    SESSION_TRACK_SCHEMA         = 1
+    PROTOCOL_VERSION_10          = 0x0a
+
+    // Client capability flags
+    CLIENT_CONNECT_WITH_DB                = 0x00000008
+    CLIENT_COMPRESS                       = 0x00000020
+    CLIENT_PROTOCOL_41                    = 0x00000200
+    CLIENT_SSL                            = 0x00000800
+    CLIENT_SECURE_CONNECTION              = 0x00008000
+    CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA = 0x00200000
+    CLIENT_ZSTD_COMPRESSION_ALGORITHM     = 0x04000000
+)
+
+// greetingThreadId returns the connection id from the server's initial
+// handshake packet (with its header), if that's what data is.
+func greetingThreadId(data []byte) (uint32, bool) {
+    if len(data) < 5 || data[3] != 0 || data[4] != PROTOCOL_VERSION_10 {
+        return 0, false
+    }
+    // Protocol version, NUL terminated server version, then the id.
+    end := 5
+    for end < len(data) && data[end] != 0 {
+        end++
+    }
+    if len(data) < end+5 {
+        return 0, false
+    }
+    id := data[end+1 : end+5]
+    return uint32(id[0]) | uint32(id[1])<<8 | uint32(id[2])<<16 | uint32(id[3])<<24, true
+}
+
+// loginUser returns the user and database (empty if none) from the client's
+// handshake response (with its header), if that's what data is.
+func loginUser(data []byte) (user string, db string, ok bool) {
+    if len(data) < 4 || data[3] != 1 {
+        return "", "", false
+    }
+    size := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+    if size < 32 || len(data) < 4+size {
+        return "", "", false
+    }
+    payload := data[4 : 4+size]
+    caps := uint32(payload[0]) | uint32(payload[1])<<8 | uint32(payload[2])<<16 | uint32(payload[3])<<24
+    if caps&CLIENT_PROTOCOL_41 == 0 || (caps&CLIENT_SSL != 0 && size == 32) {
+        return "", "", false
+    }
+
+    // Capabilities, max packet size, character set and filler, then the
+    // user and the auth response.
+    name, used := readNulString(payload[32:])
+    if used == 0 {
+        return "", "", false
+    }
+    rest := payload[32+used:]
+    switch {
+    case caps&CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA != 0:
+        _, used = readLenencString(rest)
+    case caps&CLIENT_SECURE_CONNECTION != 0:
+        if len(rest) > 0 && len(rest) > int(rest[0]) {
+            used = 1 + int(rest[0])
+        } else {
+            used = 0
+        }
+    default:
+        _, used = readNulString(rest)
+    }
+    if used == 0 {
+        return "", "", false
+    }
+    rest = rest[used:]
+    if caps&CLIENT_CONNECT_WITH_DB != 0 {
+        if schema, used := readNulString(rest); used != 0 {
+            db = string(schema)
+        }
+    }
+    return string(name), db, true
+}
+
+// changeUser returns the user and database from a COM_CHANGE_USER payload.
+// Every client that matters uses 4.1 authentication, so that is assumed.
+func changeUser(payload []byte) (user string, db string, ok bool) {
+    name, used := readNulString(payload)
+    if used == 0 || len(payload) <= used || len(payload) <= used+int(payload[used]) {
+        return "", "", false
+    }
+    rest := payload[used+1+int(payload[used]):]
+    if schema, used := readNulString(rest); used != 0 {
+        db = string(schema)
+    }
+    return string(name), db, true
+}
+
+// readNulString reads a NUL terminated string, returning it and the number
+// of bytes it took including the NUL, or 0 bytes if there is no NUL.
+func readNulString(data []byte) ([]byte, int) {
+    for i, b := range data {
+        if b == 0 {
+            return data[:i], i + 1
+        }
+    }
+    return nil, 0
+}
+
+// noteLogin records who a connection is logged in as and the database it
+// starts in.
+func noteLogin(rs *source, user string, db string) {
+    rs.user, rs.loginDb, rs.db = user, db, db
+    rs.tenant = tenantFor(rs.srcip, db)
+}
+
+// readLenencInt reads a length encoded integer, returning the value and the
+// number of bytes it took, or 0 bytes if data is too short.
+func readLenencInt(data []byte) (uint64, int) {
+    if len(data) == 0 {
+        return 0, 0
+    }
+    var size int
+    switch data[0] {
+    case 0xfc:
+        size = 2
+    case 0xfd:
+        size = 3
+    case 0xfe:
+        size = 8
+    case 0xfb, 0xff:
+        return 0, 0
+    default:
+        return uint64(data[0]), 1
+    }
+    if len(data) < size+1 {
+        return 0, 0
+    }
+    var v uint64
+    for i := size; i > 0; i-- {
+        v = v<<8 | uint64(data[i])
+    }
+    return v, size + 1
+}
+
+// readLenencString reads a length encoded string, returning it and the number
+// of bytes it took, or 0 bytes if data is too short.
+func readLenencString(data []byte) ([]byte, int) {
+    n, used := readLenencInt(data)
+    if used == 0 || uint64(len(data)-used) < n {
+        return nil, 0
+    }
+    return data[used : used+int(n)], used + int(n)
+}
+
+// okStatus returns the status flags of an OK packet (the payload after the
+// header byte), and where what follows them starts.
+func okStatus(ok []byte) (status uint16, pos int, valid bool) {
+    for i := 0; i < 2; i++ { // affected rows, last insert id
+        _, used := readLenencInt(ok[pos:])
+        if used == 0 {
+            return 0, 0, false
+        }
+        pos += used
+    }
+    if len(ok) < pos+4 {
+        return 0, 0, false
+    }
+    status = uint16(ok[pos]) | uint16(ok[pos+1])<<8
+    return status, pos + 4, true // past status and warnings
+}
+
+// sessionSchema returns the schema an OK packet (the payload after the 0x00
+// header byte) reports the session switched to, if it reports one.
+func sessionSchema(ok []byte) (string, bool) {
+    status, pos, valid := okStatus(ok)
+    if !valid {
+        return "", false
+    }
+    // The flag is only ever set when the client asked for session tracking,
+    // so the info string is length encoded rather than the rest of the packet.
+    if status&SERVER_SESSION_STATE_CHANGED == 0 {
+        return "", false
+    }
+    _, used := readLenencString(ok[pos:])
+    if used == 0 {
+        return "", false
+    }
+    pos += used
+    changes, used := readLenencString(ok[pos:])
+    if used == 0 {
+        return "", false
+    }
+
+    schema, found := "", false
+    for len(changes) > 0 {
+        kind := changes[0]
+        entry, used := readLenencString(changes[1:])
+        if used == 0 {
+            break
+        }
+        changes = changes[1+used:]
+        if kind == SESSION_TRACK_SCHEMA {
+            if name, used := readLenencString(entry); used != 0 {
+                schema, found = string(name), true
+            }
+        }
+    }
+    return schema, found
+}
+
+// noteOK looks for session tracking in an OK packet (or an EOF in OK form)
+// ending a response, and returns its status flags.
+func noteOK(rs *source, payload []byte) (uint16, bool) {
+    if len(payload) < 7 {
+        return 0, false
+    }
+    status, _, valid := okStatus(payload[1:])
+    if schema, ok := sessionSchema(payload[1:]); ok && schema != rs.db {
+        rs.db = schema
+        rs.tenant = tenantFor(rs.srcip, rs.db)
+    }
+    return status, valid
+}
@@ -0,0 +1,79 @@
+/*
+ * shutdown.go
+ *
+ * Stopping cleanly. On SIGINT or SIGTERM capture stops after the packet in
+ * hand; queries still waiting on their responses are published with what was
+ * seen of them, open accounting windows are flushed, a last top-N summary
+ * covering the rest of the run is printed and published with the final
+ * counters, and the sinks (flushing their batches) and capture are
+ * closed. A -profile still running is cut short and written. A second
+ * signal exits at once.
+ */
+
+package main
+
+import (
+    "log"
+    "os"
+    "os/signal"
+    "sync/atomic"
+    "syscall"
+    "time"
+)
+
+var stopRequested int32 // atomic
+
+// stopping says if capture should stop.
+func stopping() bool {
+    return atomic.LoadInt32(&stopRequested) != 0
+}
+
+// watchShutdown asks capture to stop on the first SIGINT or SIGTERM and
+// exits on the second.
+func watchShutdown() {
+    ch := make(chan os.Signal, 2)
+    signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+    for sig := range ch {
+        if atomic.SwapInt32(&stopRequested, 1) != 0 {
+            log.Printf("%s again, exiting now", sig)
+            os.Exit(1)
+        }
+        log.Printf("%s received, shutting down", sig)
+    }
+}
+
+// shutdown flushes everything held in memory once capture has stopped.
+func shutdown(source CaptureSource) {
+    now := time.Now()
+    stateLock.Lock()
+    for _, sh := range shards {
+        for _, rs := range sh.streams {
+            if rs.pending {
+                finishResponse(rs)
+            }
+        }
+    }
+    if procedureWindow > 0 {
+        flushRoutines(now)
+    }
+    if tenantWindow > 0 {
+        flushTenantUsage(now)
+    }
+    if tableUsageFile != "" {
+        if err := saveTableUsage(); err != nil {
+            log.Printf("Failed to save table usage: %s", err)
+        }
+    }
+    publishSummary(now)
+    log.Printf("Final stats: %d packets (%d in sync, %d ignored), %d desyncs, %d streams, "+
+        "%d unanswered, %d published, %d publish errors", stats.packets.rcvd,
+        stats.packets.rcvd_sync, stats.packets.ignored, stats.desyncs, stats.streams,
+        stats.unanswered, stats.published, stats.publish_errors)
+    stateLock.Unlock()
+
+    if err := replaceSinks(nil); err != nil {
+        log.Printf("Failed to close sinks: %s", err)
+    }
+    source.Close()
+    stopProfile()
+}
@@ -0,0 +1,497 @@
+/*
+ * sink.go
+ *
+ * Sinks are where published events go. The ZeroMQ PUB socket is the original
+ * one; others live in sink_*.go.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "regexp"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    zmq "github.com/pebbe/zmq4"
+)
+
+type sink interface {
+    // Send delivers one encoded event. It must not block capture for long.
+    Send(topic string, payload string) error
+    Close() error
+    String() string
+}
+
+// sinkSpec describes a sink. These come from the command line flags or from
+// the -sinks file, and can be replaced at runtime.
+type sinkSpec struct {
+    Type     string `json:"type"`               // zmq, exec, file, elasticsearch, statsd or sql
+    Addr     string `json:"addr,omitempty"`     // zmq, elasticsearch URL, statsd host:port
+    User     string `json:"user,omitempty"`     // zmq, elasticsearch
+    Password string `json:"password,omitempty"` // zmq, elasticsearch; file:/path or env:NAME
+    Command  string `json:"command,omitempty"`  // exec, sql
+    Path     string `json:"path,omitempty"`     // file, sql
+    Batch    int    `json:"batch,omitempty"`    // zmq, events per message
+    Compress string `json:"compress,omitempty"` // zmq, gzip or empty
+    Format   string `json:"format,omitempty"`   // zmq, json (default) or cbor
+    Sign     string `json:"sign,omitempty"`     // algorithm:key, see sink_sign.go
+    Redact   string `json:"redact,omitempty"`   // see redact.go
+    Index    string `json:"index,omitempty"`    // elasticsearch
+    Prefix   string `json:"prefix,omitempty"`   // statsd, default mysql_sniffer
+    NoTags   bool   `json:"no_tags,omitempty"`  // statsd, for plain StatsD
+    Table    string `json:"table,omitempty"`    // sql, [schema.]table
+
+    // Rotate file sinks past this size or age, keeping Keep old files.
+    RotateMB  int    `json:"rotate_mb,omitempty"`  // default no limit
+    RotateAge string `json:"rotate_age,omitempty"` // e.g. "24h"; default no limit
+    Keep      int    `json:"keep,omitempty"`       // default all
+
+    // Spool events to this directory while the sink is down.
+    Spool       string `json:"spool,omitempty"`
+    SpoolMaxMB  int    `json:"spool_max_mb,omitempty"`  // default 256
+    SpoolMaxAge string `json:"spool_max_age,omitempty"` // e.g. "24h"; default no limit
+}
+
+var sinks []sink
+var sinkSpecs []sinkSpec
+var sinksLock sync.Mutex
+var sinksFile string = ""
+
+// validSinkSpec checks what can be checked of a spec without opening
+// anything.
+func validSinkSpec(spec sinkSpec) error {
+    if err := validRedactLevel(spec.Redact); err != nil {
+        return err
+    }
+    batched := spec.Batch > 1 || spec.Compress != "" || spec.Format != ""
+    switch spec.Type {
+    case "zmq":
+    case "exec":
+        if batched {
+            return fmt.Errorf("exec sinks write one event per line; batch, compress and format are for zmq")
+        }
+    case "file":
+        if batched {
+            return fmt.Errorf("file sinks write one event per line; batch, compress and format are for zmq")
+        }
+        if spec.RotateAge != "" {
+            if _, err := time.ParseDuration(spec.RotateAge); err != nil {
+                return err
+            }
+        }
+    case "elasticsearch":
+        if batched {
+            return fmt.Errorf("elasticsearch sinks batch on their own; batch, compress and format are for zmq")
+        }
+        if err := checkEsURL(spec.Addr); err != nil {
+            return err
+        }
+    case "statsd":
+        if batched || spec.Sign != "" || spec.Spool != "" {
+            return fmt.Errorf("statsd sinks send metrics; batch, compress, format, sign and spool are for events")
+        }
+    case "sql":
+        if batched || spec.Sign != "" {
+            return fmt.Errorf("sql sinks write statements; batch, compress, format and sign are for events")
+        }
+        if err := checkSqlSink(spec.Table, spec.Path, spec.Command); err != nil {
+            return err
+        }
+    default:
+        return fmt.Errorf("unknown sink type %q", spec.Type)
+    }
+    if spec.SpoolMaxAge != "" {
+        if _, err := time.ParseDuration(spec.SpoolMaxAge); err != nil {
+            return err
+        }
+    }
+    if batched {
+        return checkBatch(spec.Compress, spec.Format)
+    }
+    return nil
+}
+
+func buildSink(spec sinkSpec) (sink, error) {
+    if err := validSinkSpec(spec); err != nil {
+        return nil, err
+    }
+    var s sink
+    switch spec.Type {
+    case "zmq":
+        z, err := newZmqSink(spec.Addr, spec.User, spec.Password)
+        if err != nil {
+            return nil, err
+        }
+        s = z
+    case "exec":
+        s = newExecSink(spec.Command)
+    case "file":
+        var maxAge time.Duration
+        if spec.RotateAge != "" {
+            maxAge, _ = time.ParseDuration(spec.RotateAge)
+        }
+        f, err := newFileSink(spec.Path, spec.RotateMB, maxAge, spec.Keep)
+        if err != nil {
+            return nil, err
+        }
+        s = f
+    case "elasticsearch":
+        es, err := newEsSink(spec.Addr, spec.Index, spec.User, spec.Password)
+        if err != nil {
+            return nil, err
+        }
+        s = es
+    case "statsd":
+        prefix := spec.Prefix
+        if prefix == "" {
+            prefix = "mysql_sniffer"
+        }
+        sd, err := newStatsdSink(spec.Addr, prefix, !spec.NoTags)
+        if err != nil {
+            return nil, err
+        }
+        s = sd
+    case "sql":
+        sq, err := newSqlSink(spec.Table, spec.Path, spec.Command)
+        if err != nil {
+            return nil, err
+        }
+        s = sq
+    }
+
+    // Spooling goes next to the sink, so batches are spooled whole and
+    // signed.
+    if spec.Spool != "" {
+        maxMB, maxAge := spec.SpoolMaxMB, time.Duration(0)
+        if maxMB <= 0 {
+            maxMB = 256
+        }
+        if spec.SpoolMaxAge != "" {
+            maxAge, _ = time.ParseDuration(spec.SpoolMaxAge)
+        }
+        spooled, err := newSpoolSink(s, spec.Spool, maxMB, maxAge)
+        if err != nil {
+            s.Close()
+            return nil, err
+        }
+        s = spooled
+    }
+    if spec.Sign != "" {
+        signed, err := newSignSink(s, spec.Sign)
+        if err != nil {
+            s.Close()
+            return nil, err
+        }
+        if spooled, ok := s.(*spoolSink); ok {
+            spooled.resign = signed.sign
+        }
+        s = signed
+    }
+    if spec.Batch > 1 || spec.Compress != "" || spec.Format != "" {
+        batched, err := newBatchSink(s, spec.Batch, spec.Compress, spec.Format)
+        if err != nil {
+            s.Close()
+            return nil, err
+        }
+        s = batched
+    }
+    return s, nil
+}
+
+// replaceSinks switches to a new set of sinks. Sinks whose spec is unchanged
+// are kept as they are; the rest are built before anything is swapped, so a
+// bad spec leaves the current sinks in place.
+func replaceSinks(specs []sinkSpec) error {
+    sinksLock.Lock()
+    current := make(map[sinkSpec]sink)
+    for i, spec := range sinkSpecs {
+        current[spec] = sinks[i]
+    }
+    sinksLock.Unlock()
+
+    var built []sink
+    newSinks := make([]sink, len(specs))
+    for i, spec := range specs {
+        if s, ok := current[spec]; ok {
+            newSinks[i] = s
+            delete(current, spec)
+            continue
+        }
+        s, err := buildSink(spec)
+        if err != nil {
+            for _, b := range built {
+                b.Close()
+            }
+            return fmt.Errorf("sink %d (%s): %s", i, spec.Type, err)
+        }
+        built = append(built, s)
+        newSinks[i] = s
+    }
+
+    sinksLock.Lock()
+    sinks, sinkSpecs = newSinks, specs
+    sinksLock.Unlock()
+
+    for _, s := range built {
+        log.Printf("Added sink %s", s)
+    }
+    for _, s := range current {
+        log.Printf("Removed sink %s", s)
+        s.Close()
+    }
+    return nil
+}
+
+// loadSinksFile reads a JSON list of sink specs and switches to them.
+func loadSinksFile(path string) error {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    var specs []sinkSpec
+    if err := json.Unmarshal(data, &specs); err != nil {
+        return fmt.Errorf("%s: %s", path, err)
+    }
+    return replaceSinks(specs)
+}
+
+// sendAll hands an event to every sink, as payloadFor encodes it for the
+// sink's redact level, counting successes and failures.
+func sendAll(topic string, payloadFor func(level string) string) {
+    sinksLock.Lock()
+    defer sinksLock.Unlock()
+    for i, s := range sinks {
+        if err := s.Send(topic, payloadFor(sinkSpecs[i].Redact)); err != nil {
+            stats.publish_errors++
+        } else {
+            stats.published++
+        }
+    }
+}
+
+const (
+    ZMQ_RECONNECT_MIN = 100 * time.Millisecond
+    ZMQ_RECONNECT_MAX = 30 * time.Second // libzmq doubles up to this
+    ZMQ_RETRY_QUEUE   = 10000            // events held while the broker is away
+)
+
+// walkSinks calls f on every sink and every sink wrapped in one. Must be
+// called with sinksLock held.
+func walkSinks(f func(s sink)) {
+    for _, s := range sinks {
+        for s != nil {
+            f(s)
+            var next sink
+            switch w := s.(type) {
+            case *spoolSink:
+                next = w.inner
+            case *batchSink:
+                next = w.inner
+            case *signSink:
+                next = w.inner
+            }
+            s = next
+        }
+    }
+}
+
+// zmqSink publishes to a ZeroMQ PUB socket connected to addr. A PUB socket
+// drops what it can't send without telling, so while the monitor says the
+// broker is away, or a send fails, events wait in a bounded queue instead,
+// the oldest dropped (and counted) once it is full; libzmq reconnects with
+// backoff, and the queue goes out first once it has.
+type zmqSink struct {
+    addr    string
+    sock    *zmq.Socket
+    lock    sync.Mutex
+    peers   int32 // atomic, connections up according to the socket monitor
+    retry   []zmqMessage
+    dropped uint64 // atomic
+    retries uint64 // atomic, reconnection attempts
+    release func() // stops following password rotation
+    closed  bool
+}
+
+type zmqMessage struct {
+    topic, payload string
+}
+
+var zmqMonitors int32
+
+// newZmqSink connects a PUB socket, authenticating with PLAIN if a password
+// (see loadSecret) is given.
+func newZmqSink(addr string, user string, password string) (*zmqSink, error) {
+    sock, err := zmq.NewSocket(zmq.PUB)
+    if err != nil {
+        return nil, err
+    }
+    s := &zmqSink{addr: addr, sock: sock}
+    sock.SetReconnectIvl(ZMQ_RECONNECT_MIN)
+    sock.SetReconnectIvlMax(ZMQ_RECONNECT_MAX)
+
+    if password != "" {
+        pass, err := loadSecret("zmq_password", password)
+        if err != nil {
+            sock.Close()
+            return nil, err
+        }
+        sock.SetPlainUsername(user)
+        sock.SetPlainPassword(pass.Value())
+
+        // PLAIN credentials are only sent during the handshake, so a
+        // rotated password needs a fresh connection.
+        s.release = pass.watch(func(value string) {
+            s.lock.Lock()
+            defer s.lock.Unlock()
+            if s.closed {
+                return
+            }
+            s.sock.Disconnect(s.addr)
+            s.sock.SetPlainPassword(value)
+            s.sock.Connect(s.addr)
+        })
+    }
+    if err := s.monitor(); err != nil {
+        s.Close()
+        return nil, err
+    }
+    if err := sock.Connect(addr); err != nil {
+        s.Close()
+        return nil, err
+    }
+    return s, nil
+}
+
+// monitor keeps s.peers up to date from the socket's connection events. A
+// PUB socket never fails a send, so this is how we know the peer is gone.
+func (s *zmqSink) monitor() error {
+    endpoint := fmt.Sprintf("inproc://zmq-sink-monitor-%d", atomic.AddInt32(&zmqMonitors, 1))
+    events := zmq.EVENT_CONNECTED | zmq.EVENT_DISCONNECTED | zmq.EVENT_CONNECT_RETRIED
+    if err := s.sock.Monitor(endpoint, events); err != nil {
+        return err
+    }
+    mon, err := zmq.NewSocket(zmq.PAIR)
+    if err != nil {
+        return err
+    }
+    if err := mon.Connect(endpoint); err != nil {
+        mon.Close()
+        return err
+    }
+    go func() {
+        defer mon.Close()
+        for {
+            ev, _, _, err := mon.RecvEvent(0)
+            switch {
+            case err != nil || ev == zmq.EVENT_MONITOR_STOPPED:
+                return
+            case ev == zmq.EVENT_CONNECTED:
+                if atomic.AddInt32(&s.peers, 1) == 1 {
+                    s.lock.Lock()
+                    log.Printf("zmq sink %s: connected, sending %d queued events", s.addr, len(s.retry))
+                    s.flush()
+                    s.lock.Unlock()
+                }
+            case ev == zmq.EVENT_DISCONNECTED:
+                if atomic.AddInt32(&s.peers, -1) == 0 {
+                    log.Printf("zmq sink %s: disconnected, queueing events", s.addr)
+                }
+            case ev == zmq.EVENT_CONNECT_RETRIED:
+                atomic.AddUint64(&s.retries, 1)
+            }
+        }
+    }()
+    return nil
+}
+
+func (s *zmqSink) Healthy() bool {
+    return atomic.LoadInt32(&s.peers) > 0
+}
+
+func (s *zmqSink) Queued() int {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    return len(s.retry)
+}
+
+func (s *zmqSink) Send(topic string, payload string) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.retry = append(s.retry, zmqMessage{topic, payload})
+    if atomic.LoadInt32(&s.peers) > 0 {
+        s.flush()
+    }
+    if over := len(s.retry) - ZMQ_RETRY_QUEUE; over > 0 {
+        atomic.AddUint64(&s.dropped, uint64(over))
+        s.retry = append(s.retry[:0], s.retry[over:]...)
+    }
+    return nil
+}
+
+// flush sends queued events until one fails. Must be called with s.lock
+// held.
+func (s *zmqSink) flush() {
+    sent := 0
+    for _, m := range s.retry {
+        _, err := s.sock.Send(m.topic, zmq.SNDMORE|zmq.DONTWAIT)
+        if err == nil {
+            _, err = s.sock.Send(m.payload, zmq.DONTWAIT)
+        }
+        if err != nil {
+            break
+        }
+        sent++
+    }
+    s.retry = append(s.retry[:0], s.retry[sent:]...)
+}
+
+func (s *zmqSink) Close() error {
+    if s.release != nil {
+        s.release()
+    }
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.closed = true
+    // Give queued messages a moment to go out, but don't hang on a dead peer.
+    s.sock.SetLinger(2 * time.Second)
+    return s.sock.Close()
+}
+
+// purge drops queued events matching re, returning how many.
+func (s *zmqSink) purge(re *regexp.Regexp) int {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    kept := s.retry[:0]
+    for _, m := range s.retry {
+        if !re.MatchString(m.payload) {
+            kept = append(kept, m)
+        }
+    }
+    n := len(s.retry) - len(kept)
+    s.retry = kept
+    return n
+}
+
+// zmqTotals sums the queues and counters of the zmq sinks. Must be called
+// with sinksLock held.
+func zmqTotals() (queued int, dropped uint64, retries uint64) {
+    walkSinks(func(s sink) {
+        if z, ok := s.(*zmqSink); ok {
+            z.lock.Lock()
+            queued += len(z.retry)
+            z.lock.Unlock()
+            dropped += atomic.LoadUint64(&z.dropped)
+            retries += atomic.LoadUint64(&z.retries)
+        }
+    })
+    return queued, dropped, retries
+}
+
+func (s *zmqSink) String() string {
+    return "zmq:" + s.addr
+}
@@ -0,0 +1,157 @@
+/*
+ * sink_batch.go
+ *
+ * Batching and compression in front of another sink, for sniffers far from
+ * their consumers. Events are held per topic until there are "batch" of
+ * them or BATCH_FLUSH passes, then sent as one payload (see event.Batch).
+ * A format of cbor sends even single events this way, as CBOR.
+ *
+ * A batch the sink won't take stays pending and goes out with the next
+ * flush; past BATCH_MAX_PENDING batches for a topic, the oldest events are
+ * dropped and counted.
+ */
+
+package main
+
+import (
+    "fmt"
+    "log"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+const (
+    BATCH_FLUSH       = time.Second
+    BATCH_MAX_PENDING = 64 // batches per topic held while the sink fails
+)
+
+type batchSink struct {
+    inner    sink
+    size     int
+    compress string
+    format   string
+    lock     sync.Mutex
+    pending  map[string][]string // by topic
+    done     chan struct{}
+    dropped  uint64 // events given up on
+}
+
+// checkBatch makes sure a batch's compression and format are supported.
+func checkBatch(compress string, format string) error {
+    if compress != "" && compress != "gzip" {
+        return fmt.Errorf("unsupported compression %q (only gzip)", compress)
+    }
+    switch format {
+    case "", event.FORMAT_JSON, event.FORMAT_CBOR:
+        return nil
+    }
+    return fmt.Errorf("unsupported format %q (json or cbor)", format)
+}
+
+func newBatchSink(inner sink, size int, compress string, format string) (*batchSink, error) {
+    if err := checkBatch(compress, format); err != nil {
+        return nil, err
+    }
+    if format == "" {
+        format = event.FORMAT_JSON
+    }
+    if size < 1 {
+        size = 1
+    }
+    s := &batchSink{inner: inner, size: size, compress: compress, format: format,
+        pending: make(map[string][]string), done: make(chan struct{})}
+    go s.flushEvery(BATCH_FLUSH)
+    return s, nil
+}
+
+func (s *batchSink) flushEvery(interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-s.done:
+            return
+        case <-ticker.C:
+            s.lock.Lock()
+            for topic := range s.pending {
+                s.flush(topic)
+            }
+            s.lock.Unlock()
+        }
+    }
+}
+
+// flush sends what is pending for topic, a batch at a time, keeping what
+// the sink won't take. Callers hold s.lock.
+func (s *batchSink) flush(topic string) error {
+    payloads := s.pending[topic]
+    var err error
+    for len(payloads) > 0 {
+        n := s.size
+        if n > len(payloads) {
+            n = len(payloads)
+        }
+        var payload string
+        if payload, err = event.BatchFormat(payloads[:n], s.compress, s.format); err != nil {
+            s.drop(topic, n, err)
+        } else if err = s.inner.Send(topic, payload); err != nil {
+            break
+        }
+        payloads = payloads[n:]
+    }
+    if over := len(payloads) - s.size*BATCH_MAX_PENDING; over > 0 {
+        s.drop(topic, over, err)
+        payloads = payloads[over:]
+    }
+    if len(payloads) == 0 {
+        delete(s.pending, topic)
+    } else {
+        s.pending[topic] = payloads
+    }
+    return err
+}
+
+// drop gives up on n events for topic.
+func (s *batchSink) drop(topic string, n int, err error) {
+    atomic.AddUint64(&s.dropped, uint64(n))
+    log.Printf("%s: dropped %d events for %s: %s", s, n, topic, err)
+}
+
+func (s *batchSink) Send(topic string, payload string) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.pending[topic] = append(s.pending[topic], payload)
+    if len(s.pending[topic]) >= s.size {
+        return s.flush(topic)
+    }
+    return nil
+}
+
+func (s *batchSink) Close() error {
+    close(s.done)
+    s.lock.Lock()
+    for topic := range s.pending {
+        if err := s.flush(topic); err != nil {
+            s.drop(topic, len(s.pending[topic]), err)
+        }
+    }
+    s.lock.Unlock()
+    return s.inner.Close()
+}
+
+func (s *batchSink) String() string {
+    return fmt.Sprintf("%s (batch=%d compress=%s format=%s)", s.inner, s.size, s.compress, s.format)
+}
+
+// batchDropped returns how many events the batch sinks gave up on.
+func batchDropped() (dropped uint64) {
+    walkSinks(func(s sink) {
+        if b, ok := s.(*batchSink); ok {
+            dropped += atomic.LoadUint64(&b.dropped)
+        }
+    })
+    return dropped
+}
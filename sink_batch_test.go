@@ -0,0 +1,60 @@
+/*
+ * sink_batch_test.go
+ *
+ * A batch the sink turned down is sent later, not lost, and what has to go
+ * is counted.
+ */
+
+package main
+
+import (
+    "fmt"
+    "reflect"
+    "testing"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+func TestBatchKeepsFailedSends(t *testing.T) {
+    inner := &flakySink{down: true}
+    // No flushEvery: the test flushes by sending.
+    s := &batchSink{inner: inner, size: 2, format: event.FORMAT_JSON,
+        pending: make(map[string][]string), done: make(chan struct{})}
+
+    var want []string
+    for i := 0; i < 3; i++ {
+        want = append(want, fmt.Sprintf("%s{\"sql\":\"SELECT %d\"}", event.Prefix, i))
+    }
+    s.Send("topic", want[0])
+    if err := s.Send("topic", want[1]); err == nil {
+        t.Fatalf("send to a down sink succeeded")
+    }
+    inner.down = false
+    if err := s.Send("topic", want[2]); err != nil {
+        t.Fatal(err)
+    }
+    var got []string
+    for _, payload := range inner.payloads {
+        batch, err := event.Unbatch(payload)
+        if err != nil {
+            t.Fatal(err)
+        }
+        got = append(got, batch...)
+    }
+    if !reflect.DeepEqual(got, want) || len(s.pending) != 0 {
+        t.Errorf("sent %q, %d pending", got, len(s.pending["topic"]))
+    }
+
+    // A sink that stays down costs the oldest events past the limit.
+    inner.down = true
+    for i := 0; i < 2*BATCH_MAX_PENDING+10; i++ {
+        s.Send("topic", want[0])
+    }
+    if n := len(s.pending["topic"]); n != 2*BATCH_MAX_PENDING || s.dropped != 10 {
+        t.Errorf("%d pending, %d dropped", n, s.dropped)
+    }
+    s.Close()
+    if s.dropped != 10+2*BATCH_MAX_PENDING {
+        t.Errorf("%d dropped after close", s.dropped)
+    }
+}
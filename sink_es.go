@@ -0,0 +1,273 @@
+/*
+ * sink_es.go
+ *
+ * A sink indexing query events into Elasticsearch with the bulk API, so
+ * Kibana can read them without a Logstash pipeline in between. Events are
+ * queued and sent ES_BATCH at a time, or every ES_FLUSH. A bulk request
+ * that fails, or the documents in it Elasticsearch rejects as overloaded
+ * (429) or broken (5xx), are retried with backoff while newer events queue
+ * up behind them; once ES_QUEUE events are waiting, new ones are dropped.
+ * Other event types aren't indexed: their fields would clash in one
+ * index's mapping. With a user, requests use basic auth; the password is a
+ * secret (see loadSecret), never part of the URL.
+ */
+
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+const (
+    ES_QUEUE       = 10000
+    ES_BATCH       = 500
+    ES_FLUSH       = time.Second
+    ES_TIMEOUT     = 30 * time.Second
+    ES_BACKOFF_MIN = time.Second
+    ES_BACKOFF_MAX = time.Minute
+)
+
+var errEsQueueFull = errors.New("elasticsearch sink: queue full, event dropped")
+
+type esSink struct {
+    url      string
+    index    string
+    user     string
+    password *secret
+    release  func()
+    client   *http.Client
+    queue   chan string
+    done    chan struct{}
+    stopped chan struct{}
+    lock    sync.Mutex
+    healthy bool
+    closed  bool
+    lost    uint64 // documents dropped or rejected for good
+}
+
+func newEsSink(addr string, index string, user string, password string) (*esSink, error) {
+    if err := checkEsURL(addr); err != nil {
+        return nil, err
+    }
+    if index == "" {
+        return nil, fmt.Errorf("elasticsearch sink needs an index")
+    }
+    pass, err := loadSecret("es_password", password)
+    if err != nil {
+        return nil, err
+    }
+    s := &esSink{
+        url:      strings.TrimSuffix(addr, "/"),
+        index:    index,
+        user:     user,
+        password: pass,
+        release:  pass.watch(nil),
+        client:   &http.Client{Timeout: ES_TIMEOUT},
+        queue:   make(chan string, ES_QUEUE),
+        done:    make(chan struct{}),
+        stopped: make(chan struct{}),
+        healthy: true,
+    }
+    go s.run()
+    return s, nil
+}
+
+// checkEsURL makes sure addr is an http(s) URL without a password in it.
+func checkEsURL(addr string) error {
+    if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+        return fmt.Errorf("elasticsearch url must be http:// or https://, not %q", addr)
+    }
+    u, err := url.Parse(addr)
+    if err != nil {
+        return err
+    }
+    if _, ok := u.User.Password(); ok {
+        return fmt.Errorf("elasticsearch url has a password in it; use -es_password (file:/path or env:NAME)")
+    }
+    return nil
+}
+
+// isQueryEvent says if an encoded event is a query event. The type is the
+// first field of every event, and query events may leave it out.
+func isQueryEvent(doc string) bool {
+    const typeField = `{"type":"`
+    if !strings.HasPrefix(doc, typeField) {
+        return true
+    }
+    return strings.HasPrefix(doc[len(typeField):], event.TYPE_QUERY+`"`)
+}
+
+// run collects queued documents into batches and sends them until closed.
+func (s *esSink) run() {
+    defer close(s.stopped)
+    ticker := time.NewTicker(ES_FLUSH)
+    defer ticker.Stop()
+    var batch []string
+    backoff := ES_BACKOFF_MIN
+    for {
+        select {
+        case doc := <-s.queue:
+            if batch = append(batch, doc); len(batch) < ES_BATCH {
+                continue
+            }
+        case <-ticker.C:
+            if len(batch) == 0 {
+                continue
+            }
+        case <-s.done:
+            // One last try with what's left.
+            for len(s.queue) > 0 {
+                batch = append(batch, <-s.queue)
+            }
+            if len(batch) > 0 {
+                if retry, _ := s.bulk(batch); len(retry) > 0 {
+                    atomic.AddUint64(&s.lost, uint64(len(retry)))
+                    log.Printf("elasticsearch sink: %d events lost on close", len(retry))
+                }
+            }
+            return
+        }
+        retry, err := s.bulk(batch)
+        s.lock.Lock()
+        s.healthy = err == nil
+        s.lock.Unlock()
+        if err == nil && len(retry) == 0 {
+            batch, backoff = batch[:0], ES_BACKOFF_MIN
+            continue
+        }
+        if err != nil {
+            log.Printf("elasticsearch sink: bulk request failed, retrying in %s: %s", backoff, err)
+        }
+        batch = retry
+        select {
+        case <-s.done:
+        case <-time.After(backoff):
+        }
+        if backoff *= 2; backoff > ES_BACKOFF_MAX {
+            backoff = ES_BACKOFF_MAX
+        }
+    }
+}
+
+// bulk indexes docs, returning those worth trying again: all of them if the
+// request failed, or the ones rejected as overloaded or by a server error.
+func (s *esSink) bulk(docs []string) ([]string, error) {
+    var body bytes.Buffer
+    action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": s.index}})
+    for _, doc := range docs {
+        body.Write(action)
+        body.WriteByte('\n')
+        body.WriteString(doc)
+        body.WriteByte('\n')
+    }
+    req, err := http.NewRequest("POST", s.url+"/_bulk", &body)
+    if err != nil {
+        return docs, err
+    }
+    req.Header.Set("Content-Type", "application/x-ndjson")
+    if s.user != "" {
+        req.SetBasicAuth(s.user, s.password.Value())
+    }
+    resp, err := s.client.Do(req)
+    if err != nil {
+        return docs, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        msg, _ := ioutil.ReadAll(resp.Body)
+        if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+            return docs, fmt.Errorf("%s: %s", resp.Status, msg)
+        }
+        // The request itself is bad; sending it again won't help.
+        atomic.AddUint64(&s.lost, uint64(len(docs)))
+        return nil, fmt.Errorf("%s: %s", resp.Status, msg)
+    }
+    var result struct {
+        Errors bool
+        Items  []map[string]struct {
+            Status int
+            Error  json.RawMessage
+        }
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+        return nil, fmt.Errorf("bad bulk response: %s", err)
+    }
+    if !result.Errors {
+        return nil, nil
+    }
+    var retry []string
+    for i, item := range result.Items {
+        for _, r := range item {
+            switch {
+            case r.Status < 300 || i >= len(docs):
+            case r.Status == http.StatusTooManyRequests || r.Status >= 500:
+                retry = append(retry, docs[i])
+            default:
+                if atomic.AddUint64(&s.lost, 1) == 1 {
+                    log.Printf("elasticsearch sink: document rejected: %s", r.Error)
+                }
+            }
+        }
+    }
+    return retry, nil
+}
+
+func (s *esSink) Send(topic string, payload string) error {
+    doc := strings.TrimPrefix(payload, event.Prefix)
+    if !isQueryEvent(doc) {
+        return nil
+    }
+    select {
+    case s.queue <- doc:
+        return nil
+    default:
+        atomic.AddUint64(&s.lost, 1)
+        return errEsQueueFull
+    }
+}
+
+func (s *esSink) Queued() int {
+    return len(s.queue)
+}
+
+func (s *esSink) Healthy() bool {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    return s.healthy
+}
+
+func (s *esSink) Close() error {
+    s.lock.Lock()
+    if s.closed {
+        s.lock.Unlock()
+        return nil
+    }
+    s.closed = true
+    s.lock.Unlock()
+    close(s.done)
+    <-s.stopped
+    s.release()
+    return nil
+}
+
+func (s *esSink) String() string {
+    shown := s.url
+    if u, err := url.Parse(s.url); err == nil && u.User != nil {
+        u.User = nil
+        shown = u.String()
+    }
+    return "elasticsearch:" + shown + "/" + s.index
+}
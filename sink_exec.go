@@ -0,0 +1,156 @@
+/*
+ * sink_exec.go
+ *
+ * A sink that runs a command and writes each event to its stdin as a line of
+ * JSON, restarting the command whenever it exits. Good for one-off
+ * integrations that don't deserve a sink of their own.
+ */
+
+package main
+
+import (
+    "errors"
+    "io"
+    "log"
+    "os"
+    "os/exec"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+const (
+    EXEC_QUEUE       = 1024
+    EXEC_BACKOFF_MIN = time.Second
+    EXEC_BACKOFF_MAX = 30 * time.Second
+)
+
+var errExecQueueFull = errors.New("exec sink: queue full, event dropped")
+
+type execSink struct {
+    command string
+    queue   chan string
+    done    chan struct{}
+    lock    sync.Mutex
+    stdin   io.WriteCloser
+    closed  bool
+    lost    uint64 // events dropped because the command wasn't running
+}
+
+// newExecSink starts command (run with sh -c) and keeps it running.
+func newExecSink(command string) *execSink {
+    s := &execSink{
+        command: command,
+        queue:   make(chan string, EXEC_QUEUE),
+        done:    make(chan struct{}),
+    }
+    go s.supervise()
+    go s.write()
+    return s
+}
+
+// supervise runs the command, restarting it with backoff when it exits.
+func (s *execSink) supervise() {
+    backoff := EXEC_BACKOFF_MIN
+    for {
+        cmd := exec.Command("sh", "-c", s.command)
+        cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+        stdin, err := cmd.StdinPipe()
+        if err == nil {
+            err = cmd.Start()
+        }
+        if err != nil {
+            log.Printf("exec sink: failed to start %q: %s", s.command, err)
+        } else {
+            started := time.Now()
+            s.lock.Lock()
+            s.stdin = stdin
+            s.lock.Unlock()
+
+            err = cmd.Wait()
+
+            s.lock.Lock()
+            s.stdin = nil
+            closed := s.closed
+            s.lock.Unlock()
+            if closed {
+                return
+            }
+            log.Printf("exec sink: %q exited (%v); restarting (%d events lost so far)",
+                s.command, err, atomic.LoadUint64(&s.lost))
+            if time.Since(started) > EXEC_BACKOFF_MAX {
+                backoff = EXEC_BACKOFF_MIN
+            }
+        }
+
+        select {
+        case <-s.done:
+            return
+        case <-time.After(backoff):
+        }
+        if backoff *= 2; backoff > EXEC_BACKOFF_MAX {
+            backoff = EXEC_BACKOFF_MAX
+        }
+    }
+}
+
+// write feeds queued events to whichever instance of the command is running.
+// Events arriving while it's down are dropped rather than stalling capture.
+func (s *execSink) write() {
+    for line := range s.queue {
+        s.lock.Lock()
+        stdin := s.stdin
+        s.lock.Unlock()
+        if stdin == nil {
+            atomic.AddUint64(&s.lost, 1)
+            continue
+        }
+        if _, err := io.WriteString(stdin, line); err != nil {
+            atomic.AddUint64(&s.lost, 1)
+        }
+    }
+    s.lock.Lock()
+    if s.stdin != nil {
+        s.stdin.Close()
+    }
+    s.lock.Unlock()
+}
+
+func (s *execSink) Send(topic string, payload string) error {
+    select {
+    case s.queue <- strings.TrimPrefix(payload, event.Prefix) + "\n":
+        return nil
+    default:
+        return errExecQueueFull
+    }
+}
+
+func (s *execSink) Queued() int {
+    return len(s.queue)
+}
+
+func (s *execSink) Healthy() bool {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    return s.stdin != nil
+}
+
+func (s *execSink) Close() error {
+    s.lock.Lock()
+    if s.closed {
+        s.lock.Unlock()
+        return nil
+    }
+    s.closed = true
+    s.lock.Unlock()
+    close(s.done)
+    close(s.queue)
+    return nil
+}
+
+func (s *execSink) String() string {
+    return "exec:" + s.command
+}
@@ -0,0 +1,126 @@
+/*
+ * sink_file.go
+ *
+ * A sink appending each event to a file as a line of JSON, for hosts that
+ * can't reach a broker and ship files with other tools instead. The file is
+ * rotated when it would grow past RotateMB or is older than RotateAge: it is
+ * renamed to path.YYYYMMDD-HHMMSS and a new one started, and the oldest of
+ * the rotated files are removed once there are more than Keep of them.
+ */
+
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+const FILE_ROTATED_FORMAT = "20060102-150405"
+
+type fileSink struct {
+    path     string
+    maxBytes int64         // 0 for no limit
+    maxAge   time.Duration // 0 for no limit
+    keep     int           // rotated files kept, 0 for all
+    lock     sync.Mutex
+    f        *os.File
+    size     int64
+    opened   time.Time
+}
+
+func newFileSink(path string, maxMB int, maxAge time.Duration, keep int) (*fileSink, error) {
+    s := &fileSink{path: path, maxBytes: int64(maxMB) << 20, maxAge: maxAge, keep: keep}
+    if err := s.open(); err != nil {
+        return nil, err
+    }
+    return s, nil
+}
+
+// open appends to the file at path, creating it if need be.
+func (s *fileSink) open() error {
+    f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+    if err != nil {
+        return err
+    }
+    info, err := f.Stat()
+    if err != nil {
+        f.Close()
+        return err
+    }
+    s.f, s.size, s.opened = f, info.Size(), time.Now()
+    return nil
+}
+
+// rotate moves the current file aside and starts a new one.
+func (s *fileSink) rotate(now time.Time) error {
+    s.f.Close()
+    s.f = nil
+    rotated := s.path + "." + now.Format(FILE_ROTATED_FORMAT)
+    for i := 1; ; i++ {
+        if _, err := os.Stat(rotated); os.IsNotExist(err) {
+            break
+        }
+        rotated = fmt.Sprintf("%s.%s-%d", s.path, now.Format(FILE_ROTATED_FORMAT), i)
+    }
+    if err := os.Rename(s.path, rotated); err != nil {
+        return err
+    }
+    if s.keep > 0 {
+        old, _ := filepath.Glob(s.path + ".[0-9]*")
+        sort.Strings(old)
+        for len(old) > s.keep {
+            os.Remove(old[0])
+            old = old[1:]
+        }
+    }
+    return s.open()
+}
+
+func (s *fileSink) Send(topic string, payload string) error {
+    line := strings.TrimPrefix(payload, event.Prefix) + "\n"
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    now := time.Now()
+    if s.f == nil {
+        if err := s.open(); err != nil {
+            return err
+        }
+    }
+    if s.size > 0 && ((s.maxBytes > 0 && s.size+int64(len(line)) > s.maxBytes) ||
+        (s.maxAge > 0 && now.Sub(s.opened) >= s.maxAge)) {
+        if err := s.rotate(now); err != nil {
+            return err
+        }
+    }
+    n, err := s.f.WriteString(line)
+    s.size += int64(n)
+    return err
+}
+
+func (s *fileSink) Healthy() bool {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    return s.f != nil
+}
+
+func (s *fileSink) Close() error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if s.f == nil {
+        return nil
+    }
+    err := s.f.Close()
+    s.f = nil
+    return err
+}
+
+func (s *fileSink) String() string {
+    return "file:" + s.path
+}
@@ -0,0 +1,54 @@
+/*
+ * sink_file_test.go
+ *
+ * The file sink writes one JSON line per event and rotates without losing
+ * any, keeping only as many old files as asked.
+ */
+
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+func TestFileSinkRotates(t *testing.T) {
+    dir, err := ioutil.TempDir("", "out")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "events.jsonl")
+
+    s, err := newFileSink(path, 0, 0, 2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    s.maxBytes = 100 // three lines a file
+    line := strings.Repeat("x", 29)
+    for i := 0; i < 12; i++ {
+        if err := s.Send("topic", event.Prefix+line); err != nil {
+            t.Fatal(err)
+        }
+    }
+    s.Close()
+
+    data, _ := ioutil.ReadFile(path)
+    if string(data) != strings.Repeat(line+"\n", 3) {
+        t.Errorf("current file %q", data)
+    }
+    rotated, _ := filepath.Glob(path + ".[0-9]*")
+    if len(rotated) != 2 {
+        t.Errorf("kept %v", rotated)
+    }
+    for _, name := range rotated {
+        if data, _ := ioutil.ReadFile(name); len(data) != 90 {
+            t.Errorf("%s has %d bytes", name, len(data))
+        }
+    }
+}
@@ -0,0 +1,159 @@
+/*
+ * sink_sign.go
+ *
+ * Signing what a sink sends (see event.Sign), for consumers that audit the
+ * stream. A key is given as algorithm:secret, the secret as for any other
+ * credential (file:/path or env:NAME):
+ *
+ *   hmac-sha256:env:SNIFF_KEY     the secret is the HMAC key itself
+ *   ed25519:file:/etc/sniff.key   base64 of a 32 byte seed or 64 byte key
+ *
+ * Consumers verify with the same HMAC key or, for ed25519, the base64 public
+ * key (mysql-sniffer subscribe -verify ...). The signature is added after
+ * batching and before spooling, so batches are signed whole and spooled
+ * events keep their signatures.
+ */
+
+package main
+
+import (
+    "crypto/ed25519"
+    "encoding/base64"
+    "fmt"
+    "log"
+    "strings"
+    "sync"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+// Flags holding algorithm:secret key specs.
+var keySpecFlags = map[string]bool{
+    "sign":   true,
+    "verify": true,
+}
+
+// redactKeySpec hides the secret part of an algorithm:secret spec.
+func redactKeySpec(spec string) string {
+    parts := strings.SplitN(spec, ":", 2)
+    if len(parts) != 2 {
+        return redactSecret(spec)
+    }
+    return parts[0] + ":" + redactSecret(parts[1])
+}
+
+// loadKeySpec splits an algorithm:secret spec and loads the secret.
+func loadKeySpec(name string, spec string) (string, *secret, error) {
+    parts := strings.SplitN(spec, ":", 2)
+    if len(parts) != 2 {
+        return "", nil, fmt.Errorf("-%s: want algorithm:key", name)
+    }
+    key, err := loadSecret(name, parts[1])
+    if err != nil {
+        return "", nil, err
+    }
+    return parts[0], key, nil
+}
+
+func makeSigner(alg string, key string) (event.Signer, error) {
+    switch alg {
+    case event.SIG_HMAC_SHA256:
+        if key == "" {
+            return nil, fmt.Errorf("empty HMAC key")
+        }
+        return event.HMACKey(key), nil
+    case event.SIG_ED25519:
+        raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+        if err != nil {
+            return nil, err
+        }
+        switch len(raw) {
+        case ed25519.SeedSize:
+            return event.Ed25519Signer(ed25519.NewKeyFromSeed(raw)), nil
+        case ed25519.PrivateKeySize:
+            return event.Ed25519Signer(raw), nil
+        }
+        return nil, fmt.Errorf("ed25519 key is %d bytes, want %d or %d", len(raw),
+            ed25519.SeedSize, ed25519.PrivateKeySize)
+    }
+    return nil, fmt.Errorf("unknown signature algorithm %q", alg)
+}
+
+func makeVerifier(alg string, key string) (event.Verifier, error) {
+    switch alg {
+    case event.SIG_HMAC_SHA256:
+        if key == "" {
+            return nil, fmt.Errorf("empty HMAC key")
+        }
+        return event.HMACKey(key), nil
+    case event.SIG_ED25519:
+        raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+        if err != nil {
+            return nil, err
+        }
+        if len(raw) != ed25519.PublicKeySize {
+            return nil, fmt.Errorf("ed25519 public key is %d bytes, want %d", len(raw),
+                ed25519.PublicKeySize)
+        }
+        return event.Ed25519Verifier(raw), nil
+    }
+    return nil, fmt.Errorf("unknown signature algorithm %q", alg)
+}
+
+type signSink struct {
+    inner   sink
+    alg     string
+    lock    sync.Mutex
+    signer  event.Signer
+    release func() // stops following key rotation
+}
+
+func newSignSink(inner sink, spec string) (*signSink, error) {
+    alg, key, err := loadKeySpec("sign", spec)
+    if err != nil {
+        return nil, err
+    }
+    signer, err := makeSigner(alg, key.Value())
+    if err != nil {
+        return nil, err
+    }
+    s := &signSink{inner: inner, alg: alg, signer: signer}
+    s.release = key.watch(func(value string) {
+        signer, err := makeSigner(alg, value)
+        if err != nil {
+            log.Printf("Keeping the old signing key: %s", err)
+            return
+        }
+        s.lock.Lock()
+        s.signer = signer
+        s.lock.Unlock()
+    })
+    return s, nil
+}
+
+// sign signs payload with the current key.
+func (s *signSink) sign(payload string) (string, error) {
+    s.lock.Lock()
+    signer := s.signer
+    s.lock.Unlock()
+    return event.Sign(payload, signer)
+}
+
+func (s *signSink) Send(topic string, payload string) error {
+    signed, err := s.sign(payload)
+    if err != nil {
+        return err
+    }
+    return s.inner.Send(topic, signed)
+}
+
+func (s *signSink) Close() error {
+    if s.release != nil {
+        s.release()
+    }
+    return s.inner.Close()
+}
+
+func (s *signSink) String() string {
+    return fmt.Sprintf("%s (signed, %s)", s.inner, s.alg)
+}
@@ -0,0 +1,418 @@
+/*
+ * sink_spool.go
+ *
+ * A local spool in front of a sink. While the sink is down (Send fails, or it
+ * says it isn't healthy) events are appended to segment files in a
+ * directory; once it is back they are sent in order before anything new.
+ * The spool is bounded by size, dropping the oldest segment first, and by
+ * age. Segments left by a previous run are picked up, so delivery is at
+ * least once: consumers may see an event twice and can tell by its sequence
+ * number.
+ */
+
+package main
+
+import (
+    "bufio"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+const SPOOL_DRAIN_EVERY = time.Second
+const SPOOL_DRAIN_CHUNK = 1000 // records sent per hold of the lock
+
+// Sinks that know whether their destination is reachable implement this.
+type healthChecker interface {
+    Healthy() bool
+}
+
+type spoolSink struct {
+    inner    sink
+    dir      string
+    maxBytes int64
+    maxAge   time.Duration
+
+    lock     sync.Mutex
+    segments []string // oldest first; the last one is being written
+    size     int64    // bytes in all segments
+    out      *os.File
+    outSize  int64
+    offset   int64 // into segments[0], already sent
+    done     chan struct{}
+
+    // Signs what a purge rebuilds, when what is spooled is signed.
+    resign func(payload string) (string, error)
+
+    spooled uint64 // atomic
+    dropped uint64 // atomic, lost to size or age limits
+}
+
+// A spooled record: unix nanoseconds, topic length, payload length, then the
+// topic and payload bytes.
+const SPOOL_HEADER = 16
+
+func newSpoolSink(inner sink, dir string, maxMB int, maxAge time.Duration) (*spoolSink, error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return nil, err
+    }
+    s := &spoolSink{inner: inner, dir: dir, maxBytes: int64(maxMB) << 20, maxAge: maxAge,
+        done: make(chan struct{})}
+    names, err := filepath.Glob(filepath.Join(dir, "spool-*.log"))
+    if err != nil {
+        return nil, err
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        if info, err := os.Stat(name); err == nil {
+            s.segments = append(s.segments, name)
+            s.size += info.Size()
+        }
+    }
+    if len(names) > 0 {
+        log.Printf("spool %s: %d segments (%d bytes) left from a previous run", dir,
+            len(names), s.size)
+    }
+    go s.drainEvery(SPOOL_DRAIN_EVERY)
+    return s, nil
+}
+
+func (s *spoolSink) healthy() bool {
+    if h, ok := s.inner.(healthChecker); ok {
+        return h.Healthy()
+    }
+    return true
+}
+
+func (s *spoolSink) Send(topic string, payload string) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if len(s.segments) == 0 && s.healthy() {
+        if err := s.inner.Send(topic, payload); err == nil {
+            return nil
+        }
+    }
+    return s.append(time.Now(), topic, payload)
+}
+
+// append writes a record to the newest segment. Callers hold s.lock.
+func (s *spoolSink) append(when time.Time, topic string, payload string) error {
+    segmentMax := s.maxBytes / 8
+    if s.out == nil || s.outSize >= segmentMax {
+        if err := s.rotate(); err != nil {
+            return err
+        }
+    }
+    n, err := s.out.Write(encodeRecord(when, topic, payload))
+    s.outSize += int64(n)
+    s.size += int64(n)
+    if err != nil {
+        return err
+    }
+    atomic.AddUint64(&s.spooled, 1)
+
+    for s.size > s.maxBytes && len(s.segments) > 1 {
+        s.dropOldest("size limit")
+    }
+    return nil
+}
+
+// rotate starts a new segment. Callers hold s.lock.
+func (s *spoolSink) rotate() error {
+    if s.out != nil {
+        s.out.Close()
+    }
+    name := filepath.Join(s.dir, fmt.Sprintf("spool-%020d.log", time.Now().UnixNano()))
+    out, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+    if err != nil {
+        s.out = nil
+        return err
+    }
+    s.out, s.outSize = out, 0
+    s.segments = append(s.segments, name)
+    return nil
+}
+
+// dropOldest throws away the oldest segment. Callers hold s.lock.
+func (s *spoolSink) dropOldest(why string) {
+    name := s.segments[0]
+    records := countRecords(name, s.offset)
+    if info, err := os.Stat(name); err == nil {
+        s.size -= info.Size()
+    }
+    if len(s.segments) == 1 && s.out != nil {
+        s.out.Close()
+        s.out = nil
+    }
+    os.Remove(name)
+    s.segments, s.offset = s.segments[1:], 0
+    atomic.AddUint64(&s.dropped, uint64(records))
+    log.Printf("spool %s: dropped %d events (%s)", s.dir, records, why)
+}
+
+// countRecords counts the records in a segment after offset.
+func countRecords(name string, offset int64) int {
+    f, err := os.Open(name)
+    if err != nil {
+        return 0
+    }
+    defer f.Close()
+    f.Seek(offset, io.SeekStart)
+    r := bufio.NewReader(f)
+    n := 0
+    for {
+        if _, _, _, err := readRecord(r); err != nil {
+            return n
+        }
+        n++
+    }
+}
+
+func encodeRecord(when time.Time, topic string, payload string) []byte {
+    var header [SPOOL_HEADER]byte
+    binary.BigEndian.PutUint64(header[0:], uint64(when.UnixNano()))
+    binary.BigEndian.PutUint32(header[8:], uint32(len(topic)))
+    binary.BigEndian.PutUint32(header[12:], uint32(len(payload)))
+    return append(append(header[:], topic...), payload...)
+}
+
+func readRecord(r *bufio.Reader) (when time.Time, topic string, payload string, err error) {
+    var header [SPOOL_HEADER]byte
+    if _, err = io.ReadFull(r, header[:]); err != nil {
+        return
+    }
+    when = time.Unix(0, int64(binary.BigEndian.Uint64(header[0:])))
+    data := make([]byte, binary.BigEndian.Uint32(header[8:])+binary.BigEndian.Uint32(header[12:]))
+    if _, err = io.ReadFull(r, data); err != nil {
+        return
+    }
+    split := binary.BigEndian.Uint32(header[8:])
+    return when, string(data[:split]), string(data[split:]), nil
+}
+
+func (s *spoolSink) drainEvery(interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-s.done:
+            return
+        case <-ticker.C:
+            for s.drainChunk() {
+            }
+        }
+    }
+}
+
+// drainChunk sends up to SPOOL_DRAIN_CHUNK spooled records. It returns true
+// if there may be more to send right away.
+func (s *spoolSink) drainChunk() bool {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if len(s.segments) == 0 || !s.healthy() {
+        return false
+    }
+    name := s.segments[0]
+    f, err := os.Open(name)
+    if err != nil {
+        s.dropOldest(err.Error())
+        return true
+    }
+    defer f.Close()
+    f.Seek(s.offset, io.SeekStart)
+    r := bufio.NewReader(f)
+    for i := 0; i < SPOOL_DRAIN_CHUNK; i++ {
+        when, topic, payload, err := readRecord(r)
+        if err != nil {
+            // End of the segment. Unless it's the one being written, it is
+            // done with.
+            if len(s.segments) == 1 && s.out != nil {
+                s.out.Close()
+                s.out = nil
+            }
+            if info, err := f.Stat(); err == nil {
+                s.size -= info.Size()
+            }
+            os.Remove(name)
+            s.segments, s.offset = s.segments[1:], 0
+            if len(s.segments) == 0 {
+                s.size = 0
+                log.Printf("spool %s: drained", s.dir)
+            }
+            return len(s.segments) > 0
+        }
+        length := int64(SPOOL_HEADER + len(topic) + len(payload))
+        if s.maxAge > 0 && time.Since(when) > s.maxAge {
+            atomic.AddUint64(&s.dropped, 1)
+        } else if err := s.inner.Send(topic, payload); err != nil {
+            return false
+        }
+        s.offset += length
+    }
+    return true
+}
+
+func (s *spoolSink) Close() error {
+    close(s.done)
+    s.lock.Lock()
+    if s.out != nil {
+        s.out.Close()
+        s.out = nil
+    }
+    s.lock.Unlock()
+    return s.inner.Close()
+}
+
+func (s *spoolSink) String() string {
+    return fmt.Sprintf("%s (spool %s)", s.inner, s.dir)
+}
+
+// spoolTotals sums the spool counters over all sinks, for /metrics.
+// Callers hold sinksLock.
+func spoolTotals() (spooled uint64, dropped uint64) {
+    walkSinks(func(s sink) {
+        if w, ok := s.(*spoolSink); ok {
+            spooled += atomic.LoadUint64(&w.spooled)
+            dropped += atomic.LoadUint64(&w.dropped)
+        }
+    })
+    return spooled, dropped
+}
+
+
+// purge rewrites the spool without the events matching re, returning how
+// many were removed. New events go to a fresh segment afterwards.
+func (s *spoolSink) purge(re *regexp.Regexp) (int, error) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if s.out != nil {
+        s.out.Close()
+        s.out = nil
+    }
+
+    removed := 0
+    var err error
+    for i, name := range s.segments {
+        offset := int64(0)
+        if i == 0 {
+            offset = s.offset
+        }
+        var n int
+        if n, err = purgeSegment(name, offset, re, s.resign); err != nil {
+            break
+        }
+        removed += n
+        if i == 0 {
+            s.offset = 0
+        }
+    }
+
+    // Segments left with nothing in them are gone.
+    var segments []string
+    s.size = 0
+    for _, name := range s.segments {
+        if info, statErr := os.Stat(name); statErr == nil {
+            segments = append(segments, name)
+            s.size += info.Size()
+        }
+    }
+    s.segments = segments
+    return removed, err
+}
+
+// purgeSegment rewrites one segment from offset on without the matching
+// events, removing it if none are left.
+func purgeSegment(name string, offset int64, re *regexp.Regexp,
+    resign func(string) (string, error)) (int, error) {
+    f, err := os.Open(name)
+    if err != nil {
+        return 0, err
+    }
+    defer f.Close()
+    f.Seek(offset, io.SeekStart)
+    r := bufio.NewReader(f)
+
+    tmp := name + ".purge"
+    out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+    if err != nil {
+        return 0, err
+    }
+    w := bufio.NewWriter(out)
+    removed, kept := 0, 0
+    for {
+        when, topic, payload, err := readRecord(r)
+        if err != nil {
+            break
+        }
+        var n int
+        payload, n = purgePayload(payload, re, resign)
+        removed += n
+        if payload != "" {
+            w.Write(encodeRecord(when, topic, payload))
+            kept++
+        }
+    }
+    err = w.Flush()
+    if closeErr := out.Close(); err == nil {
+        err = closeErr
+    }
+    switch {
+    case err != nil:
+        os.Remove(tmp)
+        return 0, err
+    case removed == 0 && offset == 0:
+        return 0, os.Remove(tmp)
+    case kept == 0:
+        os.Remove(tmp)
+        return removed, os.Remove(name)
+    }
+    return removed, os.Rename(tmp, name)
+}
+
+// purgePayload drops the events matching re from a payload, which may be a
+// batch. It returns what is left ("" for nothing) and how many went. What is
+// left of a signed batch is signed again with resign; with no key to do
+// that, none of it could be verified, so all of it goes.
+func purgePayload(payload string, re *regexp.Regexp, resign func(string) (string, error)) (string, int) {
+    payloads, err := event.Unbatch(payload)
+    if err != nil {
+        if re.MatchString(payload) {
+            return "", 1
+        }
+        return payload, 0
+    }
+    var kept []string
+    for _, p := range payloads {
+        if !re.MatchString(p) {
+            kept = append(kept, p)
+        }
+    }
+    switch {
+    case len(kept) == len(payloads):
+        return payload, 0
+    case len(kept) == 0:
+        return "", len(payloads)
+    }
+    env, _, _ := event.ParseEnvelope(payload)
+    if env.Sig != "" && resign == nil {
+        return "", len(payloads)
+    }
+    rebatched, err := event.BatchFormat(kept, env.Enc, env.Format)
+    if err == nil && env.Sig != "" {
+        rebatched, err = resign(rebatched)
+    }
+    if err != nil {
+        return "", len(payloads)
+    }
+    return rebatched, len(payloads) - len(kept)
+}
@@ -0,0 +1,180 @@
+/*
+ * sink_spool_test.go
+ *
+ * The spool must hand events over in the order they were published, however
+ * the sink came and went, and lose only what a purge asked it to.
+ */
+
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "reflect"
+    "regexp"
+    "testing"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+// flakySink fails every send while down.
+type flakySink struct {
+    memorySink
+    down bool
+}
+
+func (f *flakySink) Send(topic string, payload string) error {
+    if f.down {
+        return errors.New("down")
+    }
+    return f.memorySink.Send(topic, payload)
+}
+
+func TestSpoolDrainsInOrder(t *testing.T) {
+    dir, err := ioutil.TempDir("", "spool")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    inner := &flakySink{}
+    s, err := newSpoolSink(inner, dir, 1, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer s.Close()
+
+    var want []string
+    for i := 0; i < 5; i++ {
+        inner.down = i >= 1 && i < 4
+        payload := fmt.Sprintf("event %d", i)
+        want = append(want, payload)
+        if err := s.Send("topic", payload); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // Event 4 found the sink up but must wait behind the spooled ones.
+    if !reflect.DeepEqual(inner.payloads, want[:1]) {
+        t.Fatalf("sent %q before draining", inner.payloads)
+    }
+    for s.drainChunk() {
+    }
+    if !reflect.DeepEqual(inner.payloads, want) {
+        t.Errorf("sent %q, want %q", inner.payloads, want)
+    }
+    if len(s.segments) != 0 || s.size != 0 {
+        t.Errorf("%d segments, %d bytes left", len(s.segments), s.size)
+    }
+}
+
+func TestSpoolPurge(t *testing.T) {
+    dir, err := ioutil.TempDir("", "spool")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    inner := &flakySink{down: true}
+    s, err := newSpoolSink(inner, dir, 1, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer s.Close()
+
+    batch, _ := event.Batch([]string{event.Prefix + `{"sql":"alice@example.com"}`,
+        event.Prefix + `{"sql":"bob"}`}, "gzip")
+    for _, payload := range []string{event.Prefix + `{"sql":"carol"}`, batch,
+        event.Prefix + `{"sql":"alice@example.com"}`} {
+        s.Send("topic", payload)
+    }
+    if n, err := s.purge(regexp.MustCompile(`alice@example\.com`)); n != 2 || err != nil {
+        t.Fatalf("purged %d, %v", n, err)
+    }
+    s.Send("topic", event.Prefix+`{"sql":"dan"}`)
+    inner.down = false
+    for s.drainChunk() {
+    }
+    var got []string
+    for _, payload := range inner.payloads {
+        events, _ := event.Unbatch(payload)
+        got = append(got, events...)
+    }
+    want := []string{event.Prefix + `{"sql":"carol"}`, event.Prefix + `{"sql":"bob"}`,
+        event.Prefix + `{"sql":"dan"}`}
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("sent %q, want %q", got, want)
+    }
+}
+
+func TestSpoolPurgeSigned(t *testing.T) {
+    dir, err := ioutil.TempDir("", "spool")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    inner := &flakySink{down: true}
+    s, err := newSpoolSink(inner, dir, 1, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer s.Close()
+    key := event.HMACKey("secret")
+    s.resign = (&signSink{inner: s, signer: key}).sign
+
+    batch, _ := event.Batch([]string{event.Prefix + `{"sql":"alice@example.com"}`,
+        event.Prefix + `{"sql":"bob"}`}, "gzip")
+    signed, _ := event.Sign(batch, key)
+    s.Send("topic", signed)
+    if n, err := s.purge(regexp.MustCompile(`alice@example\.com`)); n != 1 || err != nil {
+        t.Fatalf("purged %d, %v", n, err)
+    }
+    inner.down = false
+    for s.drainChunk() {
+    }
+    if len(inner.payloads) != 1 {
+        t.Fatalf("sent %q", inner.payloads)
+    }
+    if err := event.Verify(inner.payloads[0], key); err != nil {
+        t.Errorf("purged batch: %s", err)
+    }
+    got, err := event.Unbatch(inner.payloads[0])
+    if err != nil || !reflect.DeepEqual(got, []string{event.Prefix + `{"sql":"bob"}`}) {
+        t.Errorf("purged batch carries %q, %v", got, err)
+    }
+
+    // Without the key nothing left of it could be verified.
+    if left, n := purgePayload(signed, regexp.MustCompile(`bob`), nil); left != "" || n != 2 {
+        t.Errorf("unsigned purge left %q, %d", left, n)
+    }
+}
+
+func TestSpoolPurgeCBOR(t *testing.T) {
+    batch, _ := event.BatchFormat([]string{event.Prefix + `{"sql":"alice@example.com"}`,
+        event.Prefix + `{"sql":"bob"}`}, "gzip", event.FORMAT_CBOR)
+    left, n := purgePayload(batch, regexp.MustCompile(`alice@example\.com`), nil)
+    env, _, err := event.ParseEnvelope(left)
+    if n != 1 || err != nil || env.Format != event.FORMAT_CBOR || env.Enc != "gzip" {
+        t.Fatalf("purged %d, left %+v, %v", n, env, err)
+    }
+    got, err := event.Unbatch(left)
+    if err != nil || !reflect.DeepEqual(got, []string{event.Prefix + `{"sql":"bob"}`}) {
+        t.Errorf("purged batch carries %q, %v", got, err)
+    }
+}
+
+func TestZmqQueueBounded(t *testing.T) {
+    // No broker: everything queues, the oldest going once it's full.
+    s := &zmqSink{addr: "tcp://127.0.0.1:1"}
+    for i := 0; i < ZMQ_RETRY_QUEUE+5; i++ {
+        s.Send("topic", fmt.Sprintf("event %d", i))
+    }
+    if len(s.retry) != ZMQ_RETRY_QUEUE || s.dropped != 5 || s.retry[0].payload != "event 5" {
+        t.Errorf("%d queued, %d dropped, first %q", len(s.retry), s.dropped, s.retry[0].payload)
+    }
+    if n := s.purge(regexp.MustCompile(`^event 1\d$`)); n != 10 || len(s.retry) != ZMQ_RETRY_QUEUE-10 {
+        t.Errorf("purged %d, %d left", n, len(s.retry))
+    }
+}
@@ -0,0 +1,158 @@
+/*
+ * sink_sql.go
+ *
+ * A sink writing the -interval summaries as SQL, so their history can live
+ * in a table next to the data it describes. Each summary event becomes one
+ * multi-row INSERT into Table, a row per fingerprint in its top list;
+ * other events are ignored. The statements are appended to the file at
+ * Path or piped to Command, which is how they get executed, e.g.
+ *
+ *   -sql_table perf.query_summary -sql_exec 'mysql -h warehouse --batch'
+ *
+ * A CREATE TABLE IF NOT EXISTS for the table goes first. Values are written
+ * as escaped literals for MySQL's default sql_mode.
+ */
+
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+var sqlTableName = regexp.MustCompile(`^[A-Za-z0-9_$]+(\.[A-Za-z0-9_$]+)?$`)
+
+const SQL_COLUMNS = "window_start, window_secs, service_id, tenant_id, sort_by, position, " +
+    "fingerprint, name, count, time_ms, bytes, p50_ms, p95_ms, p99_ms, errors, success_rate"
+
+type sqlSink struct {
+    table   string // quoted
+    out     sink   // a file or exec sink taking the statements
+    created bool
+}
+
+// checkSqlSink makes sure a sql sink has a usable table and one place to
+// write to.
+func checkSqlSink(table string, path string, command string) error {
+    if !sqlTableName.MatchString(table) {
+        return fmt.Errorf("sql sink: bad table name %q", table)
+    }
+    if (path == "") == (command == "") {
+        return fmt.Errorf("sql sink: needs a path or a command, not both")
+    }
+    return nil
+}
+
+func newSqlSink(table string, path string, command string) (*sqlSink, error) {
+    if err := checkSqlSink(table, path, command); err != nil {
+        return nil, err
+    }
+    s := &sqlSink{table: "`" + strings.Replace(table, ".", "`.`", 1) + "`"}
+    if command != "" {
+        s.out = newExecSink(command)
+    } else {
+        f, err := newFileSink(path, 0, 0, 0)
+        if err != nil {
+            return nil, err
+        }
+        s.out = f
+    }
+    return s, nil
+}
+
+// sqlQuote returns value as a quoted SQL string literal.
+func sqlQuote(value string) string {
+    return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\x00", `\0`, "\n", `\n`, "\r", `\r`,
+        "\x1a", `\Z`).Replace(value) + "'"
+}
+
+func (s *sqlSink) createTable() string {
+    return "CREATE TABLE IF NOT EXISTS " + s.table + " (\n" +
+        "  window_start DATETIME NOT NULL,\n" +
+        "  window_secs DOUBLE NOT NULL,\n" +
+        "  service_id VARCHAR(255) NOT NULL,\n" +
+        "  tenant_id VARCHAR(255) NOT NULL,\n" +
+        "  sort_by VARCHAR(16) NOT NULL,\n" +
+        "  position INT NOT NULL,\n" +
+        "  fingerprint TEXT NOT NULL,\n" +
+        "  name VARCHAR(255) NOT NULL,\n" +
+        "  count BIGINT UNSIGNED NOT NULL,\n" +
+        "  time_ms DOUBLE NOT NULL,\n" +
+        "  bytes BIGINT UNSIGNED NOT NULL,\n" +
+        "  p50_ms DOUBLE NOT NULL,\n" +
+        "  p95_ms DOUBLE NOT NULL,\n" +
+        "  p99_ms DOUBLE NOT NULL,\n" +
+        "  errors BIGINT UNSIGNED NOT NULL,\n" +
+        "  success_rate DOUBLE NULL,\n" +
+        "  KEY (window_start)\n" +
+        ");"
+}
+
+// insert returns the INSERT for a summary, or "" if its top list is empty.
+func (s *sqlSink) insert(e *event.SummaryEvent) string {
+    if len(e.Top) == 0 {
+        return ""
+    }
+    start := sqlQuote(time.Unix(e.WindowStart, 0).UTC().Format("2006-01-02 15:04:05"))
+    rows := make([]string, len(e.Top))
+    for i, entry := range e.Top {
+        rate := "NULL"
+        if entry.SuccessRate != nil {
+            rate = fmt.Sprint(*entry.SuccessRate)
+        }
+        rows[i] = fmt.Sprintf("(%s, %g, %s, %s, %s, %d, %s, %s, %d, %g, %d, %g, %g, %g, %d, %s)",
+            start, e.WindowSecs, sqlQuote(e.ServiceId), sqlQuote(e.TenantId), sqlQuote(e.SortBy),
+            i+1, sqlQuote(entry.Fingerprint), sqlQuote(entry.Name), entry.Count, entry.Time,
+            entry.Bytes, entry.P50, entry.P95, entry.P99, entry.Errors, rate)
+    }
+    return "INSERT INTO " + s.table + " (" + SQL_COLUMNS + ") VALUES\n" +
+        strings.Join(rows, ",\n") + ";"
+}
+
+func (s *sqlSink) Send(topic string, payload string) error {
+    e, err := event.Decode(payload)
+    if err != nil {
+        return err
+    }
+    summary, ok := e.(*event.SummaryEvent)
+    if !ok {
+        return nil
+    }
+    stmt := s.insert(summary)
+    if stmt == "" {
+        return nil
+    }
+    if !s.created {
+        if err := s.out.Send(topic, s.createTable()); err != nil {
+            return err
+        }
+        s.created = true
+    }
+    return s.out.Send(topic, stmt)
+}
+
+func (s *sqlSink) Queued() int {
+    if q, ok := s.out.(queuedSink); ok {
+        return q.Queued()
+    }
+    return 0
+}
+
+func (s *sqlSink) Healthy() bool {
+    if h, ok := s.out.(healthChecker); ok {
+        return h.Healthy()
+    }
+    return true
+}
+
+func (s *sqlSink) Close() error {
+    return s.out.Close()
+}
+
+func (s *sqlSink) String() string {
+    return "sql:" + s.table + " via " + s.out.String()
+}
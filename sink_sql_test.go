@@ -0,0 +1,74 @@
+/*
+ * sink_sql_test.go
+ *
+ * The SQL sink creates its table once and turns each summary into a quoted
+ * multi-row INSERT, ignoring other events.
+ */
+
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+func TestSqlSink(t *testing.T) {
+    dir, err := ioutil.TempDir("", "sqlsink")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "summary.sql")
+    if _, err := newSqlSink("perf.summary; DROP TABLE x", path, ""); err == nil {
+        t.Errorf("bad table name accepted")
+    }
+    if _, err := newSqlSink("summary", "", ""); err == nil {
+        t.Errorf("no destination accepted")
+    }
+
+    s, err := newSqlSink("perf.summary", path, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    rate := 0.5
+    for _, e := range []interface{}{
+        &event.AlertEvent{Type: event.TYPE_ALERT, Kind: "slow_query"},
+        &event.SummaryEvent{Type: event.TYPE_SUMMARY, ServiceId: "api", TenantId: "shop",
+            WindowStart: 60, WindowSecs: 60, SortBy: "count", Top: []event.SummaryEntry{
+                {Fingerprint: "SELECT * FROM t WHERE a = 'x\\'", Count: 3, Time: 1.5, P99: 0.75},
+                {Fingerprint: "SELECT 1", Name: "ping", Count: 2, Errors: 1, SuccessRate: &rate}}},
+        &event.SummaryEvent{Type: event.TYPE_SUMMARY, ServiceId: "api", WindowStart: 120,
+            WindowSecs: 60, SortBy: "count", Top: []event.SummaryEntry{{Fingerprint: "SELECT 2", Count: 1}}},
+    } {
+        payload, _ := event.Encode(e)
+        if err := s.Send("topic", payload); err != nil {
+            t.Fatal(err)
+        }
+    }
+    s.Close()
+
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    out := string(data)
+    if n := strings.Count(out, "CREATE TABLE IF NOT EXISTS `perf`.`summary`"); n != 1 {
+        t.Errorf("%d CREATE TABLEs in\n%s", n, out)
+    }
+    if n := strings.Count(out, "INSERT INTO `perf`.`summary`"); n != 2 {
+        t.Errorf("%d INSERTs in\n%s", n, out)
+    }
+    for _, want := range []string{
+        `('1970-01-01 00:01:00', 60, 'api', 'shop', 'count', 1, 'SELECT * FROM t WHERE a = \'x\\\'', '', 3, 1.5, 0, 0, 0, 0.75, 0, NULL),`,
+        `('1970-01-01 00:01:00', 60, 'api', 'shop', 'count', 2, 'SELECT 1', 'ping', 2, 0, 0, 0, 0, 0, 1, 0.5);`,
+    } {
+        if !strings.Contains(out, want+"\n") {
+            t.Errorf("no %s in\n%s", want, out)
+        }
+    }
+}
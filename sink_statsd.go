@@ -0,0 +1,96 @@
+/*
+ * sink_statsd.go
+ *
+ * A sink turning query events into StatsD metrics, for latency dashboards
+ * in Datadog or Graphite without a consumer of the event stream. Each query
+ * counts once in <prefix>.query.count and, when its response was seen, its
+ * time in milliseconds goes to <prefix>.query.time. With DogStatsD tags (the
+ * default) both are tagged operation, service_id and tenant_id; plain StatsD
+ * has no tags, so those go in the name instead:
+ *
+ *   mysql_sniffer.query.time:1.52|ms|#operation:select,service_id:api,tenant_id:shop
+ *   mysql_sniffer.api.shop.select.query.time:1.52|ms
+ *
+ * Metrics go out over UDP, one datagram per query, so a missing agent costs
+ * nothing but the metrics. Other event types are ignored.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net"
+    "strings"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+type statsdSink struct {
+    addr   string
+    prefix string
+    tags   bool
+    conn   net.Conn
+}
+
+func newStatsdSink(addr string, prefix string, tags bool) (*statsdSink, error) {
+    if _, _, err := net.SplitHostPort(addr); err != nil {
+        return nil, fmt.Errorf("statsd address must be host:port: %s", err)
+    }
+    conn, err := net.Dial("udp", addr)
+    if err != nil {
+        return nil, err
+    }
+    return &statsdSink{addr: addr, prefix: strings.TrimSuffix(prefix, "."), tags: tags,
+        conn: conn}, nil
+}
+
+// statsdName makes a value safe to use in a metric name or tag.
+func statsdName(value string) string {
+    if value == "" {
+        return "none"
+    }
+    return strings.Map(func(r rune) rune {
+        switch {
+        case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+            return r
+        }
+        return '_'
+    }, value)
+}
+
+// metrics returns the lines for a query event.
+func (s *statsdSink) metrics(e *event.QueryEvent) string {
+    op, service, tenant := statsdName(e.Operate), statsdName(e.ServiceId), statsdName(e.TenantId)
+    name, suffix := s.prefix+".query.", ""
+    if s.tags {
+        suffix = fmt.Sprintf("|#operation:%s,service_id:%s,tenant_id:%s", op, service, tenant)
+    } else {
+        name = fmt.Sprintf("%s.%s.%s.%s.query.", s.prefix, service, tenant, op)
+    }
+    lines := name + "count:1|c" + suffix
+    if e.Time != nil {
+        lines += fmt.Sprintf("\n%stime:%g|ms%s", name, *e.Time/1000, suffix)
+    }
+    return lines
+}
+
+func (s *statsdSink) Send(topic string, payload string) error {
+    var e event.QueryEvent
+    if err := json.Unmarshal([]byte(strings.TrimPrefix(payload, event.Prefix)), &e); err != nil {
+        return err
+    }
+    if e.Type != "" && e.Type != event.TYPE_QUERY {
+        return nil
+    }
+    _, err := s.conn.Write([]byte(s.metrics(&e)))
+    return err
+}
+
+func (s *statsdSink) Close() error {
+    return s.conn.Close()
+}
+
+func (s *statsdSink) String() string {
+    return "statsd:" + s.addr
+}
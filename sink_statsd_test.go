@@ -0,0 +1,52 @@
+/*
+ * sink_statsd_test.go
+ *
+ * The StatsD sink sends a count and a time for each query event, tagged or
+ * named by operation, service and tenant, and nothing for other events.
+ */
+
+package main
+
+import (
+    "net"
+    "testing"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+func TestStatsdSink(t *testing.T) {
+    conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    read := func() string {
+        buf := make([]byte, 1500)
+        conn.SetReadDeadline(time.Now().Add(time.Second))
+        n, _, err := conn.ReadFrom(buf)
+        if err != nil {
+            return ""
+        }
+        return string(buf[:n])
+    }
+
+    s, err := newStatsdSink(conn.LocalAddr().String(), "sniff", true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer s.Close()
+    s.Send("topic", event.Prefix+`{"type":"alert","kind":"slow_query"}`)
+    s.Send("topic", event.Prefix+`{"type":"query","operate":"select","service_id":"api","tenant_id":"","time":1520}`)
+    want := "sniff.query.count:1|c|#operation:select,service_id:api,tenant_id:none\n" +
+        "sniff.query.time:1.52|ms|#operation:select,service_id:api,tenant_id:none"
+    if got := read(); got != want {
+        t.Errorf("got %q, want %q", got, want)
+    }
+
+    s.tags = false
+    s.Send("topic", event.Prefix+`{"operate":"insert","service_id":"a.b","tenant_id":"shop"}`)
+    if got, want := read(), "sniff.a_b.shop.insert.query.count:1|c"; got != want {
+        t.Errorf("got %q, want %q", got, want)
+    }
+}
@@ -0,0 +1,248 @@
+/*
+ * soak.go
+ *
+ * The `soak` command: a stress test to run before rolling out. It plays
+ * -soak_conns synthetic clients talking to a server through handlePacket
+ * (by way of -workers, if given), as if captured but without pcap, for
+ * -duration: each logs in, runs queries against a spread of tables and
+ * disconnects, and another takes its place. Events are encoded as usual and then discarded. Progress is
+ * logged as it goes; it fails if streams outlive their connections, the
+ * stream cap is broken, the decoder loses sync or the heap grows past
+ * -soak_max_mb.
+ */
+
+package main
+
+import (
+    "fmt"
+    "log"
+    "math/rand"
+    "runtime"
+    "time"
+)
+
+const (
+    SOAK_DURATION     = time.Minute // if -duration isn't given
+    SOAK_CONN_QUERIES = 200         // queries a connection runs before going
+    SOAK_TABLES       = 100
+    SOAK_PROGRESS     = 5 * time.Second
+    SOAK_CLIENT_PORT  = 10000 // first client port used
+)
+
+var soakConns int
+var soakMaxMB int
+
+// discardSink encodes nothing further and keeps nothing.
+type discardSink struct{}
+
+func (discardSink) Send(topic string, payload string) error { return nil }
+func (discardSink) Close() error                            { return nil }
+func (discardSink) String() string                          { return "discard" }
+
+type soakConn struct {
+    ip      [4]byte
+    port    uint16
+    cseq    uint32 // next client and server sequence numbers
+    sseq    uint32
+    queries int
+    open    bool
+}
+
+type soaker struct {
+    server     [4]byte
+    serverPort uint16 // if not -P
+    now        time.Time
+    buf        []byte
+    pkt        capturedPacket
+    rnd        *rand.Rand
+    conns      []soakConn
+    dispatch   func(pkt *capturedPacket) // with -workers
+
+    queries   uint64
+    peakHeap  uint64
+    overLimit int // most streams tracked beyond -max-streams
+}
+
+// packet frames payload as an Ethernet/IPv4/TCP packet and hands it to the
+// decoder. The buffer is reused, as libpcap's is.
+func (s *soaker) packet(c *soakConn, request bool, flags byte, payload []byte) {
+    src, dst := c.ip, s.server
+    sport, dport := c.port, port
+    if s.serverPort != 0 {
+        dport = s.serverPort
+    }
+    seq := &c.cseq
+    if !request {
+        src, dst, sport, dport, seq = dst, src, dport, sport, &c.sseq
+    }
+    total := 40 + len(payload)
+    b := append(s.buf[:0], make([]byte, 12)...)
+    b = append(b, 0x08, 0x00)
+    b = append(b, 0x45, 0, byte(total>>8), byte(total), 0, 0, 0x40, 0, 64, 6, 0, 0)
+    b = append(b, src[:]...)
+    b = append(b, dst[:]...)
+    b = append(b, byte(sport>>8), byte(sport), byte(dport>>8), byte(dport),
+        byte(*seq>>24), byte(*seq>>16), byte(*seq>>8), byte(*seq), 0, 0, 0, 0,
+        5<<4, flags, 0xff, 0xff, 0, 0, 0, 0)
+    b = append(b, payload...)
+    *seq += uint32(len(payload))
+    if flags&(TCP_SYN|TCP_FIN) != 0 {
+        *seq++
+    }
+    s.buf = b
+
+    s.pkt.Time, s.pkt.Data = s.now, b
+    s.pkt.Caplen, s.pkt.Len = uint32(len(b)), uint32(len(b))
+    if s.dispatch != nil {
+        s.dispatch(&s.pkt)
+        return
+    }
+    stateLock.Lock()
+    handlePacket(&s.pkt)
+    if maxStreams > 0 && streamCount()-maxStreams > s.overLimit {
+        s.overLimit = streamCount() - maxStreams
+    }
+    stateLock.Unlock()
+}
+
+func mysqlPacket(seq byte, payload []byte) []byte {
+    size := len(payload)
+    return append([]byte{byte(size), byte(size >> 8), byte(size >> 16), seq}, payload...)
+}
+
+func (s *soaker) connect(c *soakConn) {
+    c.port++
+    if c.port < SOAK_CLIENT_PORT {
+        c.port = SOAK_CLIENT_PORT
+    }
+    c.cseq, c.sseq, c.open = s.rnd.Uint32(), s.rnd.Uint32(), true
+    s.packet(c, true, TCP_SYN, nil)
+    s.packet(c, false, TCP_SYN|TCP_ACK, nil)
+    s.packet(c, false, TCP_ACK, mysqlPacket(0, fakeGreeting()))
+    s.packet(c, true, TCP_ACK, mysqlPacket(1, fakeLogin(fmt.Sprintf("soak%d", s.rnd.Intn(10)))))
+    s.packet(c, false, TCP_ACK, mysqlPacket(2, selftestOK))
+}
+
+func (s *soaker) query(c *soakConn) {
+    var sql string
+    table := s.rnd.Intn(SOAK_TABLES)
+    switch s.rnd.Intn(4) {
+    case 0:
+        sql = fmt.Sprintf("UPDATE t%d SET n = n + 1 WHERE id = %d", table, s.rnd.Int())
+    case 1:
+        sql = fmt.Sprintf("INSERT INTO t%d (id, name) VALUES (%d, 'soak %d')", table, s.rnd.Int(), s.rnd.Int())
+    default:
+        sql = fmt.Sprintf("SELECT * FROM t%d WHERE id IN (%d, %d) LIMIT 10", table, s.rnd.Int(), s.rnd.Int())
+    }
+    s.packet(c, true, TCP_ACK, mysqlPacket(0, append([]byte{COM_QUERY}, sql...)))
+    s.now = s.now.Add(time.Duration(100+s.rnd.Intn(5000)) * time.Microsecond)
+    s.packet(c, false, TCP_ACK, mysqlPacket(1, selftestOK))
+    c.queries++
+    s.queries++
+}
+
+func (s *soaker) close(c *soakConn) {
+    s.packet(c, true, TCP_FIN|TCP_ACK, mysqlPacket(0, []byte{COM_QUIT}))
+    s.packet(c, false, TCP_FIN|TCP_ACK, nil)
+    c.queries, c.open = 0, false
+}
+
+func (s *soaker) progress(start time.Time) {
+    var m runtime.MemStats
+    runtime.ReadMemStats(&m)
+    if m.HeapAlloc > s.peakHeap {
+        s.peakHeap = m.HeapAlloc
+    }
+    stateLock.Lock()
+    streams, fingerprints := streamCount(), len(qbuf)
+    stateLock.Unlock()
+    log.Printf("soak: %d queries (%.0f/s), %d streams, %d fingerprints, heap %dMB",
+        s.queries, float64(s.queries)/time.Since(start).Seconds(), streams, fingerprints,
+        m.HeapAlloc>>20)
+}
+
+// runSoak runs the soak test. It returns false if a check failed.
+func runSoak(duration time.Duration) bool {
+    if duration <= 0 {
+        duration = SOAK_DURATION
+    }
+    sinksLock.Lock()
+    sinks, sinkSpecs = []sink{discardSink{}}, []sinkSpec{{Type: "discard"}}
+    sinksLock.Unlock()
+
+    s := &soaker{server: [4]byte{10, 0, 0, 1}, now: time.Now(),
+        rnd: rand.New(rand.NewSource(1)), conns: make([]soakConn, soakConns)}
+    for i := range s.conns {
+        n := i + 1
+        s.conns[i].ip = [4]byte{10, 1 + byte(n>>16), byte(n >> 8), byte(n)}
+        // Spread the ports so connections don't all churn at once.
+        s.conns[i].port = uint16(SOAK_CLIENT_PORT + s.rnd.Intn(1000))
+        s.conns[i].queries = s.rnd.Intn(SOAK_CONN_QUERIES)
+    }
+    if decoderCount > 0 {
+        decoders = startDecoders(decoderCount, false)
+        s.dispatch = decoders.dispatch
+    }
+    log.Printf("soak: %d connections for %s", soakConns, duration)
+
+    start := time.Now()
+    deadline := start.Add(duration)
+    nextProgress := start.Add(SOAK_PROGRESS)
+    for step := 0; ; step++ {
+        if step%1000 == 0 {
+            now := time.Now()
+            if stopping() || now.After(deadline) {
+                break
+            }
+            if now.After(nextProgress) {
+                s.progress(start)
+                nextProgress = now.Add(SOAK_PROGRESS)
+            }
+        }
+        c := &s.conns[step%len(s.conns)]
+        s.now = s.now.Add(10 * time.Microsecond)
+        if !c.open {
+            s.connect(c)
+        }
+        s.query(c)
+        if c.queries >= SOAK_CONN_QUERIES {
+            s.close(c)
+        }
+    }
+    for i := range s.conns {
+        if s.conns[i].open {
+            s.close(&s.conns[i])
+        }
+    }
+    if decoders != nil {
+        decoders.stop()
+    }
+    s.progress(start)
+
+    ok := true
+    fail := func(format string, args ...interface{}) {
+        log.Printf("soak: FAIL: "+format, args...)
+        ok = false
+    }
+    stateLock.Lock()
+    if streamCount() > 0 {
+        fail("%d streams still tracked after every connection closed", streamCount())
+    }
+    if s.overLimit > 0 {
+        fail("tracked %d streams over -max-streams", s.overLimit)
+    }
+    if stats.desyncs > 0 {
+        fail("%d desyncs", stats.desyncs)
+    }
+    if n := stats.published + stats.sampled_out; maxStreams == 0 && n < s.queries {
+        fail("%d of %d queries published", n, s.queries)
+    }
+    stateLock.Unlock()
+    if soakMaxMB > 0 && s.peakHeap > uint64(soakMaxMB)<<20 {
+        fail("heap peaked at %dMB, over -soak_max_mb", s.peakHeap>>20)
+    }
+    if ok {
+        log.Printf("soak: ok, %d queries, heap peaked at %dMB", s.queries, s.peakHeap>>20)
+    }
+    return ok
+}
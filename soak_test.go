@@ -0,0 +1,21 @@
+/*
+ * soak_test.go
+ *
+ * A short soak run stays in step and within its memory bounds.
+ */
+
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestSoak(t *testing.T) {
+    resetState()
+    soakConns = 50
+    defer func() { stats.published, stats.desyncs = 0, 0 }()
+    if !runSoak(200 * time.Millisecond) {
+        t.Errorf("soak failed")
+    }
+}
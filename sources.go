@@ -0,0 +1,75 @@
+/*
+ * sources.go
+ *
+ * Per-client totals. When the -f key already splits queries by client (#s or
+ * #i) we also keep totals per client, so reports can show who is sending the
+ * load as well as what the load is.
+ */
+
+package main
+
+import (
+    "sort"
+)
+
+type sourceData struct {
+    queries      uint64
+    bytes        uint64
+    timed        uint64
+    timeTotal    uint64 // nanoseconds
+    fingerprints map[string]bool
+}
+
+// sbuf holds the totals per source, keyed by ip:port for #s or ip for #i.
+var sbuf map[string]*sourceData = make(map[string]*sourceData)
+
+// sourceField is F_SOURCE or F_SOURCEIP if the format has one of them in it,
+// and F_NONE if per-source totals aren't kept.
+var sourceField int = F_NONE
+
+// sourceFieldOf picks the finest source field in a format.
+func sourceFieldOf(format []interface{}) int {
+    field := F_NONE
+    for _, item := range format {
+        if f, ok := item.(formatField); ok {
+            switch f.kind {
+            case F_SOURCE:
+                return F_SOURCE
+            case F_SOURCEIP:
+                field = F_SOURCEIP
+            }
+        }
+    }
+    return field
+}
+
+// noteSource counts a query with the given key against its source.
+func noteSource(rs *source, key string, bytes uint64) {
+    name := rs.src
+    if sourceField == F_SOURCEIP {
+        name = rs.srcip
+    }
+    sdata, ok := sbuf[name]
+    if !ok {
+        sdata = &sourceData{fingerprints: make(map[string]bool)}
+        sbuf[name] = sdata
+    }
+    sdata.queries++
+    sdata.bytes += bytes
+    sdata.fingerprints[key] = true
+    rs.sdata = sdata
+}
+
+// sortedSources returns the sources in sbuf, busiest first.
+func sortedSources() []string {
+    var list sortableSlice
+    for key, sdata := range sbuf {
+        list = append(list, sortable{value: -float64(sdata.queries), line: key})
+    }
+    sort.Sort(list)
+    keys := make([]string, len(list))
+    for i, item := range list {
+        keys[i] = item.line
+    }
+    return keys
+}
@@ -0,0 +1,125 @@
+/*
+ * stages.go
+ *
+ * Where the time goes inside the sniffer. With -trace_stages each stage of
+ * the pipeline counts its calls and the time spent in them, on /metrics as
+ * mysql_sniffer_stage_calls_total, mysql_sniffer_stage_seconds_total and
+ * mysql_sniffer_stage_max_seconds by stage:
+ *
+ *   capture       kernel timestamp to the packet reaching us (live only)
+ *   reassembly    frame parsing, direction and stream lookup
+ *   decode        the MySQL protocol, including the two below
+ *   canonicalize  turning statements into fingerprints
+ *   publish       encoding events and handing them to the sinks
+ *
+ * Off, it costs a branch per stage.
+ *
+ * Separately, and always when capturing live, each query event's latency
+ * from the kernel timestamp of the packet completing it to its hand-off to
+ * the sinks goes into the mysql_sniffer_publish_latency_seconds histogram:
+ * what an alert fed by the sniffer lags the server by, sinks aside.
+ */
+
+package main
+
+import (
+    "fmt"
+    "io"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    STAGE_CAPTURE = iota
+    STAGE_REASSEMBLY
+    STAGE_DECODE
+    STAGE_CANONICALIZE
+    STAGE_PUBLISH
+    STAGE_COUNT
+)
+
+var stageNames = [STAGE_COUNT]string{"capture", "reassembly", "decode", "canonicalize", "publish"}
+
+// Workers canonicalize outside stateLock, so stages are counted atomically.
+type stageStats struct {
+    calls uint64
+    nanos uint64
+    max   uint64
+}
+
+var traceStages bool
+var stages [STAGE_COUNT]stageStats
+
+var publishLatencyBounds = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01,
+    0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// measureLatency is set when capturing live; a pcap file's timestamps are
+// long past.
+var measureLatency bool
+var publishLatency = newHistogram(publishLatencyBounds)
+
+// stageStart returns the time a stage starts, or the zero time if stages
+// aren't traced.
+func stageStart() time.Time {
+    if !traceStages {
+        return time.Time{}
+    }
+    return time.Now()
+}
+
+// stageEnd counts a call of stage that started at started.
+func stageEnd(stage int, started time.Time) {
+    if started.IsZero() {
+        return
+    }
+    noteStage(stage, time.Since(started))
+}
+
+func noteStage(stage int, d time.Duration) {
+    if d < 0 {
+        d = 0
+    }
+    s := &stages[stage]
+    atomic.AddUint64(&s.calls, 1)
+    atomic.AddUint64(&s.nanos, uint64(d))
+    for {
+        max := atomic.LoadUint64(&s.max)
+        if uint64(d) <= max || atomic.CompareAndSwapUint64(&s.max, max, uint64(d)) {
+            return
+        }
+    }
+}
+
+// notePublished times a query event from capture to now.
+func notePublished() {
+    if measureLatency {
+        publishLatency.observe(time.Since(pktTime).Seconds())
+    }
+}
+
+func writeStageMetrics(w io.Writer) {
+    if measureLatency {
+        fmt.Fprintf(w, "# TYPE mysql_sniffer_publish_latency_seconds histogram\n")
+        publishLatency.writeMetric(w, "mysql_sniffer_publish_latency_seconds", "")
+    }
+    if !traceStages {
+        return
+    }
+    for _, m := range []struct {
+        name, kind string
+        value      func(s *stageStats) string
+    }{
+        {"mysql_sniffer_stage_calls_total", "counter",
+            func(s *stageStats) string { return fmt.Sprint(atomic.LoadUint64(&s.calls)) }},
+        {"mysql_sniffer_stage_seconds_total", "counter",
+            func(s *stageStats) string { return fmt.Sprintf("%.9f", float64(atomic.LoadUint64(&s.nanos))/1e9) }},
+        {"mysql_sniffer_stage_max_seconds", "gauge",
+            func(s *stageStats) string { return fmt.Sprintf("%.9f", float64(atomic.LoadUint64(&s.max))/1e9) }},
+    } {
+        fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+        for i := range stages {
+            fmt.Fprintf(w, "%s{%s} %s\n", m.name, metricLabels("stage", stageNames[i]),
+                m.value(&stages[i]))
+        }
+    }
+}
@@ -0,0 +1,55 @@
+/*
+ * stages_test.go
+ *
+ * Time spent in each pipeline stage, and from capture to publish.
+ */
+
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+func TestStageMetrics(t *testing.T) {
+    traceStages = true
+    defer func() { traceStages, stages = false, [STAGE_COUNT]stageStats{} }()
+    cleanupQuery([]byte("SELECT 1"))
+    noteStage(STAGE_CAPTURE, 2*time.Millisecond)
+    noteStage(STAGE_CAPTURE, time.Millisecond)
+    var out strings.Builder
+    writeStageMetrics(&out)
+    for _, want := range []string{
+        `mysql_sniffer_stage_calls_total{stage="canonicalize"} 1`,
+        `mysql_sniffer_stage_calls_total{stage="capture"} 2`,
+        `mysql_sniffer_stage_seconds_total{stage="capture"} 0.003000000`,
+        `mysql_sniffer_stage_max_seconds{stage="capture"} 0.002000000`,
+    } {
+        if !strings.Contains(out.String(), want+"\n") {
+            t.Errorf("no %s in\n%s", want, out.String())
+        }
+    }
+}
+
+func TestPublishLatency(t *testing.T) {
+    resetState()
+    measureLatency = true
+    defer func() { measureLatency, publishLatency = false, newHistogram(publishLatencyBounds) }()
+    pktTime = time.Now().Add(-3 * time.Millisecond)
+    publish(&event.QueryEvent{Type: event.TYPE_QUERY, Sql: "SELECT ?"})
+    publish(&event.AlertEvent{Type: event.TYPE_ALERT})
+    var out strings.Builder
+    writeStageMetrics(&out)
+    for _, want := range []string{
+        `mysql_sniffer_publish_latency_seconds_bucket{le="0.0025"} 0`,
+        `mysql_sniffer_publish_latency_seconds_bucket{le="0.005"} 1`,
+        `mysql_sniffer_publish_latency_seconds_count{} 1`,
+    } {
+        if !strings.Contains(out.String(), want+"\n") {
+            t.Errorf("no %s in\n%s", want, out.String())
+        }
+    }
+}
@@ -0,0 +1,148 @@
+/*
+ * subscribe.go
+ *
+ * The `subscribe` command: connect to the topic we publish on, check every
+ * event against the schema in the event package and print the ones matching
+ * the given filters. Lets operators confirm the pipeline end to end, and
+ * reports gaps in the event sequence numbers.
+ */
+
+package main
+
+import (
+    "fmt"
+    "log"
+    "strings"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+    zmq "github.com/pebbe/zmq4"
+)
+
+// runSubscribe prints events until the process is killed. Events are shown if
+// their SQL contains match, their operation is operate and they took at least
+// minTime milliseconds; empty/zero filters match everything. With verify, an
+// algorithm:key spec, messages without a good signature are invalid.
+func runSubscribe(match string, operate string, minTime float64, verify string) {
+    var verifier event.Verifier
+    if verify != "" {
+        alg, key, err := loadKeySpec("verify", verify)
+        if err == nil {
+            verifier, err = makeVerifier(alg, key.Value())
+        }
+        if err != nil {
+            log.Fatalf("Bad -verify: %s", err.Error())
+        }
+    }
+    addr := subaddr
+    if addr == "" {
+        addr = zmqaddr
+    }
+    sub, err := zmq.NewSocket(zmq.SUB)
+    if err != nil {
+        log.Fatalf("Failed to create socket: %s", err.Error())
+    }
+    sub.SetSubscribe(topic)
+    if err := sub.Connect(addr); err != nil {
+        log.Fatalf("Failed to connect to %s: %s", addr, err.Error())
+    }
+    log.Printf("Subscribed to %s on %s", topic, addr)
+
+    var received, invalid, lost uint64
+    lastSeq := make(map[string]uint64) // by topic
+    for {
+        msg, err := sub.RecvMessage(0)
+        if err != nil {
+            log.Fatalf("Receive failed: %s", err.Error())
+        }
+        received++
+        if len(msg) != 2 {
+            invalid++
+            log.Printf("%sINVALID%s message with %d frames (%d of %d invalid)",
+                errColor(COLOR_RED), errColor(COLOR_DEFAULT), len(msg), invalid, received)
+            continue
+        }
+
+        if verifier != nil {
+            if err := event.Verify(msg[1], verifier); err != nil {
+                invalid++
+                log.Printf("%sINVALID%s %s: %s (%d of %d invalid)", errColor(COLOR_RED),
+                    errColor(COLOR_DEFAULT), msg[0], err, invalid, received)
+                continue
+            }
+        }
+        payloads, err := event.Unbatch(msg[1])
+        if err != nil {
+            invalid++
+            log.Printf("%sINVALID%s %s: %s (%d of %d invalid)\n    %q",
+                errColor(COLOR_RED), errColor(COLOR_DEFAULT), msg[0], err, invalid, received, msg[1])
+            continue
+        }
+        for _, payload := range payloads {
+            ev, err := event.Decode(payload)
+            if err == nil {
+                err = ev.(interface {
+                    Validate() error
+                }).Validate()
+            }
+            if err != nil {
+                invalid++
+                log.Printf("%sINVALID%s %s: %s (%d of %d invalid)\n    %s",
+                    errColor(COLOR_RED), errColor(COLOR_DEFAULT), msg[0], err, invalid, received, payload)
+                continue
+            }
+
+            // Count gaps in the numbering; going backwards means the
+            // sniffer restarted.
+            if seq := ev.(event.Sequenced).Sequence(); seq > 0 {
+                if last := lastSeq[msg[0]]; last > 0 && seq > last+1 {
+                    lost += seq - last - 1
+                    log.Printf("%sLOST%s %d events on %s (%d in total)", errColor(COLOR_RED),
+                        errColor(COLOR_DEFAULT), seq-last-1, msg[0], lost)
+                } else if seq <= last {
+                    log.Printf("Sequence on %s restarted at %d", msg[0], seq)
+                }
+                lastSeq[msg[0]] = seq
+            }
+
+            switch e := ev.(type) {
+            case *event.QueryEvent:
+                latency := "-"
+                if d, ok := e.Latency(); ok {
+                    if d.Seconds()*1000 < minTime {
+                        continue
+                    }
+                    latency = d.String()
+                } else if minTime > 0 {
+                    continue
+                }
+                if (operate != "" && e.Operate != operate) ||
+                    (match != "" && !strings.Contains(e.Sql, match)) {
+                    continue
+                }
+                fmt.Printf("%s%s%s %s/%s %s%-6s%s %10s %8dB  %s\n", color(COLOR_CYAN),
+                    msg[0], color(COLOR_DEFAULT), e.TenantId, e.ServiceId, color(COLOR_YELLOW),
+                    e.Operate, color(COLOR_DEFAULT), latency, e.Size,
+                    highlightSQL(e.Sql, colorStdout))
+            case *event.ConnectionEvent:
+                if match != "" || operate != "" || minTime > 0 {
+                    continue
+                }
+                fmt.Printf("%s%s%s %s/%s %sconnection %s%s %s gen=%d\n", color(COLOR_CYAN),
+                    msg[0], color(COLOR_DEFAULT), e.TenantId, e.ServiceId, color(COLOR_GREEN),
+                    e.State, color(COLOR_DEFAULT), e.Client, e.Generation)
+            case *event.WatermarkEvent:
+                continue
+            case *event.AlertEvent:
+                fmt.Printf("%s%s%s %s/%s %salert %s%s %s\n", color(COLOR_CYAN), msg[0],
+                    color(COLOR_DEFAULT), e.TenantId, e.ServiceId, color(COLOR_RED), e.Kind,
+                    color(COLOR_DEFAULT), e.Message)
+            default:
+                if match != "" || operate != "" || minTime > 0 {
+                    continue
+                }
+                fmt.Printf("%s%s%s %s\n", color(COLOR_CYAN), msg[0], color(COLOR_DEFAULT),
+                    strings.TrimPrefix(payload, event.Prefix))
+            }
+        }
+    }
+}
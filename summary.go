@@ -0,0 +1,223 @@
+/*
+ * summary.go
+ *
+ * The periodic top-N summary. Every -interval the fingerprints that did the
+ * most in that interval, by -top_by count, time or bytes, are printed as a
+ * table and published as a "summary" event:
+ *
+ *   mysql-sniffer -interval 1m -top 10 -top_by time
+ *
+ * Only what happened during the interval counts, so a query that was hot an
+ * hour ago doesn't stay on top, and the p50/p95/p99 response times are those
+ * of the interval too.
+ */
+
+package main
+
+import (
+    "fmt"
+    "io"
+    "sort"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+const (
+    TOP_BY_COUNT = "count"
+    TOP_BY_TIME  = "time"
+    TOP_BY_BYTES = "bytes"
+)
+
+var summaryInterval time.Duration
+var summaryTop int
+var summaryBy string
+var summaryStart time.Time = time.Now()
+
+// Totals of each fingerprint at the start of the interval.
+type summaryTotals struct {
+    count     uint64
+    timeTotal uint64
+    bytes     uint64
+    times     latencyDigest
+    oks       uint64
+    errs      uint64
+}
+
+var summaryLast map[string]summaryTotals = make(map[string]summaryTotals)
+
+// successRates holds each fingerprint's success rate over the last
+// interval, for /metrics.
+var successRates map[string]float64 = make(map[string]float64)
+
+func validTopBy(by string) error {
+    switch by {
+    case TOP_BY_COUNT, TOP_BY_TIME, TOP_BY_BYTES:
+        return nil
+    }
+    return fmt.Errorf("unknown -top_by %q", by)
+}
+
+// summarize returns the top fingerprints since the last call and starts a
+// new interval. Must be called with stateLock held.
+func summarize() []event.SummaryEntry {
+    var list sortableSlice
+    deltas := make(map[string]summaryTotals)
+    last := summaryLast
+    summaryLast = make(map[string]summaryTotals, len(qbuf))
+    rates := make(map[string]float64)
+    for key, qdata := range qbuf {
+        now := summaryTotals{qdata.count, qdata.timeTotal, qdata.bytes, qdata.times.clone(),
+            qdata.oks, qdata.errs}
+        summaryLast[key] = now
+        d := now
+        // Purged and seen again since, if it went backwards.
+        if was, ok := last[key]; ok && was.count <= now.count {
+            d = summaryTotals{now.count - was.count, now.timeTotal - was.timeTotal,
+                now.bytes - was.bytes, now.times.since(&was.times), now.oks - was.oks,
+                now.errs - was.errs}
+        }
+        if rate, ok := successRate(d.oks, d.errs); ok {
+            rates[key] = rate
+        }
+        if d.count == 0 || hiddenQuery(qdata) {
+            continue
+        }
+        deltas[key] = d
+        value := float64(d.count)
+        switch summaryBy {
+        case TOP_BY_TIME:
+            value = float64(d.timeTotal)
+        case TOP_BY_BYTES:
+            value = float64(d.bytes)
+        }
+        list = append(list, sortable{value: -value, line: key})
+    }
+    successRates = rates
+    sort.Sort(list)
+    if summaryTop > 0 && len(list) > summaryTop {
+        list = list[:summaryTop]
+    }
+
+    top := make([]event.SummaryEntry, 0, len(list))
+    for _, item := range list {
+        d := deltas[item.line]
+        e := event.SummaryEntry{
+            Fingerprint: item.line,
+            Name:        nameOf(item.line),
+            Count:       d.count,
+            Time:        float64(d.timeTotal) / 1e6,
+            Bytes:       d.bytes,
+        }
+        e.P50, e.P95, e.P99 = d.times.percentilesMs()
+        e.Errors = d.errs
+        if rate, ok := rates[item.line]; ok {
+            e.SuccessRate = &rate
+        }
+        top = append(top, e)
+    }
+    return top
+}
+
+func printSummary(now time.Time, top []event.SummaryEntry) {
+    fmt.Printf("%s== top %d by %s, %s to %s%s\n", color(COLOR_CYAN), len(top), summaryBy,
+        summaryStart.Format("15:04:05"), now.Format("15:04:05"), color(COLOR_DEFAULT))
+    fmt.Printf("%8s %10s %10s %9s %9s %9s %7s  %s\n", "count", "time(ms)", "bytes", "p50", "p95",
+        "p99", "ok%", "query")
+    for _, e := range top {
+        fmt.Printf("%8d %10.1f %10d %9.3f %9.3f %9.3f %7s  %s\n", e.Count, e.Time, e.Bytes, e.P50,
+            e.P95, e.P99, percentOf(e.SuccessRate), labelOf(e.Fingerprint))
+    }
+}
+
+// noteOutcome counts a finished response as a success or an error against
+// its fingerprint.
+func noteOutcome(rs *source) {
+    if rs.qdata == nil {
+        return
+    }
+    if rs.resp.err != nil {
+        noteError(rs)
+        rs.qdata.errs++
+    } else {
+        rs.qdata.oks++
+    }
+}
+
+// successRate returns oks over all responses, if there were any.
+func successRate(oks uint64, errs uint64) (float64, bool) {
+    if oks+errs == 0 {
+        return 0, false
+    }
+    return float64(oks) / float64(oks+errs), true
+}
+
+func percentOf(rate *float64) string {
+    if rate == nil {
+        return "-"
+    }
+    return fmt.Sprintf("%.2f", *rate*100)
+}
+
+// writeSuccessMetrics writes each fingerprint's responses by outcome and,
+// with -interval, its success rate over the last interval.
+func writeSuccessMetrics(w io.Writer) {
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_query_responses_total counter\n")
+    for _, key := range sortedQueries() {
+        qdata := qbuf[key]
+        if qdata.oks+qdata.errs == 0 {
+            continue
+        }
+        fmt.Fprintf(w, "mysql_sniffer_query_responses_total{%s} %d\n",
+            metricLabels("fingerprint", key, "outcome", "ok"), qdata.oks)
+        fmt.Fprintf(w, "mysql_sniffer_query_responses_total{%s} %d\n",
+            metricLabels("fingerprint", key, "outcome", "error"), qdata.errs)
+    }
+    if len(successRates) == 0 {
+        return
+    }
+    var keys []string
+    for key := range successRates {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_query_success_ratio gauge\n")
+    for _, key := range keys {
+        fmt.Fprintf(w, "mysql_sniffer_query_success_ratio{%s} %g\n",
+            metricLabels("fingerprint", key), successRates[key])
+    }
+}
+
+// runSummaries closes an interval every summaryInterval.
+func runSummaries() {
+    stateLock.Lock()
+    summarize()
+    commandMix()
+    summaryStart = time.Now()
+    stateLock.Unlock()
+    for now := range time.Tick(summaryInterval) {
+        stateLock.Lock()
+        publishSummary(now)
+        stateLock.Unlock()
+    }
+}
+
+// publishSummary prints and publishes the top queries since summaryStart and
+// starts a new interval. Must be called with stateLock held.
+func publishSummary(now time.Time) {
+    top := summarize()
+    commands, connects := commandMix()
+    printSummary(now, top)
+    publish(&event.SummaryEvent{
+        Type:        event.TYPE_SUMMARY,
+        ServiceId:   service_id,
+        TenantId:    tenant_id,
+        WindowStart: summaryStart.Unix(),
+        WindowSecs:  now.Sub(summaryStart).Seconds(),
+        SortBy:      summaryBy,
+        Top:         top,
+        Commands:    commands,
+        Connects:    connects,
+    })
+    summaryStart = now
+}
@@ -0,0 +1,64 @@
+/*
+ * summary_test.go
+ *
+ * The periodic summary: top fingerprints since the last one, and
+ * their success rates.
+ */
+
+package main
+
+import (
+    "fmt"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+func TestSummarize(t *testing.T) {
+    qbuf = map[string]*queryData{
+        "select ?": {count: 10, timeTotal: 1e6, bytes: 100},
+        "update t": {count: 2, timeTotal: 9e6, bytes: 20},
+    }
+    summaryLast = make(map[string]summaryTotals)
+    summaryTop, summaryBy = 1, TOP_BY_TIME
+    summarize()
+    qbuf["select ?"].count, qbuf["select ?"].timeTotal = 15, 2e6
+    qbuf["update t"].count, qbuf["update t"].timeTotal = 3, 9.5e6
+    top := summarize()
+    want := []event.SummaryEntry{{Fingerprint: "select ?", Count: 5, Time: 1}}
+    if !reflect.DeepEqual(top, want) {
+        t.Errorf("got %+v, want %+v", top, want)
+    }
+    qbuf = make(map[string]*queryData)
+}
+
+func TestSuccessRate(t *testing.T) {
+    resetState()
+    summaryLast, summaryTop, summaryBy = make(map[string]summaryTotals), 0, TOP_BY_TIME
+    s, c := newTestSoaker()
+    s.connect(c)
+    errPacket := append([]byte{0xff, 0x7a, 0x04, '#'}, "42S02Table 'db.t' doesn't exist"...)
+    for i, resp := range [][]byte{selftestOK, selftestOK, selftestOK, errPacket} {
+        s.packet(c, true, TCP_ACK, mysqlPacket(0, append([]byte{COM_QUERY},
+            fmt.Sprintf("SELECT * FROM t WHERE id = %d", i)...)))
+        s.packet(c, false, TCP_ACK, mysqlPacket(1, resp))
+    }
+    top := summarize()
+    if len(top) != 1 || top[0].Errors != 1 || top[0].SuccessRate == nil || *top[0].SuccessRate != 0.75 {
+        t.Fatalf("summary %+v", top)
+    }
+    var out strings.Builder
+    writeSuccessMetrics(&out)
+    for _, want := range []string{
+        `outcome="ok"} 3`,
+        `outcome="error"} 1`,
+        `mysql_sniffer_query_success_ratio{fingerprint="` + top[0].Fingerprint + `"} 0.75`,
+    } {
+        if !strings.Contains(out.String(), want+"\n") {
+            t.Errorf("no %s in\n%s", want, out.String())
+        }
+    }
+    successRates = make(map[string]float64)
+}
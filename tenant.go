@@ -0,0 +1,174 @@
+/*
+ * tenant.go
+ *
+ * Mapping clients to tenants and per-tenant usage accounting. The -tenant_map
+ * file is a JSON list of rules, first match wins:
+ *
+ *   [{"tenant": "shop", "schemas": ["shop"], "cidrs": ["10.1.0.0/16"],
+ *     "quota_bytes": 1000000000, "quota_time_ms": 60000}]
+ *
+ * Schemas are matched before CIDRs so a shared client host can still be split
+ * by database. Clients matching nothing belong to -tenant_id. Every
+ * -tenant_window we publish a usage event per active tenant, and an alert the
+ * first time in a window that a tenant goes over one of its quotas.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "net"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+type tenantRule struct {
+    Tenant      string   `json:"tenant"`
+    Schemas     []string `json:"schemas"`
+    Cidrs       []string `json:"cidrs"`
+    QuotaBytes  uint64   `json:"quota_bytes"`   // per window, 0 for none
+    QuotaTimeMs float64  `json:"quota_time_ms"` // per window, 0 for none
+
+    nets []*net.IPNet
+}
+
+type tenantUsage struct {
+    queries uint64
+    bytes   uint64
+    time    uint64 // nanoseconds
+    alerted bool
+}
+
+var tenantRules []*tenantRule
+var tenantQuotas map[string]*tenantRule = make(map[string]*tenantRule)
+var tenantUsages map[string]*tenantUsage = make(map[string]*tenantUsage)
+var tenantWindow time.Duration
+var tenantWindowStart time.Time
+
+// loadTenantMap reads the tenant rules.
+func loadTenantMap(path string) error {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    var rules []*tenantRule
+    if err := json.Unmarshal(data, &rules); err != nil {
+        return fmt.Errorf("%s: %s", path, err)
+    }
+    for _, rule := range rules {
+        if rule.Tenant == "" {
+            return fmt.Errorf("%s: rule without a tenant", path)
+        }
+        for _, cidr := range rule.Cidrs {
+            _, ipnet, err := net.ParseCIDR(cidr)
+            if err != nil {
+                return fmt.Errorf("%s: %s", path, err)
+            }
+            rule.nets = append(rule.nets, ipnet)
+        }
+        if _, ok := tenantQuotas[rule.Tenant]; !ok {
+            tenantQuotas[rule.Tenant] = rule
+        }
+    }
+    tenantRules = rules
+    return nil
+}
+
+// tenantFor works out which tenant a client belongs to. db may be empty if
+// the schema isn't known (yet).
+func tenantFor(srcip string, db string) string {
+    if db != "" {
+        for _, rule := range tenantRules {
+            for _, schema := range rule.Schemas {
+                if schema == db {
+                    return rule.Tenant
+                }
+            }
+        }
+    }
+    ip := net.ParseIP(srcip)
+    if ip != nil {
+        for _, rule := range tenantRules {
+            for _, ipnet := range rule.nets {
+                if ipnet.Contains(ip) {
+                    return rule.Tenant
+                }
+            }
+        }
+    }
+    return tenant_id
+}
+
+// accountTenant adds traffic to a tenant's usage for the current window,
+// alerting if that takes it over quota.
+func accountTenant(tenant string, queries uint64, bytes uint64, reqtime uint64) {
+    if tenantWindow <= 0 {
+        return
+    }
+    usage, ok := tenantUsages[tenant]
+    if !ok {
+        usage = &tenantUsage{}
+        tenantUsages[tenant] = usage
+    }
+    usage.queries += queries
+    usage.bytes += bytes
+    usage.time += reqtime
+
+    quota, ok := tenantQuotas[tenant]
+    if !ok || usage.alerted {
+        return
+    }
+    timeMs := float64(usage.time) / 1e6
+    var alert *event.AlertEvent
+    if quota.QuotaBytes > 0 && usage.bytes > quota.QuotaBytes {
+        alert = &event.AlertEvent{Kind: "quota_bytes", Value: float64(usage.bytes),
+            Limit: float64(quota.QuotaBytes),
+            Message: fmt.Sprintf("tenant %s used %d bytes this window, quota is %d",
+                tenant, usage.bytes, quota.QuotaBytes)}
+    } else if quota.QuotaTimeMs > 0 && timeMs > quota.QuotaTimeMs {
+        alert = &event.AlertEvent{Kind: "quota_time", Value: timeMs,
+            Limit: quota.QuotaTimeMs,
+            Message: fmt.Sprintf("tenant %s used %.0fms of server time this window, quota is %.0fms",
+                tenant, timeMs, quota.QuotaTimeMs)}
+    }
+    if alert != nil {
+        usage.alerted = true
+        alert.Type, alert.ServiceId, alert.TenantId = event.TYPE_ALERT, service_id, tenant
+        log.Printf("Quota exceeded: %s", alert.Message)
+        publish(alert)
+    }
+}
+
+// flushTenantUsage publishes the usage for the window that just ended and
+// starts a new one. Must be called with stateLock held.
+func flushTenantUsage(now time.Time) {
+    for tenant, usage := range tenantUsages {
+        publish(&event.UsageEvent{
+            Type:        event.TYPE_USAGE,
+            ServiceId:   service_id,
+            TenantId:    tenant,
+            WindowStart: tenantWindowStart.Unix(),
+            WindowSecs:  now.Sub(tenantWindowStart).Seconds(),
+            Queries:     usage.queries,
+            Bytes:       usage.bytes,
+            Time:        float64(usage.time) / 1e6,
+            OverQuota:   usage.alerted,
+        })
+    }
+    tenantUsages = make(map[string]*tenantUsage)
+    tenantWindowStart = now
+}
+
+// runTenantAccounting closes a usage window every tenantWindow.
+func runTenantAccounting() {
+    tenantWindowStart = time.Now()
+    for now := range time.Tick(tenantWindow) {
+        stateLock.Lock()
+        flushTenantUsage(now)
+        stateLock.Unlock()
+    }
+}
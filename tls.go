@@ -0,0 +1,52 @@
+/*
+ * tls.go
+ *
+ * Encrypted connections. A client wanting TLS sends a short handshake
+ * response, the SSL request, with CLIENT_SSL set, and everything after it is
+ * TLS records. Carving those as MySQL packets only produces desyncs and
+ * nonsense statements, so such a connection is marked as encrypted, counted
+ * and from then on only its bytes are. A stream picked up mid-session is
+ * recognized by TLS_MIN_RECORDS segments starting with a TLS application
+ * data header instead; a single one could be the length of a large packet.
+ */
+
+package main
+
+const (
+    TLS_APPLICATION_DATA = 0x17
+    TLS_MIN_RECORDS      = 3
+)
+
+// sslRequest reports whether data (with its header) is a client's SSL
+// request.
+func sslRequest(data []byte) bool {
+    if len(data) < 8 || data[3] != 1 {
+        return false
+    }
+    size := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+    caps := uint32(data[4]) | uint32(data[5])<<8 | uint32(data[6])<<16 | uint32(data[7])<<24
+    return size == 32 && caps&CLIENT_SSL != 0 && caps&CLIENT_PROTOCOL_41 != 0
+}
+
+// tlsRecord reports whether data starts like a TLS 1.2 or 1.3 application
+// data record.
+func tlsRecord(data []byte) bool {
+    return len(data) >= 5 && data[0] == TLS_APPLICATION_DATA && data[1] == 3 && data[2] == 3
+}
+
+// noteTLS marks rs as encrypted if data shows it is. Called for each
+// segment before sync.
+func noteTLS(rs *source, request bool, data []byte) bool {
+    if !(request && sslRequest(data)) {
+        if !tlsRecord(data) {
+            return false
+        }
+        if rs.tlsRecords++; rs.tlsRecords < TLS_MIN_RECORDS {
+            return false
+        }
+    }
+    rs.tls = true
+    rs.reqbuffer, rs.resbuffer = nil, nil
+    stats.tls++
+    return true
+}
@@ -0,0 +1,44 @@
+/*
+ * tls_test.go
+ *
+ * TLS connections are recognized and left alone.
+ */
+
+package main
+
+import (
+    "fmt"
+    "testing"
+)
+
+func TestTLSConnections(t *testing.T) {
+    mem := resetState()
+    before, desyncs := stats.tls, stats.desyncs
+    s, c := newTestSoaker()
+    c.port++
+    c.cseq, c.sseq, c.open = 1, 1, true
+    s.packet(c, true, TCP_SYN, nil)
+    s.packet(c, false, TCP_SYN|TCP_ACK, nil)
+    s.packet(c, false, TCP_ACK, mysqlPacket(0, fakeGreeting()))
+    sslReq := fakeLogin("")[:32]
+    sslReq[1] |= CLIENT_SSL >> 8
+    s.packet(c, true, TCP_ACK, mysqlPacket(1, sslReq))
+    // COM_QUERY lookalikes inside the encrypted session.
+    for i := 0; i < 5; i++ {
+        s.packet(c, true, TCP_ACK, mysqlPacket(0, append([]byte{COM_QUERY}, "SELECT 1"...)))
+        s.packet(c, false, TCP_ACK, mysqlPacket(1, selftestOK))
+    }
+    rs := pktShard.streams[fmt.Sprintf("10.1.0.1:%d", c.port)]
+    if rs == nil || !rs.tls || stats.tls != before+1 || len(mem.payloads) != 0 || stats.desyncs != desyncs {
+        t.Errorf("tls %v, %d counted, %d published", rs != nil && rs.tls, stats.tls-before, len(mem.payloads))
+    }
+
+    // Joined mid-session: only after a few records.
+    mid := &source{}
+    record := []byte{TLS_APPLICATION_DATA, 3, 3, 0, 40}
+    for i := 1; i <= TLS_MIN_RECORDS; i++ {
+        if got := noteTLS(mid, i%2 == 0, record); got != (i == TLS_MIN_RECORDS) {
+            t.Errorf("record %d: marked %v", i, got)
+        }
+    }
+}
@@ -0,0 +1,367 @@
+/*
+ * tx.go
+ *
+ * Transactions: how many statements each one runs, how long it stays open,
+ * how often it is rolled back and how long COMMIT takes, the latter being a
+ * decent proxy for fsync and semi-sync replication pressure. All are kept per
+ * schema and per client IP, and show up in the `transactions` report and on
+ * /metrics. Each transaction is also published as a "transaction" event when
+ * it ends, to find the long-running and bloated ones.
+ *
+ * We also note whether each write ran inside a transaction (BEGIN, or with
+ * autocommit switched off) or on its own under autocommit; the `autocommit`
+ * report shows the split per client, to find apps that never group writes.
+ *
+ * The statements tell us most of this, but the status flags on the server's
+ * OK/EOF packets are authoritative when we see them: they also catch
+ * transactions opened implicitly, and ended implicitly by DDL.
+ */
+
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "sort"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+const (
+    TX_NONE = iota
+    TX_BEGIN
+    TX_COMMIT
+    TX_ROLLBACK
+    TX_AUTOCOMMIT_ON
+    TX_AUTOCOMMIT_OFF
+    TX_WRITE
+
+    TX_OUTCOME_COMMIT   = "commit"
+    TX_OUTCOME_ROLLBACK = "rollback"
+    TX_OUTCOME_IMPLICIT = "implicit"
+
+    COM_STMT_EXECUTE = 0x17
+)
+
+var txStatementBounds = []float64{1, 2, 3, 5, 10, 20, 50, 100, 500}
+var commitLatencyBounds = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025,
+    0.05, 0.1, 0.25, 0.5, 1}
+var txDurationBounds = []float64{0.001, 0.01, 0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+type txStats struct {
+    statements *histogram // per committed or rolled back transaction
+    commits    *histogram // COMMIT latency, seconds
+    durations  *histogram // BEGIN to COMMIT or ROLLBACK, seconds
+    rollbacks  uint64
+}
+
+// Per schema ("" if unknown) and per client IP.
+var txBySchema map[string]*txStats = make(map[string]*txStats)
+var txByClient map[string]*txStats = make(map[string]*txStats)
+
+// Writes per client IP, by whether they were in a transaction.
+type writeModes struct {
+    Transaction uint64 `json:"transaction"`
+    Autocommit  uint64 `json:"autocommit"`
+}
+
+var writesByClient map[string]*writeModes = make(map[string]*writeModes)
+
+func txStatsFor(m map[string]*txStats, key string) *txStats {
+    tx, ok := m[key]
+    if !ok {
+        tx = &txStats{statements: newHistogram(txStatementBounds),
+            commits: newHistogram(commitLatencyBounds), durations: newHistogram(txDurationBounds)}
+        m[key] = tx
+    }
+    return tx
+}
+
+// txCommand recognizes the statements that start and end transactions.
+func txCommand(query []byte) int {
+    query = bytes.ToUpper(bytes.TrimSpace(query))
+    switch {
+    case bytes.HasPrefix(query, []byte("BEGIN")),
+        bytes.HasPrefix(query, []byte("START TRANSACTION")):
+        return TX_BEGIN
+    case bytes.HasPrefix(query, []byte("COMMIT")):
+        return TX_COMMIT
+    case bytes.HasPrefix(query, []byte("ROLLBACK")) && !bytes.Contains(query, []byte(" TO ")):
+        return TX_ROLLBACK
+    case bytes.HasPrefix(query, []byte("INSERT")), bytes.HasPrefix(query, []byte("UPDATE")),
+        bytes.HasPrefix(query, []byte("DELETE")), bytes.HasPrefix(query, []byte("REPLACE")):
+        return TX_WRITE
+    case bytes.HasPrefix(query, []byte("SET")):
+        set := bytes.Replace(query, []byte(" "), nil, -1)
+        for _, off := range []string{"AUTOCOMMIT=0", "AUTOCOMMIT=OFF", "AUTOCOMMIT=FALSE"} {
+            if bytes.Contains(set, []byte(off)) {
+                return TX_AUTOCOMMIT_OFF
+            }
+        }
+        if bytes.Contains(set, []byte("AUTOCOMMIT=")) {
+            return TX_AUTOCOMMIT_ON
+        }
+    }
+    return TX_NONE
+}
+
+// noteTxRequest follows transaction boundaries on a stream.
+func noteTxRequest(rs *source, ptype int, pdata []byte) {
+    rs.txcmd = TX_NONE
+    if ptype != COM_QUERY && ptype != COM_STMT_EXECUTE {
+        return
+    }
+    if ptype == COM_QUERY {
+        rs.txcmd = txCommand(pdata)
+    }
+    switch rs.txcmd {
+    case TX_BEGIN:
+        rs.inTx, rs.txStmts, rs.txStart = true, 0, pktTime
+    case TX_COMMIT:
+        if rs.inTx {
+            endTx(rs, TX_OUTCOME_COMMIT)
+        }
+        rs.inTx = false
+    case TX_ROLLBACK:
+        if rs.inTx {
+            endTx(rs, TX_OUTCOME_ROLLBACK)
+        }
+        rs.inTx = false
+    case TX_AUTOCOMMIT_OFF:
+        rs.implicitTx = true
+    case TX_AUTOCOMMIT_ON:
+        rs.implicitTx = false
+    }
+    if rs.txcmd == TX_WRITE || rs.txcmd == TX_NONE {
+        if rs.inTx {
+            rs.txStmts++
+        }
+    }
+    // Without responses the statements are all we have to go on.
+    if requestOnly && rs.txcmd == TX_WRITE {
+        countWrite(rs, rs.inTx || rs.implicitTx)
+    }
+}
+
+// endTx records a transaction that just ended and publishes it.
+func endTx(rs *source, outcome string) {
+    took := pktTime.Sub(rs.txStart)
+    for _, tx := range []*txStats{txStatsFor(txBySchema, rs.db),
+        txStatsFor(txByClient, rs.srcip)} {
+        tx.statements.observe(float64(rs.txStmts))
+        tx.durations.observe(took.Seconds())
+        if outcome == TX_OUTCOME_ROLLBACK {
+            tx.rollbacks++
+        }
+    }
+    publish(&event.TransactionEvent{
+        Type:       event.TYPE_TRANSACTION,
+        ServiceId:  service_id,
+        TenantId:   rs.tenant,
+        Client:     rs.src,
+        ThreadId:   rs.threadId,
+        User:       rs.user,
+        Schema:     rs.db,
+        Outcome:    outcome,
+        Statements: rs.txStmts,
+        Begin:      float64(rs.txStart.UnixNano()) / 1e9,
+        Duration:   float64(took.Nanoseconds()) / 1e6,
+    })
+}
+
+// noteTxResponseEnd is called when a response is complete. The status flags
+// of its last OK/EOF, if we saw them, correct what the statements suggested.
+func noteTxResponseEnd(rs *source) {
+    inTx := rs.inTx || rs.implicitTx
+    if rs.resp.flagged {
+        status := rs.resp.status
+        inTx = status&SERVER_STATUS_IN_TRANS != 0
+        rs.implicitTx = status&SERVER_STATUS_AUTOCOMMIT == 0
+        switch {
+        case inTx && !rs.inTx && rs.txcmd != TX_COMMIT && rs.txcmd != TX_ROLLBACK:
+            // Opened without BEGIN, e.g. with autocommit off.
+            rs.inTx, rs.txStmts = true, 1
+            rs.txStart = rs.timing.firstByte.Add(-time.Duration(rs.timing.reqtime))
+        case !inTx && rs.inTx && rs.txcmd != TX_BEGIN:
+            // Ended without COMMIT, e.g. by DDL.
+            endTx(rs, TX_OUTCOME_IMPLICIT)
+            rs.inTx = false
+        }
+    }
+    if rs.txcmd == TX_WRITE {
+        countWrite(rs, inTx)
+    }
+}
+
+// countWrite counts a write by whether it was part of a transaction.
+func countWrite(rs *source, inTx bool) {
+    modes, ok := writesByClient[rs.srcip]
+    if !ok {
+        modes = &writeModes{}
+        writesByClient[rs.srcip] = modes
+    }
+    if inTx {
+        modes.Transaction++
+        rs.writes.Transaction++
+    } else {
+        modes.Autocommit++
+        rs.writes.Autocommit++
+    }
+}
+
+// noteTxResponse records COMMIT latency when its response arrives.
+func noteTxResponse(rs *source, reqtime uint64) {
+    if rs.txcmd != TX_COMMIT {
+        return
+    }
+    seconds := float64(reqtime) / 1e9
+    txStatsFor(txBySchema, rs.db).commits.observe(seconds)
+    txStatsFor(txByClient, rs.srcip).commits.observe(seconds)
+}
+
+func sortedTxKeys(m map[string]*txStats) []string {
+    keys := make([]string, 0, len(m))
+    for key := range m {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// writeTxMetrics writes the transaction histograms for /metrics.
+func writeTxMetrics(w io.Writer) {
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_tx_statements histogram\n")
+    for _, dim := range []struct {
+        label string
+        m     map[string]*txStats
+    }{{"schema", txBySchema}, {"client", txByClient}} {
+        for _, key := range sortedTxKeys(dim.m) {
+            dim.m[key].statements.writeMetric(w, "mysql_sniffer_tx_statements",
+                metricLabels(dim.label, key))
+        }
+    }
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_tx_duration_seconds histogram\n")
+    for _, dim := range []struct {
+        label string
+        m     map[string]*txStats
+    }{{"schema", txBySchema}, {"client", txByClient}} {
+        for _, key := range sortedTxKeys(dim.m) {
+            dim.m[key].durations.writeMetric(w, "mysql_sniffer_tx_duration_seconds",
+                metricLabels(dim.label, key))
+        }
+    }
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_tx_rollbacks_total counter\n")
+    for _, dim := range []struct {
+        label string
+        m     map[string]*txStats
+    }{{"schema", txBySchema}, {"client", txByClient}} {
+        for _, key := range sortedTxKeys(dim.m) {
+            fmt.Fprintf(w, "mysql_sniffer_tx_rollbacks_total{%s} %d\n", metricLabels(dim.label, key),
+                dim.m[key].rollbacks)
+        }
+    }
+    fmt.Fprintf(w, "# TYPE mysql_sniffer_commit_latency_seconds histogram\n")
+    for _, dim := range []struct {
+        label string
+        m     map[string]*txStats
+    }{{"schema", txBySchema}, {"client", txByClient}} {
+        for _, key := range sortedTxKeys(dim.m) {
+            dim.m[key].commits.writeMetric(w, "mysql_sniffer_commit_latency_seconds",
+                metricLabels(dim.label, key))
+        }
+    }
+}
+
+type txSummary struct {
+    Key          string  `json:"key"`
+    Transactions uint64  `json:"transactions"`
+    Rollbacks    uint64  `json:"rollbacks"`
+    RollbackRate float64 `json:"rollback_rate"`
+    StmtsMean    float64 `json:"statements_mean"`
+    StmtsP50     float64 `json:"statements_p50"`
+    StmtsP99     float64 `json:"statements_p99"`
+    DurMeanMs    float64 `json:"duration_mean_ms"`
+    DurP50Ms     float64 `json:"duration_p50_ms"`
+    DurP99Ms     float64 `json:"duration_p99_ms"`
+    Commits      uint64  `json:"commits"`
+    CommitMeanMs float64 `json:"commit_mean_ms"`
+    CommitP50Ms  float64 `json:"commit_p50_ms"`
+    CommitP99Ms  float64 `json:"commit_p99_ms"`
+}
+
+func summarizeTx(m map[string]*txStats) []txSummary {
+    var out []txSummary
+    for _, key := range sortedTxKeys(m) {
+        tx := m[key]
+        var rate float64
+        if tx.statements.count > 0 {
+            rate = float64(tx.rollbacks) / float64(tx.statements.count)
+        }
+        out = append(out, txSummary{Key: key, Transactions: tx.statements.count,
+            Rollbacks: tx.rollbacks, RollbackRate: rate, StmtsMean: tx.statements.mean(),
+            StmtsP50: tx.statements.quantile(0.5), StmtsP99: tx.statements.quantile(0.99),
+            DurMeanMs: tx.durations.mean() * 1000, DurP50Ms: tx.durations.quantile(0.5) * 1000,
+            DurP99Ms: tx.durations.quantile(0.99) * 1000,
+            Commits: tx.commits.count, CommitMeanMs: tx.commits.mean() * 1000,
+            CommitP50Ms: tx.commits.quantile(0.5) * 1000,
+            CommitP99Ms: tx.commits.quantile(0.99) * 1000})
+    }
+    return out
+}
+
+func reportTransactions(w io.Writer, asJSON bool) {
+    bySchema, byClient := summarizeTx(txBySchema), summarizeTx(txByClient)
+    if asJSON {
+        json.NewEncoder(w).Encode(map[string]interface{}{"transactions": map[string]interface{}{
+            "schemas": bySchema, "clients": byClient}})
+        return
+    }
+    for _, section := range []struct {
+        title string
+        rows  []txSummary
+    }{{"schema", bySchema}, {"client", byClient}} {
+        fmt.Fprintf(w, "%s== transactions per %s%s\n", color(COLOR_CYAN), section.title,
+            color(COLOR_DEFAULT))
+        fmt.Fprintf(w, "%8s %9s %9s %9s %9s %9s %10s %10s %8s %10s %10s %10s  %s\n", "tx",
+            "rollback", "rollback%", "stmt avg", "stmt p50", "stmt p99", "dur p50", "dur p99",
+            "commits", "commit avg", "commit p50", "commit p99", section.title)
+        for _, row := range section.rows {
+            key := row.Key
+            if key == "" {
+                key = "(unknown)"
+            }
+            fmt.Fprintf(w, "%8d %9d %8.1f%% %9.1f %9.1f %9.1f %8.1fms %8.1fms %8d %8.2fms %8.2fms %8.2fms  %s\n",
+                row.Transactions, row.Rollbacks, row.RollbackRate*100, row.StmtsMean,
+                row.StmtsP50, row.StmtsP99, row.DurP50Ms, row.DurP99Ms, row.Commits,
+                row.CommitMeanMs, row.CommitP50Ms, row.CommitP99Ms, key)
+        }
+        fmt.Fprintf(w, "\n")
+    }
+}
+
+// reportAutocommit shows, per client, how many writes ran in transactions and
+// how many under autocommit.
+func reportAutocommit(w io.Writer, asJSON bool) {
+    clients := make([]string, 0, len(writesByClient))
+    for client := range writesByClient {
+        clients = append(clients, client)
+    }
+    sort.Strings(clients)
+    if asJSON {
+        json.NewEncoder(w).Encode(map[string]interface{}{"autocommit": writesByClient})
+        return
+    }
+    fmt.Fprintf(w, "%s== writes in transactions vs autocommit%s\n", color(COLOR_CYAN),
+        color(COLOR_DEFAULT))
+    fmt.Fprintf(w, "%12s %12s %11s  %s\n", "transaction", "autocommit", "autocommit%", "client")
+    for _, client := range clients {
+        modes := writesByClient[client]
+        pct := 100 * float64(modes.Autocommit) / float64(modes.Autocommit+modes.Transaction)
+        fmt.Fprintf(w, "%12d %12d %10.1f%%  %s\n", modes.Transaction, modes.Autocommit, pct,
+            client)
+    }
+    fmt.Fprintf(w, "\n")
+}
@@ -0,0 +1,58 @@
+/*
+ * tx_test.go
+ *
+ * Transaction events and the per client statistics.
+ */
+
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/elvis2002/mysql-sniffer/event"
+)
+
+func TestTransactionEvents(t *testing.T) {
+    mem := resetState()
+    txBySchema, txByClient = make(map[string]*txStats), make(map[string]*txStats)
+    s, c := newTestSoaker()
+    s.connect(c)
+    inTxOK := []byte{0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00}
+    for _, step := range []string{"BEGIN", "UPDATE t SET a = 1", "SELECT a FROM t", "COMMIT",
+        "BEGIN", "DELETE FROM t", "ROLLBACK"} {
+        s.packet(c, true, TCP_ACK, mysqlPacket(0, append([]byte{COM_QUERY}, step...)))
+        s.now = s.now.Add(time.Second)
+        ok := inTxOK
+        if step == "COMMIT" || step == "ROLLBACK" {
+            ok = selftestOK
+        }
+        s.packet(c, false, TCP_ACK, mysqlPacket(1, ok))
+    }
+
+    var got []*event.TransactionEvent
+    for _, payload := range mem.payloads {
+        if ev, err := event.Decode(payload); err == nil {
+            if tx, ok := ev.(*event.TransactionEvent); ok {
+                got = append(got, tx)
+            }
+        }
+    }
+    if len(got) != 2 {
+        t.Fatalf("%d transaction events, want 2", len(got))
+    }
+    if got[0].Outcome != TX_OUTCOME_COMMIT || got[0].Statements != 2 || got[0].Duration != 3000 ||
+        got[0].Begin != 100 {
+        t.Errorf("first transaction %+v", got[0])
+    }
+    if got[1].Outcome != TX_OUTCOME_ROLLBACK || got[1].Statements != 1 || got[1].Duration != 2000 {
+        t.Errorf("second transaction %+v", got[1])
+    }
+    if err := got[0].Validate(); err != nil {
+        t.Error(err)
+    }
+    rows := summarizeTx(txByClient)
+    if len(rows) != 1 || rows[0].Transactions != 2 || rows[0].RollbackRate != 0.5 {
+        t.Errorf("per client %+v", rows)
+    }
+}
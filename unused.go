@@ -0,0 +1,276 @@
+/*
+ * unused.go
+ *
+ * Unused tables. With -table_usage set, the sniffer remembers when each table
+ * was last queried, in that file, so the record outlives restarts and can
+ * cover weeks (-table_usage_window). The `unused` report (or GET /unused)
+ * then lists the server's tables that no query touched in the window. The
+ * tables come from -schema_tables, a file or a command printing one table a
+ * line, as schema.table or schema<TAB>table:
+ *
+ *   -schema_tables 'exec:mysql -N -e "SELECT table_schema, table_name FROM information_schema.tables"'
+ *   -schema_tables file:/etc/sniff/tables.txt
+ *
+ * A table named without a schema counts as used in every schema when the
+ * query's schema isn't known, so nothing in use is reported unused.
+ */
+
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "os"
+    "os/exec"
+    "sort"
+    "strings"
+    "time"
+)
+
+// A tableRef is a table a query names, schema "" if it doesn't say.
+type tableRef struct {
+    schema, name string
+}
+
+// Schemas of the server itself, never reported.
+var systemSchemas = map[string]bool{"mysql": true, "information_schema": true,
+    "performance_schema": true, "sys": true}
+
+var tableUsageFile string
+var tableUsageWindow time.Duration
+var schemaTables string
+
+// tableUsage is what -table_usage holds: unix seconds each table was last
+// queried, by schema.table, or by name if the schema wasn't known.
+type tableUsage struct {
+    Since  int64            `json:"since"` // start of tracking
+    Tables map[string]int64 `json:"tables"`
+}
+
+var tablesSeen = tableUsage{Tables: make(map[string]int64)}
+
+// tablesIn returns the tables a canonical query names after FROM, JOIN,
+// UPDATE, INTO or TRUNCATE TABLE, each once.
+func tablesIn(query string) []tableRef {
+    words := queryWords([]byte(query))
+    seen := make(map[tableRef]bool)
+    var refs []tableRef
+    for i := 0; i+1 < len(words); i++ {
+        switch words[i] {
+        case "from", "join", "into":
+        case "table":
+            if i == 0 || words[i-1] != "truncate" {
+                continue
+            }
+        case "update":
+            if i > 0 && words[i-1] == "key" {
+                continue // ON DUPLICATE KEY UPDATE
+            }
+        default:
+            continue
+        }
+        // FROM a, b lists more than one.
+        for j := i + 1; j < len(words) && isIdentifier(words[j]) && !joinKeywords[words[j]]; {
+            ref := tableRef{name: words[j]}
+            if j+2 < len(words) && words[j+1] == "." && isIdentifier(words[j+2]) {
+                ref = tableRef{schema: words[j], name: words[j+2]}
+                j += 2
+            }
+            if !seen[ref] {
+                seen[ref] = true
+                refs = append(refs, ref)
+            }
+            j++
+            for j < len(words) && words[j] != "," && isIdentifier(words[j]) &&
+                !joinKeywords[words[j]] {
+                j++ // alias
+            }
+            if j+1 >= len(words) || words[j] != "," || words[i] != "from" {
+                break
+            }
+            j++
+        }
+    }
+    return refs
+}
+
+// noteTableUsage records the tables of the query just seen on rs.
+func noteTableUsage(rs *source, qdata *queryData) {
+    if tableUsageFile == "" {
+        return
+    }
+    now := pktTime.Unix()
+    for _, ref := range qdata.tables {
+        tablesSeen.Tables[tableKey(ref, rs.db)] = now
+    }
+}
+
+func loadTableUsage(path string) error {
+    data, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        tablesSeen.Since = time.Now().Unix()
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    var usage tableUsage
+    if err := json.Unmarshal(data, &usage); err != nil {
+        return fmt.Errorf("%s: %s", path, err)
+    }
+    if usage.Tables == nil {
+        usage.Tables = make(map[string]int64)
+    }
+    tablesSeen = usage
+    return nil
+}
+
+// saveTableUsage forgets tables last seen before the window and writes the
+// rest to -table_usage. Must be called with stateLock held.
+func saveTableUsage() error {
+    if tableUsageWindow > 0 {
+        oldest := time.Now().Add(-tableUsageWindow).Unix()
+        for key, last := range tablesSeen.Tables {
+            if last < oldest {
+                delete(tablesSeen.Tables, key)
+            }
+        }
+    }
+    data, err := json.Marshal(&tablesSeen)
+    if err != nil {
+        return err
+    }
+    tmp := tableUsageFile + ".tmp"
+    if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, tableUsageFile)
+}
+
+// runTableUsage saves the usage every minute.
+func runTableUsage() {
+    for _ = range time.Tick(time.Minute) {
+        stateLock.Lock()
+        err := saveTableUsage()
+        stateLock.Unlock()
+        if err != nil {
+            log.Printf("Failed to save table usage: %s", err)
+        }
+    }
+}
+
+// loadSchemaTables returns the tables -schema_tables names, as schema.table.
+func loadSchemaTables(spec string) ([]string, error) {
+    var data []byte
+    var err error
+    switch {
+    case strings.HasPrefix(spec, "file:"):
+        data, err = ioutil.ReadFile(spec[len("file:"):])
+    case strings.HasPrefix(spec, "exec:"):
+        var stderr bytes.Buffer
+        cmd := exec.Command("sh", "-c", spec[len("exec:"):])
+        cmd.Stderr = &stderr
+        if data, err = cmd.Output(); err != nil {
+            err = fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+        }
+    default:
+        return nil, fmt.Errorf("-schema_tables: want file:/path or exec:command")
+    }
+    if err != nil {
+        return nil, fmt.Errorf("-schema_tables: %s", err)
+    }
+    var tables []string
+    scanner := bufio.NewScanner(bytes.NewReader(data))
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        name := strings.ToLower(strings.Join(strings.Fields(line), "."))
+        if !strings.Contains(name, ".") {
+            return nil, fmt.Errorf("-schema_tables: %q has no schema", line)
+        }
+        if !systemSchemas[strings.SplitN(name, ".", 2)[0]] {
+            tables = append(tables, name)
+        }
+    }
+    sort.Strings(tables)
+    return tables, nil
+}
+
+type unusedTables struct {
+    Since   int64    `json:"since"`
+    Window  string   `json:"window"`
+    Tracked int      `json:"tracked"` // tables seen in the window
+    Unused  []string `json:"unused"`
+    Error   string   `json:"error,omitempty"`
+}
+
+// findUnused compares the tables of -schema_tables (all, or err if they
+// couldn't be had) with those seen. Must be called with stateLock held.
+func findUnused(all []string, err error) unusedTables {
+    out := unusedTables{Since: tablesSeen.Since, Window: tableUsageWindow.String(),
+        Tracked: len(tablesSeen.Tables)}
+    if err != nil {
+        out.Error = err.Error()
+        return out
+    }
+    for _, table := range all {
+        name := table[strings.IndexByte(table, '.')+1:]
+        if _, ok := tablesSeen.Tables[table]; ok {
+            continue
+        }
+        if _, ok := tablesSeen.Tables[name]; ok {
+            continue
+        }
+        out.Unused = append(out.Unused, table)
+    }
+    return out
+}
+
+func schemaTableList() ([]string, error) {
+    if schemaTables == "" {
+        return nil, fmt.Errorf("no -schema_tables to compare with")
+    }
+    return loadSchemaTables(schemaTables)
+}
+
+func reportUnused(w io.Writer, asJSON bool) {
+    writeUnused(w, asJSON, findUnused(schemaTableList()))
+}
+
+func writeUnused(w io.Writer, asJSON bool, out unusedTables) {
+    if asJSON {
+        json.NewEncoder(w).Encode(out)
+        return
+    }
+    if out.Error != "" {
+        fmt.Fprintf(w, "unused: %s\n", out.Error)
+        return
+    }
+    since := time.Unix(out.Since, 0)
+    if oldest := time.Now().Add(-tableUsageWindow); tableUsageWindow > 0 && since.Before(oldest) {
+        since = oldest
+    }
+    fmt.Fprintf(w, "%s== unused tables (%d, not queried since %s)%s\n", color(COLOR_CYAN),
+        len(out.Unused), since.Format("2006-01-02 15:04"), color(COLOR_DEFAULT))
+    for _, table := range out.Unused {
+        fmt.Fprintf(w, "  %s\n", table)
+    }
+    fmt.Fprintf(w, "\n")
+}
+
+func handleUnused(w http.ResponseWriter, r *http.Request) {
+    all, err := schemaTableList() // may run a command, so not under the lock
+    stateLock.Lock()
+    out := findUnused(all, err)
+    stateLock.Unlock()
+    w.Header().Set("Content-Type", "application/json")
+    writeUnused(w, true, out)
+}
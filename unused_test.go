@@ -0,0 +1,25 @@
+/*
+ * unused_test.go
+ *
+ * Tables a statement reads or writes.
+ */
+
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestTablesIn(t *testing.T) {
+    refs := tablesIn("SELECT * FROM shop.orders o, items i JOIN customers c ON c.id = o.customer_id " +
+        "WHERE o.id IN (SELECT order_id FROM returns) ORDER BY o.id, i.id")
+    want := []tableRef{{"shop", "orders"}, {"", "items"}, {"", "customers"}, {"", "returns"}}
+    if !reflect.DeepEqual(refs, want) {
+        t.Errorf("got %v, want %v", refs, want)
+    }
+    refs = tablesIn("INSERT INTO log (a) VALUES (?) ON DUPLICATE KEY UPDATE a = ?")
+    if want := []tableRef{{"", "log"}}; !reflect.DeepEqual(refs, want) {
+        t.Errorf("got %v, want %v", refs, want)
+    }
+}
@@ -0,0 +1,250 @@
+/*
+ * workers.go
+ *
+ * Sharded capture for hosts with more traffic than one process can decode.
+ * With -processes N the process becomes a parent that starts N copies of
+ * itself, each with -shard i/N, and restarts them when they exit. A shard
+ * only sees the connections whose ports hash to it, by a BPF expression
+ * that gives both directions of a connection the same hash, so the kernel
+ * does the splitting. Children publish to the sinks themselves; if -control
+ * is given, the parent serves it with the children's metrics added up.
+ */
+
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "os/exec"
+    "os/signal"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+)
+
+var shardIndex int
+var shardCount int
+
+// Children's control APIs listen on consecutive loopback ports from here.
+var workerControlBase int = 17380
+
+// parseShard reads a -shard value of the form i/N.
+func parseShard(spec string) error {
+    if spec == "" {
+        return nil
+    }
+    parts := strings.SplitN(spec, "/", 2)
+    if len(parts) != 2 {
+        return fmt.Errorf("%s: want i/N", spec)
+    }
+    i, err1 := strconv.Atoi(parts[0])
+    n, err2 := strconv.Atoi(parts[1])
+    if err1 != nil || err2 != nil || n < 1 || i < 0 || i >= n {
+        return fmt.Errorf("%s: want i/N with 0 <= i < N", spec)
+    }
+    shardIndex, shardCount = i, n
+    return nil
+}
+
+// shardFilter narrows the capture filter to this shard. The sum of the two
+// ports is the same whichever way a segment goes. libpcap only indexes
+// tcp[] over IPv4, so over IPv6 the ports are read right after the fixed
+// header; segments behind extension headers go to no shard.
+func shardFilter() string {
+    if shardCount <= 1 {
+        return ""
+    }
+    return fmt.Sprintf(" and ((ip and (tcp[0:2] + tcp[2:2]) %% %d = %d) or "+
+        "(ip6 and ip6[6] = 6 and (ip6[40:2] + ip6[42:2]) %% %d = %d))",
+        shardCount, shardIndex, shardCount, shardIndex)
+}
+
+type worker struct {
+    shard    int
+    count    int
+    args     []string
+    lock     sync.Mutex
+    cmd      *exec.Cmd
+    restarts int
+}
+
+// runWorkers starts and supervises n children until the parent is told to
+// stop. It does not return.
+func runWorkers(n int, control string) {
+    var base []string
+    flag.Visit(func(f *flag.Flag) {
+        switch f.Name {
+        case "processes", "shard", "control":
+        default:
+            base = append(base, "-"+f.Name+"="+f.Value.String())
+        }
+    })
+
+    workers := make([]*worker, n)
+    for i := range workers {
+        args := append([]string{}, base...)
+        args = append(args, fmt.Sprintf("-shard=%d/%d", i, n))
+        if control != "" {
+            args = append(args, fmt.Sprintf("-control=127.0.0.1:%d", workerControlBase+i))
+        }
+        workers[i] = &worker{shard: i, count: n, args: args}
+        go workers[i].supervise()
+    }
+    log.Printf("Started %d capture processes", n)
+
+    if control != "" {
+        mux := http.NewServeMux()
+        mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+            w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+            mergeWorkerMetrics(w, n)
+        })
+        mux.HandleFunc("/purge", func(w http.ResponseWriter, r *http.Request) {
+            purgeWorkers(w, r, n)
+        })
+        go func() {
+            log.Printf("Control API listening on %s", control)
+            if err := http.ListenAndServe(control, mux); err != nil {
+                log.Fatalf("Control API failed: %s", err.Error())
+            }
+        }()
+    }
+
+    ch := make(chan os.Signal, 1)
+    signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+    for sig := range ch {
+        for _, w := range workers {
+            w.signal(sig)
+        }
+        if sig != syscall.SIGHUP {
+            // Give the children a moment to flush before going.
+            time.Sleep(time.Second)
+            os.Exit(0)
+        }
+    }
+}
+
+// supervise runs the worker, restarting it with backoff when it exits.
+func (w *worker) supervise() {
+    backoff := EXEC_BACKOFF_MIN
+    for {
+        cmd := exec.Command(os.Args[0], w.args...)
+        cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+        started := time.Now()
+        err := cmd.Start()
+        if err == nil {
+            w.lock.Lock()
+            w.cmd = cmd
+            w.lock.Unlock()
+            err = cmd.Wait()
+            w.lock.Lock()
+            w.cmd = nil
+            w.restarts++
+            w.lock.Unlock()
+        }
+        log.Printf("Worker %d/%d exited (%v); restarting", w.shard, w.count, err)
+        if time.Since(started) > EXEC_BACKOFF_MAX {
+            backoff = EXEC_BACKOFF_MIN
+        }
+        time.Sleep(backoff)
+        if backoff *= 2; backoff > EXEC_BACKOFF_MAX {
+            backoff = EXEC_BACKOFF_MAX
+        }
+    }
+}
+
+func (w *worker) signal(sig os.Signal) {
+    w.lock.Lock()
+    defer w.lock.Unlock()
+    if w.cmd != nil && w.cmd.Process != nil {
+        w.cmd.Process.Signal(sig)
+    }
+}
+
+// mergeWorkerMetrics adds up the children's /metrics by series. Counters
+// and histogram buckets sum as they should; gauges sum to the host total.
+func mergeWorkerMetrics(w http.ResponseWriter, n int) {
+    var types []string
+    seenType := make(map[string]bool)
+    var series []string
+    values := make(map[string]float64)
+    client := http.Client{Timeout: 5 * time.Second}
+    for i := 0; i < n; i++ {
+        resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", workerControlBase+i))
+        if err != nil {
+            fmt.Fprintf(w, "# worker %d unavailable: %s\n", i, err)
+            continue
+        }
+        scanner := bufio.NewScanner(resp.Body)
+        for scanner.Scan() {
+            line := scanner.Text()
+            if strings.HasPrefix(line, "# TYPE ") {
+                if !seenType[line] {
+                    seenType[line] = true
+                    types = append(types, line)
+                }
+                continue
+            }
+            sp := strings.LastIndex(line, " ")
+            if sp < 0 || strings.HasPrefix(line, "#") {
+                continue
+            }
+            v, err := strconv.ParseFloat(line[sp+1:], 64)
+            if err != nil {
+                continue
+            }
+            key := line[:sp]
+            if _, ok := values[key]; !ok {
+                series = append(series, key)
+            }
+            values[key] += v
+        }
+        resp.Body.Close()
+    }
+
+    // Each TYPE line goes before the series of its metric.
+    sort.Strings(series)
+    written := make(map[string]bool)
+    for _, key := range series {
+        name := key
+        if brace := strings.IndexByte(name, '{'); brace >= 0 {
+            name = name[:brace]
+        }
+        for _, t := range types {
+            metric := strings.Fields(t)[2]
+            if !written[t] && (name == metric || strings.HasPrefix(name, metric+"_")) {
+                fmt.Fprintf(w, "%s\n", t)
+                written[t] = true
+            }
+        }
+        fmt.Fprintf(w, "%s %s\n", key, strconv.FormatFloat(values[key], 'f', -1, 64))
+    }
+}
+
+// purgeWorkers passes a purge on to every child and adds up what they did.
+func purgeWorkers(w http.ResponseWriter, r *http.Request, n int) {
+    if r.Method != "POST" {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    var total purgeResult
+    for i := 0; i < n; i++ {
+        res, err := postPurge(fmt.Sprintf("127.0.0.1:%d", workerControlBase+i), r.FormValue("pattern"))
+        if err != nil {
+            res.Error = fmt.Sprintf("worker %d: %s", i, err)
+        }
+        total.add(res)
+    }
+    w.Header().Set("Content-Type", "application/json")
+    if total.Error != "" {
+        w.WriteHeader(http.StatusInternalServerError)
+    }
+    json.NewEncoder(w).Encode(total)
+}
@@ -0,0 +1,58 @@
+/*
+ * workers_test.go
+ *
+ * Each connection, over IPv4 or IPv6, lands on exactly one shard.
+ */
+
+package main
+
+import (
+    "testing"
+
+    "github.com/google/gopacket"
+    "github.com/google/gopacket/layers"
+    "github.com/google/gopacket/pcap"
+)
+
+// shardTestFrame returns an Ethernet frame holding a bare TCP segment from
+// sport to dport, over IPv6 if v6.
+func shardTestFrame(v6 bool, sport, dport uint16) []byte {
+    frame := make([]byte, 12, 94)
+    if v6 {
+        frame = append(frame, 0x86, 0xdd, 0x60, 0, 0, 0, 0, 20, 6, 64)
+        frame = append(frame, make([]byte, 32)...) // addresses
+    } else {
+        frame = append(frame, 0x08, 0x00, 0x45, 0, 0, 40, 0, 0, 0, 0, 64, 6, 0, 0)
+        frame = append(frame, 10, 1, 0, 1, 10, 0, 0, 1)
+    }
+    frame = append(frame, byte(sport>>8), byte(sport), byte(dport>>8), byte(dport))
+    frame = append(frame, 0, 0, 0, 0, 0, 0, 0, 0, 0x50, 0x02, 0xff, 0xff, 0, 0, 0, 0)
+    return frame
+}
+
+func TestShardFilter(t *testing.T) {
+    defer func() { shardIndex, shardCount = 0, 0 }()
+    for _, v6 := range []bool{false, true} {
+        for _, dir := range [][2]uint16{{50001, 3306}, {3306, 50001}} {
+            data := shardTestFrame(v6, dir[0], dir[1])
+            ci := gopacket.CaptureInfo{CaptureLength: len(data), Length: len(data)}
+            matched := 0
+            for i := 0; i < 3; i++ {
+                shardIndex, shardCount = i, 3
+                bpf, err := pcap.NewBPF(layers.LinkTypeEthernet, CAPTURE_SNAPLEN, captureFilter())
+                if err != nil {
+                    t.Fatalf("%s: %s", captureFilter(), err)
+                }
+                if bpf.Matches(ci, data) {
+                    if want := (50001 + 3306) % 3; i != want {
+                        t.Errorf("ipv6 %v, %d->%d: on shard %d, not %d", v6, dir[0], dir[1], i, want)
+                    }
+                    matched++
+                }
+            }
+            if matched != 1 {
+                t.Errorf("ipv6 %v, %d->%d: on %d shards", v6, dir[0], dir[1], matched)
+            }
+        }
+    }
+}
@@ -0,0 +1,156 @@
+/*
+ * workload.go
+ *
+ * Workload export: the captured mix of fingerprints with their share,
+ * arrival rate and latency, as a description (`workload` report) or as
+ * input for benchmark tools, so synthetic benchmarks can follow production:
+ *
+ *   mysql-sniffer report -r prod.pcap sysbench > workload.lua
+ *   sysbench workload.lua --mysql-db=app --rate=500 run
+ *
+ * `oltpbench` writes the weights and rate of an OLTPBench/BenchBase config;
+ * the transaction types it names still need procedures on that side.
+ */
+
+package main
+
+import (
+    "encoding/json"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "strings"
+)
+
+type workloadQuery struct {
+    Name    string  `json:"name"`
+    Sql     string  `json:"sql"`
+    Operate string  `json:"operate"`
+    Count   uint64  `json:"count"`
+    Mix     float64 `json:"mix_pct"`
+    QPS     float64 `json:"qps"`
+    AvgMs   float64 `json:"avg_ms"`
+    P95Ms   float64 `json:"p95_ms"`
+}
+
+type workloadDescription struct {
+    Span    string          `json:"span"`
+    Queries uint64          `json:"queries"`
+    QPS     float64         `json:"qps"`
+    Mix     []workloadQuery `json:"mix"`
+}
+
+// describeWorkload summarizes everything captured, heaviest first.
+func describeWorkload() workloadDescription {
+    desc := workloadDescription{Span: reportSpan.String()}
+    keys := sortedQueries()
+    for _, key := range keys {
+        desc.Queries += qbuf[key].count
+    }
+    desc.QPS = perSecond(desc.Queries)
+    for i, key := range keys {
+        qdata := qbuf[key]
+        name := nameOf(key)
+        if name == "" {
+            name = fmt.Sprintf("q%d", i+1)
+        }
+        desc.Mix = append(desc.Mix, workloadQuery{Name: name, Sql: qdata.sql,
+            Operate: strings.ToLower(strings.SplitN(qdata.sql, " ", 2)[0]),
+            Count:   qdata.count, Mix: float64(qdata.count) * 100 / float64(desc.Queries),
+            QPS:   perSecond(qdata.count), AvgMs: avgMs(qdata.timeTotal, qdata.timed),
+            P95Ms: float64(qdata.times.quantile(0.95)) / 1e6})
+    }
+    return desc
+}
+
+func reportWorkload(w io.Writer, asJSON bool) {
+    desc := describeWorkload()
+    if asJSON {
+        json.NewEncoder(w).Encode(map[string]interface{}{"workload": desc})
+        return
+    }
+    fmt.Fprintf(w, "%s== workload (%d queries over %s, %.2f qps)%s\n", color(COLOR_CYAN),
+        desc.Queries, desc.Span, desc.QPS, color(COLOR_DEFAULT))
+    fmt.Fprintf(w, "%8s %10s %10s %10s  %s\n", "mix %", "qps", "avg ms", "p95 ms", "query")
+    for _, q := range desc.Mix {
+        fmt.Fprintf(w, "%8.2f %10.2f %10.3f %10.3f  [%s] %s\n", q.Mix, q.QPS, q.AvgMs, q.P95Ms,
+            q.Name, q.Sql)
+    }
+    fmt.Fprintf(w, "\n")
+}
+
+// luaString quotes s as a Lua long string that s can't terminate early.
+func luaString(s string) string {
+    level := ""
+    for strings.Contains(s, "]"+level+"]") {
+        level += "="
+    }
+    return "[" + level + "[" + s + "]" + level + "]"
+}
+
+// reportSysbench writes a sysbench Lua script replaying the mix. Each ? is
+// bound to a random integer, which MySQL will compare against strings too.
+func reportSysbench(w io.Writer, asJSON bool) {
+    desc := describeWorkload()
+    fmt.Fprintf(w, "-- Workload captured by mysql-sniffer: %d queries over %s, %.2f qps.\n",
+        desc.Queries, desc.Span, desc.QPS)
+    fmt.Fprintf(w, "-- Run with: sysbench workload.lua --rate=%.0f run\n\n", desc.QPS)
+    fmt.Fprintf(w, "queries = {\n")
+    for _, q := range desc.Mix {
+        fmt.Fprintf(w, "  -- %s: %.2f%%, avg %.3fms, p95 %.3fms\n", q.Name, q.Mix, q.AvgMs, q.P95Ms)
+        fmt.Fprintf(w, "  {weight = %d, sql = %s},\n", q.Count, luaString(q.Sql))
+    }
+    fmt.Fprintf(w, "}\n\n")
+    fmt.Fprintf(w, `local total = 0
+for _, q in ipairs(queries) do total = total + q.weight end
+
+local function bind()
+  return tostring(sysbench.rand.uniform(1, 1000000))
+end
+
+function thread_init()
+  drv = sysbench.sql.driver()
+  con = drv:connect()
+end
+
+function thread_done()
+  con:disconnect()
+end
+
+function event()
+  local r = sysbench.rand.uniform(1, total)
+  for _, q in ipairs(queries) do
+    r = r - q.weight
+    if r <= 0 then
+      con:query((q.sql:gsub("%%?", bind)))
+      return
+    end
+  end
+end
+`)
+}
+
+// reportOLTPBench writes an OLTPBench/BenchBase configuration with one
+// transaction type per fingerprint, weighted by its share.
+func reportOLTPBench(w io.Writer, asJSON bool) {
+    desc := describeWorkload()
+    var weights []string
+    for _, q := range desc.Mix {
+        weights = append(weights, fmt.Sprintf("%.2f", q.Mix))
+    }
+    fmt.Fprintf(w, "<?xml version=\"1.0\"?>\n<parameters>\n")
+    fmt.Fprintf(w, "    <!-- Workload captured by mysql-sniffer: %d queries over %s -->\n",
+        desc.Queries, desc.Span)
+    fmt.Fprintf(w, "    <type>MYSQL</type>\n    <driver>com.mysql.cj.jdbc.Driver</driver>\n")
+    fmt.Fprintf(w, "    <terminals>1</terminals>\n    <works>\n        <work>\n")
+    fmt.Fprintf(w, "            <time>60</time>\n            <rate>%.0f</rate>\n", desc.QPS)
+    fmt.Fprintf(w, "            <weights>%s</weights>\n", strings.Join(weights, ","))
+    fmt.Fprintf(w, "        </work>\n    </works>\n    <transactiontypes>\n")
+    for _, q := range desc.Mix {
+        fmt.Fprintf(w, "        <!-- %s -->\n", strings.Replace(q.Sql, "--", "- -", -1))
+        fmt.Fprintf(w, "        <transactiontype>\n            <name>")
+        xml.EscapeText(w, []byte(q.Name))
+        fmt.Fprintf(w, "</name>\n        </transactiontype>\n")
+    }
+    fmt.Fprintf(w, "    </transactiontypes>\n</parameters>\n")
+}
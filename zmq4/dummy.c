@@ -1,5 +0,0 @@
-/*
-
-You need CGO_ENABLED=1 to build this package
-
-*/